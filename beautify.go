@@ -7,10 +7,11 @@ import (
 )
 
 const (
-	color_white_bold = "\033[1;37m"
-	color_red_bold   = "\033[1;31m"
-	color_green_bold = "\033[1;32m"
-	color_none       = "\033[0m"
+	color_white_bold  = "\033[1;37m"
+	color_red_bold    = "\033[1;31m"
+	color_green_bold  = "\033[1;32m"
+	color_yellow_bold = "\033[1;33m"
+	color_none        = "\033[0m"
 )
 
 // Returns a prettified version of the `err`.
@@ -29,21 +30,30 @@ const (
 // typical terminal escape sequnces to the resulting string in case if the
 // argument is true.
 //
-// It will prettify only ParseError or UnmarshalError errors, if something else
-// is given it will return error.Error() output.
+// It will prettify only ParseError, UnmarshalError or Diagnostic errors, if
+// something else is given it will return error.Error() output. Diagnostics
+// are labeled with their severity. The quoted source line of a redacted
+// UnmarshalError is cut at the value.
 func Beautify(err error, getcont func(string) []byte, ctx *SourceContext, colors bool) string {
 	var loc SourceLoc
+	severity := SeverityError
+	redacted := false
 	switch e := err.(type) {
 	case *ParseError:
 		loc = e.Location
 	case *UnmarshalError:
 		loc = e.Node.Location
+		redacted = e.Redacted
+	case *Diagnostic:
+		loc = e.Location
+		severity = e.Severity
 	default:
 		return e.Error()
 	}
 
 	locex := ctx.Decode(loc)
-	contents := getcont(locex.Filename)
+	// offsets belong to the file, even if a line directive applies
+	contents := getcont(ctx.find_file(loc).name)
 	col := utf8.RuneCount(contents[locex.LineOffset:locex.Offset]) + 1
 
 	linecont := contents[locex.LineOffset:]
@@ -51,15 +61,24 @@ func Beautify(err error, getcont func(string) []byte, ctx *SourceContext, colors
 	if end != -1 {
 		linecont = linecont[:end]
 	}
+	if redacted {
+		// the rest of the line starts with the secret value
+		pos := locex.Offset - locex.LineOffset
+		linecont = append(linecont[:pos:pos], redacted_value...)
+	}
 
 	var buf bytes.Buffer
 	if colors {
-		fmt.Fprintf(&buf, "%s%s:%d:%d: %serror: %s%s%s\n",
-			color_white_bold, locex.Filename, locex.Line, col, color_red_bold,
-			color_white_bold, err, color_none)
+		color := color_red_bold
+		if severity == SeverityWarning {
+			color = color_yellow_bold
+		}
+		fmt.Fprintf(&buf, "%s%s:%d:%d: %s%s: %s%s%s\n",
+			color_white_bold, locex.Filename, locex.Line, col, color,
+			severity, color_white_bold, err, color_none)
 	} else {
-		fmt.Fprintf(&buf, "%s:%d:%d: error: %s\n",
-			locex.Filename, locex.Line, col, err)
+		fmt.Fprintf(&buf, "%s:%d:%d: %s: %s\n",
+			locex.Filename, locex.Line, col, severity, err)
 	}
 	fmt.Fprintf(&buf, "%s\n", linecont)
 	for i := locex.LineOffset; i < locex.Offset; {
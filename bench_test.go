@@ -0,0 +1,118 @@
+package sexp_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nsf/sexp"
+	"github.com/nsf/sexp/sexptest"
+)
+
+// The benchmarks use the corpora of the sexptest package, compare the results
+// of a change using benchstat. TestAllocs guards the number of allocations on
+// the hot paths, raise its limits only deliberately.
+
+const bench_size = 1000
+
+func bench_parse(b *testing.B, src []byte) {
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := sexp.Parse(bytes.NewReader(src), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseWide(b *testing.B)    { bench_parse(b, sexptest.WideList(bench_size)) }
+func BenchmarkParseDeep(b *testing.B)    { bench_parse(b, sexptest.DeepList(bench_size)) }
+func BenchmarkParseStrings(b *testing.B) { bench_parse(b, sexptest.StringList(bench_size)) }
+func BenchmarkParseNumbers(b *testing.B) { bench_parse(b, sexptest.NumberList(bench_size)) }
+func BenchmarkParseRecords(b *testing.B) { bench_parse(b, sexptest.Records(bench_size)) }
+
+func must_parse_list(tb testing.TB, src []byte) *sexp.Node {
+	root, err := sexp.Parse(bytes.NewReader(src), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return root.Children
+}
+
+// Unmarshals the tree into a fresh value created by `v` each iteration.
+func bench_unmarshal(b *testing.B, src []byte, v func() interface{}) {
+	list := must_parse_list(b, src)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := list.Unmarshal(v()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalStrings(b *testing.B) {
+	bench_unmarshal(b, sexptest.StringList(bench_size), func() interface{} { return new([]string) })
+}
+
+func BenchmarkUnmarshalNumbers(b *testing.B) {
+	bench_unmarshal(b, sexptest.NumberList(bench_size), func() interface{} { return new([]float64) })
+}
+
+func BenchmarkUnmarshalRecords(b *testing.B) {
+	bench_unmarshal(b, sexptest.Records(bench_size), func() interface{} { return new([]sexptest.Record) })
+}
+
+// Decodes the binary encoding of the corpus, compare with BenchmarkParseRecords.
+func BenchmarkUnmarshalBinaryRecords(b *testing.B) {
+	root, err := sexp.Parse(bytes.NewReader(sexptest.Records(bench_size)), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data, _ := root.MarshalBinary()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var n sexp.Node
+		if err := n.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipped in short mode")
+	}
+	check := func(name string, limit float64, f func()) {
+		if n := testing.AllocsPerRun(10, f); n > limit {
+			t.Errorf("%s: %.0f allocations, at most %.0f expected", name, n, limit)
+		}
+	}
+	// roughly one allocation per atom, its value, nodes are allocated in
+	// batches
+	for _, c := range []struct {
+		name  string
+		src   []byte
+		limit float64
+	}{
+		{"parse wide", sexptest.WideList(bench_size), bench_size + 22},
+		{"parse deep", sexptest.DeepList(bench_size), 22},
+		{"parse strings", sexptest.StringList(bench_size), bench_size + 35},
+		{"parse numbers", sexptest.NumberList(bench_size), bench_size + 22},
+	} {
+		src := c.src
+		check(c.name, c.limit, func() { sexp.Parse(bytes.NewReader(src), nil) })
+	}
+
+	// about two allocations per element, the slice grows as well
+	strs := must_parse_list(t, sexptest.StringList(bench_size))
+	check("unmarshal strings", 2*bench_size+15, func() { var v []string; strs.Unmarshal(&v) })
+	nums := must_parse_list(t, sexptest.NumberList(bench_size))
+	check("unmarshal numbers", 2*bench_size+15, func() { var v []float64; nums.Unmarshal(&v) })
+
+	// the nodes and the values are allocated at once
+	root, _ := sexp.Parse(bytes.NewReader(sexptest.Records(bench_size)), nil)
+	data, _ := root.MarshalBinary()
+	check("unmarshal binary", 2, func() { var n sexp.Node; n.UnmarshalBinary(data) })
+}
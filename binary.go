@@ -0,0 +1,215 @@
+package sexp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The binary encoding starts with the magic, the format version and a flags
+// byte, followed by the total number of nodes (uvarint) and the nodes in
+// depth-first order. Each node is encoded as:
+//
+//     kind        byte
+//     location    uvarint, if binary_locations is set
+//     value       uvarint length followed by the bytes
+//     children    uvarint count, the children follow the node
+const (
+	binary_magic   = "SXB"
+	binary_version = 1
+)
+
+const binary_locations = 1 << 0
+
+// Encodes the node and its children (without its siblings) using a compact
+// binary encoding, which is much faster to decode than the text, e.g. for
+// caching pre-parsed files on disk, see UnmarshalBinary. The encoding is
+// stable: it doesn't depend on the platform or on the version of the package,
+// future versions are able to decode it. Source locations are included, see
+// AppendBinary for leaving them out.
+func (n *Node) MarshalBinary() ([]byte, error) {
+	return AppendBinary(nil, n, true), nil
+}
+
+// Appends the binary encoding of the node to `buf`, see (*Node).MarshalBinary.
+// If `locations` is false, locations of the nodes are not encoded and are
+// zero when decoded, which makes the encoding smaller.
+func AppendBinary(buf []byte, n *Node, locations bool) []byte {
+	flags := byte(0)
+	if locations {
+		flags |= binary_locations
+	}
+	total := 1
+	count_nodes(n.Children, &total)
+	buf = append(buf, binary_magic...)
+	buf = append(buf, binary_version, flags)
+	buf = binary.AppendUvarint(buf, uint64(total))
+	return append_binary_node(buf, n, locations)
+}
+
+func append_binary_node(buf []byte, n *Node, locations bool) []byte {
+	buf = append(buf, byte(n.Kind))
+	if locations {
+		buf = binary.AppendUvarint(buf, uint64(n.Location))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(n.Value)))
+	buf = append(buf, n.Value...)
+	num := 0
+	for c := n.Children; c != nil; c = c.Next {
+		num++
+	}
+	buf = binary.AppendUvarint(buf, uint64(num))
+	for c := n.Children; c != nil; c = c.Next {
+		buf = append_binary_node(buf, c, locations)
+	}
+	return buf
+}
+
+// Decodes the binary encoding produced by MarshalBinary or AppendBinary into
+// the node, replacing it. The node's Next pointer is cleared. Nodes are
+// allocated in a single slice and values share a single string, hence
+// holding on to any node of the tree keeps the whole tree in memory.
+func (n *Node) UnmarshalBinary(data []byte) error {
+	if len(data) < len(binary_magic)+2 || string(data[:len(binary_magic)]) != binary_magic {
+		return errors.New("sexp: not a binary encoded tree")
+	}
+	data = data[len(binary_magic):]
+	if data[0] != binary_version {
+		return fmt.Errorf("sexp: unsupported binary encoding version %d", data[0])
+	}
+	d := binary_decoder{
+		data:      string(data[2:]),
+		locations: data[1]&binary_locations != 0,
+	}
+	total := d.uvarint()
+	if d.err != nil || total == 0 || total > uint64(len(d.data)) {
+		return errors.New("sexp: invalid number of nodes in the binary encoding")
+	}
+	d.nodes = make([]Node, total)
+	root := d.tree()
+	if d.err == nil && d.used != len(d.nodes) {
+		d.err = errors.New("sexp: number of nodes doesn't match the binary encoded tree")
+	}
+	if d.err == nil && d.pos != len(d.data) {
+		d.err = errors.New("sexp: unexpected data after the binary encoded tree")
+	}
+	if d.err != nil {
+		return d.err
+	}
+	*n = *root
+	return nil
+}
+
+type binary_decoder struct {
+	data      string
+	pos       int
+	locations bool
+	nodes     []Node // preallocated nodes, the first one is the root
+	used      int
+	err       error
+}
+
+func (d *binary_decoder) fail(message string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("sexp: %s at byte %d of the binary encoding", message, d.pos)
+	}
+}
+
+func (d *binary_decoder) uvarint() uint64 {
+	var v uint64
+	var shift uint
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		if d.pos >= len(d.data) {
+			d.fail("unexpected end of data")
+			return 0
+		}
+		b := d.data[d.pos]
+		d.pos++
+		if b < 0x80 {
+			return v | uint64(b)<<shift
+		}
+		v |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+	d.fail("invalid varint")
+	return 0
+}
+
+// Decodes the tree, the root is the first preallocated node. The lists being
+// decoded are kept on a stack rather than decoded recursively, so that deeply
+// nested input can't exhaust the goroutine stack.
+func (d *binary_decoder) tree() *Node {
+	root := &d.nodes[0]
+	d.used = 1
+	stack := make([]binary_frame, 1, 32)
+	stack[0] = binary_frame{list: root, left: d.header(root)}
+	for len(stack) != 0 && d.err == nil {
+		top := &stack[len(stack)-1]
+		if top.left == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		top.left--
+		if d.used >= len(d.nodes) {
+			d.fail("number of children exceeds the number of nodes")
+			break
+		}
+		c := &d.nodes[d.used]
+		d.used++
+		if top.last == nil {
+			top.list.Children = c
+		} else {
+			top.last.Next = c
+		}
+		top.last = c
+		stack = append(stack, binary_frame{list: c, left: d.header(c)})
+	}
+	return root
+}
+
+// A list being decoded, the number of its children left to decode and the
+// last decoded one.
+type binary_frame struct {
+	list *Node
+	last *Node
+	left uint64
+}
+
+// Decodes the node without its children into `n`, returns the number of the
+// children.
+func (d *binary_decoder) header(n *Node) uint64 {
+	if d.pos >= len(d.data) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	kind := NodeKind(d.data[d.pos])
+	if int(kind) >= len(node_kind_names) {
+		d.fail("invalid node kind")
+		return 0
+	}
+	d.pos++
+	n.Kind = kind
+	if d.locations {
+		n.Location = SourceLoc(d.uvarint())
+	}
+	length := d.uvarint()
+	if d.err != nil {
+		return 0
+	}
+	if length > uint64(len(d.data)-d.pos) {
+		d.fail("value exceeds the data")
+		return 0
+	}
+	n.Value = d.data[d.pos : d.pos+int(length)]
+	d.pos += int(length)
+
+	num := d.uvarint()
+	if d.err != nil {
+		return 0
+	}
+	if num > uint64(len(d.nodes)-d.used) {
+		d.fail("number of children exceeds the number of nodes")
+		return 0
+	}
+	return num
+}
@@ -0,0 +1,71 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestBinary(t *testing.T) {
+	src := "(a \"b c\" `d` 42 1.5 ()) (e (f (g))) \"\""
+	var ctx SourceContext
+	root, err := Parse(strings.NewReader(src), ctx.AddFile("test.sexp", len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := root.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Node
+	if err := out.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !trees_equal(&out, root) {
+		t.Error("decoded tree differs from the original")
+	}
+
+	small := AppendBinary([]byte("prefix"), root, false)
+	if !strings.HasPrefix(string(small), "prefix") || len(small)-len("prefix") >= len(data) {
+		t.Errorf("unexpected encoding without locations: %q", small)
+	}
+	out = Node{Next: root}
+	if err := out.UnmarshalBinary(small[len("prefix"):]); err != nil {
+		t.Fatal(err)
+	}
+	if out.Next != nil || out.Children.Location != 0 || out.Children.Next.Children.Next.Value != "" {
+		t.Errorf("unexpected tree: %+v", out)
+	}
+
+	// every truncation and corruption must be reported, not panic
+	for i := 0; i < len(data); i++ {
+		if err := out.UnmarshalBinary(data[:i]); err == nil {
+			t.Errorf("truncation at %d is not reported", i)
+		}
+		broken := append([]byte(nil), data...)
+		broken[i] = 0xff
+		out.UnmarshalBinary(broken)
+	}
+	error_must_contain(t, out.UnmarshalBinary([]byte("(a b)")), "not a binary encoded tree")
+	error_must_contain(t, out.UnmarshalBinary([]byte("SXB\x02\x00\x01")), "unsupported binary encoding version 2")
+	error_must_contain(t, out.UnmarshalBinary(append(data, 0)), "unexpected data after")
+
+	// deeply nested lists are decoded without recursion
+	const depth = 1 << 20
+	deep := AppendBinary(nil, &Node{}, false)
+	deep = deep[:len(binary_magic)+2]
+	deep = binary.AppendUvarint(deep, depth)
+	deep = append(deep, bytes.Repeat([]byte{byte(NodeList), 0, 1}, depth-1)...)
+	deep = append(deep, byte(NodeSymbol), 1, 'x', 0)
+	if err := out.UnmarshalBinary(deep); err != nil {
+		t.Fatal(err)
+	}
+	n := &out
+	for i := 1; i < depth; i++ {
+		n = n.Children
+	}
+	if n.Value != "x" || n.Children != nil {
+		t.Errorf("unexpected innermost node: %+v", n)
+	}
+}
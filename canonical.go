@@ -0,0 +1,86 @@
+package sexp
+
+import (
+	"bytes"
+	"hash"
+	"sort"
+	"strconv"
+)
+
+// Option for Canonicalize.
+type CanonicalOption func(o *canonical_options)
+
+type canonical_options struct {
+	preserve_order bool
+}
+
+// Keeps key/value pairs in the document order, see Canonicalize.
+func CanonicalPreserveOrder() CanonicalOption {
+	return func(o *canonical_options) {
+		o.preserve_order = true
+	}
+}
+
+// Returns the canonical form of the node (without its siblings), which is
+// deterministic and identical for documents which differ only cosmetically,
+// suitable for hashing and comparison.
+//
+// The encoding is the canonical form from Rivest's S-expressions draft: every
+// atom is written as its length in bytes in decimal, a colon and the bytes of
+// the atom, lists are written in parentheses, there is no whitespace at all:
+//
+//     (3:foo(1:a1:b))
+//
+// An empty list is written as "()", an empty atom as "0:".
+//
+// Comments and formatting of the source are not a part of the tree, hence
+// they don't affect the result. In addition to that, the items of lists
+// consisting of key/value pairs only are sorted by key, the relative order
+// of pairs with equal keys is preserved. Use CanonicalPreserveOrder to
+// disable sorting.
+func Canonicalize(n *Node, opts ...CanonicalOption) []byte {
+	var o canonical_options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var buf bytes.Buffer
+	write_canonical(&buf, n, &o)
+	return buf.Bytes()
+}
+
+func write_canonical(buf *bytes.Buffer, n *Node, o *canonical_options) {
+	// an empty list is scalar, but it must differ from an empty atom
+	if n.IsScalar() && n.Kind != NodeList {
+		buf.WriteString(strconv.Itoa(len(n.Value)))
+		buf.WriteByte(':')
+		buf.WriteString(n.Value)
+		return
+	}
+
+	var items []*Node
+	for c := n.Children; c != nil; c = c.Next {
+		items = append(items, c)
+	}
+	if !o.preserve_order && is_record(n) {
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Children.Value < items[j].Children.Value
+		})
+	}
+	buf.WriteByte('(')
+	for _, c := range items {
+		write_canonical(buf, c, o)
+	}
+	buf.WriteByte(')')
+}
+
+// Returns the digest of the canonical form of the node (see Canonicalize)
+// computed using `h`, which is reset first. The digest is stable across
+// cosmetic changes to the document, such as formatting, comments or the
+// order of key/value pairs.
+//
+//     sum := sexp.HashTree(root, sha256.New())
+func HashTree(n *Node, h hash.Hash, opts ...CanonicalOption) []byte {
+	h.Reset()
+	h.Write(Canonicalize(n, opts...))
+	return h.Sum(nil)
+}
@@ -0,0 +1,60 @@
+package sexp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func test_canonical(t *testing.T, source, gold string, opts ...CanonicalOption) {
+	root, err := Parse(strings.NewReader(source), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(Canonicalize(root, opts...)); out != gold {
+		t.Errorf("%s != %s", out, gold)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	test_canonical(t, "foo", "(3:foo)")
+	test_canonical(t, `(a "b c" ()) ; comment`, "((1:a3:b c()))")
+	test_canonical(t, `(a "")`, "((1:a0:))")
+	test_canonical(t, "ж", "(2:ж)")
+
+	// pairs are sorted
+	src1 := "(name x)\n(size (640 480))\n(tag a)\n(attrs ((z 1) (y 2)))\n(tag b)"
+	src2 := "(attrs ((y 2)\n        (z 1)))\n(tag a) (size (640 480))\n(tag b)\n(name \"x\") ; the name"
+	gold := "((5:attrs((1:y1:2)(1:z1:1)))(4:name1:x)(4:size(3:6403:480))(3:tag1:a)(3:tag1:b))"
+	test_canonical(t, src1, gold)
+	test_canonical(t, src2, gold)
+
+	// not a list of pairs
+	test_canonical(t, "(b 1) c (a 2)", "((1:b1:1)1:c(1:a1:2))")
+	test_canonical(t, "(b 1) (a 2)", "((1:b1:1)(1:a1:2))", CanonicalPreserveOrder())
+}
+
+func TestHashTree(t *testing.T) {
+	hash := func(source string) string {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(HashTree(root, sha256.New()))
+	}
+	a := hash("(b 2)\n(a 1) ; comment")
+	b := hash("(a  1) (b \"2\")")
+	c := hash("(a 1) (b 3)")
+	if a != b {
+		t.Errorf("cosmetic changes are not expected to affect the hash")
+	}
+	if a == c {
+		t.Errorf("different documents are expected to have different hashes")
+	}
+	// sha256 of "((1:a1:1)(1:b1:2))"
+	sum := sha256.Sum256([]byte("((1:a1:1)(1:b1:2))"))
+	if a != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected hash: %s", a)
+	}
+}
@@ -0,0 +1,159 @@
+package sexp
+
+import (
+	"sort"
+)
+
+// Sorts the children of the list node using `less`, the sort is stable. The
+// children nodes are relinked, none of them is copied. E.g. to sort key/value
+// pairs by key:
+//
+//     list.SortChildren(func(a, b *sexp.Node) bool {
+//         return a.Children.Value < b.Children.Value
+//     })
+func (n *Node) SortChildren(less func(a, b *Node) bool) {
+	var children []*Node
+	for c := n.Children; c != nil; c = c.Next {
+		children = append(children, c)
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return less(children[i], children[j])
+	})
+	var chain node_chain
+	for _, c := range children {
+		chain.push(c)
+	}
+	n.Children = chain.finish()
+	n.ResetIndex()
+}
+
+// Removes the children of the list node which are equal to any preceding
+// child, the first occurrence is kept. Uses Equal if `equal` is nil. Returns
+// the number of removed children. Every child is compared with all the kept
+// ones, for sorted lists DedupAdjacentChildren is cheaper.
+func (n *Node) DedupChildren(equal func(a, b *Node) bool) int {
+	if equal == nil {
+		equal = Equal
+	}
+	var kept []*Node
+	return n.remove_children(func(c *Node) bool {
+		for _, k := range kept {
+			if equal(k, c) {
+				return true
+			}
+		}
+		kept = append(kept, c)
+		return false
+	})
+}
+
+// Like DedupChildren, but a child is compared with the preceding kept one
+// only, i.e. runs of equal children are collapsed.
+func (n *Node) DedupAdjacentChildren(equal func(a, b *Node) bool) int {
+	if equal == nil {
+		equal = Equal
+	}
+	var prev *Node
+	return n.remove_children(func(c *Node) bool {
+		if prev != nil && equal(prev, c) {
+			return true
+		}
+		prev = c
+		return false
+	})
+}
+
+// Unlinks the children for which `remove` returns true, returns their
+// number.
+func (n *Node) remove_children(remove func(c *Node) bool) int {
+	removed := 0
+	var chain node_chain
+	for c := n.Children; c != nil; {
+		next := c.Next
+		if remove(c) {
+			c.Next = nil
+			removed++
+		} else {
+			chain.push(c)
+		}
+		c = next
+	}
+	n.Children = chain.finish()
+	n.ResetIndex()
+	return removed
+}
+
+// Replaces the child list of the node with the child's children in place,
+// e.g. splicing `(b c)` into `(a (b c) d)` results in `(a b c d)`. An empty
+// child is just removed. Returns false if `child` is not a child of the node
+// or is not a list, nothing is done then.
+func (n *Node) Splice(child *Node) bool {
+	if child == nil || !child.IsList() && child.Kind != NodeList {
+		return false
+	}
+	var chain node_chain
+	found := false
+	for c := n.Children; c != nil; {
+		next := c.Next
+		if c == child {
+			chain.push_all(c.Children)
+			c.Children = nil
+			c.Next = nil
+			found = true
+		} else {
+			chain.push(c)
+		}
+		c = next
+	}
+	n.Children = chain.finish()
+	if found {
+		n.ResetIndex()
+	}
+	return found
+}
+
+// Splices all the child lists of the node (see Splice), `depth` times:
+// Flatten(1) turns `(a (b (c)) d)` into `(a b (c) d)`, Flatten(2) into
+// `(a b c d)`. Empty lists are removed. A negative depth flattens the list
+// entirely.
+func (n *Node) Flatten(depth int) {
+	for ; depth != 0; depth-- {
+		var chain node_chain
+		spliced := false
+		for c := n.Children; c != nil; {
+			next := c.Next
+			if c.IsList() || c.Kind == NodeList {
+				chain.push_all(c.Children)
+				c.Children = nil
+				c.Next = nil
+				spliced = true
+			} else {
+				chain.push(c)
+			}
+			c = next
+		}
+		n.Children = chain.finish()
+		n.ResetIndex()
+		if !spliced {
+			break
+		}
+	}
+}
+
+// Returns true if the trees rooted at `a` and `b` (without their siblings)
+// have the same structure and values. Locations and kinds of atoms are not
+// compared, e.g. `"x"` equals `x`, but an empty list doesn't equal an empty
+// atom.
+func Equal(a, b *Node) bool {
+	if a.Value != b.Value ||
+		(a.IsList() || a.Kind == NodeList) != (b.IsList() || b.Kind == NodeList) {
+		return false
+	}
+	ca, cb := a.Children, b.Children
+	for ; ca != nil && cb != nil; ca, cb = ca.Next, cb.Next {
+		if !Equal(ca, cb) {
+			return false
+		}
+	}
+	return ca == nil && cb == nil
+}
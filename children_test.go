@@ -0,0 +1,106 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Parses the source and returns its first expression.
+func parse_first(t *testing.T, source string) *Node {
+	root, err := Parse(strings.NewReader(source), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root.Children
+}
+
+func test_children(t *testing.T, n *Node, gold string) {
+	var buf bytes.Buffer
+	if _, err := n.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != gold {
+		t.Errorf("%s != %s", buf.String(), gold)
+	}
+}
+
+func TestSortChildren(t *testing.T) {
+	n := parse_first(t, "((b 1) (a 2) (c 3) (a 1))")
+	n.SortChildren(func(a, b *Node) bool {
+		return a.Children.Value < b.Children.Value
+	})
+	test_children(t, n, "((a 2) (a 1) (b 1) (c 3))")
+
+	empty := parse_first(t, "()")
+	empty.SortChildren(func(a, b *Node) bool { return true })
+	if empty.Children != nil {
+		t.Error("empty list must stay empty")
+	}
+}
+
+func TestDedupChildren(t *testing.T) {
+	n := parse_first(t, `(a b "a" (c d) b (c d) (c e) () "" ())`)
+	if r := n.DedupChildren(nil); r != 4 {
+		t.Errorf("4 removed children expected, got %d", r)
+	}
+	test_children(t, n, `(a b (c d) (c e) () "")`)
+
+	n = parse_first(t, "(a a b a (c) (c))")
+	if r := n.DedupAdjacentChildren(nil); r != 2 {
+		t.Errorf("2 removed children expected, got %d", r)
+	}
+	test_children(t, n, "(a b a (c))")
+
+	n = parse_first(t, "((x 1) (y 2) (x 3))")
+	n.DedupChildren(func(a, b *Node) bool {
+		return a.Children.Value == b.Children.Value
+	})
+	test_children(t, n, "((x 1) (y 2))")
+}
+
+func TestEqual(t *testing.T) {
+	a := parse_first(t, `(a (b "c") ())`)
+	b := parse_first(t, "(a (b c) ())")
+	if !Equal(a, b) {
+		t.Error("trees must be equal")
+	}
+	for _, s := range []string{"(a (b c))", "(a (b c) x)", "(a (b c d) ())", "(a (b c) \"\")", "a"} {
+		if Equal(a, parse_first(t, s)) {
+			t.Errorf("%s: trees must not be equal", s)
+		}
+	}
+}
+
+func TestSplice(t *testing.T) {
+	n := parse_first(t, "(a (b c) () d)")
+	b := n.Children.Next
+	if !n.Splice(b) || n.Splice(b) {
+		t.Error("a child must be spliced once")
+	}
+	test_children(t, n, "(a b c () d)")
+	n.Splice(n.Children.Next.Next.Next)
+	test_children(t, n, "(a b c d)")
+	if n.Splice(n) {
+		t.Error("the node itself is not its child")
+	}
+	if n.Splice(n.Children) {
+		t.Error("an atom must not be spliced")
+	}
+	test_children(t, n, "(a b c d)")
+}
+
+func TestFlatten(t *testing.T) {
+	for depth, gold := range []string{
+		"(a (b (c (d))) e)",
+		"(a b (c (d)) e)",
+		"(a b c (d) e)",
+	} {
+		n := parse_first(t, "(a (b (c (d))) e)")
+		n.Flatten(depth)
+		test_children(t, n, gold)
+	}
+	n := parse_first(t, "((a (b (c (d)))) () e)")
+	n.Flatten(-1)
+	test_children(t, n, "(a b c d e)")
+}
@@ -0,0 +1,93 @@
+// Command json2sexp converts a stream of JSON values to S-expressions, see
+// sexp.FromJSON for the conversion rules. Without file arguments it reads the
+// standard input. Each JSON value is written on its own line, unless -pretty
+// is given.
+//
+// Usage:
+//
+//     json2sexp [flags] [file...]
+//
+// Flags:
+//
+//     -objects  JSON objects convention: pairs or tagged
+//     -pretty   indent the output
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nsf/sexp"
+)
+
+var (
+	objects = flag.String("objects", "pairs", "JSON objects convention: pairs or tagged")
+	pretty  = flag.Bool("pretty", false, "indent the output")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: json2sexp [flags] [file...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var opts sexp.JSONOptions
+	switch *objects {
+	case "pairs":
+		opts.Objects = sexp.JSONPairs
+	case "tagged":
+		opts.Objects = sexp.JSONTagged
+	default:
+		fmt.Fprintf(os.Stderr, "json2sexp: unknown objects convention: %s\n", *objects)
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	failed := false
+	for _, filename := range files {
+		if err := process(out, filename, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "json2sexp: %s: %s\n", filename, err)
+			failed = true
+		}
+	}
+	if failed {
+		out.Flush()
+		os.Exit(1)
+	}
+}
+
+func process(out *bufio.Writer, filename string, opts sexp.JSONOptions) error {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	root, err := sexp.FromJSON(bufio.NewReader(r), opts)
+	if err != nil {
+		return err
+	}
+	for c := root.Children; c != nil; c = c.Next {
+		if *pretty {
+			c.WriteIndent(out, "  ")
+		} else {
+			c.WriteTo(out)
+		}
+		out.WriteByte('\n')
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Command sexp2json converts S-expressions to JSON, see sexp.ToJSON for the
+// conversion rules. Without file arguments it reads the standard input. Each
+// top-level expression is converted to a separate JSON value written on its
+// own line, unless -doc is given.
+//
+// Usage:
+//
+//     sexp2json [flags] [file...]
+//
+// Flags:
+//
+//     -doc      convert the whole document as a single list of expressions
+//     -infer    convert numbers, true, false and null to JSON values
+//     -objects  JSON objects convention: pairs or tagged
+//     -pretty   indent the output
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/nsf/sexp"
+)
+
+var (
+	doc     = flag.Bool("doc", false, "convert the whole document as a single list of expressions")
+	infer   = flag.Bool("infer", false, "convert numbers, true, false and null to JSON values")
+	objects = flag.String("objects", "pairs", "JSON objects convention: pairs or tagged")
+	pretty  = flag.Bool("pretty", false, "indent the output")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sexp2json [flags] [file...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var opts sexp.JSONOptions
+	switch *objects {
+	case "pairs":
+		opts.Objects = sexp.JSONPairs
+	case "tagged":
+		opts.Objects = sexp.JSONTagged
+	default:
+		fmt.Fprintf(os.Stderr, "sexp2json: unknown objects convention: %s\n", *objects)
+		os.Exit(2)
+	}
+	opts.InferScalars = *infer
+	if *pretty {
+		opts.Indent = "  "
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	failed := false
+	for _, filename := range files {
+		if err := process(out, filename, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "sexp2json: %s\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		out.Flush()
+		os.Exit(1)
+	}
+}
+
+func process(out *bufio.Writer, filename string, opts sexp.JSONOptions) error {
+	var src []byte
+	var err error
+	if filename == "-" {
+		filename = "<stdin>"
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	var ctx sexp.SourceContext
+	f := ctx.AddFile(filename, len(src))
+	root, err := sexp.Parse(strings.NewReader(string(src)), f)
+	if err != nil {
+		getcont := func(string) []byte { return src }
+		return fmt.Errorf("%s", sexp.Beautify(err, getcont, &ctx, false))
+	}
+
+	convert := func(n *sexp.Node) error {
+		data, err := sexp.ToJSON(n, opts)
+		if err != nil {
+			return err
+		}
+		out.Write(data)
+		out.WriteByte('\n')
+		return nil
+	}
+	if *doc {
+		return convert(root)
+	}
+	for c := root.Children; c != nil; c = c.Next {
+		if err := convert(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
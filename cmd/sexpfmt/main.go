@@ -0,0 +1,112 @@
+// Command sexpfmt formats S-expression files, see sexp.Format for the
+// formatting rules. Without file arguments it formats the standard input.
+//
+// Usage:
+//
+//     sexpfmt [flags] [file...]
+//
+// Flags:
+//
+//     -w       write the result to the source file instead of stdout
+//     -l       list files whose formatting differs from sexpfmt's
+//     -indent  string used for a single level of indentation
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nsf/sexp"
+)
+
+var (
+	write  = flag.Bool("w", false, "write result to the source file instead of stdout")
+	list   = flag.Bool("l", false, "list files whose formatting differs from sexpfmt's")
+	indent = flag.String("indent", "  ", "string used for a single level of indentation")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sexpfmt [flags] [file...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintf(os.Stderr, "sexpfmt: cannot use -w with standard input\n")
+			os.Exit(2)
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err == nil {
+			err = process("<stdin>", src)
+		}
+		if err != nil {
+			report(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := false
+	for _, filename := range flag.Args() {
+		src, err := ioutil.ReadFile(filename)
+		if err == nil {
+			err = process(filename, src)
+		}
+		if err != nil {
+			report(err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type format_error struct {
+	filename string
+	src      []byte
+	err      error
+}
+
+func (e *format_error) Error() string {
+	// re-parse the source within a named context to get a nice error
+	var ctx sexp.SourceContext
+	f := ctx.AddFile(e.filename, len(e.src))
+	_, err := sexp.Parse(bytes.NewReader(e.src), f)
+	if err == nil {
+		err = e.err
+	}
+	getcont := func(string) []byte { return e.src }
+	return sexp.Beautify(err, getcont, &ctx, false)
+}
+
+func report(err error) {
+	fmt.Fprintf(os.Stderr, "sexpfmt: %s\n", err)
+}
+
+func process(filename string, src []byte) error {
+	out, err := sexp.Format(src, sexp.FormatOptions{Indent: *indent})
+	if err != nil {
+		return &format_error{filename, src, err}
+	}
+
+	changed := !bytes.Equal(src, out)
+	if *list && changed {
+		fmt.Println(filename)
+	}
+	if *write {
+		if changed {
+			return ioutil.WriteFile(filename, out, 0666)
+		}
+		return nil
+	}
+	if !*list {
+		os.Stdout.Write(out)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Command sexpgen generates reflection-free UnmarshalSexp methods for struct
+// types annotated with the "//sexp:generate" directive. For each input file
+// "name.go" it writes "name_sexp.go" next to it.
+//
+// Typical usage is via go:generate:
+//
+//     //go:generate sexpgen $GOFILE
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/nsf/sexp"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sexpgen file.go...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, filename := range flag.Args() {
+		if err := generate(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "sexpgen: %s: %s\n", filename, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func generate(filename string) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	out, err := sexp.GenerateUnmarshalers(filename, src)
+	if err != nil {
+		return err
+	}
+	outname := strings.TrimSuffix(filename, ".go") + "_sexp.go"
+	return ioutil.WriteFile(outname, out, 0666)
+}
@@ -0,0 +1,98 @@
+// Command sexpq prints nodes of S-expression documents matching a path query,
+// see sexp.CompileQuery for the query syntax. Without file arguments it reads
+// the standard input.
+//
+// Usage:
+//
+//     sexpq [flags] query [file...]
+//
+// Flags:
+//
+//     -json  print matches as JSON, see sexp.ToJSON
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/nsf/sexp"
+)
+
+var as_json = flag.Bool("json", false, "print matches as JSON")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sexpq [flags] query [file...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	q, err := sexp.CompileQuery(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sexpq: %s\n", err)
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	files := flag.Args()[1:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	failed := false
+	for _, filename := range files {
+		if err := process(out, q, filename); err != nil {
+			fmt.Fprintf(os.Stderr, "sexpq: %s\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		out.Flush()
+		os.Exit(1)
+	}
+}
+
+func process(out *bufio.Writer, q *sexp.Query, filename string) error {
+	var src []byte
+	var err error
+	if filename == "-" {
+		filename = "<stdin>"
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	var ctx sexp.SourceContext
+	f := ctx.AddFile(filename, len(src))
+	root, err := sexp.Parse(strings.NewReader(string(src)), f)
+	if err != nil {
+		getcont := func(string) []byte { return src }
+		return fmt.Errorf("%s", sexp.Beautify(err, getcont, &ctx, false))
+	}
+
+	for _, n := range q.Find(root) {
+		if *as_json {
+			data, err := sexp.ToJSON(n, sexp.JSONOptions{})
+			if err != nil {
+				return err
+			}
+			out.Write(data)
+		} else {
+			n.WriteTo(out)
+		}
+		out.WriteByte('\n')
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Command sexpvalidate validates S-expression documents against a schema, see
+// sexp.Schema for the schema language. Each violation is printed as a
+// file:line:col diagnostic with the offending line and a caret. Exits with a
+// non-zero status if any of the documents is invalid.
+//
+// Usage:
+//
+//     sexpvalidate -schema file [flags] document...
+//
+// Flags:
+//
+//     -schema  schema file
+//     -color   colorize diagnostics
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nsf/sexp"
+)
+
+var (
+	schema_file = flag.String("schema", "", "schema file")
+	color       = flag.Bool("color", false, "colorize diagnostics")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sexpvalidate -schema file [flags] document...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *schema_file == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var l sexp.Loader
+	root, err := l.Load(*schema_file)
+	var schema *sexp.Schema
+	if err == nil {
+		schema, err = sexp.ParseSchema(root)
+	}
+	if err != nil {
+		report(&l, err)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, filename := range flag.Args() {
+		var l sexp.Loader
+		root, err := l.Load(filename)
+		if err != nil {
+			report(&l, err)
+			failed = true
+			continue
+		}
+		for _, d := range schema.Diagnostics(root) {
+			report(&l, d)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func report(l *sexp.Loader, err error) {
+	if d, ok := sexp.AsDiagnostic(err); ok {
+		fmt.Fprintln(os.Stderr, sexp.Beautify(d, l.Contents, &l.Context, *color))
+	} else {
+		fmt.Fprintf(os.Stderr, "sexpvalidate: %s\n", err)
+	}
+}
@@ -0,0 +1,108 @@
+package sexp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+)
+
+// Decompressor recognizes a compression format by the magic bytes at the
+// beginning of the input and decompresses it. Gzip is supported out of the
+// box, other formats can be plugged in using the Decompress parse option,
+// see also NewZstdDecompressor.
+type Decompressor interface {
+	// Returns true if the input starting with `header` is compressed using
+	// this format. The header is at most 8 bytes long, it's shorter only if
+	// the input is.
+	Match(header []byte) bool
+
+	// Returns a reader decompressing the data read from `r`.
+	NewReader(r io.Reader) (io.Reader, error)
+}
+
+type magic_decompressor struct {
+	magic      []byte
+	new_reader func(r io.Reader) (io.Reader, error)
+}
+
+func (d *magic_decompressor) Match(header []byte) bool {
+	return bytes.HasPrefix(header, d.magic)
+}
+
+func (d *magic_decompressor) NewReader(r io.Reader) (io.Reader, error) {
+	return d.new_reader(r)
+}
+
+var gzip_decompressor = &magic_decompressor{
+	magic: []byte{0x1F, 0x8B},
+	new_reader: func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+}
+
+// Returns a Decompressor which recognizes zstd frames and decompresses them
+// using the given function. The package doesn't depend on any zstd
+// implementation, e.g. with github.com/klauspost/compress/zstd:
+//
+//     zstd := sexp.NewZstdDecompressor(func(r io.Reader) (io.Reader, error) {
+//         return zstd.NewReader(r)
+//     })
+//     root, err := sexp.Parse(r, nil, sexp.Decompress(zstd))
+func NewZstdDecompressor(new_reader func(r io.Reader) (io.Reader, error)) Decompressor {
+	return &magic_decompressor{
+		magic:      []byte{0x28, 0xB5, 0x2F, 0xFD},
+		new_reader: new_reader,
+	}
+}
+
+// Makes the parser detect compressed input by its magic bytes and decompress
+// it transparently. Gzip is always recognized, additional formats are given
+// as arguments and take precedence over gzip. Input which doesn't match any
+// format is parsed as is.
+//
+// Source locations are offsets within the decompressed data. The option
+// requires the reader passed to Parse to implement io.Reader, it's ignored by
+// ParseOne. Loader decompresses whole files before parsing, hence Contents
+// returns decompressed data in that case.
+func Decompress(ds ...Decompressor) ParseOption {
+	return func(o *parse_options) {
+		o.decompress = true
+		o.decompressors = ds
+	}
+}
+
+// Returns a reader producing decompressed contents of `r` if it's compressed
+// using one of the formats known to the decompressors or gzip, otherwise the
+// returned reader produces the contents of `r` as is.
+func NewDecompressReader(r io.Reader, ds ...Decompressor) (io.Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	// errors are reported by the following reads
+	header, _ := br.Peek(8)
+	for _, d := range append(ds, gzip_decompressor) {
+		if d.Match(header) {
+			return d.NewReader(br)
+		}
+	}
+	return br, nil
+}
+
+// Decompresses `data` if it's compressed and the options enable it.
+func decompress_data(data []byte, opts []ParseOption) ([]byte, error) {
+	var o parse_options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.decompress {
+		return data, nil
+	}
+	r, err := NewDecompressReader(bytes.NewReader(data), o.decompressors...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,96 @@
+package sexp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gzip_string(s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.String()
+}
+
+// Fake "compression": the magic followed by the reversed data.
+func new_reverse_decompressor() Decompressor {
+	return &magic_decompressor{
+		magic: []byte("REV"),
+		new_reader: func(r io.Reader) (io.Reader, error) {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			data = data[3:]
+			for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+				data[i], data[j] = data[j], data[i]
+			}
+			return bytes.NewReader(data), nil
+		},
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	test := func(src, gold string, opts ...ParseOption) {
+		root, err := Parse(strings.NewReader(src), nil, opts...)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		format_tree(&buf, root)
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+	test(gzip_string("(a b) c"), `(("a" "b") "c")`, Decompress())
+	test("(a b) c", `(("a" "b") "c")`, Decompress())
+	test("REV)b a(", `(("a" "b"))`, Decompress(new_reverse_decompressor()))
+
+	_, err := Parse(strings.NewReader("\x1f\x8bgarbage"), nil, Decompress())
+	error_must_contain(t, err, "unexpected EOF")
+
+	var nodes []*Node
+	err = ParseEach(strings.NewReader(gzip_string("a b c")), func(n *Node) error {
+		nodes = append(nodes, n)
+		return nil
+	}, Decompress())
+	if err != nil || len(nodes) != 3 {
+		t.Errorf("unexpected result: %d nodes, %v", len(nodes), err)
+	}
+
+	d := NewDecoder(strings.NewReader(gzip_string("(1 2)")), nil, Decompress())
+	var v []int
+	if err := d.Decode(&v); err != nil || len(v) != 2 {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+}
+
+func TestLoaderDecompress(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"main.sexp.gz":  gzip_string("(n 1) (include \"other.sexp.gz\")"),
+		"other.sexp.gz": gzip_string("(m 2)"),
+	})
+
+	l := Loader{ParseOptions: []ParseOption{Decompress()}}
+	root, err := l.Load(filepath.Join(dir, "main.sexp.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ N, M int }
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.N != 1 || v.M != 2 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	if c := l.Contents(filepath.Join(dir, "other.sexp.gz")); string(c) != "(m 2)" {
+		t.Errorf("unexpected contents: %q", c)
+	}
+}
@@ -0,0 +1,161 @@
+package sexp
+
+import (
+	"bufio"
+	"io"
+)
+
+// Decoder reads S-expressions from a stream and unmarshals them, one
+// top-level node at a time. Unlike Parse it never keeps more than one
+// top-level node in memory. See also DecodeList for decoding elements of a
+// huge top-level list one by one.
+//
+// Source locations are encoded using the given SourceFile, which is finalized
+// when the end of the stream or a syntax error is reached, see Parse for
+// details.
+type Decoder struct {
+	p       parser
+	started bool
+	err     error // sticky error, syntax errors and EOF
+	opts    *unmarshal_options
+}
+
+// Creates a new decoder reading from `r`, which is wrapped with bufio.Reader
+// unless it implements io.RuneReader. `f` may be nil. If the Decompress option
+// is given and the input cannot be decompressed, the error is returned by the
+// first Next or Decode call.
+func NewDecoder(r io.Reader, f *SourceFile, opts ...ParseOption) *Decoder {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+	if f == nil {
+		var ctx SourceContext
+		f = ctx.AddFile("", -1)
+	}
+
+	d := &Decoder{opts: &unmarshal_options{}}
+	d.p.init_options(opts)
+	d.err = d.p.init_reader(rr)
+	d.p.init_fast(false)
+	if d.err != nil {
+		f.finish(0)
+	}
+	d.p.f = f
+	d.p.last_seq = seq{offset: -1}
+	d.p.expect_eof = true
+	return d
+}
+
+// Runs `f` catching parser panics, io.EOF and syntax errors are sticky.
+func (d *Decoder) protect(f func()) (err error) {
+	if d.err != nil {
+		return d.err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if ferr := d.p.f.finalize(d.p.offset); ferr != nil && e == io.EOF {
+				e = ferr
+			}
+			if e == io.EOF {
+				d.err = io.EOF
+				err = io.EOF
+				return
+			}
+			if sexperr, ok := e.(*ParseError); ok {
+				d.err = sexperr
+				err = sexperr
+				return
+			}
+			panic(e)
+		}
+	}()
+
+	if !d.started {
+		d.started = true
+		d.p.next()
+		d.p.skip_bom()
+		d.p.skip_shebang()
+	}
+	f()
+	return nil
+}
+
+// Parses the next top-level node. Returns io.EOF if there are no more nodes.
+func (d *Decoder) Next() (*Node, error) {
+	var node *Node
+	err := d.protect(func() {
+		d.p.skip_spaces()
+		node = d.p.parse_node()
+		if node == nil {
+			d.p.error(d.p.f.Encode(d.p.offset),
+				"unexpected ')' at the top level")
+		}
+	})
+	return node, err
+}
+
+// Parses the next top-level node and unmarshals it to `v`, see
+// (*Node).Unmarshal. Returns io.EOF if there are no more nodes.
+func (d *Decoder) Decode(v interface{}) error {
+	n, err := d.Next()
+	if err != nil {
+		return err
+	}
+	return n.unmarshal_with(v, d.opts)
+}
+
+// Sets the options used by Decode and DecodeList to unmarshal values.
+func (d *Decoder) SetUnmarshalOptions(opts ...UnmarshalOption) {
+	d.opts = new_unmarshal_options(opts)
+}
+
+// Parses the next top-level node, which must be a list, calling `f` for each
+// of its children as soon as it's parsed. Used by DecodeList.
+func (d *Decoder) each_child(f func(*Node) error) error {
+	var ferr error
+	err := d.protect(func() {
+		p := &d.p
+		p.skip_spaces()
+		for p.is_comment() {
+			p.skip_comment()
+			p.skip_spaces()
+		}
+		if p.cur == 0 && p.curlen == 0 {
+			panic(io.EOF)
+		}
+		if _, ok := p.list_open[p.cur]; !ok && p.cur != '(' {
+			p.error(p.f.Encode(p.offset), "list expected")
+		}
+		close := p.closing_delim(p.cur)
+		save := p.advance_delim_state()
+		p.next() // skip opening delimiter
+		for {
+			p.skip_spaces()
+			if p.cur == close {
+				// skip enclosing delimiter, but it could be EOF also
+				p.restore_delim_state(save)
+				p.next()
+				return
+			}
+			if p.cur == ')' || p.list_close[p.cur] {
+				p.error(p.f.Encode(p.offset), "unexpected '%c', '%c' expected", p.cur, close)
+			}
+			node := p.parse_node()
+			if node == nil {
+				continue
+			}
+			if ferr = f(node); ferr != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if ferr != nil {
+		// the rest of the list is not consumed, can't continue
+		d.err = ferr
+	}
+	return ferr
+}
@@ -0,0 +1,39 @@
+package sexp
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	d := NewDecoder(strings.NewReader("((x 1) (y 2))\n((x 3))"), nil)
+	var points []point
+	for {
+		var p point
+		err := d.Decode(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		points = append(points, p)
+	}
+	if len(points) != 2 || points[0] != (point{1, 2}) || points[1] != (point{3, 0}) {
+		t.Errorf("unexpected points: %v", points)
+	}
+	if err := d.Decode(new(point)); err != io.EOF {
+		t.Errorf("io.EOF is expected to be sticky, got: %v", err)
+	}
+
+	d = NewDecoder(strings.NewReader("a ) b"), nil)
+	if n, err := d.Next(); err != nil || n.Value != "a" {
+		t.Fatalf("unexpected result: %v, %v", n, err)
+	}
+	_, err := d.Next()
+	error_must_contain(t, err, `unexpected '\)'`)
+}
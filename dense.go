@@ -0,0 +1,113 @@
+package sexp
+
+// An alternative representation of the tree, where children of a list are
+// stored in a slice instead of being linked via the Next pointers. It's more
+// compact and much faster to walk, which matters for the code processing
+// large trees, but it cannot be modified as easily. Use NewDenseNode and
+// (*DenseNode).Node to convert between the representations.
+//
+// Just like with Node, a list node has empty value and at least one child, a
+// scalar node has no children.
+type DenseNode struct {
+	Location SourceLoc
+	Value    string
+	Children []DenseNode
+	Kind     NodeKind
+}
+
+// Returns true if the node is a list (has children).
+func (d *DenseNode) IsList() bool {
+	return len(d.Children) != 0
+}
+
+// Returns true if the node is a scalar (has no children).
+func (d *DenseNode) IsScalar() bool {
+	return len(d.Children) == 0
+}
+
+// Converts the node and its children to the dense representation, siblings
+// of the node are not converted. All the children in the tree are allocated
+// in a single slice, the children of each list are laid out contiguously.
+func NewDenseNode(n *Node) DenseNode {
+	total := 0
+	count_nodes(n.Children, &total)
+	buf := make([]DenseNode, total)
+	d := DenseNode{Location: n.Location, Value: n.Value, Kind: n.Kind}
+	d.Children = dense_children(n.Children, &buf)
+	return d
+}
+
+func count_nodes(n *Node, total *int) {
+	for ; n != nil; n = n.Next {
+		*total++
+		count_nodes(n.Children, total)
+	}
+}
+
+// Converts the sibling chain, slices are carved from `buf`.
+func dense_children(n *Node, buf *[]DenseNode) []DenseNode {
+	num := 0
+	for c := n; c != nil; c = c.Next {
+		num++
+	}
+	if num == 0 {
+		return nil
+	}
+	out := (*buf)[:num:num]
+	*buf = (*buf)[num:]
+	i := 0
+	for c := n; c != nil; c = c.Next {
+		out[i] = DenseNode{Location: c.Location, Value: c.Value, Kind: c.Kind}
+		i++
+	}
+	i = 0
+	for c := n; c != nil; c = c.Next {
+		out[i].Children = dense_children(c.Children, buf)
+		i++
+	}
+	return out
+}
+
+// Converts the node and its children to the regular representation, all the
+// nodes are allocated in a single slice.
+func (d *DenseNode) Node() *Node {
+	total := 1
+	count_dense_nodes(d, &total)
+	buf := make([]Node, total)
+	n := &buf[0]
+	buf = buf[1:]
+	n.Location = d.Location
+	n.Value = d.Value
+	n.Kind = d.Kind
+	n.Children = linked_children(d.Children, &buf)
+	return n
+}
+
+func count_dense_nodes(d *DenseNode, total *int) {
+	*total += len(d.Children)
+	for i := range d.Children {
+		count_dense_nodes(&d.Children[i], total)
+	}
+}
+
+// Converts the children slice, nodes are taken from `buf`.
+func linked_children(children []DenseNode, buf *[]Node) *Node {
+	if len(children) == 0 {
+		return nil
+	}
+	nodes := (*buf)[:len(children)]
+	*buf = (*buf)[len(children):]
+	for i := range children {
+		n := &nodes[i]
+		n.Location = children[i].Location
+		n.Value = children[i].Value
+		n.Kind = children[i].Kind
+		if i+1 < len(nodes) {
+			n.Next = &nodes[i+1]
+		}
+	}
+	for i := range children {
+		nodes[i].Children = linked_children(children[i].Children, buf)
+	}
+	return &nodes[0]
+}
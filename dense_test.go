@@ -0,0 +1,35 @@
+package sexp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDenseNode(t *testing.T) {
+	root, err := Parse(strings.NewReader("(a (b c) ()) d ((e))"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDenseNode(root)
+	if len(d.Children) != 3 || !d.Children[0].IsList() || d.Children[1].Value != "d" {
+		t.Fatalf("unexpected dense tree: %+v", d)
+	}
+	a := d.Children[0]
+	if len(a.Children) != 3 || a.Children[1].Children[1].Value != "c" || !a.Children[2].IsScalar() {
+		t.Errorf("unexpected dense list: %+v", a)
+	}
+	if loc := a.Children[1].Children[1].Location; loc != 6 {
+		t.Errorf("location 6 expected, got %d", loc)
+	}
+
+	n := d.Node()
+	if !trees_equal(n, root) {
+		t.Error("round trip mismatch")
+	}
+	if err := ValidateTree(n); err != nil {
+		t.Error(err)
+	}
+	if n.Next != nil || n.Children.Next.Next.Next != nil {
+		t.Error("unexpected siblings")
+	}
+}
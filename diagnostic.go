@@ -0,0 +1,76 @@
+package sexp
+
+// Severity of a Diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "unknown"
+}
+
+// Diagnostic is a uniform representation of problems found in a document:
+// syntax errors, unmarshaling errors, schema violations and parser warnings.
+// It's meant for tools which report problems to users, e.g. linters and
+// editor integrations. Location is the primary location of the problem,
+// Related holds secondary ones, e.g. the end of an unclosed list, see
+// ParseError. Locations can be decoded using the SourceContext used for
+// parsing, Beautify accepts diagnostics too.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Location SourceLoc
+	Related  []SourceLoc
+}
+
+// Satisfy the built-in error interface. Returns the message (without source
+// location and severity).
+func (d *Diagnostic) Error() string {
+	return d.Message
+}
+
+// Returns the error as an error diagnostic.
+func (e *ParseError) Diagnostic() *Diagnostic {
+	return &Diagnostic{
+		Severity: SeverityError,
+		Message:  e.message,
+		Location: e.Location,
+		Related:  e.Related,
+	}
+}
+
+// Returns the error as an error diagnostic located at the node. If the error
+// has no node, the location is zero.
+func (e *UnmarshalError) Diagnostic() *Diagnostic {
+	d := &Diagnostic{Severity: SeverityError, Message: e.Error()}
+	if e.Node != nil {
+		d.Location = e.Node.Location
+	}
+	return d
+}
+
+// Converts *ParseError, *UnmarshalError and *LoadError (using the error it
+// wraps) to a diagnostic, returns *Diagnostic as is. Returns false for other
+// errors.
+func AsDiagnostic(err error) (*Diagnostic, bool) {
+	switch e := err.(type) {
+	case *Diagnostic:
+		return e, true
+	case *ParseError:
+		return e.Diagnostic(), true
+	case *UnmarshalError:
+		return e.Diagnostic(), true
+	case *LoadError:
+		return AsDiagnostic(e.Err)
+	}
+	return nil, false
+}
@@ -0,0 +1,55 @@
+package sexp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	src := "(a\n  (b c)"
+	var ctx SourceContext
+	f := ctx.AddFile("test.sexp", len(src))
+	_, err := Parse(strings.NewReader(src), f)
+	d, ok := AsDiagnostic(err)
+	if !ok {
+		t.Fatalf("diagnostic expected, got: %v", err)
+	}
+	if d.Severity != SeverityError || d.Location != 0 || len(d.Related) != 1 || d.Related[0] != 10 {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	getcont := func(string) []byte { return []byte(src) }
+	out := Beautify(d, getcont, &ctx, false)
+	must_contain(t, out, `^test.sexp:1:1: error: missing matching sequence delimiter '\)'`)
+
+	d.Severity = SeverityWarning
+	out = Beautify(d, getcont, &ctx, false)
+	must_contain(t, out, `^test.sexp:1:1: warning: missing`)
+
+	root, err := Parse(strings.NewReader("((x 1) (y 2))"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ X, Y bool }
+	d, ok = AsDiagnostic(root.Children.Unmarshal(&v))
+	if !ok || d.Location != 4 || !strings.HasPrefix(d.Message, "x: undefined boolean value") {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+
+	schema_src, err := Parse(strings.NewReader("(root (record (x int) (y bool)))"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ParseSchema(schema_src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := schema.Diagnostics(root.Children)
+	if len(diags) != 1 || diags[0].Location != 10 || diags[0].Severity != SeverityError {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+
+	if _, ok := AsDiagnostic(errors.New("x")); ok {
+		t.Errorf("unexpected diagnostic for a plain error")
+	}
+}
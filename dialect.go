@@ -0,0 +1,180 @@
+package sexp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Dialect selects the lexical conventions of the output of WriteDialect.
+type Dialect int
+
+const (
+	// The syntax of this package, the output is the same as WriteTo's.
+	DialectDefault Dialect = iota
+
+	// The syntax of Scheme (R7RS) data: atoms which are Scheme literals
+	// (`#t`, `#\a`, `#x1F`) are written as is, other atoms which cannot be
+	// written bare are written as `|...|` symbols, strings use Scheme escape
+	// sequences, `(quote x)` is written as `'x`, similarly for quasiquote,
+	// unquote and unquote-splicing. Atoms read from Scheme sources, like
+	// `'x` (which is a single atom for this package), are written back as
+	// they were, atoms with quote characters elsewhere, like `a,b`, are
+	// written as `|...|` symbols.
+	DialectScheme
+)
+
+// Writes the node (without its siblings) on a single line, like WriteTo, but
+// following the conventions of the dialect, which allows read-transform-write
+// workflows for data files of other Lisps.
+func (n *Node) WriteDialect(w io.Writer, d Dialect) (int64, error) {
+	if err := ValidateTree(n); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	switch d {
+	case DialectDefault:
+		write_node(&buf, n)
+	case DialectScheme:
+		write_scheme_node(&buf, n)
+	default:
+		panic(fmt.Sprintf("sexp: unknown dialect %d", d))
+	}
+	return buf.WriteTo(w)
+}
+
+var scheme_quote_prefixes = map[string]string{
+	"quote":            "'",
+	"quasiquote":       "`",
+	"unquote":          ",",
+	"unquote-splicing": ",@",
+}
+
+func write_scheme_node(buf *bytes.Buffer, n *Node) {
+	if n.IsScalar() {
+		buf.WriteString(quote_scheme_scalar(n))
+		return
+	}
+	if head := n.Children; head.IsScalar() && head.Kind == NodeSymbol &&
+		head.Next != nil && head.Next.Next == nil {
+		if prefix, ok := scheme_quote_prefixes[head.Value]; ok {
+			buf.WriteString(prefix)
+			write_scheme_node(buf, head.Next)
+			return
+		}
+	}
+	buf.WriteByte('(')
+	for c := n.Children; c != nil; c = c.Next {
+		if c != n.Children {
+			buf.WriteByte(' ')
+		}
+		write_scheme_node(buf, c)
+	}
+	buf.WriteByte(')')
+}
+
+func quote_scheme_scalar(n *Node) string {
+	switch n.Kind {
+	case NodeString, NodeRawString:
+		return quote_scheme_string(n.Value)
+	case NodeList:
+		return "()"
+	}
+	s := n.Value
+	if is_scheme_literal(s) || !scheme_atom_needs_quoting(s) {
+		return s
+	}
+	if !utf8.ValidString(s) {
+		return quote_scheme_string(s)
+	}
+	return "|" + symbol_escaper.Replace(s) + "|"
+}
+
+func scheme_atom_needs_quoting(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\r\n()[]{}\"|;\\") {
+		return true
+	}
+	// an atom read from Scheme source, e.g. 'x, is kept as is if it's a
+	// valid datum, quote characters elsewhere would split the atom
+	if rest := trim_scheme_quote_prefix(s); rest != s {
+		return !is_scheme_literal(rest) && scheme_atom_needs_quoting(rest)
+	}
+	if strings.HasPrefix(s, "#") || strings.ContainsAny(s, "'`,") {
+		return true
+	}
+	for _, r := range s {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strips a single quote prefix of the atom: ', `, , or ,@.
+func trim_scheme_quote_prefix(s string) string {
+	switch {
+	case strings.HasPrefix(s, ",@"):
+		return s[2:]
+	case s != "" && strings.IndexByte("'`,", s[0]) != -1:
+		return s[1:]
+	}
+	return s
+}
+
+// Returns true if the atom is a Scheme boolean, character or a number with a
+// radix or exactness prefix.
+func is_scheme_literal(s string) bool {
+	switch s {
+	case "#t", "#f", "#true", "#false":
+		return true
+	}
+	if rest := strings.TrimPrefix(s, `#\`); rest != s {
+		// a single character or a character name, e.g. #\space or #\x41
+		if utf8.RuneCountInString(rest) == 1 {
+			return true
+		}
+		return rest != "" && strings.Trim(rest, scheme_alnum) == ""
+	}
+	if len(s) > 2 && s[0] == '#' && strings.IndexByte("xXbBoOdDeEiI", s[1]) != -1 {
+		return strings.Trim(s[2:], scheme_number_chars) == ""
+	}
+	return false
+}
+
+const scheme_alnum = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+const scheme_number_chars = "0123456789abcdefABCDEF.+-/#xXbBoOdDeEiI"
+
+// Quotes the string using the escape sequences of R7RS, other non-printable
+// characters are written as hex escapes, e.g. `\x7f;`.
+func quote_scheme_string(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == utf8.RuneError && size == 1:
+			// there is no way to write a raw byte, write its value
+			fmt.Fprintf(&buf, `\x%x;`, s[i])
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(&buf, `\x%x;`, r)
+		default:
+			buf.WriteRune(r)
+		}
+		i += size
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,40 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func test_dialect(t *testing.T, src, gold string, d Dialect, opts ...ParseOption) {
+	t.Helper()
+	root, err := Parse(strings.NewReader(src), nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for c := root.Children; c != nil; c = c.Next {
+		if c != root.Children {
+			buf.WriteByte(' ')
+		}
+		if _, err := c.WriteDialect(&buf, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.String() != gold {
+		t.Errorf("%s != %s", buf.String(), gold)
+	}
+}
+
+func TestWriteDialect(t *testing.T) {
+	test_dialect(t, `(a "b c" #t)`, `(a "b c" "#t")`, DialectDefault)
+
+	test_dialect(t, `(define (f x) (if x #t #false)) (display #\a #\space) 'x`,
+		`(define (f x) (if x #t #false)) (display #\a #\space) 'x`, DialectScheme)
+	test_dialect(t, `(#x1F #e1.5 #b101 #foo "#t")`, `(#x1F #e1.5 #b101 |#foo| "#t")`, DialectScheme)
+	test_dialect(t, `(quote (a b)) (quasiquote (a (unquote b) (unquote-splicing c))) (quote a b) ("quote" a)`,
+		"'(a b) `(a ,b ,@c) (quote a b) (\"quote\" a)", DialectScheme)
+	test_dialect(t, `("a\"b\\c\n\x01é" |hello world| "" |a\|b|)`,
+		`("a\"b\\c\n\x1;é" |hello world| "" |a\|b|)`, DialectScheme, PipedSymbols())
+	test_dialect(t, "(a,b x' c`d ' ,@x '#t 'a'b ,'x)", "(|a,b| |x'| |c`d| |'| ,@x '#t |'a'b| ,'x)", DialectScheme)
+}
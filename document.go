@@ -0,0 +1,249 @@
+package sexp
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+// Document keeps the source of a file along with its parse tree, it's meant
+// for editors which re-parse the file after every change. Edit re-parses only
+// the top-level expressions affected by a change, the rest of the tree is
+// kept, with locations shifted as necessary.
+//
+// Locations of the nodes are decoded using the document's SourceContext,
+// which is replaced on every edit, hence locations must not be decoded using
+// a context obtained before the last edit.
+//
+// The ExactlyOne, SharedLabels and Decompress options are not supported,
+// warnings are not reported.
+type Document struct {
+	name   string
+	src    []byte
+	opts   []ParseOption
+	ctx    *SourceContext
+	root   *Node
+	forms  []doc_form // top-level expressions in the source order
+	broken bool       // the last edit resulted in a syntax error
+}
+
+// The span of a top-level expression and the nodes it was parsed into.
+type doc_form struct {
+	start, end  int
+	first, last *Node
+}
+
+// Parses `src` as a document, see Parse for the meaning of options. The
+// `filename` is used for the document's SourceContext.
+func NewDocument(filename string, src []byte, opts ...ParseOption) (*Document, error) {
+	var o parse_options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.exactly_one || o.shared_labels || o.decompress || o.bind_context {
+		return nil, errors.New("ExactlyOne, SharedLabels, Decompress, BindContext and DocComments options are not supported by Document")
+	}
+
+	d := &Document{name: filename, opts: opts, root: &Node{Kind: NodeList}}
+	if err := d.Edit(0, 0, string(src)); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Returns the current source of the document.
+func (d *Document) Source() []byte {
+	return d.src
+}
+
+// Returns the virtual list node holding the top-level expressions just like
+// the one returned by Parse, or nil if the last edit resulted in a syntax
+// error.
+func (d *Document) Root() *Node {
+	if d.broken {
+		return nil
+	}
+	return d.root
+}
+
+// Returns the source context the locations of the nodes belong to.
+func (d *Document) Context() *SourceContext {
+	return d.ctx
+}
+
+// Replaces `removed` bytes at `offset` with `text` and re-parses the affected
+// top-level expressions, which are replaced within the tree. Nodes of the
+// unaffected expressions are kept, their locations are updated.
+//
+// If the new source contains a syntax error, it's returned as *ParseError.
+// The edit is applied nevertheless and Root returns nil until an edit fixes
+// the error, the whole document is re-parsed by that edit.
+func (d *Document) Edit(offset, removed int, text string) error {
+	if offset < 0 || removed < 0 || offset+removed > len(d.src) {
+		return errors.New("edit is out of the document bounds")
+	}
+	src := make([]byte, 0, len(d.src)-removed+len(text))
+	src = append(src, d.src[:offset]...)
+	src = append(src, text...)
+	src = append(src, d.src[offset+removed:]...)
+	delta := len(text) - removed
+	d.src = src
+	d.update_context()
+
+	old := d.forms
+	if d.broken {
+		// nothing to reuse
+		old = nil
+	}
+
+	// re-parse starting from the end of the last expression preceding the
+	// edit, spaces and comments in between could be affected as well
+	first := sort.Search(len(old), func(i int) bool {
+		return old[i].end >= offset
+	})
+	start := 0
+	if first > 0 {
+		start = old[first-1].end
+	}
+
+	// re-parse until the end of an expression matches the end of an old
+	// expression following the edit, the rest of the source is unchanged
+	// then
+	edit_end := offset + len(text)
+	s := Scanner{src: src, pos: start, parse_options: d.parse_options()}
+	var forms []doc_form
+	rest := len(old)
+	for synced := false; !synced; {
+		fstart, fend, ok := next_form(&s)
+		if !ok {
+			break
+		}
+		f, err := d.parse_form(fstart, fend)
+		if err != nil {
+			d.broken = true
+			return err
+		}
+		forms = append(forms, f)
+		if fend >= edit_end {
+			i := sort.Search(len(old), func(i int) bool {
+				return old[i].end >= fend-delta
+			})
+			if i < len(old) && i >= first && old[i].end == fend-delta {
+				rest = i + 1
+				synced = true
+			}
+		}
+	}
+
+	for i := rest; i < len(old); i++ {
+		f := &old[i]
+		f.start += delta
+		f.end += delta
+		for n := f.first; ; n = n.Next {
+			shift_locations(n, delta)
+			if n == f.last {
+				break
+			}
+		}
+	}
+	d.forms = append(append(append([]doc_form(nil), old[:first]...), forms...), old[rest:]...)
+	d.relink()
+	return nil
+}
+
+func (d *Document) parse_options() parse_options {
+	var o parse_options
+	o.semicolon_comments = true
+	for _, opt := range d.opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Creates a new source context for the current source.
+func (d *Document) update_context() {
+	d.ctx = new(SourceContext)
+	f := d.ctx.AddFile(d.name, len(d.src))
+	for i, c := range d.src {
+		if c == '\n' {
+			f.AddLine(i + 1)
+		}
+	}
+}
+
+// Parses the top-level expression at the given span.
+func (d *Document) parse_form(start, end int) (doc_form, error) {
+	f := doc_form{start: start, end: end}
+	root, err := Parse(bytes.NewReader(d.src[start:end]), nil, d.opts...)
+	if err != nil {
+		if perr, ok := err.(*ParseError); ok {
+			perr.Location += SourceLoc(start)
+			for i := range perr.Related {
+				perr.Related[i] += SourceLoc(start)
+			}
+		}
+		return f, err
+	}
+	f.first = root.Children
+	for n := root.Children; n != nil; n = n.Next {
+		shift_locations(n, start)
+		f.last = n
+	}
+	return f, nil
+}
+
+// Links the nodes of all the expressions to the root node, the root node
+// itself is kept.
+func (d *Document) relink() {
+	var chain node_chain
+	for _, f := range d.forms {
+		if f.first == nil {
+			continue
+		}
+		f.last.Next = nil
+		chain.push_all(f.first)
+	}
+	d.root.Children = chain.finish()
+	d.root.ResetIndex()
+	d.broken = false
+}
+
+// Returns the span of the next top-level expression, spaces and comments
+// preceding it are skipped. A list extends to the matching ')' or to the end
+// of input.
+func next_form(s *Scanner) (start, end int, ok bool) {
+	depth := 0
+	for {
+		tok := s.Scan()
+		switch tok.Kind {
+		case TokenEOF:
+			return start, tok.End, depth != 0
+		case TokenSpace, TokenComment:
+			continue
+		case TokenOpen:
+			if depth == 0 {
+				start = tok.Start
+			}
+			depth++
+			continue
+		case TokenClose:
+			if depth > 0 {
+				depth--
+				if depth == 0 {
+					return start, tok.End, true
+				}
+				continue
+			}
+		}
+		if depth == 0 {
+			return tok.Start, tok.End, true
+		}
+	}
+}
+
+func shift_locations(n *Node, delta int) {
+	n.Location = SourceLoc(int(n.Location) + delta)
+	for c := n.Children; c != nil; c = c.Next {
+		shift_locations(c, delta)
+	}
+}
@@ -0,0 +1,70 @@
+package sexp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func trees_equal(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Location != b.Location || a.Value != b.Value || a.Kind != b.Kind || !trees_equal(a.Children, b.Children) {
+		return false
+	}
+	return trees_equal(a.Next, b.Next)
+}
+
+// Applies the edit and compares the tree with the one produced by Parse.
+func test_document_edit(t *testing.T, d *Document, offset, removed int, text string) {
+	if err := d.Edit(offset, removed, text); err != nil {
+		t.Errorf("%q: %s", d.Source(), err)
+		return
+	}
+	gold, err := Parse(bytes.NewReader(d.Source()), nil, d.opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trees_equal(d.Root().Children, gold.Children) {
+		t.Errorf("%q: tree mismatch", d.Source())
+	}
+}
+
+func TestDocument(t *testing.T) {
+	d, err := NewDocument("doc.sexp", []byte("(a 1)\n(b (c 2)) ; comment\n(d 3)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := d.Root().Children
+	last := first.Next.Next
+
+	test_document_edit(t, d, 10, 1, "42")
+	if d.Root().Children != first || d.Root().Children.Next.Next != last {
+		t.Error("unaffected expressions must be kept")
+	}
+	if loc := d.Context().Decode(last.Location); loc.Line != 3 || loc.Offset != 27 {
+		t.Errorf("unexpected location of the last expression: %+v", loc)
+	}
+
+	test_document_edit(t, d, 0, 0, "x ")
+	test_document_edit(t, d, 3, 0, "y")
+	test_document_edit(t, d, 7, 3, "")        // joins the first two expressions
+	test_document_edit(t, d, 7, 0, ")\n(")    // splits them back
+	test_document_edit(t, d, 22, 0, "\n")     // within the comment
+	test_document_edit(t, d, 20, 1, "")       // removes ';'
+	test_document_edit(t, d, 20, 0, "\"(\" ") // a string with a parenthesis
+	test_document_edit(t, d, len(d.Source()), 0, "(e)")
+	test_document_edit(t, d, 0, len(d.Source()), "")
+
+	err = d.Edit(0, 0, "(a")
+	error_must_contain(t, err, "missing matching sequence delimiter")
+	if d.Root() != nil {
+		t.Error("Root must return nil after a syntax error")
+	}
+	test_document_edit(t, d, 2, 0, ")")
+
+	_, err = NewDocument("", nil, SharedLabels())
+	error_must_contain(t, err, "not supported")
+	err = d.Edit(5, 0, "a")
+	error_must_contain(t, err, "out of the document bounds")
+}
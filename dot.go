@@ -0,0 +1,52 @@
+package sexp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Writes the tree rooted at `root` (without its siblings) as a Graphviz graph
+// in the DOT language. Every node becomes a graph vertex labeled with its
+// value (atoms) or "()" (lists) followed by its raw SourceLoc, edges go from
+// lists to their children in order. Trees with cycles or shared nodes are
+// rejected, see ValidateTree. Render it with something like:
+//
+//     dot -Tsvg tree.dot > tree.svg
+func WriteDot(w io.Writer, root *Node) error {
+	if err := ValidateTree(root); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	d := dot_writer{w: bw}
+	fmt.Fprintf(bw, "digraph sexp {\n")
+	fmt.Fprintf(bw, "\tnode [fontname=monospace];\n")
+	d.write_node(root)
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+type dot_writer struct {
+	w    *bufio.Writer
+	next int
+}
+
+// Writes the vertex for the node and the subtree below it, returns the vertex
+// id.
+func (d *dot_writer) write_node(n *Node) int {
+	id := d.next
+	d.next++
+	if n.IsList() {
+		fmt.Fprintf(d.w, "\tn%d [shape=box, label=%s];\n", id,
+			strconv.Quote(fmt.Sprintf("() @%d", n.Location)))
+	} else {
+		fmt.Fprintf(d.w, "\tn%d [label=%s];\n", id,
+			strconv.Quote(fmt.Sprintf("%s @%d", strconv.Quote(n.Value), n.Location)))
+	}
+	for c := n.Children; c != nil; c = c.Next {
+		cid := d.write_node(c)
+		fmt.Fprintf(d.w, "\tn%d -> n%d;\n", id, cid)
+	}
+	return id
+}
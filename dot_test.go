@@ -0,0 +1,32 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDot(t *testing.T) {
+	root, err := Parse(strings.NewReader(`(a "b c")`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteDot(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph sexp {
+	node [fontname=monospace];
+	n0 [shape=box, label="() @0"];
+	n1 [shape=box, label="() @0"];
+	n2 [label="\"a\" @1"];
+	n1 -> n2;
+	n3 [label="\"b c\" @3"];
+	n1 -> n3;
+	n0 -> n1;
+}
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
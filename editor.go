@@ -0,0 +1,232 @@
+package sexp
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+// Editor makes minimal edits to a source: nodes of its tree are given new
+// values and the output differs from the source only in the bytes the edited
+// nodes span, formatting and comments elsewhere are left untouched. It's the
+// safest way of modifying configuration files maintained by humans:
+//
+//     e, err := sexp.NewEditor(src)
+//     // ...
+//     port, err := sexp.ResolvePath(e.Root(), "server/port/1")
+//     // ...
+//     e.Set(port, "8081")
+//     out, err := e.Bytes()
+//
+// The SharedLabels and Decompress options are not supported.
+type Editor struct {
+	src      []byte
+	opts     parse_options
+	root     *Node
+	edits    map[*Node]bool
+	inserts  []editor_span
+	inserted map[*Node]bool // nodes of the inserted subtrees
+}
+
+// Parses `src`, see Parse for the meaning of options.
+func NewEditor(src []byte, opts ...ParseOption) (*Editor, error) {
+	e := &Editor{
+		src:      src,
+		edits:    make(map[*Node]bool),
+		inserted: make(map[*Node]bool),
+	}
+	e.opts.semicolon_comments = true
+	for _, opt := range opts {
+		opt(&e.opts)
+	}
+	if e.opts.shared_labels || e.opts.decompress {
+		return nil, errors.New("SharedLabels and Decompress options are not supported by Editor")
+	}
+	root, err := Parse(bytes.NewReader(src), nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	e.root = root
+	return e, nil
+}
+
+// Returns the virtual list node holding the top-level expressions of the
+// source, see Parse. Its nodes are the ones to be edited.
+func (e *Editor) Root() *Node {
+	return e.root
+}
+
+// Sets the value of the scalar node, the value is written in the form of the
+// node, see NodeKind.
+func (e *Editor) Set(n *Node, value string) {
+	if n.IsList() {
+		panic("sexp: Editor.Set on a list node, use Replace")
+	}
+	n.Value = value
+	if n.Kind == NodeInt || n.Kind == NodeFloat {
+		n.Kind = atom_kind(value)
+	}
+	e.edits[n] = true
+}
+
+// Replaces the node with a copy of `with`, which is written on a single line
+// (see WriteTo) in place of the node. The node keeps its location and
+// siblings.
+func (e *Editor) Replace(n, with *Node) {
+	c := copy_tree(with)
+	n.Value, n.Children, n.Kind = c.Value, c.Children, c.Kind
+	e.edits[n] = true
+}
+
+// Inserts a copy of `pair` into the list after its last child starting with
+// `key`, e.g. `(port 8080)` for the key "port", or after the last child if
+// there is no such child. Returns the inserted copy, it can be edited
+// further.
+//
+// The insertion follows the formatting of the anchor child: if it's on a line
+// of its own, the pair is written on a new line with the same indentation
+// after the anchor and a comment following it on the same line, if any;
+// otherwise the pair is written after the anchor separated by a space.
+func (e *Editor) InsertChildAfterKey(list *Node, key string, pair *Node) *Node {
+	if list.IsScalar() && list.Kind != NodeList {
+		panic("sexp: Editor.InsertChildAfterKey on a scalar node")
+	}
+	var anchor *Node
+	for c := list.Children; c != nil; c = c.Next {
+		if name, ok := query_name_of(c); ok && name == key || c.Next == nil && anchor == nil {
+			anchor = c
+		}
+	}
+
+	n := copy_tree(pair)
+	e.mark_inserted(n)
+	if anchor == nil {
+		list.Children = n
+		list.ResetIndex()
+		if list == e.root {
+			// the root has no delimiters, the source may contain comments
+			// only, the pair goes on a line of its own at the end
+			offset := len(e.src)
+			prefix := ""
+			if offset != 0 && e.src[offset-1] != '\n' {
+				prefix = "\n"
+			}
+			e.inserts = append(e.inserts, editor_span{start: offset, end: offset, prefix: prefix, n: n})
+			return n
+		}
+		// empty list, right after the opening delimiter
+		offset := e.span_of(list).start + 1
+		e.inserts = append(e.inserts, editor_span{start: offset, end: offset, n: n})
+		return n
+	}
+	n.Next = anchor.Next
+	anchor.Next = n
+	list.ResetIndex()
+
+	if e.inserted[anchor] {
+		// goes right after the inserted anchor, formatted the same way
+		for _, s := range e.inserts {
+			if s.n == anchor {
+				if s.prefix == "" {
+					s.prefix = " "
+				}
+				s.n = n
+				e.inserts = append(e.inserts, s)
+				break
+			}
+		}
+		return n
+	}
+	span := e.span_of(anchor)
+	line := bytes.LastIndexByte(e.src[:span.start], '\n') + 1
+	indent := e.src[line:span.start]
+	if len(bytes.TrimLeft(indent, " \t")) != 0 {
+		e.inserts = append(e.inserts, editor_span{start: span.end, end: span.end, prefix: " ", n: n})
+		return n
+	}
+
+	// skip the rest of the line if there are only spaces and a comment
+	offset := span.end
+	s := Scanner{src: e.src, pos: span.end, parse_options: e.opts}
+	for {
+		tok := s.Scan()
+		if tok.Kind == TokenComment {
+			offset = tok.End
+			continue
+		}
+		if tok.Kind == TokenSpace && bytes.IndexByte(e.src[tok.Start:tok.End], '\n') == -1 {
+			continue
+		}
+		if tok.Kind == TokenSpace || tok.Kind == TokenEOF || tok.Kind == TokenClose {
+			break
+		}
+		// other items follow on the same line
+		offset = span.end
+		indent = nil
+		break
+	}
+	if indent == nil {
+		e.inserts = append(e.inserts, editor_span{start: offset, end: offset, prefix: " ", n: n})
+	} else {
+		e.inserts = append(e.inserts, editor_span{start: offset, end: offset, prefix: "\n" + string(indent), n: n})
+	}
+	return n
+}
+
+func (e *Editor) mark_inserted(n *Node) {
+	e.inserted[n] = true
+	for c := n.Children; c != nil; c = c.Next {
+		e.mark_inserted(c)
+	}
+}
+
+// Returns the span of the node in the source.
+func (e *Editor) span_of(n *Node) editor_span {
+	s := Scanner{src: e.src, pos: int(n.Location), parse_options: e.opts}
+	start, end, _ := next_form(&s)
+	return editor_span{start: start, end: end, n: n}
+}
+
+// A part of the source replaced by the node written with the prefix.
+type editor_span struct {
+	start, end int
+	prefix     string
+	n          *Node
+}
+
+// Returns the source with the edits applied. When both a node and some of
+// its descendants are edited, the node is written as a whole.
+func (e *Editor) Bytes() ([]byte, error) {
+	spans := append([]editor_span(nil), e.inserts...)
+	for n := range e.edits {
+		if !e.inserted[n] {
+			spans = append(spans, e.span_of(n))
+		}
+	}
+	for _, s := range spans {
+		if err := ValidateTree(s.n); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var buf bytes.Buffer
+	last := 0
+	for _, s := range spans {
+		if s.start < last {
+			// within the span of an edited ancestor
+			continue
+		}
+		buf.Write(e.src[last:s.start])
+		buf.WriteString(s.prefix)
+		write_node(&buf, s.n)
+		last = s.end
+	}
+	buf.Write(e.src[last:])
+	return buf.Bytes(), nil
+}
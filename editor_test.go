@@ -0,0 +1,118 @@
+package sexp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEditor(t *testing.T) {
+	src := `; server settings
+(server
+  (port   8080)   ; the port
+  (host "localhost")
+  (users (alice bob)))
+`
+	e, err := NewEditor([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(path string) *Node {
+		n, err := ResolvePath(e.Root(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	e.Set(at("server/port/1"), "8081")
+	e.Set(at("server/host/1"), "example.com")
+	users := at("server/users/1")
+	e.Set(users.Children, "carol")
+	e.Replace(users, &Node{Children: &Node{Value: "dave", Next: &Node{Value: "eve"}}})
+	out, err := e.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gold := `; server settings
+(server
+  (port   8081)   ; the port
+  (host "example.com")
+  (users (dave eve)))
+`
+	if string(out) != gold {
+		t.Errorf("%s != %s", out, gold)
+	}
+
+	if _, err := NewEditor([]byte("(a")); err == nil {
+		t.Error("syntax error expected")
+	}
+}
+
+func TestEditorInsert(t *testing.T) {
+	src := `(server
+  (port 8080)   ; the port
+  (host localhost)
+  (users (alice bob) ()))
+(flat (a 1) (b 2) (c 3))`
+	e, err := NewEditor([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(path string) *Node {
+		n, err := ResolvePath(e.Root(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	pair := func(k, v string) *Node {
+		return &Node{Children: &Node{Value: k, Next: &Node{Value: v}}}
+	}
+	server := at("server")
+	e.InsertChildAfterKey(server, "port", pair("tls", "on"))
+	e.InsertChildAfterKey(server, "tls", pair("cert", "a.pem"))
+	timeout := e.InsertChildAfterKey(server, "nothing", pair("timeout", "5"))
+	e.Set(timeout.Children.Next, "10")
+	e.InsertChildAfterKey(at("flat"), "a", pair("a2", "x"))
+	e.InsertChildAfterKey(at("server/users/2"), "x", &Node{Value: "carol"})
+	out, err := e.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gold := `(server
+  (port 8080)   ; the port
+  (tls on)
+  (cert a.pem)
+  (host localhost)
+  (users (alice bob) (carol))
+  (timeout 10))
+(flat (a 1) (a2 x) (b 2) (c 3))`
+	if string(out) != gold {
+		t.Errorf("%s != %s", out, gold)
+	}
+
+	var buf bytes.Buffer
+	server.WriteTo(&buf)
+	if s := buf.String(); s != "(server (port 8080) (tls on) (cert a.pem) (host localhost) (users (alice bob) (carol)) (timeout 10))" {
+		t.Errorf("unexpected tree: %s", s)
+	}
+
+	// empty root
+	for src, gold := range map[string]string{
+		"":                   "(port 1)",
+		"; only a comment\n": "; only a comment\n(port 1)",
+		"; only a comment":   "; only a comment\n(port 1)",
+	} {
+		e, err := NewEditor([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.InsertChildAfterKey(e.Root(), "port", pair("port", "1"))
+		out, err := e.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != gold {
+			t.Errorf("%q: %q != %q", src, out, gold)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package sexp
+
+import (
+	"bytes"
+	"os"
+	"strings"
+)
+
+// Expander performs variable substitution on a tree. It understands the
+// following constructs:
+//
+//     (define-var name value)   ; defines a variable, the form is removed
+//     (ref name)                ; replaced with a copy of the variable's value
+//     "http://${HOST}:8080"     ; ${NAME} within a value is substituted
+//
+// Variables must be defined before they are referenced (in the document
+// order) and their values can be arbitrary nodes, including lists. The
+// ${NAME} form is substituted by the value of a scalar variable if there is
+// one with that name, otherwise by the environment variable. Use $$ to
+// produce a literal $ character.
+//
+// Zero value is ready to use.
+type Expander struct {
+	// Used for ${NAME} lookups, os.LookupEnv is used if nil.
+	Env func(name string) (string, bool)
+
+	vars map[string]*Node
+}
+
+// Defines a variable programmatically, as if it was defined via define-var.
+func (e *Expander) Define(name string, value *Node) {
+	if e.vars == nil {
+		e.vars = make(map[string]*Node)
+	}
+	e.vars[name] = value
+}
+
+// Expands all the variable related constructs within the children of the
+// list node `root`, modifying the tree in place. Errors are returned as
+// *ParseError.
+func (e *Expander) Expand(root *Node) (err error) {
+	defer catch_parse_error(&err)
+	e.expand_list(root)
+	return nil
+}
+
+// Returns true if the node is a list with a given head symbol.
+func is_directive(n *Node, name string) bool {
+	return n.IsList() && n.Children.IsScalar() && n.Children.Value == name
+}
+
+func (e *Expander) expand_list(list *Node) {
+	var chain node_chain
+	for c := list.Children; c != nil; {
+		next := c.Next
+		if is_directive(c, "define-var") {
+			e.define_var(c)
+		} else {
+			chain.push(e.expand_value(c))
+		}
+		c = next
+	}
+	list.Children = chain.finish()
+	list.ResetIndex()
+}
+
+// Expands a single node, returns the replacement for it.
+func (e *Expander) expand_value(n *Node) *Node {
+	if is_directive(n, "ref") {
+		name := n.Children.Next
+		if name == nil || name.IsList() || name.Next != nil {
+			node_error(n, "ref expects a single variable name")
+		}
+		v, ok := e.vars[name.Value]
+		if !ok {
+			node_error(name, "undefined variable %q", name.Value)
+		}
+		return copy_tree(v)
+	}
+	if n.IsList() {
+		e.expand_list(n)
+	} else if strings.IndexByte(n.Value, '$') != -1 {
+		n.Value = e.expand_string(n)
+	}
+	return n
+}
+
+func (e *Expander) define_var(n *Node) {
+	name := n.Children.Next
+	if name == nil || name.IsList() || name.Next == nil || name.Next.Next != nil {
+		node_error(n, "define-var expects a variable name and a value")
+	}
+	value := e.expand_value(name.Next)
+	value.Next = nil
+	e.Define(name.Value, value)
+}
+
+func (e *Expander) lookup(n *Node, name string) string {
+	if v, ok := e.vars[name]; ok && v.IsScalar() {
+		return v.Value
+	}
+	env := e.Env
+	if env == nil {
+		env = os.LookupEnv
+	}
+	if v, ok := env(name); ok {
+		return v
+	}
+	node_error(n, "undefined variable %q", name)
+	return ""
+}
+
+func (e *Expander) expand_string(n *Node) string {
+	var buf bytes.Buffer
+	s := n.Value
+	for {
+		i := strings.IndexByte(s, '$')
+		if i == -1 || i == len(s)-1 {
+			buf.WriteString(s)
+			return buf.String()
+		}
+		buf.WriteString(s[:i])
+		s = s[i+1:]
+		switch s[0] {
+		case '$':
+			buf.WriteByte('$')
+			s = s[1:]
+		case '{':
+			end := strings.IndexByte(s, '}')
+			if end == -1 {
+				node_error(n, "unterminated variable reference in %q", n.Value)
+			}
+			buf.WriteString(e.lookup(n, s[1:end]))
+			s = s[end+1:]
+		default:
+			buf.WriteByte('$')
+		}
+	}
+}
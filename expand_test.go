@@ -0,0 +1,79 @@
+package sexp
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpander(t *testing.T) {
+	root, err := Parse(strings.NewReader(`
+		(define-var host example.com)
+		(define-var ports (80 443))
+		(url "http://${host}:${PORT}/$$x")
+		(ports (ref ports))
+		(nested ((define-var local 5) (value (ref local))))
+	`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Expander{Env: func(name string) (string, bool) {
+		if name == "PORT" {
+			return "8080", true
+		}
+		return "", false
+	}}
+	if err := e.Expand(root); err != nil {
+		t.Fatal(err)
+	}
+
+	var v struct {
+		URL    string
+		Ports  []int
+		Nested struct{ Value int }
+	}
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.URL != "http://example.com:8080/$x" {
+		t.Errorf("unexpected url: %q", v.URL)
+	}
+	if len(v.Ports) != 2 || v.Ports[1] != 443 || v.Nested.Value != 5 {
+		t.Errorf("unexpected result: %+v", v)
+	}
+}
+
+func TestExpanderErrors(t *testing.T) {
+	test := func(source string) error {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := Expander{Env: func(string) (string, bool) { return "", false }}
+		return e.Expand(root)
+	}
+	error_must_contain(t, test(`(x (ref y))`), `undefined variable "y"`)
+	error_must_contain(t, test(`(x "${Y}")`), `undefined variable "Y"`)
+	error_must_contain(t, test(`(x "${Y")`), `unterminated variable reference`)
+	error_must_contain(t, test(`(define-var x)`), `expects a variable name and a value`)
+	error_must_contain(t, test(`(x (ref))`), `expects a single variable name`)
+}
+
+func TestLoaderExpander(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"main.sexp":  "(define-var name main)\n(include \"other.sexp\")",
+		"other.sexp": "(name (ref name))",
+	})
+	l := Loader{Expander: new(Expander)}
+	root, err := l.Load(filepath.Join(dir, "main.sexp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ Name string }
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "main" {
+		t.Errorf("\"main\" expected, got: %q", v.Name)
+	}
+}
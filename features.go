@@ -0,0 +1,51 @@
+package sexp
+
+// Evaluates conditional sections within the children of the list node `root`
+// against a given feature set, modifying the tree in place:
+//
+//     (when-feature linux (path /usr/lib))   ; kept if "linux" is enabled
+//     (unless-feature debug (level warn))    ; kept if "debug" is disabled
+//
+// The body of a section which holds is spliced into the enclosing list, the
+// body of a section which doesn't is removed along with the section itself.
+// Sections can be nested and can appear at any level. Malformed sections are
+// reported as *ParseError.
+func PruneFeatures(root *Node, features map[string]bool) error {
+	var chain node_chain
+	for c := root.Children; c != nil; {
+		next := c.Next
+		when := is_directive(c, "when-feature")
+		if !when && !is_directive(c, "unless-feature") {
+			if c.IsList() {
+				if err := PruneFeatures(c, features); err != nil {
+					return err
+				}
+			}
+			chain.push(c)
+			c = next
+			continue
+		}
+
+		name := c.Children.Next
+		if name == nil || name.IsList() {
+			return &ParseError{
+				Location: c.Location,
+				message: c.Children.Value +
+					" expects a feature name followed by the body",
+			}
+		}
+		if features[name.Value] == when {
+			// evaluate the body as if it was a list on its own, then
+			// splice it in place of the section
+			body := &Node{Children: name.Next}
+			if err := PruneFeatures(body, features); err != nil {
+				return err
+			}
+			chain.push_all(body.Children)
+		}
+		c = next
+	}
+	root.Children = chain.finish()
+	root.ResetIndex()
+	return nil
+}
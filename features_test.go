@@ -0,0 +1,64 @@
+package sexp
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPruneFeatures(t *testing.T) {
+	test := func(source string, features map[string]bool, gold string) {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PruneFeatures(root, features); err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		format_siblings(&buf, root.Children)
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+
+	linux := map[string]bool{"linux": true}
+	test("a (when-feature linux b c) d", linux, `"a" "b" "c" "d"`)
+	test("a (when-feature windows b c) d", linux, `"a" "d"`)
+	test("a (unless-feature debug b) d", linux, `"a" "b" "d"`)
+	test("(x (when-feature linux (unless-feature linux 1) 2))", linux, `("x" "2")`)
+	test("(when-feature linux (when-feature debug 1) 2) 3", nil, `"3"`)
+
+	root, err := Parse(strings.NewReader("(when-feature (a b) c)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PruneFeatures(root, linux)
+	error_must_contain(t, err, "when-feature expects a feature name")
+}
+
+func TestLoaderFeatures(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"main.sexp": `
+			(when-feature linux (define-var path /usr/lib))
+			(unless-feature linux (define-var path C:/lib))
+			(path (ref path))`,
+	})
+	l := Loader{
+		Features: map[string]bool{"linux": true},
+		Expander: new(Expander),
+	}
+	root, err := l.Load(filepath.Join(dir, "main.sexp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ Path string }
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Path != "/usr/lib" {
+		t.Errorf("\"/usr/lib\" expected, got: %q", v.Path)
+	}
+}
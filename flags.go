@@ -0,0 +1,188 @@
+package sexp
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Named bit of a flag set, see the "flags" struct tag option.
+type flag_bit struct {
+	name string
+	bits uint64
+}
+
+// Registers a named flag set, which can be referred to using the "flags"
+// struct tag option instead of listing the flags in every tag:
+//
+//     type File struct {
+//         Perms uint8 `sexp:"perms,flags=perms"`
+//     }
+//     err := n.UnmarshalWith(&file, sexp.FlagSet("perms", map[string]uint64{
+//         "read": 1, "write": 2, "execute": 4,
+//     }))
+func FlagSet(name string, bits map[string]uint64) UnmarshalOption {
+	set := make([]flag_bit, 0, len(bits))
+	for k, v := range bits {
+		set = append(set, flag_bit{k, v})
+	}
+	sort.Slice(set, func(i, j int) bool {
+		if set[i].bits != set[j].bits {
+			return set[i].bits < set[j].bits
+		}
+		return set[i].name < set[j].name
+	})
+	return func(o *unmarshal_options) {
+		if o.flag_sets == nil {
+			o.flag_sets = make(map[string][]flag_bit)
+		}
+		o.flag_sets[name] = set
+	}
+}
+
+// Parses the value of the "flags" option: "name:bits;name:bits", where bits
+// are in Go integer literal syntax. Returns nil if the syntax is invalid.
+func parse_flags_option(spec string) []flag_bit {
+	var set []flag_bit
+	for _, s := range strings.Split(spec, ";") {
+		i := strings.IndexByte(s, ':')
+		if i <= 0 {
+			return nil
+		}
+		bits, err := strconv.ParseUint(s[i+1:], 0, 64)
+		if err != nil {
+			return nil
+		}
+		set = append(set, flag_bit{s[:i], bits})
+	}
+	return set
+}
+
+// Returns the flags for the "flags" option value, which is either a list of
+// flags or a name of a flag set registered using FlagSet.
+func (o *unmarshal_options) flags(spec string) ([]flag_bit, string) {
+	if !strings.Contains(spec, ":") {
+		if set, ok := o.flag_sets[spec]; ok {
+			return set, ""
+		}
+		return nil, "unknown flag set " + strconv.Quote(spec)
+	}
+	if set := parse_flags_option(spec); set != nil {
+		return set, ""
+	}
+	return nil, "invalid flags option " + strconv.Quote(spec)
+}
+
+// Decodes a field tagged with the "flags" option: a list of flag names (or
+// the names following the key in case of the "siblings" option), which are
+// ORed together. Numeric atoms are taken as raw bits, a single atom is
+// accepted instead of a list as well.
+func (n *Node) unmarshal_flags(v reflect.Value, siblings bool, key *Node, spec string, o *unmarshal_options) {
+	fail := func(n *Node, t reflect.Type, format string, args ...interface{}) {
+		err := NewUnmarshalError(n, t, format, args...)
+		err.Path = "." + key.Value
+		panic(err)
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+	signed := false
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		signed = true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		fail(n, t, "flags field %s must be of an integer type", key.Value)
+	}
+	set, errmsg := o.flags(spec)
+	if set == nil {
+		fail(n, t, "%s", errmsg)
+	}
+
+	c := n
+	if !siblings && n.IsList() {
+		c = n.Children
+	}
+	var bits uint64
+next:
+	for ; c != nil; c = c.Next {
+		if c.IsList() {
+			fail(c, t, "scalar value required")
+		}
+		if c.Value == "" {
+			// `()` is an empty atom
+			continue
+		}
+		for _, f := range set {
+			if f.name == c.Value {
+				bits |= f.bits
+				continue next
+			}
+		}
+		if num, err := strconv.ParseUint(c.Value, 0, 64); err == nil {
+			bits |= num
+			continue
+		}
+		names := make([]string, len(set))
+		for i, f := range set {
+			names[i] = f.name
+		}
+		fail(c, t, "unknown flag, use %s", strings.Join(names, "|"))
+	}
+
+	if signed {
+		if v.OverflowInt(int64(bits)) || int64(bits) < 0 {
+			fail(n, t, "integer overflow")
+		}
+		v.SetInt(int64(bits))
+	} else {
+		if v.OverflowUint(bits) {
+			fail(n, t, "integer overflow")
+		}
+		v.SetUint(bits)
+	}
+}
+
+// Encodes a field tagged with the "flags" option as a list of flag names,
+// bits which are not covered by the flags are written as a number. Flag sets
+// registered using FlagSet are not available to Marshal, such fields are
+// encoded as numbers.
+func marshal_flags(v reflect.Value, spec string) *Node {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	var bits uint64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits = uint64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		bits = v.Uint()
+	default:
+		marshal_error(v.Type(), "flags field must be of an integer type")
+	}
+	if !strings.Contains(spec, ":") {
+		return &Node{Value: strconv.FormatUint(bits, 10)}
+	}
+	set := parse_flags_option(spec)
+	if set == nil {
+		marshal_error(v.Type(), "invalid flags option %q", spec)
+	}
+
+	var chain node_chain
+	for _, f := range set {
+		if f.bits != 0 && bits&f.bits == f.bits {
+			chain.push(&Node{Value: f.name})
+			bits &^= f.bits
+		}
+	}
+	if bits != 0 {
+		chain.push(&Node{Value: strconv.FormatUint(bits, 10)})
+	}
+	return &Node{Children: chain.finish(), Kind: NodeList}
+}
@@ -0,0 +1,49 @@
+package sexp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlags(t *testing.T) {
+	type file struct {
+		Perms uint8 `sexp:"perms,flags=read:1;write:2;execute:4"`
+		Mode  int   `sexp:"mode,siblings,flags=mode"`
+	}
+	modes := FlagSet("mode", map[string]uint64{"a": 1, "b": 2, "ab": 3})
+
+	root, err := Parse(strings.NewReader("((perms (read execute)) (mode b a 8))"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f file
+	if err := root.Children.UnmarshalWith(&f, modes); err != nil {
+		t.Fatal(err)
+	}
+	if f.Perms != 5 || f.Mode != 11 {
+		t.Errorf("unexpected value: %+v", f)
+	}
+
+	test := func(src, what string) {
+		root, err := Parse(strings.NewReader(src), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		error_must_contain(t, root.Children.UnmarshalWith(&f, modes), what)
+	}
+	test("((perms (read exec)))", `^perms: unknown flag, use read\|write\|execute \(value: "exec"\)`)
+	test("((perms (read (write))))", `^perms: scalar value required`)
+	test("((perms 256))", `^perms: integer overflow`)
+	test("((mode c))", `^mode: unknown flag, use a\|b\|ab`)
+
+	empty, _ := Parse(strings.NewReader("((perms ()))"), nil)
+	if err := empty.Children.Unmarshal(&f); err != nil || f.Perms != 0 {
+		t.Errorf("unexpected result: %+v, %v", f, err)
+	}
+	err = root.Children.Unmarshal(&f)
+	error_must_contain(t, err, `unknown flag set "mode"`)
+
+	test_marshal(t, file{Perms: 7, Mode: 3}, "((perms (read write execute)) (mode 3))")
+	test_marshal(t, file{Perms: 0x12}, `((perms (write 16)) (mode 0))`)
+	test_marshal(t, file{}, "((perms ()) (mode 0))")
+}
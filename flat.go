@@ -0,0 +1,86 @@
+package sexp
+
+import "errors"
+
+// A plain value representation of a tree, without pointers, meant for
+// encoding/gob, encoding/json and RPC systems, e.g. to pass parsed trees
+// between the processes of a build pipeline. Use ToFlat and FromFlat to
+// convert between the representations.
+//
+// Nodes are stored in depth-first order, each node is followed by its
+// children (and their descendants), the first node is the root.
+type FlatTree struct {
+	Nodes []FlatNode
+}
+
+// A node of FlatTree, NumChildren is the number of its direct children.
+type FlatNode struct {
+	Location    SourceLoc
+	Value       string
+	Kind        NodeKind
+	NumChildren int
+}
+
+// Converts the node and its children to the flat representation, siblings of
+// the node are not converted.
+func ToFlat(n *Node) FlatTree {
+	total := 1
+	count_nodes(n.Children, &total)
+	t := FlatTree{Nodes: make([]FlatNode, 0, total)}
+	t.add(n)
+	return t
+}
+
+func (t *FlatTree) add(n *Node) {
+	i := len(t.Nodes)
+	t.Nodes = append(t.Nodes, FlatNode{Location: n.Location, Value: n.Value, Kind: n.Kind})
+	for c := n.Children; c != nil; c = c.Next {
+		t.Nodes[i].NumChildren++
+		t.add(c)
+	}
+}
+
+// Converts the flat representation back to a tree, returns its root. The
+// nodes are allocated in a single slice. Returns an error if the tree is
+// malformed, e.g. it was not produced by ToFlat.
+func FromFlat(t FlatTree) (*Node, error) {
+	if len(t.Nodes) == 0 {
+		return nil, errors.New("flat tree has no nodes")
+	}
+	nodes := make([]Node, len(t.Nodes))
+	next := 1
+	var link func(i int) error
+	link = func(i int) error {
+		f := &t.Nodes[i]
+		n := &nodes[i]
+		n.Location, n.Value, n.Kind = f.Location, f.Value, f.Kind
+		if f.NumChildren < 0 {
+			return errors.New("negative number of children of a flat tree node")
+		}
+		var prev *Node
+		for j := 0; j < f.NumChildren; j++ {
+			if next >= len(nodes) {
+				return errors.New("number of children of a flat tree node exceeds the number of nodes")
+			}
+			c := next
+			next++
+			if err := link(c); err != nil {
+				return err
+			}
+			if prev == nil {
+				n.Children = &nodes[c]
+			} else {
+				prev.Next = &nodes[c]
+			}
+			prev = &nodes[c]
+		}
+		return nil
+	}
+	if err := link(0); err != nil {
+		return nil, err
+	}
+	if next != len(nodes) {
+		return nil, errors.New("flat tree has nodes which don't belong to the tree")
+	}
+	return &nodes[0], nil
+}
@@ -0,0 +1,49 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+)
+
+func TestFlat(t *testing.T) {
+	src := "(a \"b\" (c (d)) ()) 1.5"
+	var ctx SourceContext
+	root, err := Parse(strings.NewReader(src), ctx.AddFile("test.sexp", len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := ToFlat(root)
+	if len(flat.Nodes) != 10 || flat.Nodes[0].NumChildren != 2 || flat.Nodes[1].NumChildren != 4 {
+		t.Errorf("unexpected flat tree: %+v", flat)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(flat); err != nil {
+		t.Fatal(err)
+	}
+	var decoded FlatTree
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	out, err := FromFlat(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trees_equal(out, root) {
+		t.Error("converted tree differs from the original")
+	}
+
+	for _, nodes := range [][]FlatNode{
+		nil,
+		{{NumChildren: 1}},
+		{{NumChildren: 1}, {NumChildren: 1}},
+		{{NumChildren: 1}, {}, {}},
+		{{NumChildren: -1}},
+	} {
+		if _, err := FromFlat(FlatTree{Nodes: nodes}); err == nil {
+			t.Errorf("%+v: error expected", nodes)
+		}
+	}
+}
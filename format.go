@@ -0,0 +1,223 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Options for Format, zero value gives the default formatting.
+type FormatOptions struct {
+	// String used for a single level of indentation, two spaces if empty.
+	Indent string
+
+	// Keys of key/value pairs whose values are printed as "<redacted>"
+	// atoms, see (*Node).Redact and SecretKeys. Keys are compared with the
+	// source text of atoms, quoted keys don't match.
+	Redact []string
+}
+
+// Formats S-expressions source code, comments are preserved.
+//
+// The formatting rules are simple. Spacing between items is normalized to a
+// single space. A list which doesn't span multiple lines in the source (the
+// position of the closing parenthesis doesn't matter) is printed on a single
+// line. In a list spanning multiple lines, items which start a new line in
+// the source start a new line in the output as well, properly indented; the
+// first item always follows the opening parenthesis and the closing
+// parenthesis always follows the last item, Lisp style. Consecutive blank
+// lines are collapsed into one. Atoms and strings are printed exactly as they
+// appear in the source.
+//
+// If the source contains a syntax error, it's returned as *ParseError.
+func Format(src []byte, opts FormatOptions) ([]byte, error) {
+	if _, err := Parse(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+
+	f := formatter{indent: opts.Indent}
+	if f.indent == "" {
+		f.indent = "  "
+	}
+	if len(opts.Redact) != 0 {
+		f.redact = make(map[string]bool, len(opts.Redact))
+		for _, k := range opts.Redact {
+			f.redact[k] = true
+		}
+	}
+	l := fmt_lexer{src: src}
+	root := l.parse_items()
+	f.write_root(root)
+	return f.buf.Bytes(), nil
+}
+
+type fmt_kind int
+
+const (
+	fmt_atom fmt_kind = iota
+	fmt_comment
+	fmt_list
+)
+
+type fmt_item struct {
+	kind     fmt_kind
+	text     string      // atoms and comments
+	items    []*fmt_item // lists
+	newlines int         // newlines preceding the item, capped at 2
+}
+
+// A simple lexer which unlike the parser keeps comments and original text of
+// atoms. It expects syntactically valid source.
+type fmt_lexer struct {
+	src []byte
+	pos int
+}
+
+// Skips spaces, returns the number of newlines skipped, capped at 2.
+func (l *fmt_lexer) skip_spaces() int {
+	n := 0
+	for l.pos < len(l.src) && is_space(rune(l.src[l.pos])) {
+		if l.src[l.pos] == '\n' && n < 2 {
+			n++
+		}
+		l.pos++
+	}
+	return n
+}
+
+// Parses items until the end of input or until the closing parenthesis, which
+// is consumed.
+func (l *fmt_lexer) parse_items() []*fmt_item {
+	var items []*fmt_item
+	for {
+		newlines := l.skip_spaces()
+		if l.pos >= len(l.src) {
+			return items
+		}
+
+		var it *fmt_item
+		start := l.pos
+		switch l.src[l.pos] {
+		case ')':
+			l.pos++
+			return items
+		case '(':
+			l.pos++
+			it = &fmt_item{kind: fmt_list, items: l.parse_items()}
+		case ';':
+			end := bytes.IndexByte(l.src[l.pos:], '\n')
+			if end == -1 {
+				end = len(l.src) - l.pos
+			}
+			l.pos += end
+			text := strings.TrimRight(string(l.src[start:l.pos]), " \t\r")
+			it = &fmt_item{kind: fmt_comment, text: text}
+		case '"':
+			l.pos++
+			for l.src[l.pos] != '"' {
+				if l.src[l.pos] == '\\' {
+					l.pos++
+				}
+				l.pos++
+			}
+			l.pos++
+			it = &fmt_item{kind: fmt_atom, text: string(l.src[start:l.pos])}
+		case '`':
+			end := bytes.IndexByte(l.src[l.pos+1:], '`')
+			l.pos += end + 2
+			it = &fmt_item{kind: fmt_atom, text: string(l.src[start:l.pos])}
+		default:
+			for l.pos < len(l.src) && !is_delimiter(rune(l.src[l.pos])) {
+				l.pos++
+			}
+			it = &fmt_item{kind: fmt_atom, text: string(l.src[start:l.pos])}
+		}
+		it.newlines = newlines
+		items = append(items, it)
+	}
+}
+
+// Returns true if the list must be printed on multiple lines.
+func (it *fmt_item) multiline() bool {
+	for i, c := range it.items {
+		switch {
+		case c.kind == fmt_comment:
+			return true
+		case i > 0 && c.newlines > 0:
+			return true
+		case c.kind == fmt_list && c.multiline():
+			return true
+		}
+	}
+	return false
+}
+
+type formatter struct {
+	buf    bytes.Buffer
+	indent string
+	redact map[string]bool // see FormatOptions.Redact
+}
+
+func (f *formatter) newline(blank bool, depth int) {
+	if blank {
+		f.buf.WriteByte('\n')
+	}
+	f.buf.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		f.buf.WriteString(f.indent)
+	}
+}
+
+func (f *formatter) write_root(items []*fmt_item) {
+	f.write_items(items, 0, true)
+	if len(items) != 0 {
+		f.buf.WriteByte('\n')
+	}
+}
+
+// Writes items separated appropriately, the first item is written as is, the
+// following ones which start a new line are indented by `depth` levels.
+func (f *formatter) write_items(items []*fmt_item, depth int, multiline bool) {
+	for i, it := range items {
+		if i > 0 {
+			prev := items[i-1]
+			if multiline && (it.newlines > 0 || prev.kind == fmt_comment) {
+				f.newline(it.newlines > 1, depth)
+			} else {
+				f.buf.WriteByte(' ')
+			}
+		}
+		f.write_item(it, depth)
+	}
+}
+
+func (f *formatter) write_item(it *fmt_item, depth int) {
+	if it.kind != fmt_list {
+		f.buf.WriteString(it.text)
+		return
+	}
+
+	multiline := it.multiline()
+	f.buf.WriteByte('(')
+	f.write_items(f.redact_items(it.items), depth+1, multiline)
+	if n := len(it.items); n != 0 && it.items[n-1].kind == fmt_comment {
+		f.newline(false, depth)
+	}
+	f.buf.WriteByte(')')
+}
+
+// Replaces the values of a key/value pair with a secret key by "<redacted>"
+// atoms, comments are kept.
+func (f *formatter) redact_items(items []*fmt_item) []*fmt_item {
+	if len(items) == 0 || items[0].kind != fmt_atom || !f.redact[items[0].text] {
+		return items
+	}
+	out := make([]*fmt_item, len(items))
+	out[0] = items[0]
+	for i, it := range items[1:] {
+		if it.kind != fmt_comment {
+			it = &fmt_item{kind: fmt_atom, text: redacted_value, newlines: it.newlines}
+		}
+		out[i+1] = it
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package sexp
+
+import (
+	"testing"
+)
+
+func test_format(t *testing.T, opts FormatOptions, source, gold string) {
+	out, err := Format([]byte(source), opts)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(out) != gold {
+		t.Errorf("formatting mismatch, got:\n%s\nexpected:\n%s", out, gold)
+		return
+	}
+
+	// formatting must be idempotent
+	again, err := Format(out, opts)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(again) != string(out) {
+		t.Errorf("formatting is not idempotent, got:\n%s", again)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var opts FormatOptions
+	test_format(t, opts, "", "")
+	test_format(t, opts, "  ( a   b\t(c  d ) )  ", "(a b (c d))\n")
+	test_format(t, opts, "(a\n  b)", "(a\n  b)\n")
+	test_format(t, opts, "(\n a b\n )", "(a b)\n")
+	test_format(t, opts, "(a (b\nc)\n)", "(a (b\n    c))\n")
+	test_format(t, opts, "(a ; comment  \n b)", "(a ; comment\n  b)\n")
+	test_format(t, opts, "(a b ; comment\n)", "(a b ; comment\n)\n")
+	test_format(t, opts, "; header\n\n\n\n(a)\n(b) (c)\n", "; header\n\n(a)\n(b) (c)\n")
+	test_format(t, opts, "(a \"x  y\" `raw\n  text`)", "(a \"x  y\" `raw\n  text`)\n")
+	test_format(t, opts, `("\"(" b)`, "(\"\\\"(\" b)\n")
+	test_format(t, opts, "()", "()\n")
+	test_format(t, FormatOptions{Indent: "\t"}, "(a\n(b\nc))", "(a\n\t(b\n\t\tc))\n")
+
+	test_format(t, opts, config, `(namespace Gtk)
+(version 3.0)
+(blacklist
+  (structs (StockItem))
+  (structdefs (ActionEntry
+      RadioActionEntry
+      ToggleActionEntry))
+  (functions (accelerator_parse_with_keycode
+      binding_entry_add_signal_from_string
+      binding_entry_add_signall
+      binding_entry_remove
+      binding_entry_skip
+      binding_set_find
+      paper_size_get_default
+      paper_size_get_paper_sizes
+      rc_property_parse_border
+      rc_property_parse_color
+      rc_property_parse_enum
+      rc_property_parse_flags
+      rc_property_parse_requisition
+      print_run_page_setup_dialog
+      print_run_page_setup_dialog_async
+      init_with_args
+      stock_add ; implemented manually and renamed to StockAddItems (name clash)
+      stock_lookup ; implemented manually
+      stock_add_static ; doesn't make sense
+      rc_parse_color
+      rc_parse_color_full
+      rc_parse_priority
+      rc_parse_state
+      rc_find_pixmap_in_path
+      stock_set_translate_func
+      tree_row_reference_deleted
+      tree_row_reference_inserted))) ; testing a comment at the end of file
+`)
+
+	_, err := Format([]byte("(a (b)"), opts)
+	error_must_contain(t, err, "missing matching sequence delimiter")
+}
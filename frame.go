@@ -0,0 +1,127 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Default value of FrameReader.MaxSize.
+const DefaultMaxFrameSize = 16 << 20
+
+// FrameWriter writes S-expressions to a stream as frames: each node is
+// serialized using (*Node).WriteTo and prefixed with its length in bytes as a
+// 4 byte big-endian integer. Unlike the plain text stream written by Encoder,
+// framed stream has reliable message boundaries, which makes it suitable for
+// RPC over TCP or Unix sockets. Frames are read by FrameReader.
+//
+// Every frame is written using a single Write call. FrameWriter is not safe
+// for concurrent use.
+type FrameWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// Creates a new frame writer writing to `w`.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+// Writes the node (without its siblings) as a single frame.
+func (fw *FrameWriter) WriteNode(n *Node) error {
+	fw.buf.Reset()
+	fw.buf.Write([]byte{0, 0, 0, 0})
+	if _, err := n.WriteTo(&fw.buf); err != nil {
+		return err
+	}
+	return fw.flush()
+}
+
+// Writes the encoding of `v` as a single frame, see Marshal.
+func (fw *FrameWriter) Encode(v interface{}) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	fw.buf.Reset()
+	fw.buf.Write([]byte{0, 0, 0, 0})
+	fw.buf.Write(data)
+	return fw.flush()
+}
+
+func (fw *FrameWriter) flush() error {
+	frame := fw.buf.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	_, err := fw.w.Write(frame)
+	return err
+}
+
+// FrameReader reads S-expressions from a stream written by FrameWriter, one
+// frame at a time. Each frame must contain exactly one S-expression. Source
+// locations of the returned nodes are relative to the beginning of the frame
+// they come from.
+type FrameReader struct {
+	// Frames larger than that (in bytes) are rejected without reading them,
+	// DefaultMaxFrameSize if zero.
+	MaxSize int
+
+	r    io.Reader
+	opts []ParseOption
+	buf  []byte
+}
+
+// Creates a new frame reader reading from `r`, frames are parsed using given
+// options.
+func NewFrameReader(r io.Reader, opts ...ParseOption) *FrameReader {
+	return &FrameReader{r: r, opts: opts}
+}
+
+// Reads and parses the next frame. Returns io.EOF if the stream ends at a
+// frame boundary, io.ErrUnexpectedEOF if it ends within a frame. Syntax
+// errors are returned as *ParseError, the stream remains usable after them.
+func (fr *FrameReader) Next() (*Node, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:]))
+	max := int64(fr.MaxSize)
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+	if size > max {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the limit of %d bytes", size, max)
+	}
+	if int64(cap(fr.buf)) < size {
+		fr.buf = make([]byte, size)
+	}
+	fr.buf = fr.buf[:size]
+	if _, err := io.ReadFull(fr.r, fr.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	var ctx SourceContext
+	root, err := Parse(bytes.NewReader(fr.buf), ctx.AddFile("", len(fr.buf)), fr.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if root.NumChildren() != 1 {
+		return nil, &ParseError{message: fmt.Sprintf(
+			"frame must contain a single expression, got %d", root.NumChildren())}
+	}
+	return root.Children, nil
+}
+
+// Reads the next frame and unmarshals it to `v`, see (*Node).Unmarshal.
+// Returns io.EOF if there are no more frames.
+func (fr *FrameReader) Decode(v interface{}) error {
+	n, err := fr.Next()
+	if err != nil {
+		return err
+	}
+	return n.Unmarshal(v)
+}
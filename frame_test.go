@@ -0,0 +1,86 @@
+package sexp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	type message struct {
+		Method string   `sexp:"method"`
+		Args   []string `sexp:"args,siblings"`
+	}
+
+	client, server := net.Pipe()
+	go func() {
+		fw := NewFrameWriter(client)
+		fw.Encode(message{"ping", []string{"a b", "c"}})
+		fw.WriteNode(&Node{Value: "x y"})
+		fw.Encode(message{Method: "quit"})
+		client.Close()
+	}()
+
+	fr := NewFrameReader(server)
+	var m message
+	if err := fr.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Method != "ping" || len(m.Args) != 2 || m.Args[0] != "a b" {
+		t.Errorf("unexpected message: %+v", m)
+	}
+	n, err := fr.Next()
+	if err != nil || n.Value != "x y" {
+		t.Errorf("unexpected result: %v, %v", n, err)
+	}
+	if err := fr.Decode(&m); err != nil || m.Method != "quit" {
+		t.Errorf("unexpected result: %+v, %v", m, err)
+	}
+	if _, err := fr.Next(); err != io.EOF {
+		t.Errorf("io.EOF expected, got: %v", err)
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	frame := func(s string) string {
+		n := len(s)
+		return string([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}) + s
+	}
+
+	fr := NewFrameReader(strings.NewReader(frame("a b") + frame("(c") + frame("d") + "\x00\x00"))
+	_, err := fr.Next()
+	error_must_contain(t, err, "single expression, got 2")
+	_, err = fr.Next()
+	error_must_contain(t, err, `missing matching sequence delimiter '\)'`)
+	if n, err := fr.Next(); err != nil || n.Value != "d" {
+		t.Errorf("unexpected result: %v, %v", n, err)
+	}
+	if _, err := fr.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("io.ErrUnexpectedEOF expected, got: %v", err)
+	}
+
+	fr = NewFrameReader(strings.NewReader(frame("(1 2 3)")))
+	fr.MaxSize = 4
+	_, err = fr.Next()
+	error_must_contain(t, err, "frame of 7 bytes exceeds the limit of 4 bytes")
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Encode([]int{1, 2})
+	e.SetIndent("  ")
+	e.Encode(map[string]string{"a": "b", "c": "d"})
+	gold := "(1 2)\n((a b)\n  (c d))\n"
+	if buf.String() != gold {
+		t.Errorf("%q != %q", buf.String(), gold)
+	}
+
+	d := NewDecoder(&buf, nil)
+	var v []int
+	if err := d.Decode(&v); err != nil || len(v) != 2 {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+}
@@ -0,0 +1,264 @@
+package sexp
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Generates Go source code with struct definitions inferred from a sample
+// document. The node `n` must be a list of key/value pairs (typically the root
+// node returned by Parse), it becomes a struct type called Root, every other
+// struct type is named after the key it was found at.
+//
+// Inference rules are simple and mirror what Unmarshal can decode:
+//  - "true" and "false" become bool, integers become int, other numbers
+//    become float64, everything else is a string
+//  - a list of key/value pairs becomes a struct
+//  - any other list becomes a slice
+//  - a key/value pair with more than one value becomes a slice with the
+//    `siblings` tag option
+//  - values of different types which cannot be unified become interface{}
+//
+// The output is gofmt'ed and ready to be saved as a .go file.
+func GenerateTypes(n *Node, pkg string) ([]byte, error) {
+	if !is_record(n) {
+		return nil, NewUnmarshalError(n, nil,
+			"node is not a list of key/value pairs")
+	}
+
+	var g type_generator
+	g.names = make(map[string]bool)
+	root := g.infer_record(n)
+
+	fmt.Fprintf(&g.buf, "// Code generated by sexp.GenerateTypes; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&g.buf, "package %s\n", pkg)
+	g.emit_struct("Root", root)
+	for i := 0; i < len(g.pending); i++ {
+		s := g.pending[i]
+		g.emit_struct(s.name, s)
+	}
+	return format.Source(g.buf.Bytes())
+}
+
+type gen_kind int
+
+const (
+	gen_bool gen_kind = iota
+	gen_int
+	gen_float
+	gen_string
+	gen_iface
+	gen_slice
+	gen_struct
+)
+
+type gen_type struct {
+	kind   gen_kind
+	elem   *gen_type    // gen_slice only
+	name   string       // gen_struct only
+	key    string       // gen_struct only, the key used to derive the name
+	fields []*gen_field // gen_struct only
+}
+
+type gen_field struct {
+	key      string
+	typ      *gen_type
+	siblings bool
+}
+
+type type_generator struct {
+	buf     bytes.Buffer
+	names   map[string]bool
+	pending []*gen_type
+}
+
+// Returns true if the node is a list of key/value pairs.
+func is_record(n *Node) bool {
+	if !n.IsList() {
+		return false
+	}
+	for c := n.Children; c != nil; c = c.Next {
+		if !c.IsList() || !c.Children.IsScalar() || c.Children.Next == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *type_generator) infer_record(n *Node) *gen_type {
+	t := &gen_type{kind: gen_struct}
+	for c := n.Children; c != nil; c = c.Next {
+		f := &gen_field{key: c.Children.Value}
+		v := c.Children.Next
+		if v.Next != nil {
+			f.siblings = true
+			f.typ = &gen_type{kind: gen_slice, elem: g.infer_siblings(v)}
+		} else {
+			f.typ = g.infer_value(v, f.key)
+		}
+		t.add_field(f)
+	}
+	return t
+}
+
+func (g *type_generator) infer_siblings(n *Node) *gen_type {
+	var t *gen_type
+	for ; n != nil; n = n.Next {
+		t = unify_types(t, g.infer_value(n, ""))
+	}
+	return t
+}
+
+func (g *type_generator) infer_value(n *Node, key string) *gen_type {
+	if n.IsScalar() {
+		return infer_scalar(n)
+	}
+	if is_record(n) {
+		t := g.infer_record(n)
+		t.key = key
+		return t
+	}
+	return &gen_type{kind: gen_slice, elem: g.infer_siblings(n.Children)}
+}
+
+// Uses the kind of the atom assigned by the parser, see NodeKind.
+func infer_scalar(n *Node) *gen_type {
+	switch n.Kind {
+	case NodeSymbol:
+		if n.Value == "true" || n.Value == "false" {
+			return &gen_type{kind: gen_bool}
+		}
+	case NodeInt:
+		// Unmarshal accepts decimal int64 values only
+		if _, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+			return &gen_type{kind: gen_int}
+		}
+		return &gen_type{kind: gen_float}
+	case NodeFloat:
+		return &gen_type{kind: gen_float}
+	}
+	return &gen_type{kind: gen_string}
+}
+
+func (t *gen_type) add_field(f *gen_field) {
+	for _, of := range t.fields {
+		if of.key == f.key {
+			of.typ = unify_types(of.typ, f.typ)
+			of.siblings = of.siblings || f.siblings
+			return
+		}
+	}
+	t.fields = append(t.fields, f)
+}
+
+// Returns a type which can hold values of both types, nil is treated as "no
+// type yet".
+func unify_types(a, b *gen_type) *gen_type {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+
+	if a.kind != b.kind {
+		if (a.kind == gen_int && b.kind == gen_float) ||
+			(a.kind == gen_float && b.kind == gen_int) {
+			return &gen_type{kind: gen_float}
+		}
+		return &gen_type{kind: gen_iface}
+	}
+
+	switch a.kind {
+	case gen_slice:
+		return &gen_type{kind: gen_slice, elem: unify_types(a.elem, b.elem)}
+	case gen_struct:
+		for _, f := range b.fields {
+			a.add_field(f)
+		}
+	}
+	return a
+}
+
+// Converts an arbitrary key to an exported Go identifier: "string-length" ->
+// "StringLength".
+func go_name(key string) string {
+	var buf bytes.Buffer
+	upper := true
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if buf.Len() == 0 && unicode.IsDigit(r) {
+			buf.WriteByte('X')
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	if buf.Len() == 0 {
+		return "X"
+	}
+	return buf.String()
+}
+
+func unique_name(names map[string]bool, name string) string {
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	names[unique] = true
+	return unique
+}
+
+func (g *type_generator) type_name(t *gen_type) string {
+	switch t.kind {
+	case gen_bool:
+		return "bool"
+	case gen_int:
+		return "int"
+	case gen_float:
+		return "float64"
+	case gen_string:
+		return "string"
+	case gen_slice:
+		if t.elem == nil {
+			return "[]interface{}"
+		}
+		return "[]" + g.type_name(t.elem)
+	case gen_struct:
+		if t.name == "" {
+			key := t.key
+			if key == "" {
+				key = "item"
+			}
+			t.name = unique_name(g.names, go_name(key))
+			g.pending = append(g.pending, t)
+		}
+		return t.name
+	}
+	return "interface{}"
+}
+
+func (g *type_generator) emit_struct(name string, t *gen_type) {
+	g.names[name] = true
+	fields := make(map[string]bool)
+	fmt.Fprintf(&g.buf, "\ntype %s struct {\n", name)
+	for _, f := range t.fields {
+		tag := f.key
+		if f.siblings {
+			tag += ",siblings"
+		}
+		fmt.Fprintf(&g.buf, "\t%s %s `sexp:%s`\n",
+			unique_name(fields, go_name(f.key)), g.type_name(f.typ),
+			strconv.Quote(strings.Replace(tag, "`", "", -1)))
+	}
+	fmt.Fprintf(&g.buf, "}\n")
+}
@@ -0,0 +1,147 @@
+package sexp
+
+import (
+	"go/ast"
+	"go/importer"
+	goparser "go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+// Type-checks the Go source files as a single package.
+func type_check(t *testing.T, srcs ...[]byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, src := range srcs {
+		f, err := goparser.ParseFile(fset, "", src, 0)
+		if err != nil {
+			t.Fatalf("%s\n%s", err, src)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check(files[0].Name.Name, fset, files, nil); err != nil {
+		t.Fatalf("%s\n%s", err, srcs[len(srcs)-1])
+	}
+}
+
+func TestGenerateTypes(t *testing.T) {
+	root, err := Parse(strings.NewReader(`
+		(name "hello world")
+		(version 3.0)
+		(enabled true)
+		(position 5 10 4.7)
+		(window ((width 640) (height 480) (title-text x)))
+		(tags (a b c))
+		(mixed (1 x))
+	`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := GenerateTypes(root, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type_check(t, src)
+	out := string(src)
+	for _, s := range []string{
+		"package config",
+		"type Root struct",
+		"Name     string    `sexp:\"name\"`",
+		"Version  float64   `sexp:\"version\"`",
+		"Enabled  bool      `sexp:\"enabled\"`",
+		"Position []float64 `sexp:\"position,siblings\"`",
+		"Window   Window    `sexp:\"window\"`",
+		"Tags     []string  `sexp:\"tags\"`",
+		"Mixed    []interface{} `sexp:\"mixed\"`",
+		"type Window struct",
+		"TitleText string `sexp:\"title-text\"`",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(out), " "),
+			strings.Join(strings.Fields(s), " ")) {
+			t.Errorf("output should contain: %s", s)
+		}
+	}
+
+	_, err = GenerateTypes(root.Children, "config")
+	error_must_contain(t, err, "not a list of key/value pairs")
+}
+
+const generate_unmarshalers_src = `
+package config
+
+import "github.com/nsf/sexp"
+
+//sexp:generate
+type Window struct {
+	Title  string ` + "`sexp:\"title\"`" + `
+	Size   [2]uint16
+	Tags   []string ` + "`sexp:\"tags,siblings,enum=a|b\"`" + `
+	Mode   string   ` + "`sexp:\"mode,enum=on|off\"`" + `
+	Label  string   ` + "`sexp:\"label,string\"`" + `
+	Perms  uint8    ` + "`sexp:\"perms,flags=read:1;write:2\"`" + `
+	Extra  map[string]int
+	Loc    sexp.SourceLoc ` + "`sexp:\",location\"`" + `
+	Node   *sexp.Node     ` + "`sexp:\",node\"`" + `
+	hidden int
+}
+
+type Ignored struct {
+	X int
+}
+`
+
+func TestGenerateUnmarshalers(t *testing.T) {
+	src, err := GenerateUnmarshalers("config.go", []byte(generate_unmarshalers_src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type_check(t, []byte(generate_unmarshalers_src), src)
+	out := string(src)
+	for _, s := range []string{
+		"package config",
+		"func (v *Window) UnmarshalSexp(n *sexp.Node) error",
+		`key.Value == "title" || key.Value == "Title"`,
+		"strconv.ParseUint(c0.Value, 10, 64)",
+		"for c0 := val; c0 != nil; c0 = c0.Next",
+		"val.Unmarshal(&v.Extra)",
+		"writing to an unexported field",
+		"v.Loc = n.Location",
+		"v.Node = n\n",
+		`if val.IsList() || (val.Value != "on" && val.Value != "off")`,
+		`"invalid value, use a|b"`,
+		`if val.IsList() || val.Kind != sexp.NodeString && val.Kind != sexp.NodeRawString`,
+		"case c.Value == \"write\":\n\t\t\t\t\tbits |= 2",
+		"v.Perms = uint8(bits)",
+		"return validator.ValidateSexp(n)",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output should contain: %s", s)
+		}
+	}
+	if strings.Contains(out, "Ignored") {
+		t.Errorf("output should not contain unannotated types")
+	}
+
+	_, err = GenerateUnmarshalers("flags.go", []byte("package config\n//sexp:generate\n"+
+		"type File struct {\nPerms int `sexp:\"perms,flags=perms\"`\n}\n"))
+	error_must_contain(t, err, `^flags.go:4:7: flag sets are not supported`)
+	_, err = GenerateUnmarshalers("using.go", []byte("package config\n//sexp:generate\n"+
+		"type File struct {\nSize int `sexp:\"size,using=human-size\"`\n}\n"))
+	error_must_contain(t, err, `^using.go:4:6: decode functions are not supported`)
+	_, err = GenerateUnmarshalers("pos.go", []byte("package config\n//sexp:generate\n"+
+		"type File struct {\nSize int `sexp:\",pos=0\"`\n}\n"))
+	error_must_contain(t, err, `^pos.go:4:6: positional fields are not supported`)
+	_, err = GenerateUnmarshalers("min.go", []byte("package config\n//sexp:generate\n"+
+		"type File struct {\nSize int `sexp:\"size,min=1\"`\n}\n"))
+	error_must_contain(t, err, `^min.go:4:6: validation constraints are not supported`)
+	_, err = GenerateUnmarshalers("inline.go", []byte("package config\n//sexp:generate\n"+
+		"type File struct {\nNames []string `sexp:\"names,inline\"`\n}\n"))
+	error_must_contain(t, err, `^inline.go:4:7: inline fields are not supported`)
+
+	_, err = GenerateUnmarshalers("empty.go", []byte("package config\n"))
+	error_must_contain(t, err, "no struct types annotated")
+}
@@ -0,0 +1,436 @@
+package sexp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Generates reflection-free UnmarshalSexp methods for annotated struct types
+// found in the Go source file `src`. A struct type is annotated if its doc
+// comment contains a line with the "//sexp:generate" directive:
+//
+//     //sexp:generate
+//     type Config struct {
+//         Name string `sexp:"name"`
+//         Port uint16
+//     }
+//
+// Generated methods follow the same semantics as the reflection based
+// (*Node).Unmarshal for structs. Fields of basic types (numbers, bool,
+// string) and arrays and slices of them are decoded directly, fields of any
+// other type are decoded using (*Node).Unmarshal.
+//
+// Returns gofmt'ed source code of a file which belongs to the same package as
+// the input file. See cmd/sexpgen for a go:generate friendly frontend.
+func GenerateUnmarshalers(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, filename, src, goparser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	g := unmarshaler_generator{fset: fset, imports: make(map[string]bool)}
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			if !has_generate_directive(gd.Doc) && !has_generate_directive(ts.Doc) {
+				continue
+			}
+			g.emit_unmarshaler(ts.Name.Name, st)
+		}
+	}
+	if g.err != nil {
+		return nil, g.err
+	}
+	if g.body.Len() == 0 {
+		return nil, errors.New("no struct types annotated with //sexp:generate found")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by sexpgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\nimport (\n", file.Name.Name)
+	for _, imp := range []string{"reflect", "strconv", "strings"} {
+		if g.imports[imp] {
+			fmt.Fprintf(&buf, "\t%q\n", imp)
+		}
+	}
+	fmt.Fprintf(&buf, "\n\t\"github.com/nsf/sexp\"\n)\n")
+	buf.Write(g.body.Bytes())
+	return format.Source(buf.Bytes())
+}
+
+func has_generate_directive(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == "//sexp:generate" {
+			return true
+		}
+	}
+	return false
+}
+
+var generator_basic_kinds = map[string]reflect.Kind{
+	"bool":    reflect.Bool,
+	"string":  reflect.String,
+	"int":     reflect.Int,
+	"int8":    reflect.Int8,
+	"int16":   reflect.Int16,
+	"int32":   reflect.Int32,
+	"int64":   reflect.Int64,
+	"rune":    reflect.Int32,
+	"uint":    reflect.Uint,
+	"uint8":   reflect.Uint8,
+	"uint16":  reflect.Uint16,
+	"uint32":  reflect.Uint32,
+	"uint64":  reflect.Uint64,
+	"byte":    reflect.Uint8,
+	"float32": reflect.Float32,
+	"float64": reflect.Float64,
+}
+
+type unmarshaler_generator struct {
+	fset    *token.FileSet
+	body    bytes.Buffer
+	imports map[string]bool
+	err     error // the first error, generation continues regardless
+}
+
+func (g *unmarshaler_generator) errorf(pos token.Pos, format string, args ...interface{}) {
+	if g.err == nil {
+		g.err = fmt.Errorf("%s: %s", g.fset.Position(pos), fmt.Sprintf(format, args...))
+	}
+}
+
+func (g *unmarshaler_generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.body, format, args...)
+}
+
+func (g *unmarshaler_generator) expr_string(e ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, g.fset, e)
+	return buf.String()
+}
+
+func (g *unmarshaler_generator) emit_unmarshaler(name string, st *ast.StructType) {
+	g.printf("\nfunc (v *%s) UnmarshalSexp(n *sexp.Node) error {\n", name)
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			continue
+		}
+		opts := field_options(f)
+		for _, fname := range f.Names {
+			switch {
+			case opts.contains("location"):
+				g.printf("v.%s = n.Location\n", fname.Name)
+			case opts.contains("node"):
+				g.printf("v.%s = n\n", fname.Name)
+			}
+		}
+	}
+	g.printf("err := n.IterKeyValues(func(key, val *sexp.Node) error {\n")
+	g.printf("switch {\n")
+	for _, f := range st.Fields.List {
+		// embedded fields are skipped by the reflection based decoder
+		if len(f.Names) == 0 {
+			continue
+		}
+		tag := field_tag(f)
+		if tag == "-" {
+			continue
+		}
+		tagname, opts := parse_tag(tag)
+		if opts.contains("location") || opts.contains("node") {
+			continue
+		}
+		for _, fname := range f.Names {
+			g.imports["strings"] = true
+			g.printf("case ")
+			if tagname != "" {
+				g.printf("key.Value == %q || ", tagname)
+			}
+			g.printf("key.Value == %q || strings.EqualFold(key.Value, %q):\n",
+				fname.Name, fname.Name)
+			if !fname.IsExported() {
+				g.printf("return sexp.NewUnmarshalError(n, reflect.TypeOf(*v), %q)\n",
+					"writing to an unexported field")
+				g.imports["reflect"] = true
+				continue
+			}
+			if _, ok := opts.value("using"); ok {
+				g.errorf(f.Type.Pos(), "decode functions are not supported by "+
+					"generated unmarshalers, field %s uses one", fname.Name)
+				continue
+			}
+			if _, ok := opts.value("pos"); ok {
+				g.errorf(f.Type.Pos(), "positional fields are not supported by "+
+					"generated unmarshalers, field %s is one", fname.Name)
+				continue
+			}
+			if opts.contains("repeated") {
+				g.errorf(f.Type.Pos(), "repeated fields are not supported by "+
+					"generated unmarshalers, field %s is one", fname.Name)
+				continue
+			}
+			if opts.contains("inline") {
+				g.errorf(f.Type.Pos(), "inline fields are not supported by "+
+					"generated unmarshalers, field %s is one, use siblings", fname.Name)
+				continue
+			}
+			if has_constraints(opts) {
+				g.errorf(f.Type.Pos(), "validation constraints are not supported by "+
+					"generated unmarshalers, field %s uses them", fname.Name)
+				continue
+			}
+			if spec, ok := opts.value("flags"); ok {
+				g.emit_flags("v."+fname.Name, f.Type, "val",
+					opts.contains("siblings"), spec)
+				continue
+			}
+			if values, ok := opts.value("enum"); ok {
+				g.emit_enum_check("v."+fname.Name, f.Type, "val",
+					opts.contains("siblings"), values)
+			}
+			if opts.contains("symbol") || opts.contains("string") {
+				g.emit_kind_check("v."+fname.Name, f.Type, "val",
+					opts.contains("siblings"), opts.contains("symbol"))
+			}
+			g.emit_decode("v."+fname.Name, f.Type, "val",
+				opts.contains("siblings"), 0)
+		}
+	}
+	g.printf("}\nreturn nil\n})\n")
+	g.printf("if err != nil {\nreturn err\n}\n")
+	g.printf("if validator, ok := interface{}(v).(sexp.Validator); ok {\nreturn validator.ValidateSexp(n)\n}\n")
+	g.printf("return nil\n}\n")
+}
+
+func field_tag(f *ast.Field) string {
+	if f.Tag == nil {
+		return ""
+	}
+	s, _ := strconv.Unquote(f.Tag.Value)
+	return reflect.StructTag(s).Get("sexp")
+}
+
+// Returns true if the tag has any of the min, max, pattern, minlen and maxlen
+// options, see (*Node).check_constraints.
+func has_constraints(opts tag_options) bool {
+	for _, option := range [...]string{"min", "max", "pattern", "minlen", "maxlen"} {
+		if _, ok := opts.value(option); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func field_options(f *ast.Field) tag_options {
+	_, opts := parse_tag(field_tag(f))
+	return opts
+}
+
+func (g *unmarshaler_generator) emit_error(src, dst, format string, args ...string) {
+	g.imports["reflect"] = true
+	g.printf("return sexp.NewUnmarshalError(%s, reflect.TypeOf(%s), %q",
+		src, dst, format)
+	for _, a := range args {
+		g.printf(", %s", a)
+	}
+	g.printf(")\n")
+}
+
+// Emits code which checks the values of a field with the "enum" option, see
+// (*Node).check_enum.
+func (g *unmarshaler_generator) emit_enum_check(dst string, typ ast.Expr, src string, siblings bool, values string) {
+	g.emit_elem_check(dst, typ, src, siblings, "invalid value, use "+values, func(n string) string {
+		var cond []string
+		for _, a := range strings.Split(values, "|") {
+			cond = append(cond, fmt.Sprintf("%s.Value != %q", n, a))
+		}
+		return fmt.Sprintf("%s.IsList() || (%s)", n, strings.Join(cond, " && "))
+	})
+}
+
+// Emits code which checks the kind of the values of a field with the
+// "symbol" or "string" option, see (*Node).check_kind.
+func (g *unmarshaler_generator) emit_kind_check(dst string, typ ast.Expr, src string, siblings bool, symbol bool) {
+	if symbol {
+		g.emit_elem_check(dst, typ, src, siblings, "symbol expected", func(n string) string {
+			return fmt.Sprintf("%s.IsList() || %s.Kind == sexp.NodeList || "+
+				"%s.Kind == sexp.NodeString || %s.Kind == sexp.NodeRawString", n, n, n, n)
+		})
+		return
+	}
+	g.emit_elem_check(dst, typ, src, siblings, "string expected", func(n string) string {
+		return fmt.Sprintf("%s.IsList() || %s.Kind != sexp.NodeString && %s.Kind != sexp.NodeRawString",
+			n, n, n)
+	})
+}
+
+// Emits code which returns an error with `message` if the condition returned
+// by `invalid` holds for the value of a field, or for any of its elements if
+// it's an array or a slice.
+func (g *unmarshaler_generator) emit_elem_check(dst string, typ ast.Expr, src string, siblings bool, message string, invalid func(n string) string) {
+	g.imports["reflect"] = true
+	check := func(n, typeof string) {
+		g.printf("if %s", invalid(n))
+		g.printf(" {\nreturn sexp.NewUnmarshalError(%s, %s, %q)\n}\n",
+			n, typeof, message)
+	}
+	if _, ok := typ.(*ast.ArrayType); !ok {
+		check(src, "reflect.TypeOf("+dst+")")
+		return
+	}
+	first := src + ".Children"
+	if siblings {
+		first = src
+	}
+	g.printf("for c := %s; c != nil; c = c.Next {\n", first)
+	check("c", "reflect.TypeOf("+dst+").Elem()")
+	g.printf("}\n")
+}
+
+// Emits code which decodes a field with the "flags" option, see
+// (*Node).unmarshal_flags.
+func (g *unmarshaler_generator) emit_flags(dst string, typ ast.Expr, src string, siblings bool, spec string) {
+	t, ok := typ.(*ast.Ident)
+	if !ok {
+		g.errorf(typ.Pos(), "flags field %s must be of an integer type", dst)
+		return
+	}
+	if !strings.Contains(spec, ":") {
+		g.errorf(typ.Pos(), "flag sets are not supported by generated unmarshalers, "+
+			"list the flags in the tag of %s", dst)
+		return
+	}
+	set := parse_flags_option(spec)
+	if set == nil {
+		g.errorf(typ.Pos(), "invalid flags option %q", spec)
+		return
+	}
+	names := make([]string, len(set))
+	for i, f := range set {
+		names[i] = f.name
+	}
+
+	g.imports["reflect"] = true
+	g.imports["strconv"] = true
+	g.printf("var bits uint64\n")
+	if siblings {
+		g.printf("c := %s\n", src)
+	} else {
+		g.printf("c := %s\nif %s.IsList() {\nc = %s.Children\n}\n", src, src, src)
+	}
+	g.printf("for ; c != nil; c = c.Next {\n")
+	g.printf("switch {\ncase c.IsList():\n")
+	g.emit_error("c", dst, "scalar value required")
+	g.printf("case c.Value == \"\":\n")
+	for _, f := range set {
+		g.printf("case c.Value == %q:\nbits |= %d\n", f.name, f.bits)
+	}
+	g.printf("default:\nx, err := strconv.ParseUint(c.Value, 0, 64)\n")
+	g.printf("if err != nil {\n")
+	g.emit_error("c", dst, "unknown flag, use "+strings.Join(names, "|"))
+	g.printf("}\nbits |= x\n}\n}\n")
+	g.printf("if uint64(%s(bits)) != bits || %s(bits) < 0 {\n", t.Name, t.Name)
+	g.emit_error(src, dst, "integer overflow")
+	g.printf("}\n%s = %s(bits)\n", dst, t.Name)
+}
+
+// Emits code which decodes `src` node to the `dst` variable of type `typ`.
+func (g *unmarshaler_generator) emit_decode(dst string, typ ast.Expr, src string, siblings bool, depth int) {
+	c := fmt.Sprintf("c%d", depth)
+	x := fmt.Sprintf("x%d", depth)
+	switch t := typ.(type) {
+	case *ast.Ident:
+		kind, ok := generator_basic_kinds[t.Name]
+		if !ok {
+			break
+		}
+		g.printf("if !%s.IsScalar() {\n", src)
+		g.emit_error(src, dst, "scalar value required")
+		g.printf("}\n")
+		switch kind {
+		case reflect.Bool:
+			g.printf("switch %s.Value {\n", src)
+			g.printf("case \"true\":\n%s = true\n", dst)
+			g.printf("case \"false\":\n%s = false\n", dst)
+			g.printf("default:\n")
+			g.emit_error(src, dst, "undefined boolean value, use true|false")
+			g.printf("}\n")
+		case reflect.String:
+			g.printf("%s = %s.Value\n", dst, src)
+		case reflect.Float32, reflect.Float64:
+			g.imports["strconv"] = true
+			g.printf("%s, err := strconv.ParseFloat(%s.Value, 64)\n", x, src)
+			g.printf("if err != nil {\n")
+			g.emit_error(src, dst, "%s", "err")
+			g.printf("}\n%s = %s(%s)\n", dst, t.Name, x)
+		default:
+			parse, base := "ParseInt", "int64"
+			if kind >= reflect.Uint && kind <= reflect.Uint64 {
+				parse, base = "ParseUint", "uint64"
+			}
+			g.imports["strconv"] = true
+			g.printf("%s, err := strconv.%s(%s.Value, 10, 64)\n", x, parse, src)
+			g.printf("if err != nil {\n")
+			g.emit_error(src, dst, "%s", "err")
+			g.printf("}\n")
+			g.printf("if %s(%s(%s)) != %s {\n", base, t.Name, x, x)
+			g.emit_error(src, dst, "integer overflow")
+			g.printf("}\n%s = %s(%s)\n", dst, t.Name, x)
+		}
+		return
+	case *ast.ArrayType:
+		first := src + ".Children"
+		if siblings {
+			first = src
+		} else {
+			g.printf("if !%s.IsList() {\n", src)
+			g.emit_error(src, dst, "list value required")
+			g.printf("}\n")
+		}
+		elem := g.expr_string(t.Elt)
+		if t.Len == nil {
+			g.printf("%s = %s[:0]\n", dst, dst)
+			g.printf("for %s := %s; %s != nil; %s = %s.Next {\n",
+				c, first, c, c, c)
+			g.printf("var %s %s\n", x, elem)
+			g.printf("{\n")
+			g.emit_decode(x, t.Elt, c, false, depth+1)
+			g.printf("}\n%s = append(%s, %s)\n}\n", dst, dst, x)
+			return
+		}
+		i := fmt.Sprintf("i%d", depth)
+		g.printf("%s := 0\n", i)
+		g.printf("for %s := %s; %s != nil && %s < len(%s); %s = %s.Next {\n",
+			c, first, c, i, dst, c, c)
+		g.printf("{\n")
+		g.emit_decode(dst+"["+i+"]", t.Elt, c, false, depth+1)
+		g.printf("}\n%s++\n}\n", i)
+		g.printf("for ; %s < len(%s); %s++ {\n", i, dst, i)
+		g.printf("var %s %s\n%s[%s] = %s\n}\n", x, elem, dst, i, x)
+		return
+	}
+
+	// everything else goes through the reflection based decoder
+	g.printf("if err := %s.Unmarshal(&%s); err != nil {\nreturn err\n}\n",
+		src, dst)
+}
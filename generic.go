@@ -0,0 +1,100 @@
+package sexp
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+)
+
+// Unmarshals the node to a freshly allocated value of type T and returns it.
+// It's a shortcut for:
+//     var v T
+//     err := n.Unmarshal(&v)
+func UnmarshalInto[T any](n *Node) (T, error) {
+	var v T
+	err := n.Unmarshal(&v)
+	return v, err
+}
+
+// Parses all S-expressions from the reader and unmarshals each top-level
+// expression to a value of type T. If the reader doesn't implement
+// io.RuneReader, it will be wrapped with bufio.Reader.
+func DecodeAll[T any](r io.Reader) ([]T, error) {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+	root, err := Parse(rr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []T
+	for c := root.Children; c != nil; c = c.Next {
+		v, err := UnmarshalInto[T](c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// Decodes the next top-level node of the decoder's stream, which must be a
+// list, element by element: each child is unmarshaled to a value of type T
+// and passed to `f` as soon as it's parsed, neither nodes nor values are
+// kept around. Stops at the first error returned by `f` and returns it.
+// Returns io.EOF if there are no more nodes in the stream.
+func DecodeList[T any](dec *Decoder, f func(T) error) error {
+	return dec.each_child(func(n *Node) error {
+		var v T
+		if err := n.unmarshal_with(&v, dec.opts); err != nil {
+			return err
+		}
+		return f(v)
+	})
+}
+
+// Registers a decode function used for all values of type T, instead of the
+// default decoding or the UnmarshalSexp method of T, e.g.:
+//
+//     sexp.DecodeType(func(n *sexp.Node) (time.Duration, error) {
+//         return time.ParseDuration(n.Value)
+//     })
+//
+// Errors returned by the function are reported as *UnmarshalError pointing
+// at the node, unless they are *UnmarshalError already.
+func DecodeType[T any](f func(n *Node) (T, error)) UnmarshalOption {
+	h := new_decode_hook(f)
+	return func(o *unmarshal_options) {
+		if o.type_hooks == nil {
+			o.type_hooks = make(map[reflect.Type]*decode_hook)
+		}
+		o.type_hooks[h.typ] = h
+	}
+}
+
+// Registers a named decode function for the "using" struct tag option, e.g.
+// a field tagged with `sexp:"size,using=human-size"` is decoded using the
+// function registered as "human-size". The result must be assignable to the
+// field or to the value the field points to. With the "siblings" option the
+// function receives the first of the sibling nodes.
+func DecodeUsing[T any](name string, f func(n *Node) (T, error)) UnmarshalOption {
+	h := new_decode_hook(f)
+	return func(o *unmarshal_options) {
+		if o.using_hooks == nil {
+			o.using_hooks = make(map[string]*decode_hook)
+		}
+		o.using_hooks[name] = h
+	}
+}
+
+func new_decode_hook[T any](f func(n *Node) (T, error)) *decode_hook {
+	return &decode_hook{
+		typ: reflect.TypeOf((*T)(nil)).Elem(),
+		f: func(n *Node) (reflect.Value, error) {
+			v, err := f(n)
+			return reflect.ValueOf(&v).Elem(), err
+		},
+	}
+}
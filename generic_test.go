@@ -0,0 +1,155 @@
+package sexp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalInto(t *testing.T) {
+	root, err := Parse(strings.NewReader("(1 2 3)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := UnmarshalInto[[]int](root.Children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 3 || v[2] != 3 {
+		t.Errorf("[1 2 3] expected, got: %v", v)
+	}
+
+	_, err = UnmarshalInto[bool](root.Children)
+	error_must_contain(t, err, "scalar value required")
+}
+
+func TestDecodeAll(t *testing.T) {
+	type point struct{ X, Y int }
+	points, err := DecodeAll[point](bytes.NewBufferString(`
+		((x 1) (y 2))
+		((x 3) (y 4))
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[1] != (point{3, 4}) {
+		t.Errorf("[{1 2} {3 4}] expected, got: %v", points)
+	}
+
+	_, err = DecodeAll[point](strings.NewReader("((x 1) (y 2)) ((x 3) (y oops))"))
+	error_must_contain(t, err, "invalid syntax")
+
+	_, err = DecodeAll[point](strings.NewReader("((x 1)"))
+	error_must_contain(t, err, "missing matching sequence delimiter")
+}
+
+func TestDecodeList(t *testing.T) {
+	d := NewDecoder(strings.NewReader("(1 2 3) () (4 x)"), nil)
+	var sum int
+	for i := 0; i < 2; i++ {
+		err := DecodeList(d, func(v int) error {
+			sum += v
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sum != 6 {
+		t.Errorf("6 expected, got: %d", sum)
+	}
+
+	err := DecodeList(d, func(v int) error {
+		sum += v
+		return nil
+	})
+	error_must_contain(t, err, `invalid syntax`)
+	if sum != 10 {
+		t.Errorf("10 expected, got: %d", sum)
+	}
+
+	d = NewDecoder(strings.NewReader("[1 2] [3 4)"), nil, ListDelimiters("[]"))
+	sum = 0
+	err = DecodeList(d, func(v int) error {
+		sum += v
+		return nil
+	})
+	if err != nil || sum != 3 {
+		t.Errorf("3 expected, got: %d, %v", sum, err)
+	}
+	error_must_contain(t, DecodeList(d, func(int) error { return nil }), `unexpected '\)', '\]' expected`)
+
+	d = NewDecoder(strings.NewReader("a"), nil)
+	error_must_contain(t, DecodeList(d, func(string) error { return nil }), `list expected`)
+	d = NewDecoder(strings.NewReader(" "), nil)
+	if err := DecodeList(d, func(string) error { return nil }); err != io.EOF {
+		t.Errorf("io.EOF expected, got: %v", err)
+	}
+
+	// comments before and after the lists
+	d = NewDecoder(strings.NewReader("; header\n(1 2 3) ; trailer\n"), nil)
+	sum = 0
+	err = DecodeList(d, func(v int) error {
+		sum += v
+		return nil
+	})
+	if err != nil || sum != 6 {
+		t.Errorf("6 expected, got: %d, %v", sum, err)
+	}
+	if err := DecodeList(d, func(int) error { return nil }); err != io.EOF {
+		t.Errorf("io.EOF expected, got: %v", err)
+	}
+}
+
+func TestDecodeHooks(t *testing.T) {
+	type config struct {
+		Size    int64           `sexp:"size,using=human-size"`
+		MaxSize *int64          `sexp:"max-size,using=human-size"`
+		Timeout time.Duration   `sexp:"timeout"`
+		Delays  []time.Duration `sexp:"delays,siblings"`
+	}
+	human_size := DecodeUsing("human-size", func(n *Node) (int64, error) {
+		num := strings.TrimSuffix(n.Value, "GiB")
+		v, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return 0, errors.New("invalid size")
+		}
+		if num != n.Value {
+			v <<= 30
+		}
+		return v, nil
+	})
+	duration := DecodeType(func(n *Node) (time.Duration, error) {
+		return time.ParseDuration(n.Value)
+	})
+
+	d := NewDecoder(strings.NewReader(`((size 10GiB) (max-size 1) (timeout 1h30m) (delays 1s 2ms))`+
+		`((size 10GB))`), nil)
+	d.SetUnmarshalOptions(human_size, duration)
+	var c config
+	if err := d.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 10<<30 || *c.MaxSize != 1 || c.Timeout != 90*time.Minute ||
+		len(c.Delays) != 2 || c.Delays[1] != 2*time.Millisecond {
+		t.Errorf("unexpected value: %+v", c)
+	}
+	err := d.Decode(&c)
+	error_must_contain(t, err, `^size: invalid size \(value: "10GB"\) \(type: int64\)`)
+
+	root, err := Parse(strings.NewReader("(size 1)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = root.UnmarshalWith(&c)
+	error_must_contain(t, err, `^size: unknown decode function "human-size"`)
+	var wrong struct {
+		Size string `sexp:"size,using=human-size"`
+	}
+	err = root.UnmarshalWith(&wrong, human_size)
+	error_must_contain(t, err, `returns int64, which is not assignable`)
+}
@@ -0,0 +1,54 @@
+package sexp
+
+// Random access index of the children of a list node, see (*Node).Index.
+type NodeIndex struct {
+	children []*Node
+	keys     map[string]*Node
+}
+
+// Returns the index of the node's children, building it on the first call.
+// The index is cached on the node, which makes Nth and key lookups O(1) for
+// lists accessed repeatedly. It reflects the children at the time it was
+// built: functions of this package which modify lists (Merge, Loader,
+// Expander, Macros, PruneFeatures, Document) drop the cached index, code
+// modifying the Children and Next links directly must call ResetIndex.
+func (n *Node) Index() *NodeIndex {
+	if n.index == nil {
+		idx := &NodeIndex{keys: make(map[string]*Node)}
+		for c := n.Children; c != nil; c = c.Next {
+			idx.children = append(idx.children, c)
+			if is_pair(c) {
+				if _, ok := idx.keys[c.Children.Value]; !ok {
+					idx.keys[c.Children.Value] = c
+				}
+			}
+		}
+		n.index = idx
+	}
+	return n.index
+}
+
+// Drops the cached index of the node, see Index.
+func (n *Node) ResetIndex() {
+	n.index = nil
+}
+
+// Returns the number of children.
+func (idx *NodeIndex) Len() int {
+	return len(idx.children)
+}
+
+// Returns the Nth child node (counting from 0) or nil if there is no such
+// child.
+func (idx *NodeIndex) Nth(num int) *Node {
+	if num < 0 || num >= len(idx.children) {
+		return nil
+	}
+	return idx.children[num]
+}
+
+// Returns the first key/value pair `(key value...)` with a given key or nil
+// if there is none.
+func (idx *NodeIndex) Lookup(key string) *Node {
+	return idx.keys[key]
+}
@@ -0,0 +1,45 @@
+package sexp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeIndex(t *testing.T) {
+	root, err := Parse(strings.NewReader("(a 1) (b 2) x (a 3)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := root.Index()
+	if idx.Len() != 4 || idx.Nth(2).Value != "x" || idx.Nth(4) != nil || idx.Nth(-1) != nil {
+		t.Error("unexpected index children")
+	}
+	if p := idx.Lookup("a"); p == nil || p.Children.Next.Value != "1" {
+		t.Error("the first pair with a given key expected")
+	}
+	if idx.Lookup("x") != nil {
+		t.Error("only key/value pairs are indexed")
+	}
+	if root.Index() != idx {
+		t.Error("the index must be cached")
+	}
+
+	override, err := Parse(strings.NewReader("(c 4)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Merge(root, override)
+	idx = root.Index()
+	if idx.Len() != 5 || idx.Lookup("c") == nil {
+		t.Error("Merge must drop the cached index")
+	}
+	if copy_tree(root).index != nil {
+		t.Error("copies must not share the index")
+	}
+
+	root.Children = nil
+	root.ResetIndex()
+	if root.Index().Len() != 0 {
+		t.Error("ResetIndex must drop the cached index")
+	}
+}
@@ -0,0 +1,214 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Conventions for representing JSON objects as S-expressions.
+type JSONObjects int
+
+const (
+	// Objects are lists of key/value pairs: {"a": 1, "b": [2]} is
+	// ((a 1) (b (2))). It's the form Unmarshal expects for maps and
+	// structs. When converting to JSON, every non-empty list of two-element
+	// lists with unique atom keys is considered an object.
+	JSONPairs JSONObjects = iota
+
+	// Objects are lists of key/value pairs tagged with the `@` atom:
+	// {"a": 1, "b": [2]} is (@ (a 1) (b (2))). Unlike JSONPairs, the
+	// conversion is unambiguous.
+	JSONTagged
+)
+
+// Options for JSON conversions.
+type JSONOptions struct {
+	// Convention used for JSON objects.
+	Objects JSONObjects
+
+	// ToJSON only. Atoms are converted to JSON strings, unless this flag is
+	// set, in which case bare atoms which are valid JSON numbers and bare
+	// atoms "true", "false" and "null" are converted to the corresponding
+	// JSON values. Strings, e.g. "42", are always converted to JSON strings.
+	InferScalars bool
+
+	// ToJSON only. If not empty, the output is indented using this string
+	// per level, otherwise it's compact.
+	Indent string
+}
+
+// Converts the node (without its siblings) to JSON. Lists become arrays or
+// objects (see JSONObjects), atoms become strings or other scalars (see
+// JSONOptions.InferScalars). An empty list becomes an empty array, an empty
+// atom becomes an empty string.
+func ToJSON(n *Node, opts JSONOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := write_json(&buf, n, &opts); err != nil {
+		return nil, err
+	}
+	if opts.Indent == "" {
+		return buf.Bytes(), nil
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", opts.Indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Returns true if the node is a JSON object according to the convention,
+// returns the first key/value pair of the object as well.
+func json_object(n *Node, objects JSONObjects) (bool, *Node) {
+	if !n.IsList() {
+		return false, nil
+	}
+	pairs := n.Children
+	if objects == JSONTagged {
+		if !pairs.IsScalar() || pairs.Value != "@" {
+			return false, nil
+		}
+		pairs = pairs.Next
+	}
+	keys := make(map[string]bool)
+	for c := pairs; c != nil; c = c.Next {
+		if !c.IsList() || !c.Children.IsScalar() ||
+			c.Children.Next == nil || c.Children.Next.Next != nil {
+			return false, nil
+		}
+		if keys[c.Children.Value] {
+			return false, nil
+		}
+		keys[c.Children.Value] = true
+	}
+	return true, pairs
+}
+
+func write_json_scalar(buf *bytes.Buffer, n *Node, opts *JSONOptions) error {
+	if opts.InferScalars && n.Kind.is_bare() {
+		switch v := n.Value; {
+		case v == "true" || v == "false" || v == "null":
+			buf.WriteString(v)
+			return nil
+		case json.Valid([]byte(v)) && v[0] != '"' && v[0] != '[' && v[0] != '{':
+			// no spaces allowed, json.Valid ignores them
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				buf.WriteString(v)
+				return nil
+			}
+		}
+	}
+	data, err := json.Marshal(n.Value)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+func write_json(buf *bytes.Buffer, n *Node, opts *JSONOptions) error {
+	if n.IsScalar() && n.Kind != NodeList {
+		return write_json_scalar(buf, n, opts)
+	}
+
+	if ok, pairs := json_object(n, opts.Objects); ok {
+		buf.WriteByte('{')
+		for c := pairs; c != nil; c = c.Next {
+			if c != pairs {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(c.Children.Value)
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := write_json(buf, c.Children.Next, opts); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	}
+
+	buf.WriteByte('[')
+	for c := n.Children; c != nil; c = c.Next {
+		if c != n.Children {
+			buf.WriteByte(',')
+		}
+		if err := write_json(buf, c, opts); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+// Converts a stream of JSON values to S-expressions. Returned node is a
+// virtual list node with all the converted values as children, just like the
+// one returned by Parse. Arrays become lists, objects become lists according
+// to the JSONObjects convention (order of keys is preserved), other values
+// become atoms. Only the Objects field of the options is used.
+func FromJSON(r io.Reader, opts JSONOptions) (*Node, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var chain node_chain
+	for {
+		n, err := json_value(dec, opts.Objects)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		chain.push(n)
+	}
+	return &Node{Children: chain.finish()}, nil
+}
+
+func json_value(dec *json.Decoder, objects JSONObjects) (*Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		var chain node_chain
+		if t == '{' && objects == JSONTagged {
+			chain.push(&Node{Value: "@"})
+		}
+		for dec.More() {
+			v, err := json_value(dec, objects)
+			if err != nil {
+				return nil, err
+			}
+			if t == '{' {
+				// the key was read, read the value and make a pair
+				val, err := json_value(dec, objects)
+				if err != nil {
+					return nil, err
+				}
+				// keys are names rather than strings
+				v.Kind = atom_kind(v.Value)
+				v.Next = val
+				v = &Node{Children: v, Kind: NodeList}
+			}
+			chain.push(v)
+		}
+		// consume closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return &Node{Children: chain.finish(), Kind: NodeList}, nil
+	case string:
+		return &Node{Value: t, Kind: NodeString}, nil
+	case json.Number:
+		return &Node{Value: t.String(), Kind: atom_kind(t.String())}, nil
+	case bool:
+		return &Node{Value: strconv.FormatBool(t)}, nil
+	case nil:
+		return &Node{Value: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected JSON token: %v", tok)
+}
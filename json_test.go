@@ -0,0 +1,77 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	test := func(source string, opts JSONOptions, gold string) {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ToJSON(root.Children, opts)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(data) != gold {
+			t.Errorf("%s != %s", data, gold)
+		}
+	}
+
+	var pairs, tagged JSONOptions
+	tagged.Objects = JSONTagged
+	infer := JSONOptions{InferScalars: true}
+
+	test("(a b (c d))", pairs, `["a","b",["c","d"]]`)
+	test("((b 1) (a (2 3)))", pairs, `{"b":"1","a":["2","3"]}`)
+	test("((b 1) (b 2))", pairs, `[["b","1"],["b","2"]]`)
+	test("((b 1) (a (2 3)))", tagged, `[["b","1"],["a",["2","3"]]]`)
+	test("(@ (b 1) (a (@)))", tagged, `{"b":"1","a":{}}`)
+	test("(1 -2.5e3 true null x 0x10 \"1 \")", infer, `[1,-2.5e3,true,null,"x","0x10","1 "]`)
+	test("(\"42\" `true` () \"\")", infer, `["42","true",[],""]`)
+	test("((a 1))", JSONOptions{Indent: "  "}, "{\n  \"a\": \"1\"\n}")
+}
+
+func TestFromJSON(t *testing.T) {
+	test := func(source string, opts JSONOptions, gold string) {
+		root, err := FromJSON(strings.NewReader(source), opts)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		for c := root.Children; c != nil; c = c.Next {
+			if c != root.Children {
+				buf.WriteString(" ")
+			}
+			c.WriteTo(&buf)
+		}
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+
+	test(`{"b": 1, "a": [true, null, "x y"]}`, JSONOptions{}, `((b 1) (a (true null "x y")))`)
+	test(`{"b": {"c": 1.5e3}}`, JSONOptions{Objects: JSONTagged}, `(@ (b (@ (c 1.5e3))))`)
+	test(`1 "two" [3] "42" []`, JSONOptions{}, `1 "two" (3) "42" ()`)
+
+	_, err := FromJSON(strings.NewReader(`{"a": }`), JSONOptions{})
+	error_must_contain(t, err, "invalid character|missing value")
+}
+
+func TestWriteIndent(t *testing.T) {
+	root, err := Parse(strings.NewReader("(server (port 80) (hosts (a (b c))))"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	root.Children.WriteIndent(&buf, "  ")
+	gold := "(server\n  (port 80)\n  (hosts\n    (a\n      (b c))))"
+	if buf.String() != gold {
+		t.Errorf("got:\n%s\nexpected:\n%s", buf.String(), gold)
+	}
+}
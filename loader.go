@@ -0,0 +1,289 @@
+package sexp
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Loader parses S-expression files resolving the include directive:
+//
+//     (include "other.sexp")
+//
+// The directive is allowed anywhere within a file, it is replaced with all
+// the top-level S-expressions of the included file. Relative paths are
+// resolved relative to the directory of the including file. Include cycles
+// are detected and reported as errors.
+//
+// Every loaded file is registered in the loader's SourceContext, hence all
+// the locations (of nodes and errors) can be decoded using it. Loader also
+// keeps the contents of loaded files around, the Contents method is suitable
+// as a `getcont` argument for Beautify.
+//
+// After all the includes are resolved, conditional sections are evaluated if
+// the Features map is not nil (see PruneFeatures), then the Expander and the
+// Macros are applied if they are not nil (see Expander and Macros). Use an
+// empty map to evaluate conditional sections against no features at all.
+//
+// ParseOptions are passed to Parse for every loaded file. If DecodeUTF16 is
+// true, files starting with a UTF-16 byte order mark are transcoded to UTF-8
+// before parsing (see NewUTF16Reader), Contents returns transcoded data in
+// that case, so that it matches the source locations. The same applies to
+// compressed files if the Decompress option is given.
+//
+// If FS is not nil, files are read from it instead of the OS filesystem, e.g.
+// from an embed.FS. File names are slash-separated paths in that case, see
+// fs.ValidPath, includes are resolved relative to the including file as usual.
+//
+// Zero value is ready to use.
+type Loader struct {
+	FS           fs.FS
+	Context      SourceContext
+	ParseOptions []ParseOption
+	DecodeUTF16  bool
+	Features     map[string]bool
+	Expander     *Expander
+	Macros       *Macros
+	files        map[string][]byte
+}
+
+// Loads a file and all the files it includes. Returned node is a virtual list
+// node just like the one returned by Parse.
+func (l *Loader) Load(filename string) (*Node, error) {
+	data, err := l.read_file(filename)
+	if err != nil {
+		return nil, err
+	}
+	root, err := l.load(filename, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if l.Features != nil {
+		if err := PruneFeatures(root, l.Features); err != nil {
+			return nil, err
+		}
+	}
+	if l.Expander != nil {
+		if err := l.Expander.Expand(root); err != nil {
+			return nil, err
+		}
+	}
+	if l.Macros != nil {
+		if err := l.Macros.Expand(root); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// Returns contents of a previously loaded file or nil if there is no such file.
+func (l *Loader) Contents(filename string) []byte {
+	return l.files[filename]
+}
+
+func (l *Loader) load(filename string, data []byte, stack []string) (*Node, error) {
+	if l.files == nil {
+		l.files = make(map[string][]byte)
+	}
+	data, err := decompress_data(data, l.ParseOptions)
+	if err != nil {
+		return nil, err
+	}
+	if l.DecodeUTF16 {
+		data = transcode_utf16(data)
+	}
+	l.files[filename] = data
+
+	f := l.Context.AddFile(filename, len(data))
+	root, err := Parse(bytes.NewReader(data), f, l.ParseOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	stack = append(stack, filename)
+	err = l.resolve_includes(root, stack)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (l *Loader) include(n *Node, stack []string) (*Node, error) {
+	arg := n.Children.Next
+	if arg == nil || arg.IsList() || arg.Next != nil {
+		return nil, &ParseError{
+			Location: n.Location,
+			message:  "include directive expects a single file name",
+		}
+	}
+
+	filename := l.resolve_path(stack[len(stack)-1], arg.Value)
+	for i, s := range stack {
+		if l.clean_path(s) == l.clean_path(filename) {
+			cycle := append(stack[i:len(stack):len(stack)], filename)
+			return nil, &ParseError{
+				Location: arg.Location,
+				message: fmt.Sprintf("include cycle detected: %s",
+					strings.Join(cycle, " -> ")),
+			}
+		}
+	}
+
+	data, err := l.read_file(filename)
+	if err != nil {
+		return nil, &ParseError{
+			Location: arg.Location,
+			message:  fmt.Sprintf("cannot include %q: %s", arg.Value, err),
+		}
+	}
+	return l.load(filename, data, stack)
+}
+
+func (l *Loader) read_file(filename string) ([]byte, error) {
+	if l.FS != nil {
+		return fs.ReadFile(l.FS, filename)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// Resolves the path of a file included from `from`.
+func (l *Loader) resolve_path(from, filename string) string {
+	if l.FS != nil {
+		if strings.HasPrefix(filename, "/") {
+			// fs.FS paths are rooted already
+			return path.Clean(filename[1:])
+		}
+		return path.Join(path.Dir(from), filename)
+	}
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(filepath.Dir(from), filename)
+}
+
+func (l *Loader) clean_path(filename string) string {
+	if l.FS != nil {
+		return path.Clean(filename)
+	}
+	return filepath.Clean(filename)
+}
+
+// Replaces include directives within the list with the contents of the
+// included files, recursively.
+func (l *Loader) resolve_includes(list *Node, stack []string) error {
+	var chain node_chain
+	for c := list.Children; c != nil; {
+		next := c.Next
+		if is_directive(c, "include") {
+			inc, err := l.include(c, stack)
+			if err != nil {
+				return err
+			}
+			chain.push_all(inc.Children)
+		} else {
+			if c.IsList() {
+				if err := l.resolve_includes(c, stack); err != nil {
+					return err
+				}
+			}
+			chain.push(c)
+		}
+		c = next
+	}
+	list.Children = chain.finish()
+	list.ResetIndex()
+	return nil
+}
+
+// Error returned by Load, wraps a *ParseError or an *UnmarshalError with the
+// decoded location of it.
+type LoadError struct {
+	Err      error
+	Location SourceLocEx
+	Column   int // starting from 1
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Location.Filename,
+		e.Location.Line, e.Column, e.Err)
+}
+
+// Wraps *ParseError and *UnmarshalError errors with LoadError, other errors
+// are returned as is.
+func (l *Loader) located_error(err error) error {
+	var loc SourceLoc
+	switch e := err.(type) {
+	case *ParseError:
+		loc = e.Location
+	case *UnmarshalError:
+		if e.Node == nil {
+			return err
+		}
+		loc = e.Node.Location
+	default:
+		return err
+	}
+
+	locex := l.Context.Decode(loc)
+	contents := l.Contents(l.Context.find_file(loc).name)
+	col := utf8.RuneCount(contents[locex.LineOffset:locex.Offset]) + 1
+	return &LoadError{Err: err, Location: locex, Column: col}
+}
+
+// Loads a file using Loader and unmarshals the result to `v`, see (*Node).Unmarshal
+// for details. Structs are decoded from the top-level forms of the file, see
+// TopLevelForms. Syntax and unmarshaling errors are returned as *LoadError.
+func Load(filename string, v interface{}) error {
+	var l Loader
+	return l.load_value(filename, v)
+}
+
+// Like Load, but the file and all the files it includes are read from `fsys`,
+// see Loader.FS.
+func LoadFS(fsys fs.FS, filename string, v interface{}) error {
+	l := Loader{FS: fsys}
+	return l.load_value(filename, v)
+}
+
+// Loads multiple files and merges them using Merge, each file overrides the
+// previous ones. Then the result is unmarshaled to `v`, see Load for details.
+// All the files share the same SourceContext, errors are located within the
+// file the problematic node comes from.
+func LoadLayers(v interface{}, filenames ...string) error {
+	var l Loader
+	var root *Node
+	for _, filename := range filenames {
+		layer, err := l.Load(filename)
+		if err != nil {
+			return l.located_error(err)
+		}
+		if root == nil {
+			root = layer
+		} else {
+			root = Merge(root, layer)
+		}
+	}
+	if root == nil {
+		return nil
+	}
+	if err := root.UnmarshalWith(v, TopLevelForms()); err != nil {
+		return l.located_error(err)
+	}
+	return nil
+}
+
+func (l *Loader) load_value(filename string, v interface{}) error {
+	root, err := l.Load(filename)
+	if err == nil {
+		err = root.UnmarshalWith(v, TopLevelForms())
+	}
+	if err != nil {
+		return l.located_error(err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package sexp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func write_files(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoaderInclude(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"main.sexp":       "(name main)\n(include \"sub/window.sexp\")\n(list (1 (include \"empty.sexp\") 2))",
+		"sub/window.sexp": "(width 640)\n(include \"height.sexp\")",
+		"sub/height.sexp": "(height 480)",
+		"empty.sexp":      "; nothing here",
+	})
+
+	var l Loader
+	root, err := l.Load(filepath.Join(dir, "main.sexp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Name   string
+		Width  int
+		Height int
+		List   []int
+	}
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "main" || v.Width != 640 || v.Height != 480 || len(v.List) != 2 {
+		t.Errorf("unexpected result: %+v", v)
+	}
+
+	height, err := root.Nth(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := l.Context.Decode(height.Location)
+	if filepath.Base(loc.Filename) != "height.sexp" || loc.Line != 1 {
+		t.Errorf("height.sexp:1 expected, got: %s:%d", loc.Filename, loc.Line)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"a.sexp":       "(x 1)\n(include \"b.sexp\")",
+		"b.sexp":       "(include \"a.sexp\")",
+		"missing.sexp": "(include \"nope.sexp\")",
+		"bad.sexp":     "(include a b)",
+		"syntax.sexp":  "(include \"broken.sexp\")",
+		"broken.sexp":  "\n\n  (oops",
+		"value.sexp":   "(x 1)\n(y abc)",
+	})
+
+	test := func(name string) error {
+		var l Loader
+		_, err := l.Load(filepath.Join(dir, name))
+		return err
+	}
+	error_must_contain(t, test("a.sexp"), `include cycle detected: .*a\.sexp -> .*b\.sexp -> .*a\.sexp`)
+	error_must_contain(t, test("missing.sexp"), `cannot include "nope.sexp"`)
+	error_must_contain(t, test("bad.sexp"), "expects a single file name")
+
+	var v struct{ X, Y int }
+	err := Load(filepath.Join(dir, "syntax.sexp"), &v)
+	error_must_contain(t, err, `broken\.sexp:3:3: missing matching sequence delimiter`)
+	if _, ok := err.(*LoadError); !ok {
+		t.Errorf("*LoadError expected, got: %T", err)
+	}
+	err = Load(filepath.Join(dir, "value.sexp"), &v)
+	error_must_contain(t, err, `value\.sexp:2:4: y: strconv.ParseInt: parsing "abc"`)
+}
+
+func TestLoaderUTF16(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"main.sexp": "\xff\xfe(\x00n\x00 \x001\x00)\x00",
+	})
+
+	l := Loader{DecodeUTF16: true}
+	root, err := l.Load(filepath.Join(dir, "main.sexp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ N int }
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.N != 1 {
+		t.Errorf("unexpected value: %d", v.N)
+	}
+	if c := l.Contents(filepath.Join(dir, "main.sexp")); string(c) != "(n 1)" {
+		t.Errorf("unexpected contents: %q", c)
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/main.sexp":        {Data: []byte(`(name "main") (include "parts/size.sexp")`)},
+		"conf/parts/size.sexp":  {Data: []byte(`(include "/conf/parts/width.sexp") (height 20)`)},
+		"conf/parts/width.sexp": {Data: []byte(`(width 10)`)},
+		"cycle.sexp":            {Data: []byte(`(include "./cycle.sexp")`)},
+		"bad.sexp":              {Data: []byte(`(width x)`)},
+	}
+	var v struct {
+		Name          string
+		Width, Height int
+	}
+	if err := LoadFS(fsys, "conf/main.sexp", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "main" || v.Width != 10 || v.Height != 20 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+
+	err := LoadFS(fsys, "cycle.sexp", &v)
+	error_must_contain(t, err, `include cycle detected: cycle.sexp -> cycle.sexp`)
+	err = LoadFS(fsys, "bad.sexp", &v)
+	error_must_contain(t, err, `^bad\.sexp:1:8: `)
+	err = LoadFS(fsys, "missing.sexp", &v)
+	error_must_contain(t, err, `file does not exist`)
+}
+
+func TestLoadTopLevelForms(t *testing.T) {
+	fsys := fstest.MapFS{"gtk.sexp": {Data: []byte(`
+		(namespace Gtk)
+		(version 3.0)
+		(blacklist
+		  (structs (StockItem))
+		  (functions (accelerator_parse binding_set_find)))
+		(tags a b c)
+	`)}}
+	var v struct {
+		Namespace string
+		Version   float64
+		Blacklist struct {
+			Structs   []string
+			Functions []string
+		}
+		Tags []string
+	}
+	if err := LoadFS(fsys, "gtk.sexp", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Namespace != "Gtk" || v.Version != 3.0 || len(v.Blacklist.Structs) != 1 ||
+		len(v.Blacklist.Functions) != 2 || len(v.Tags) != 3 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+
+	// forms with a single item
+	fsys["single.sexp"] = &fstest.MapFile{Data: []byte(`
+		(blacklist (structs (A)))
+		(tags a)
+	`)}
+	v.Blacklist.Functions = nil
+	if err := LoadFS(fsys, "single.sexp", &v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Blacklist.Structs) != 1 || v.Blacklist.Structs[0] != "A" ||
+		len(v.Tags) != 1 || v.Tags[0] != "a" {
+		t.Errorf("unexpected value: %+v", v)
+	}
+
+	// nested lists are decoded as usual
+	fsys["nested.sexp"] = &fstest.MapFile{Data: []byte(`(blacklist (structs A B) (functions))`)}
+	err := LoadFS(fsys, "nested.sexp", &v)
+	error_must_contain(t, err, `^nested\.sexp:1:21: blacklist\.structs: list value required`)
+}
+
+func TestLineDirectives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.sexp": {Data: []byte("(name main)\n\n(include \"size.sexp\")\n(port\n  80)\n")},
+		"size.sexp": {Data: []byte("; comment\n(width 10)\n(height x)\n")},
+	}
+	l := Loader{FS: fsys}
+	root, err := l.Load("main.sexp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := root.WriteLineDirectives(&buf, &l.Context); err != nil {
+		t.Fatal(err)
+	}
+	gold := `(#line "main.sexp" 1)
+(name main)
+(#line "size.sexp" 2)
+(width 10)
+(height x)
+(#line "main.sexp" 4)
+(port
+80)
+`
+	if buf.String() != gold {
+		t.Fatalf("got:\n%s\nexpected:\n%s", buf.String(), gold)
+	}
+
+	// errors in the preprocessed file point at the original sources
+	fsys["out.sexp"] = &fstest.MapFile{Data: buf.Bytes()}
+	var v struct {
+		Name          string
+		Width, Height int
+		Port          int
+	}
+	l2 := Loader{FS: fsys, ParseOptions: []ParseOption{LineDirectives()}}
+	err = l2.load_value("out.sexp", &v)
+	error_must_contain(t, err, `^size\.sexp:3:9: height: strconv`)
+
+	root, err = Parse(strings.NewReader(buf.String()), nil, LineDirectives())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.NumChildren() != 4 {
+		t.Errorf("directives must be removed from the tree")
+	}
+
+	_, err = Parse(strings.NewReader(`(#line "a")`), nil, LineDirectives())
+	error_must_contain(t, err, "line directive expects a file name and a line number")
+	_, err = Parse(strings.NewReader(`(a (#line "a" 0))`), nil, LineDirectives())
+	error_must_contain(t, err, `invalid line number "0"`)
+}
@@ -1,5 +1,12 @@
 package sexp
 
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
 // Compressed SourceLocEx, can be decoded using an appropriate SourceContext.
 type SourceLoc uint32
 
@@ -7,6 +14,9 @@ type SourceLoc uint32
 // traditional choice. The column is not specified, but you can find it by
 // counting runes between LineOffset and Offset within the source file this
 // location belongs to.
+//
+// Filename and Line are affected by line directives (see LineDirectives),
+// while offsets always refer to the file the location was encoded by.
 type SourceLocEx struct {
 	Filename   string
 	Line       int // starting from 1
@@ -24,10 +34,32 @@ type source_line struct {
 // method to encode source location information, method takes byte offset from
 // the beginning of the file as an argument.
 type SourceFile struct {
+	ctx    *SourceContext // the context the file was added to
 	name   string
 	offset SourceLoc // relative to the beginning of the SourceContext
 	length int
 	lines  []source_line
+	// line directives in the order of lines they apply from
+	directives []line_directive
+	// comment blocks by the offsets of the nodes they precede, see
+	// DocComments
+	docs map[int]string
+	// the size of the provisional space of a file with unknown length which
+	// is followed by other files, 0 if the space is unlimited; it's set by
+	// AddFile while the file may be being parsed, hence atomic
+	limit atomic.Int64
+	// the file was removed from the context, its range is kept reserved so
+	// that the locations encoded by it don't decode as locations of other
+	// files, see SourceContext.Remove
+	removed bool
+}
+
+// Maps the lines starting from `line` to the lines of another file starting
+// from `target`.
+type line_directive struct {
+	line     int
+	filename string
+	target   int
 }
 
 // Returns the last line in the file, assumes there is at least one line.
@@ -68,24 +100,142 @@ func (f *SourceFile) AddLine(offset int) {
 	})
 }
 
+// Returns the offsets of the beginnings of lines known so far, the first one
+// is always 0. The result can be passed to SourceContext.AddFileLines to
+// restore the file without scanning its contents again.
+func (f *SourceFile) Lines() []int {
+	out := make([]int, len(f.lines))
+	for i, l := range f.lines {
+		out[i] = l.offset
+	}
+	return out
+}
+
 // Encodes an offset from the beginning of the file as a source location.
+// Panics with *ParseError if the offset is beyond the provisional space of
+// the file (see SourceContext.AddFile) or beyond the space of locations,
+// which the parsing functions return as an error.
 func (f *SourceFile) Encode(offset int) SourceLoc {
+	limit := int(f.limit.Load())
+	if limit != 0 && offset >= limit || uint64(offset) > math.MaxUint32-uint64(f.offset) {
+		panic(f.space_error(limit))
+	}
 	return f.offset + SourceLoc(offset)
 }
 
+// Returns the error about the file not fitting into its space, `limit` is
+// the size of its provisional space or 0.
+func (f *SourceFile) space_error(limit int) *ParseError {
+	if limit != 0 {
+		return &ParseError{
+			Location: f.offset + SourceLoc(limit-1),
+			message:  fmt.Sprintf("%q exceeds its provisional space of %d bytes", f.name, limit),
+		}
+	}
+	return &ParseError{
+		Location: math.MaxUint32,
+		message:  fmt.Sprintf("%q exceeds the space of source locations, see SourceContext.Compact", f.name),
+	}
+}
+
+// Makes the lines starting from `line` decode as lines of `filename` starting
+// from `target`, see LineDirectives. Directives must be added in the order of
+// lines.
+func (f *SourceFile) add_line_directive(line int, filename string, target int) {
+	f.directives = append(f.directives, line_directive{line, filename, target})
+}
+
 // If the length of the file is unknown at the beginning, the file must be
-// finalized at some point using this method. Otherwise no new files can be
-// added to the source context.
+// finalized at some point using this method. The parsing functions do that
+// themselves once they reach the end of the file or fail. If
+// other files were added after this one, the unused part of its provisional
+// space is left as a gap, see SourceContext.AddFile. Panics with *ParseError
+// if the length exceeds the provisional space.
 func (f *SourceFile) Finalize(len int) {
+	if err := f.finalize(len); err != nil {
+		panic(err)
+	}
+}
+
+// Like Finalize, but the error is returned, the parsing functions finalize
+// files while recovering from panics. A file exceeding its provisional space
+// is finalized with the length of the space.
+func (f *SourceFile) finalize(len int) *ParseError {
+	f.ctx.mu.Lock()
+	defer f.ctx.mu.Unlock()
+	var err *ParseError
+	if limit := int(f.limit.Load()); limit != 0 {
+		if len > limit {
+			err = f.space_error(limit)
+			len = limit
+		}
+		if len < limit {
+			f.ctx.add_gap(f, limit-len)
+		}
+		f.limit.Store(0)
+	}
 	f.length = len
+	return err
+}
+
+// Finalizes the file if its length is unknown, used when parsing fails before
+// reading anything.
+func (f *SourceFile) finish(len int) {
+	if f.length == -1 {
+		f.Finalize(len)
+	}
 }
 
+// The size of the provisional space given to a file with unknown length when
+// another file is added after it.
+const provisional_space = 64 << 20
+
 // Source context holds information needed to decompress source locations.
-// It supports multiple files with knowns and unknowns lengths. Although
-// having a file with unknown length prevents you from adding more files
-// until it's been finalized.
+// It supports multiple files with knowns and unknowns lengths, files with
+// unknown lengths are finalized when their lengths become known, see
+// SourceFile.Finalize.
+//
+// The methods of the context may be called concurrently, e.g. to add files
+// while other files are being parsed.
 type SourceContext struct {
-	files []*SourceFile
+	mu        sync.Mutex
+	files     []*SourceFile
+	generated *SourceFile // see Generated
+}
+
+// The name of the file of generated nodes, see SourceContext.Generated.
+const GeneratedFilename = "<generated>"
+
+// Returns the location of nodes which don't come from any source file, e.g.
+// built with ValueOf and merged into a parsed tree. It decodes as the first
+// line of a file named GeneratedFilename, which is added to the context
+// when the location is requested for the first time. Tools reporting errors
+// can tell such nodes apart using IsGenerated.
+func (s *SourceContext) Generated() SourceLoc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generated == nil || s.generated.removed {
+		s.generated = s.add_file(GeneratedFilename, 1)
+	}
+	return s.generated.offset
+}
+
+// Returns true if the location is the one returned by Generated.
+func (s *SourceContext) IsGenerated(loc SourceLoc) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generated != nil && !s.generated.removed && loc == s.generated.offset
+}
+
+// Sets the locations of the node and its children (without its siblings) to
+// the Generated one and binds them to the context (see BindContext), returns
+// the node. Use it for nodes built in code before linking them into parsed
+// trees, so that their locations don't decode as positions in real files,
+// e.g. Merge(root, ctx.MarkGenerated(defaults)).
+func (s *SourceContext) MarkGenerated(n *Node) *Node {
+	set_location(n, s.Generated())
+	bind_context(n, s)
+	return n
 }
 
 // Returns the last file in the context, assumes there is at least one file.
@@ -95,12 +245,18 @@ func (s *SourceContext) last_file() *SourceFile {
 
 // Find file for a given source location.
 func (s *SourceContext) find_file(l SourceLoc) *SourceFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[s.find_file_index(l)]
+}
+
+func (s *SourceContext) find_file_index(l SourceLoc) int {
 	// simple binary search, we know that files are sorted
 	beg, end := 0, len(s.files)
 	for {
 		len := end - beg
 		if len == 1 {
-			return s.files[beg]
+			return beg
 		}
 		mid := beg + len/2
 		if s.files[mid].offset > l {
@@ -114,23 +270,53 @@ func (s *SourceContext) find_file(l SourceLoc) *SourceFile {
 	panic("unreachable")
 }
 
-// Adds a new file to the context, use -1 as length if the length is unknown, but
-// keep in mind that having a file with unknown length prevents further
-// AddFile calls, they will panic. In order to continue adding files to the
-// context, the last file with unknown length must be finalized. Method doesn't
-// read anything, all the arguments are purely informative.
+// Adds a new file to the context, use -1 as length if the length is unknown.
+// Method doesn't read anything, all the arguments are purely informative.
+//
+// Files with unknown lengths can be parsed concurrently: if the last file's
+// length is unknown, it gets a provisional space of 64 MiB and the new file
+// is placed after that space, so that the locations encoded by both of them
+// stay valid. When the former file is finalized, the unused part of its space
+// becomes a gap, which is reclaimed by Compact. Encoding offsets beyond the
+// provisional space panics with *ParseError, which the parsing functions
+// return as an error. Panics if the space of locations is exhausted, see
+// Compact.
 func (s *SourceContext) AddFile(filename string, length int) *SourceFile {
-	if len(s.files) != 0 && s.last_file().length == -1 {
-		panic("SourceContext: last file was not finalized")
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add_file(filename, length)
+}
 
+func (s *SourceContext) add_file(filename string, length int) *SourceFile {
+	end := uint64(0)
+	if len(s.files) != 0 {
+		last := s.last_file()
+		end = uint64(last.offset)
+		if last.length == -1 {
+			end += provisional_space
+		} else {
+			end += uint64(last.length)
+		}
+	}
+	if length != -1 {
+		end += uint64(length)
+	}
+	if end > math.MaxUint32 {
+		panic("SourceContext: the space of locations is exhausted, compact the context")
+	}
 	offset := SourceLoc(0)
 	if len(s.files) != 0 {
 		last := s.last_file()
-		offset = last.offset + SourceLoc(last.length)
+		if last.length == -1 {
+			last.limit.Store(provisional_space)
+			offset = last.offset + provisional_space
+		} else {
+			offset = last.offset + SourceLoc(last.length)
+		}
 	}
 
 	f := &SourceFile{
+		ctx:    s,
 		name:   filename,
 		offset: offset,
 		length: length,
@@ -140,19 +326,199 @@ func (s *SourceContext) AddFile(filename string, length int) *SourceFile {
 	return f
 }
 
+// Adds a new file with a precomputed line table, see AddFile and
+// SourceFile.Lines. The offsets of the beginnings of lines must be in the
+// increasing order starting with 0, the first line; they must not exceed the
+// length unless it's unknown. Useful for indexers which already know where
+// the lines are.
+func (s *SourceContext) AddFileLines(filename string, length int, lines []int) *SourceFile {
+	if len(lines) == 0 || lines[0] != 0 {
+		panic("SourceContext: the first line must start at offset 0")
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i] <= lines[i-1] || length != -1 && lines[i] > length {
+			panic("SourceContext: invalid line table")
+		}
+	}
+	f := s.AddFile(filename, length)
+	for _, offset := range lines[1:] {
+		f.AddLine(offset)
+	}
+	return f
+}
+
+// Adds a removed file of the given length right after the file, which marks
+// the unused part of its provisional space. Expects the mutex to be locked.
+func (s *SourceContext) add_gap(f *SourceFile, length int) {
+	i := s.file_index(f) + 1
+	gap := &SourceFile{
+		ctx:     s,
+		offset:  f.offset + SourceLoc(int(f.limit.Load())-length),
+		length:  length,
+		removed: true,
+	}
+	if i < len(s.files) && s.files[i].removed {
+		s.files[i].offset = gap.offset
+		s.files[i].length += length
+		return
+	}
+	s.files = append(s.files, nil)
+	copy(s.files[i+1:], s.files[i:])
+	s.files[i] = gap
+}
+
+// Returns the index of the file in the context, panics if it's not there.
+func (s *SourceContext) file_index(f *SourceFile) int {
+	for i, file := range s.files {
+		if file == f && !f.removed {
+			return i
+		}
+	}
+	panic("SourceContext: the file doesn't belong here")
+}
+
+// Removes the file from the context releasing the memory used by its line
+// table, the file must be finalized. Its locations become invalid, decoding
+// them panics. The range of locations the file occupied is not reused until
+// the context is compacted, see Compact.
+func (s *SourceContext) Remove(f *SourceFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.file_index(f)
+	if f.length == -1 {
+		panic("SourceContext: removing a file which was not finalized")
+	}
+	f.name = ""
+	f.lines = nil
+	f.directives = nil
+	f.docs = nil
+	f.removed = true
+
+	// merge adjacent removed ranges, so that their number is bounded by the
+	// number of the files left
+	if i+1 < len(s.files) && s.files[i+1].removed {
+		f.length += s.files[i+1].length
+		s.files = append(s.files[:i+1], s.files[i+2:]...)
+	}
+	if i > 0 && s.files[i-1].removed {
+		s.files[i-1].length += f.length
+		s.files = append(s.files[:i], s.files[i+1:]...)
+	}
+}
+
+// Removes the file and adds a new one with the same name, see Remove and
+// AddFile. Use it when a file is parsed again after it was modified.
+func (s *SourceContext) Replace(f *SourceFile, length int) *SourceFile {
+	name := f.name
+	s.Remove(f)
+	return s.AddFile(name, length)
+}
+
+// Packs the files left in the context, so that the ranges of removed files
+// are reused. Long-running processes parsing files over and over should
+// compact the context from time to time, the space of locations is limited
+// to 4 GiB.
+//
+// The files keep working, but their locations encoded before compaction are
+// different now, the returned function translates them to the new ones. It
+// panics on locations of removed files.
+func (s *SourceContext) Compact() func(SourceLoc) SourceLoc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := SourceContext{files: make([]*SourceFile, len(s.files))}
+	moved := make([]SourceLoc, len(s.files))
+	offset := SourceLoc(0)
+	files := s.files[:0]
+	for i, f := range s.files {
+		old.files[i] = &SourceFile{offset: f.offset, removed: f.removed}
+		if f.removed {
+			continue
+		}
+		moved[i] = offset
+		f.offset = offset
+		if f.length == -1 {
+			offset += SourceLoc(f.limit.Load())
+		} else {
+			offset += SourceLoc(f.length)
+		}
+		files = append(files, f)
+	}
+	for i := len(files); i < len(s.files); i++ {
+		s.files[i] = nil
+	}
+	s.files = files
+
+	return func(loc SourceLoc) SourceLoc {
+		i := old.find_file_index(loc)
+		if old.files[i].removed {
+			panic("SourceContext: relocating location of a removed file")
+		}
+		return moved[i] + loc - old.files[i].offset
+	}
+}
+
 // Decodes an encoded source location.
 func (s *SourceContext) Decode(loc SourceLoc) SourceLocEx {
+	s.mu.Lock()
 	if len(s.files) == 0 {
+		s.mu.Unlock()
 		panic("SourceContext: decoding location that doesn't belong here")
 	}
+	file := s.files[s.find_file_index(loc)]
+	s.mu.Unlock()
 
-	file := s.find_file(loc)
+	if file.removed {
+		panic("SourceContext: decoding location of a removed file")
+	}
 	offset := int(loc - file.offset)
 	line := file.find_line(offset)
-	return SourceLocEx{
+	ex := SourceLocEx{
 		Filename:   file.name,
 		Line:       line.num,
 		LineOffset: line.offset,
 		Offset:     offset,
 	}
+	for i := len(file.directives) - 1; i >= 0; i-- {
+		if d := file.directives[i]; d.line <= line.num {
+			ex.Filename = d.filename
+			ex.Line = d.target + line.num - d.line
+			break
+		}
+	}
+	return ex
+}
+
+// Decodes the location of the node. If `ctx` is nil, the context bound to the
+// node by the parser is used (see BindContext), it panics if there is none.
+func (n *Node) LocationIn(ctx *SourceContext) SourceLocEx {
+	if ctx == nil {
+		ctx = n.ctx
+	}
+	if ctx == nil {
+		panic("sexp: the node is not bound to a source context")
+	}
+	return ctx.Decode(n.Location)
+}
+
+// Binds the context to the node and its descendants, the subtrees already
+// bound to it (shared ones, see SharedLabels) are skipped.
+func bind_context(n *Node, ctx *SourceContext) {
+	if n.ctx == ctx {
+		return
+	}
+	n.ctx = ctx
+	for c := n.Children; c != nil; c = c.Next {
+		bind_context(c, ctx)
+	}
+}
+
+// Returns the comment block preceding the node in the source, without the
+// comment introducers, lines are separated by '\n'. Returns an empty string
+// if there is none or if the node was not parsed with the DocComments option.
+func (n *Node) DocComment() string {
+	if n.ctx == nil {
+		return ""
+	}
+	f := n.ctx.find_file(n.Location)
+	return f.docs[int(n.Location-f.offset)]
 }
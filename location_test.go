@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"io"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -49,8 +50,9 @@ func TestSourceContextForPanics(t *testing.T) {
 	})
 
 	expect_panic(func() {
-		ctx.AddFile("1.txt", -1)
+		f := ctx.AddFile("1.txt", -1)
 		ctx.AddFile("2.txt", 50)
+		f.Encode(provisional_space)
 	}, func(v interface{}) {
 		if v == nil {
 			t.Fatal("expected panic")
@@ -144,3 +146,231 @@ func TestSourceLocation(t *testing.T) {
 		}
 	}
 }
+
+func TestLocationIn(t *testing.T) {
+	var ctx SourceContext
+	ctx.AddFile("a.sexp", 10)
+	f := ctx.AddFile("b.sexp", -1)
+	root, err := Parse(strings.NewReader("(a\n  (b c))"), f, BindContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := ResolvePath(root, "a/b/1")
+	loc := c.LocationIn(nil)
+	if loc.Filename != "b.sexp" || loc.Line != 2 || loc.Offset != 8 {
+		t.Errorf("unexpected location: %+v", loc)
+	}
+	if c.LocationIn(&ctx) != loc || root.ctx != &ctx {
+		t.Error("the bound context must be the one of the file")
+	}
+
+	root, err = Parse(strings.NewReader("a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect_panic(func() { root.Children.LocationIn(nil) }, func(v interface{}) {
+		if v == nil {
+			t.Error("LocationIn must panic on unbound nodes")
+		}
+	})
+}
+
+func TestGenerated(t *testing.T) {
+	var ctx SourceContext
+	src := "((port 80)\n (host a))"
+	f := ctx.AddFile("a.sexp", len(src))
+	root, err := Parse(strings.NewReader(src), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults, err := ValueOf(map[string]interface{}{"host": "b", "user": []string{"x", "y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Merge(root.Children, ctx.MarkGenerated(defaults))
+	port, _ := ResolvePath(root, "0/port/1")
+	user, _ := ResolvePath(root, "0/user/1/0")
+	if loc := port.LocationIn(&ctx); loc.Filename != "a.sexp" || loc.Offset != 7 {
+		t.Errorf("unexpected location of a parsed node: %+v", loc)
+	}
+	if loc := user.LocationIn(nil); loc.Filename != GeneratedFilename || loc.Line != 1 {
+		t.Errorf("unexpected location of a generated node: %+v", loc)
+	}
+	if !ctx.IsGenerated(user.Location) || ctx.IsGenerated(port.Location) || ctx.Generated() != user.Location {
+		t.Error("generated locations must be told apart")
+	}
+
+	// the bucket is added again after removal
+	ctx.Remove(ctx.generated)
+	if ctx.IsGenerated(user.Location) || ctx.Generated() == user.Location {
+		t.Error("the removed bucket must not be reused")
+	}
+}
+
+func TestFileLines(t *testing.T) {
+	var ctx, imported SourceContext
+	locs := read_file(&ctx, "1.txt", strings.NewReader(text1))
+	locs = append(locs, read_file(&ctx, "2.txt", strings.NewReader(text2))...)
+	for _, f := range ctx.files {
+		imported.AddFileLines(f.name, f.length, f.Lines())
+	}
+	if lines := ctx.files[0].Lines(); len(lines) != 6 || lines[2] != 157 {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+	for i, l := range locs {
+		if a, b := ctx.Decode(l), imported.Decode(l); a != b {
+			t.Errorf("[%d] source locations mismatch: %#v != %#v", i, a, b)
+		}
+	}
+
+	for _, lines := range [][]int{nil, {1}, {0, 5, 5}, {0, 100}} {
+		expect_panic(func() {
+			var ctx SourceContext
+			ctx.AddFileLines("1.txt", 50, lines)
+		}, func(v interface{}) {
+			if v == nil {
+				t.Errorf("%v: expected panic", lines)
+			}
+		})
+	}
+}
+
+func TestSourceContextRemove(t *testing.T) {
+	var ctx SourceContext
+	read_file(&ctx, "1.txt", strings.NewReader(text1))
+	locs := read_file(&ctx, "2.txt", strings.NewReader(text2))
+	read_file(&ctx, "3.txt", strings.NewReader(text1))
+	read_file(&ctx, "4.txt", strings.NewReader(text2))
+	gold := make([]SourceLocEx, len(locs))
+	for i, l := range locs {
+		gold[i] = ctx.Decode(l)
+	}
+
+	ctx.Remove(ctx.files[0])
+	ctx.Remove(ctx.files[2])
+	f := ctx.Replace(ctx.files[3], len(text1))
+	if len(ctx.files) != 4 || f.name != "4.txt" {
+		t.Fatalf("adjacent removed files must be merged, got %d files", len(ctx.files))
+	}
+	for i, l := range locs {
+		if loc := ctx.Decode(l); loc != gold[i] {
+			t.Errorf("[%d] source locations mismatch: %#v != %#v", i, loc, gold[i])
+		}
+	}
+	stale := f.offset - 1
+	expect_panic(func() { ctx.Decode(stale) }, func(v interface{}) {
+		if v == nil {
+			t.Error("decoding a location of a removed file must panic")
+		}
+	})
+
+	relocate := ctx.Compact()
+	if len(ctx.files) != 2 || ctx.files[0].offset != 0 || f.offset != SourceLoc(len(text2)) {
+		t.Fatalf("unexpected files after compaction")
+	}
+	for i, l := range locs {
+		if loc := ctx.Decode(relocate(l)); loc != gold[i] {
+			t.Errorf("[%d] source locations mismatch: %#v != %#v", i, loc, gold[i])
+		}
+	}
+	expect_panic(func() { relocate(stale) }, func(v interface{}) {
+		if v == nil {
+			t.Error("relocating a location of a removed file must panic")
+		}
+	})
+}
+
+func TestUnknownLengths(t *testing.T) {
+	var ctx SourceContext
+	a := ctx.AddFile("a.sexp", -1)
+	b := ctx.AddFile("b.sexp", -1)
+	rootb, err := Parse(strings.NewReader("(b\n  c)"), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roota, err := Parse(strings.NewReader("(a\n  d)"), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(strings.NewReader("(e"), ctx.AddFile("c.sexp", -1)); err == nil {
+		t.Fatal("syntax error expected")
+	}
+	if a.length != 7 || b.length != 7 || ctx.last_file().length != 2 {
+		t.Fatalf("all files must be finalized: %d %d %d", a.length, b.length, ctx.last_file().length)
+	}
+	if len(ctx.files) != 4 || !ctx.files[1].removed {
+		t.Fatalf("the unused space of a.sexp must be a gap")
+	}
+
+	test := func(n *Node, filename string) {
+		loc := ctx.Decode(n.Location)
+		if loc.Filename != filename || loc.Line != 2 || loc.Offset != 5 {
+			t.Errorf("unexpected location: %+v", loc)
+		}
+	}
+	test(roota.Children.Children.Next, "a.sexp")
+	test(rootb.Children.Children.Next, "b.sexp")
+
+	relocate := ctx.Compact()
+	if b.offset != 7 {
+		t.Errorf("b.sexp must follow a.sexp after compaction, its offset is %d", b.offset)
+	}
+	n := rootb.Children.Children.Next
+	n.Location = relocate(n.Location)
+	test(n, "b.sexp")
+}
+
+func TestUnknownLengthsConcurrent(t *testing.T) {
+	var ctx SourceContext
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := ctx.AddFile("a.sexp", -1)
+			root, err := Parse(strings.NewReader("(a\n  b)"), f)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if loc := ctx.Decode(root.Children.Children.Next.Location); loc.Line != 2 {
+				t.Errorf("unexpected location: %+v", loc)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Produces spaces endlessly.
+type space_reader struct{}
+
+func (space_reader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = ' '
+	}
+	return len(b), nil
+}
+
+func TestProvisionalSpaceExceeded(t *testing.T) {
+	var ctx SourceContext
+	f := ctx.AddFile("big.sexp", -1)
+	ctx.AddFile("small.sexp", 10)
+	r := io.MultiReader(io.LimitReader(space_reader{}, provisional_space), strings.NewReader("a"))
+	_, err := Parse(bufio.NewReader(r), f)
+	error_must_contain(t, err, `exceeds its provisional space`)
+	if f.length != provisional_space {
+		t.Errorf("the file must be finalized with the length of its space: %d", f.length)
+	}
+
+	// the space of locations is 4 GiB, it's exhausted by 64 files of
+	// unknown lengths
+	expect_panic(func() {
+		for i := 0; i < 64; i++ {
+			ctx.AddFile("x.sexp", -1)
+		}
+	}, func(v interface{}) {
+		if v == nil {
+			t.Fatal("expected panic")
+		}
+	})
+}
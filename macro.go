@@ -0,0 +1,144 @@
+package sexp
+
+// Macros performs macro expansion on a tree. Macros can be defined in the
+// document itself:
+//
+//     (defmacro endpoint (id p)
+//       (service (name id) (listen (host 0.0.0.0) (port p))))
+//
+//     (endpoint api 8080)
+//     (endpoint admin 9090)
+//
+// or programmatically using the Define method. Definitions are removed from
+// the tree and every list whose head is a name of a defined macro is replaced
+// with a copy of the macro's template, where each atom equal to a parameter
+// name is replaced with a copy of the corresponding argument. Substitution is
+// hygienic in a sense that arguments are inserted as is, they are never
+// scanned for parameter names of the macro being expanded. Results of an
+// expansion are expanded again, hence macros can use other macros.
+//
+// Zero value is ready to use.
+type Macros struct {
+	defs map[string]*macro
+}
+
+type macro struct {
+	params   []string
+	template *Node
+}
+
+// Maximum nesting level of macro expansions, exceeding it most likely means
+// there is a recursive macro.
+const max_macro_depth = 100
+
+// Defines a macro programmatically, as if it was defined via defmacro.
+func (m *Macros) Define(name string, params []string, template *Node) {
+	if m.defs == nil {
+		m.defs = make(map[string]*macro)
+	}
+	m.defs[name] = &macro{params: params, template: template}
+}
+
+// Expands macros within the children of the list node `root`, modifying the
+// tree in place. Errors are returned as *ParseError.
+func (m *Macros) Expand(root *Node) (err error) {
+	defer catch_parse_error(&err)
+	m.expand_list(root, 0)
+	return nil
+}
+
+func (m *Macros) expand_list(list *Node, depth int) {
+	var chain node_chain
+	for c := list.Children; c != nil; {
+		next := c.Next
+		if is_directive(c, "defmacro") {
+			m.define(c)
+		} else {
+			chain.push(m.expand_node(c, depth))
+		}
+		c = next
+	}
+	list.Children = chain.finish()
+	list.ResetIndex()
+}
+
+func (m *Macros) define(n *Node) {
+	name := n.Children.Next
+	if name == nil || name.IsList() || name.Next == nil ||
+		name.Next.Next == nil || name.Next.Next.Next != nil {
+		node_error(n, "defmacro expects a name, a list of parameters and a template")
+	}
+
+	var params []string
+	plist := name.Next
+	if plist.IsScalar() && plist.Value != "" {
+		node_error(plist, "list of macro parameters expected")
+	}
+	for p := plist.Children; p != nil; p = p.Next {
+		if p.IsList() {
+			node_error(p, "macro parameter must be a name")
+		}
+		params = append(params, p.Value)
+	}
+
+	template := plist.Next
+	m.Define(name.Value, params, template)
+}
+
+// Expands a single node, returns the replacement for it.
+func (m *Macros) expand_node(n *Node, depth int) *Node {
+	if !n.IsList() {
+		return n
+	}
+
+	var mac *macro
+	if n.Children.IsScalar() {
+		mac = m.defs[n.Children.Value]
+	}
+	if mac == nil {
+		m.expand_list(n, depth)
+		return n
+	}
+
+	if depth >= max_macro_depth {
+		node_error(n, "macro expansion is too deep, recursive macro %q?",
+			n.Children.Value)
+	}
+
+	args := make(map[string]*Node, len(mac.params))
+	a := n.Children.Next
+	for _, p := range mac.params {
+		if a == nil {
+			break
+		}
+		args[p] = a
+		a = a.Next
+	}
+	if len(args) != len(mac.params) || a != nil {
+		node_error(n, "macro %q expects %d arguments", n.Children.Value,
+			len(mac.params))
+	}
+
+	return m.expand_node(substitute(mac.template, args), depth+1)
+}
+
+// Returns a copy of the template with atoms equal to keys of the `args` map
+// replaced by copies of the corresponding nodes.
+func substitute(template *Node, args map[string]*Node) *Node {
+	if template.IsScalar() {
+		if a, ok := args[template.Value]; ok {
+			return copy_tree(a)
+		}
+		return copy_tree(template)
+	}
+
+	c := *template
+	c.Next = nil
+	c.index = nil
+	var chain node_chain
+	for child := template.Children; child != nil; child = child.Next {
+		chain.push(substitute(child, args))
+	}
+	c.Children = chain.finish()
+	return &c
+}
@@ -0,0 +1,65 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMacros(t *testing.T) {
+	test := func(m *Macros, source, gold string) {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Expand(root); err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		format_siblings(&buf, root.Children)
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+
+	test(new(Macros), `
+		(defmacro endpoint (id p) (service (name id) (port p)))
+		(endpoint api 8080)
+		(endpoint (a b) 9090)`,
+		`("service" ("name" "api") ("port" "8080")) `+
+			`("service" ("name" ("a" "b")) ("port" "9090"))`)
+
+	// hygiene: the argument "y" is not substituted again
+	test(new(Macros), `
+		(defmacro pair (x y) (x y))
+		(pair y 1)`,
+		`("y" "1")`)
+
+	// macros using other macros, macros without parameters
+	test(new(Macros), `
+		(defmacro one () 1)
+		(defmacro twice (x) (x x))
+		(twice (one))`,
+		`("1" "1")`)
+
+	var m Macros
+	m.Define("answer", nil, &Node{Value: "42"})
+	test(&m, `(x (answer))`, `("x" "42")`)
+}
+
+func TestMacrosErrors(t *testing.T) {
+	test := func(source string) error {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return new(Macros).Expand(root)
+	}
+	error_must_contain(t, test(`(defmacro m (a) a) (m)`), `macro "m" expects 1 arguments`)
+	error_must_contain(t, test(`(defmacro m (a) a) (m 1 2)`), `macro "m" expects 1 arguments`)
+	error_must_contain(t, test(`(defmacro m (a) (m a)) (m 1)`), `too deep, recursive macro "m"`)
+	error_must_contain(t, test(`(defmacro m a a)`), `list of macro parameters expected`)
+	error_must_contain(t, test(`(defmacro m ((a)) a)`), `macro parameter must be a name`)
+	error_must_contain(t, test(`(defmacro m)`), `defmacro expects`)
+}
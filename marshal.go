@@ -0,0 +1,434 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+type Marshaler interface {
+	MarshalSexp() (*Node, error)
+}
+
+// Error returned by Marshal when a value cannot be represented as an
+// S-expression.
+type MarshalError struct {
+	Type    reflect.Type
+	message string
+}
+
+func (e *MarshalError) Error() string {
+	if e.Type == nil {
+		return e.message
+	}
+	return fmt.Sprintf("%s (type: %s)", e.message, e.Type)
+}
+
+func marshal_error(t reflect.Type, format string, args ...interface{}) {
+	panic(&MarshalError{Type: t, message: fmt.Sprintf(format, args...)})
+}
+
+// Returns the S-expression encoding of `v` written on a single line, see
+// (*Node).WriteTo. The encoding is the inverse of (*Node).Unmarshal:
+//  - any type which implements Marshaler
+//  - *Node: written as is
+//  - bool, numbers and strings: a single atom
+//  - arrays and slices: a list of items
+//  - maps: a list of key/value pairs `((key value) (key value))`, keys must
+//    be encoded as atoms, keys implementing encoding.TextMarshaler are
+//    encoded using it
+//  - structs: a list of key/value pairs, where the key is the name from the
+//    `sexp` tag or the field name, `siblings` fields are written as
+//    `(key item item item)`
+//  - pointers and interfaces: the value they point to
+//
+// Fields tagged with "-", unexported and embedded fields are skipped, as well
+// as the fields with the `location` and `node` options. Nil pointers and
+// interfaces cannot be encoded, struct fields holding them are omitted, in
+// any other place they cause an error.
+//
+// Additional struct tag options:
+//  omitempty: omits the field if its value is false, 0, an empty string, a
+//             nil pointer or interface, or an array, a slice or a map of
+//             length zero.
+//  omitzero:  omits the field if its value is the zero value of its type or
+//             if it has an `IsZero() bool` method which returns true.
+//  flags=F:   writes an integer as a list of flag names, see (*Node).Unmarshal,
+//             bits not covered by the flags are written as a number.
+//  order=N:   changes the position of the field in the output, it's written
+//             as if it was the Nth field of the struct (counting from 0),
+//             fields are written in the declaration order otherwise.
+//  pos=N:     writes the field as the Nth item of the list, before the
+//             key/value pairs, see (*Node).Unmarshal. Positions must be
+//             contiguous starting from 0, the fields are always written.
+//  siblings:  for struct fields, writes the items of the struct's list after
+//             the key, e.g. `(version 3.0 stable)`.
+//  inline:    writes an array or a slice like siblings does, e.g.
+//             `(functions a b c)`.
+//  repeated:  writes a slice as a key/value pair per element, e.g.
+//             `(plugin a) (plugin b)`.
+//
+// The output is deterministic: map entries are sorted by key, numerically
+// for integer and floating point keys and lexicographically for others.
+//
+// Returned errors are *MarshalError, or errors returned by MarshalSexp
+// methods.
+func Marshal(v interface{}) ([]byte, error) {
+	n, err := marshal_node(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := n.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Like Marshal, but the output is indented, see (*Node).WriteIndent.
+func MarshalIndent(v interface{}, indent string) ([]byte, error) {
+	n, err := marshal_node(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := n.WriteIndent(&buf, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Converts `v` to a tree using the Marshal rules without writing it, which is
+// handy for injecting Go values into trees being manipulated, e.g. using
+// Merge. The returned node is a single value, not a virtual list node like the
+// one returned by Parse.
+func ValueOf(v interface{}) (*Node, error) {
+	return marshal_node(v)
+}
+
+// marshaler errors other than *MarshalError are wrapped with it to be passed
+// through panics
+type marshaler_error struct {
+	err error
+}
+
+func marshal_node(v interface{}) (*Node, error) {
+	return marshal_node_with(v, marshal_value)
+}
+
+// Converts `v` to a tree using `f`, recovering marshal errors.
+func marshal_node_with(v interface{}, f func(v reflect.Value) *Node) (n *Node, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch e := e.(type) {
+			case *MarshalError:
+				err = e
+			case marshaler_error:
+				err = e.err
+			default:
+				panic(e)
+			}
+		}
+	}()
+
+	return f(reflect.ValueOf(v)), nil
+}
+
+var marshaler_type = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+func marshal_value(v reflect.Value) *Node {
+	if !v.IsValid() {
+		marshal_error(nil, "cannot marshal nil")
+	}
+	t := v.Type()
+	if t == node_ptr_type {
+		if v.IsNil() {
+			marshal_error(t, "cannot marshal nil")
+		}
+		return copy_tree(v.Interface().(*Node))
+	}
+	if t.Implements(marshaler_type) && (t.Kind() != reflect.Ptr || !v.IsNil()) {
+		n, err := v.Interface().(Marshaler).MarshalSexp()
+		if err != nil {
+			panic(marshaler_error{err})
+		}
+		return n
+	}
+	if v.CanAddr() && reflect.PtrTo(t).Implements(marshaler_type) {
+		return marshal_value(v.Addr())
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Node{Value: strconv.FormatInt(v.Int(), 10), Kind: NodeInt}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Node{Value: strconv.FormatUint(v.Uint(), 10), Kind: NodeInt}
+	case reflect.Float32:
+		s := strconv.FormatFloat(v.Float(), 'g', -1, 32)
+		return &Node{Value: s, Kind: atom_kind(s)}
+	case reflect.Float64:
+		s := strconv.FormatFloat(v.Float(), 'g', -1, 64)
+		return &Node{Value: s, Kind: atom_kind(s)}
+	case reflect.Bool:
+		return &Node{Value: strconv.FormatBool(v.Bool())}
+	case reflect.String:
+		return &Node{Value: v.String()}
+	case reflect.Array, reflect.Slice:
+		return &Node{Children: marshal_items(v), Kind: NodeList}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			marshal_error(t, "cannot marshal nil")
+		}
+		return marshal_value(v.Elem())
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort_map_keys(keys)
+		var chain node_chain
+		for _, k := range keys {
+			key := marshal_map_key(k)
+			if key.IsList() {
+				marshal_error(t, "map key must be encoded as an atom")
+			}
+			key.Next = marshal_value(v.MapIndex(k))
+			chain.push(&Node{Children: key})
+		}
+		return &Node{Children: chain.finish(), Kind: NodeList}
+	case reflect.Struct:
+		return &Node{Children: marshal_fields(v), Kind: NodeList}
+	}
+	marshal_error(t, "unsupported type")
+	return nil
+}
+
+var text_marshaler_type = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// Map keys implementing encoding.TextMarshaler are encoded using it, unless
+// they implement Marshaler as well.
+func marshal_map_key(k reflect.Value) *Node {
+	t := k.Type()
+	if !t.Implements(marshaler_type) && t.Implements(text_marshaler_type) &&
+		(t.Kind() != reflect.Ptr || !k.IsNil()) {
+		text, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			panic(marshaler_error{err})
+		}
+		return &Node{Value: string(text)}
+	}
+	return marshal_value(k)
+}
+
+// Returns the items of an array or a slice as a chain of siblings.
+func marshal_items(v reflect.Value) *Node {
+	var chain node_chain
+	for i, n := 0, v.Len(); i < n; i++ {
+		chain.push(marshal_value(v.Index(i)))
+	}
+	return chain.finish()
+}
+
+func sort_map_keys(keys []reflect.Value) {
+	if len(keys) == 0 {
+		return
+	}
+	var less func(a, b reflect.Value) bool
+	switch keys[0].Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		less = func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float32, reflect.Float64:
+		less = func(a, b reflect.Value) bool { return a.Float() < b.Float() }
+	case reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	default:
+		less = func(a, b reflect.Value) bool {
+			return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return less(keys[i], keys[j])
+	})
+}
+
+type marshal_field struct {
+	order int
+	node  *Node
+}
+
+// Returns the fields of a struct as a chain of key/value pairs.
+func marshal_fields(v reflect.Value) *Node {
+	t := v.Type()
+	var fields, positional []marshal_field
+	for i, n := 0, t.NumField(); i < n; i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("sexp")
+		if tag == "-" || f.Anonymous || f.PkgPath != "" {
+			continue
+		}
+		name, opts := parse_tag(tag)
+		if opts.contains("location") || opts.contains("node") {
+			continue
+		}
+		if s, ok := opts.value("pos"); ok {
+			pos, err := strconv.Atoi(s)
+			if err != nil || pos < 0 {
+				marshal_error(t, "invalid pos option of field %s: %q", f.Name, s)
+			}
+			positional = append(positional, marshal_field{pos, marshal_value(v.Field(i))})
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		order := i
+		if s, ok := opts.value("order"); ok {
+			var err error
+			order, err = strconv.Atoi(s)
+			if err != nil {
+				marshal_error(t, "invalid order option of field %s: %q", f.Name, s)
+			}
+		}
+
+		fv := v.Field(i)
+		if opts.contains("omitempty") && is_empty_value(fv) {
+			continue
+		}
+		if opts.contains("omitzero") && is_zero_value(fv) {
+			continue
+		}
+		if (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface) && fv.IsNil() {
+			continue
+		}
+
+		if opts.contains("repeated") && fv.Kind() == reflect.Slice {
+			for j := 0; j < fv.Len(); j++ {
+				key := &Node{Value: name, Next: marshal_value(fv.Index(j))}
+				if opts.contains("siblings") && key.Next.IsList() {
+					key.Next = key.Next.Children
+				}
+				fields = append(fields, marshal_field{order, &Node{Children: key}})
+			}
+			continue
+		}
+
+		key := &Node{Value: name}
+		if spec, ok := opts.value("flags"); ok {
+			flags := marshal_flags(fv, spec)
+			if opts.contains("siblings") && flags.IsList() {
+				key.Next = flags.Children
+			} else {
+				key.Next = flags
+			}
+			if key.Next == nil {
+				key.Next = &Node{}
+			}
+		} else if (opts.contains("siblings") || opts.contains("inline")) && (fv.Kind() == reflect.Slice || fv.Kind() == reflect.Array) {
+			key.Next = marshal_items(fv)
+			if key.Next == nil {
+				// `(key)` is not a valid key/value pair
+				continue
+			}
+			if !opts.contains("siblings") && key.Next.Next == nil &&
+				(key.Next.IsList() || key.Next.Kind == NodeList) {
+				// a single list item would be decoded as the nested list
+				key.Next = &Node{Children: key.Next, Kind: NodeList}
+			}
+		} else if opts.contains("siblings") && fv.Kind() == reflect.Struct {
+			key.Next = marshal_value(fv).Children
+			if key.Next == nil {
+				continue
+			}
+		} else {
+			key.Next = marshal_value(fv)
+		}
+		fields = append(fields, marshal_field{order, &Node{Children: key}})
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].order < fields[j].order
+	})
+	sort.SliceStable(positional, func(i, j int) bool {
+		return positional[i].order < positional[j].order
+	})
+	var chain node_chain
+	for i, f := range positional {
+		if f.order != i {
+			marshal_error(t, "positions of fields must be contiguous, position %d is missing", i)
+		}
+		chain.push(f.node)
+	}
+	for _, f := range fields {
+		chain.push(f.node)
+	}
+	return chain.finish()
+}
+
+func is_empty_value(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+type is_zeroer interface {
+	IsZero() bool
+}
+
+func is_zero_value(v reflect.Value) bool {
+	if z, ok := v.Interface().(is_zeroer); ok {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return true
+		}
+		return z.IsZero()
+	}
+	return v.IsZero()
+}
+
+// Encoder writes values to a stream as S-expressions, one top-level node per
+// value, it's the counterpart of Decoder. See also FrameWriter.
+type Encoder struct {
+	w      io.Writer
+	indent string
+}
+
+// Creates a new encoder writing to `w`.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Makes the encoder indent the output as MarshalIndent does, an empty string
+// gives the compact output of Marshal.
+func (e *Encoder) SetIndent(indent string) {
+	e.indent = indent
+}
+
+// Writes the encoding of `v` to the stream followed by a newline, see Marshal.
+func (e *Encoder) Encode(v interface{}) error {
+	var data []byte
+	var err error
+	if e.indent == "" {
+		data, err = Marshal(v)
+	} else {
+		data, err = MarshalIndent(v, e.indent)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
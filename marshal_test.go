@@ -0,0 +1,209 @@
+package sexp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type marshal_window struct {
+	Title    string   `sexp:"title"`
+	Size     [2]int   `sexp:"size"`
+	Tags     []string `sexp:"tags,siblings"`
+	Visible  bool     `sexp:"visible"`
+	Opacity  float64  `sexp:"opacity,omitempty"`
+	Parent   *marshal_window
+	Props    map[string]int `sexp:"props,omitempty"`
+	Created  time.Time      `sexp:"created,omitzero"`
+	Node     *Node          `sexp:",node"`
+	internal int
+}
+
+type upper_marshaler string
+
+func (u upper_marshaler) MarshalSexp() (*Node, error) {
+	if u == "" {
+		return nil, errors.New("empty upper_marshaler")
+	}
+	return &Node{Value: strings.ToUpper(string(u))}, nil
+}
+
+func test_marshal(t *testing.T, v interface{}, gold string) {
+	data, err := Marshal(v)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != gold {
+		t.Errorf("%s != %s", data, gold)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	test_marshal(t, 42, "42")
+	test_marshal(t, -1.5, "-1.5")
+	test_marshal(t, float32(0.1), "0.1")
+	test_marshal(t, "hello world", `"hello world"`)
+	test_marshal(t, []interface{}{true, uint8(7), []string{"a", "b"}}, "(true 7 (a b))")
+	test_marshal(t, map[string]int{"a": 1}, "((a 1))")
+	test_marshal(t, []upper_marshaler{"a", "b"}, "(A B)")
+	test_marshal(t, struct {
+		A []int
+		B map[string]int
+		C struct{}
+	}{[]int{}, map[string]int{}, struct{}{}}, "((A ()) (B ()) (C ()))")
+	var empty struct{ A []int }
+	if root, err := Parse(strings.NewReader("((A ()))"), nil); err != nil {
+		t.Fatal(err)
+	} else if err := root.Children.Unmarshal(&empty); err != nil {
+		t.Errorf("empty lists must unmarshal to slices: %v", err)
+	}
+
+	w := marshal_window{
+		Title: "main",
+		Size:  [2]int{640, 480},
+		Tags:  []string{"x", "y"},
+		Parent: &marshal_window{
+			Title:   "root",
+			Opacity: 0.5,
+		},
+	}
+	test_marshal(t, w, `((title main) (size (640 480)) (tags x y) (visible false) `+
+		`(Parent ((title root) (size (0 0)) (visible false) (opacity 0.5))))`)
+
+	// round trip
+	data, err := MarshalIndent(&w, "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := Parse(strings.NewReader(string(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w2 marshal_window
+	if err := root.Children.Unmarshal(&w2); err != nil {
+		t.Fatal(err)
+	}
+	if w2.Title != "main" || w2.Size != w.Size || len(w2.Tags) != 2 || w2.Parent.Opacity != 0.5 {
+		t.Errorf("round trip mismatch: %+v", w2)
+	}
+}
+
+func TestMarshalOrder(t *testing.T) {
+	test_marshal(t, map[string]int{"b": 2, "c": 3, "a": 1}, "((a 1) (b 2) (c 3))")
+	test_marshal(t, map[int]bool{10: true, 9: false, -1: true}, "((-1 true) (9 false) (10 true))")
+
+	var v struct {
+		A int
+		B int `sexp:"b,order=0"`
+		C int
+		D int `sexp:",order=-1"`
+		E int `sexp:",order=2"`
+	}
+	test_marshal(t, v, "((D 0) (A 0) (b 0) (C 0) (E 0))")
+
+	var bad struct {
+		A int `sexp:",order=first"`
+	}
+	_, err := Marshal(bad)
+	error_must_contain(t, err, `invalid order option of field A: "first"`)
+}
+
+func TestMarshalPositional(t *testing.T) {
+	type version struct {
+		Channel string  `sexp:",pos=1"`
+		Number  float64 `sexp:",pos=0"`
+		Date    string  `sexp:"date,omitempty"`
+	}
+	v := struct {
+		Version version `sexp:"version,siblings"`
+		Build   version `sexp:"build"`
+	}{version{"stable", 3.5, ""}, version{"beta", 4, "today"}}
+	test_marshal(t, v, "((version 3.5 stable) (build (4 beta (date today))))")
+
+	var bad struct {
+		A int `sexp:",pos=1"`
+	}
+	_, err := Marshal(bad)
+	error_must_contain(t, err, "position 0 is missing")
+}
+
+func TestMarshalRepeated(t *testing.T) {
+	v := struct {
+		Plugins [][]string `sexp:"plugin,repeated,siblings"`
+		Paths   []string   `sexp:"path,repeated"`
+		Empty   []int      `sexp:"empty,repeated"`
+	}{[][]string{{"a", "1"}, {"b"}}, []string{"/x"}, nil}
+	test_marshal(t, v, "((plugin a 1) (plugin b) (path /x))")
+}
+
+func TestMarshalInline(t *testing.T) {
+	v := struct {
+		Functions []string `sexp:"functions,inline"`
+		Empty     []string `sexp:"empty,inline"`
+	}{Functions: []string{"a", "b"}}
+	test_marshal(t, v, "((functions a b))")
+
+	// a single list element is written in the nested form, round trip
+	type server struct {
+		Host string `sexp:"host"`
+	}
+	type config struct {
+		Pairs   [][]string `sexp:"pairs,inline"`
+		Servers []server   `sexp:"servers,inline"`
+	}
+	in := config{[][]string{{"a", "b"}}, []server{{"x"}}}
+	test_marshal(t, in, "((pairs ((a b))) (servers (((host x)))))")
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := Parse(strings.NewReader(string(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out config
+	if err := root.Children.Unmarshal(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("unexpected round trip result: %+v", out)
+	}
+}
+
+func TestValueOf(t *testing.T) {
+	n, err := ValueOf(map[string][]int{"a": {1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := Parse(strings.NewReader("(a 0) (b 1)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Merge(base, n)
+	var buf strings.Builder
+	if _, err := base.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "((a (1 2)) (b 1))" {
+		t.Errorf("unexpected merge result: %s", buf.String())
+	}
+
+	_, err = ValueOf(make(chan int))
+	error_must_contain(t, err, "unsupported type")
+}
+
+func TestMarshalErrors(t *testing.T) {
+	_, err := Marshal(nil)
+	error_must_contain(t, err, "cannot marshal nil")
+	_, err = Marshal([]*int{nil})
+	error_must_contain(t, err, `cannot marshal nil \(type: \*int\)`)
+	_, err = Marshal(map[[2]int]int{{1, 2}: 3})
+	error_must_contain(t, err, "map key must be encoded as an atom")
+	_, err = Marshal(make(chan int))
+	error_must_contain(t, err, "unsupported type")
+	_, err = Marshal(upper_marshaler(""))
+	error_must_contain(t, err, "^empty upper_marshaler$")
+}
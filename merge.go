@@ -0,0 +1,127 @@
+package sexp
+
+// Merges the children of the `override` list node into the children of the
+// `base` list node, modifying `base` in place and returning it. Nodes of
+// `override` are linked into `base`, they are not copied.
+//
+// Both nodes are treated as lists of key/value pairs, e.g. the root nodes
+// returned by Parse. For each pair of `override`:
+//  - if `base` has no pair with the same key, the pair is appended
+//  - if both pairs have values which are records (lists of key/value pairs
+//    with distinct keys which are not numbers) themselves, the values are
+//    merged recursively, this applies to the form where the pairs follow
+//    the key directly as well: `(key (a 1) (b 2))`; a value consisting of
+//    a single pair is taken for a plain list unless the other value shares
+//    its key, so that `(tags (a b c))` is replaced by `(tags (x y))`
+//  - otherwise the pair of `override` replaces the pair of `base`
+// Children of `override` which are not key/value pairs are appended. If there
+// are repeated keys in `base`, only the first pair with a given key is
+// considered.
+//
+// No nodes are synthesized, every node of the result comes from one of the
+// trees and keeps its location, so that errors found while validating the
+// result point at the files the values come from. Nodes built in code, e.g.
+// with ValueOf, have zero locations, mark them using
+// SourceContext.MarkGenerated before merging.
+func Merge(base, override *Node) *Node {
+	base.ResetIndex()
+	override.ResetIndex()
+	for c := override.Children; c != nil; {
+		next := c.Next
+		c.Next = nil
+		merge_pair(base, c)
+		c = next
+	}
+	return base
+}
+
+// Returns true if the node is a key/value pair: `(key value...)`.
+func is_pair(n *Node) bool {
+	return n.IsList() && n.Children.IsScalar() && n.Children.Next != nil
+}
+
+// Returns true if the sibling chain is a record: key/value pairs with
+// distinct keys which are not numbers.
+func is_record_chain(n *Node) bool {
+	seen := make(map[string]bool)
+	for ; n != nil; n = n.Next {
+		if !is_pair(n) {
+			return false
+		}
+		key := n.Children
+		if key.Kind == NodeInt || key.Kind == NodeFloat || key.Kind == NodeList || seen[key.Value] {
+			return false
+		}
+		seen[key.Value] = true
+	}
+	return true
+}
+
+// Returns true if the values of two pairs are records to be merged. A single
+// pair is indistinguishable from a plain list, e.g. `(a b c)`, hence when
+// both values are single pairs, they are merged only if their keys match,
+// which gives the same result as replacing.
+func mergeable_records(base, over *Node) bool {
+	if !is_record_chain(base) || !is_record_chain(over) {
+		return false
+	}
+	return base.Next != nil || over.Next != nil || base.Children.Value == over.Children.Value
+}
+
+func find_pair(list *Node, key string) (prev, pair *Node) {
+	for c := list.Children; c != nil; c = c.Next {
+		if is_pair(c) && c.Children.Value == key {
+			return prev, c
+		}
+		prev = c
+	}
+	return nil, nil
+}
+
+func merge_pair(base, over *Node) {
+	if !is_pair(over) {
+		append_child(base, over)
+		return
+	}
+	prev, pair := find_pair(base, over.Children.Value)
+	if pair == nil {
+		append_child(base, over)
+		return
+	}
+
+	bv, ov := pair.Children.Next, over.Children.Next
+	switch {
+	case mergeable_records(bv, ov):
+		// (key (a 1) (b 2)) form, merge the tails
+		tail := &Node{Children: bv}
+		Merge(tail, &Node{Children: ov})
+		pair.Children.Next = tail.Children
+		pair.ResetIndex()
+	case bv.Next == nil && ov.Next == nil && bv.IsList() && ov.IsList() &&
+		mergeable_records(bv.Children, ov.Children):
+		// (key ((a 1) (b 2))) form, merge the values
+		Merge(bv, ov)
+	default:
+		over.Next = pair.Next
+		if prev == nil {
+			base.Children = over
+		} else {
+			prev.Next = over
+		}
+	}
+}
+
+func append_child(list, child *Node) {
+	if list.Children == nil {
+		list.Children = child
+		return
+	}
+	last_sibling(list.Children).Next = child
+}
+
+func last_sibling(n *Node) *Node {
+	for n.Next != nil {
+		n = n.Next
+	}
+	return n
+}
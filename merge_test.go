@@ -0,0 +1,69 @@
+package sexp
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	test := func(base, override, gold string) {
+		b, err := Parse(strings.NewReader(base), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := Parse(strings.NewReader(override), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		format_siblings(&buf, Merge(b, o).Children)
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+
+	test("(a 1) (b 2)", "(b 3) (c 4)", `("a" "1") ("b" "3") ("c" "4")`)
+	test("(a 1)", "(a (x y))", `("a" ("x" "y"))`)
+	test("(w ((x 1) (y 2)))", "(w ((y 3) (z 4)))",
+		`("w" (("x" "1") ("y" "3") ("z" "4")))`)
+	test("(w (x 1) (y 2))", "(w (y (3)))", `("w" ("x" "1") ("y" ("3")))`)
+	test("(w (x 1) (y 2))", "(w 5)", `("w" "5")`)
+	test("(a 1) x", "y (a 2)", `("a" "2") "x" "y"`)
+	test("", "(a 1)", `("a" "1")`)
+
+	// plain lists are replaced, not merged
+	test("(tags (a b c))", "(tags (x y))", `("tags" ("x" "y"))`)
+	test("(tags ((a b c)))", "(tags ((x y)))", `("tags" (("x" "y")))`)
+	test("(point (1 2))", "(point (3 4))", `("point" ("3" "4"))`)
+	test("(point (x 1) (x 2))", "(point (y 3))", `("point" ("y" "3"))`)
+	test("(w (x 1))", "(w (x 2))", `("w" ("x" "2"))`)
+	test("(w (x 1))", "(w (y 2) (z 3))", `("w" ("x" "1") ("y" "2") ("z" "3"))`)
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"base.sexp":  "(name app)\n(server (host localhost) (port 80))",
+		"prod.sexp":  "(server (host example.com))",
+		"local.sexp": "\n(server (port oops))",
+	})
+
+	var v struct {
+		Name   string
+		Server struct {
+			Host string
+			Port int
+		}
+	}
+	err := LoadLayers(&v, filepath.Join(dir, "base.sexp"), filepath.Join(dir, "prod.sexp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "app" || v.Server.Host != "example.com" || v.Server.Port != 80 {
+		t.Errorf("unexpected result: %+v", v)
+	}
+
+	err = LoadLayers(&v, filepath.Join(dir, "base.sexp"), filepath.Join(dir, "local.sexp"))
+	error_must_contain(t, err, `local\.sexp:2:15: .*invalid syntax`)
+}
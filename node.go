@@ -1,10 +1,14 @@
 package sexp
 
 import (
+	"encoding"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // The main and only AST structure. All fields are self explanatory, however
@@ -27,11 +31,65 @@ import (
 //       Node{Value: "2"}}, Next:
 //     Node{Value: "3", Next:
 //     Node{Value: "4"}}}}
+//
+// The Kind field tells how the node was written in the source, see NodeKind.
 type Node struct {
 	Location SourceLoc
 	Value    string
 	Children *Node
 	Next     *Node
+	Kind     NodeKind
+	index    *NodeIndex     // see Index
+	ctx      *SourceContext // see BindContext
+}
+
+// The syntactic form of a node, set by the parser. Writers keep the form of
+// scalars, e.g. a NodeString node is always written as a '"' string, even if
+// it could be written as a bare atom. The zero value is NodeSymbol, nodes
+// built by hand are written the way QuoteAtom does it unless their kind is
+// set.
+//
+// Bare atoms which look like numbers are classified while lexing, so that
+// tools don't have to guess: NodeInt atoms are integers, optionally signed,
+// in decimal or with a 0x, 0o or 0b radix prefix, e.g. "-42" or "0xff";
+// NodeFloat atoms are decimal numbers with a fraction or an exponent, e.g.
+// "3.0" or "1e-9". Whether the number fits any Go type is not checked. Piped
+// symbols and strings are never classified as numbers.
+//
+// Empty lists have no children, hence they are scalars with empty value just
+// like `""`, the kind is the only way to tell them apart. Whether a node is a
+// list is still determined by its children, the kind of non-empty lists is
+// informational.
+type NodeKind uint8
+
+const (
+	NodeSymbol    NodeKind = iota // a bare atom or a piped symbol
+	NodeString                    // a '"' string, a text block or a heredoc
+	NodeRawString                 // a '`' string
+	NodeList                      // a list
+	NodeInt                       // a bare atom which is an integer
+	NodeFloat                     // a bare atom which is a floating point number
+)
+
+var node_kind_names = [...]string{
+	NodeSymbol:    "symbol",
+	NodeString:    "string",
+	NodeRawString: "raw string",
+	NodeList:      "list",
+	NodeInt:       "int",
+	NodeFloat:     "float",
+}
+
+func (k NodeKind) String() string {
+	if int(k) < len(node_kind_names) {
+		return node_kind_names[k]
+	}
+	return fmt.Sprintf("NodeKind(%d)", int(k))
+}
+
+// Returns true for the kinds of bare atoms: symbols and numbers.
+func (k NodeKind) is_bare() bool {
+	return k == NodeSymbol || k == NodeInt || k == NodeFloat
 }
 
 // Returns true if the node is a list (has children).
@@ -48,7 +106,7 @@ func (n *Node) String() string {
 	return n.Value
 }
 
-// Returns the number of children nodes. Has O(N) complexity.
+// Returns the number of children nodes. Has O(N) complexity, see Index.
 func (n *Node) NumChildren() int {
 	i := 0
 	c := n.Children
@@ -59,7 +117,8 @@ func (n *Node) NumChildren() int {
 	return i
 }
 
-// Returns Nth child node. If node is not a list, it will return an error.
+// Returns Nth child node. If node is not a list, it will return an error. Has
+// O(N) complexity, see Index.
 func (n *Node) Nth(num int) (*Node, error) {
 	if !n.IsList() {
 		return nil, NewUnmarshalError(n, nil, "node is not a list")
@@ -109,6 +168,15 @@ type Unmarshaler interface {
 	UnmarshalSexp(n *Node) error
 }
 
+// Validator is implemented by struct types which check their values after
+// decoding. (*Node).Unmarshal calls ValidateSexp with the node the struct was
+// decoded from after all its fields are set, so that errors can point at the
+// relevant nodes, see NewUnmarshalError. Other errors are reported as
+// *UnmarshalError pointing at the node.
+type Validator interface {
+	ValidateSexp(n *Node) error
+}
+
 // Unmarshals all children nodes of the node to pointer values. Applies the
 // same logic as Unmarshal. See description of the (*Node).Unmarshal method for
 // more details.
@@ -163,35 +231,107 @@ func (n *Node) UnmarshalChildren(vals ...interface{}) (err error) {
 //  - structs
 //  - pointers to any of the supported types (only one level of indirection)
 //  - any type which implements Unmarshaler
+//  - *Node
 //
 // Here's some details on unmarshaling semantics:
 //  (u)ints: unmarshaled using strconv.ParseInt/strconv.ParseUint with base 10
 //           only
 //  floats:  unmarshaled using strconv.ParseFloat
-//  bool:    works strictly on two values "true" or "false"
+//  bool:    works strictly on two values "true" or "false", unless more
+//           values are given using the BoolStrings option
 //  string:  unmarshaled as is (keep in mind that lexer supports escape sequences)
 //  arrays:  uses up to len(array) elements, if there is a smaller amount of
-//           elements, the rest is zeroed
+//           elements, the rest is zeroed, extra elements are ignored unless
+//           the StrictArrays option is given
 //  slices:  uses all elements appending them to the slice, however if the slice
 //           was bigger than the amount of elements, it will reslice it to the
 //           appropriate length
-//  iface:   only empty interfaces are supported, it will unmarshal AST to
-//           a []interface{} or a string
+//  iface:   empty interfaces receive a []interface{} or a string, other
+//           interfaces require the WithInterfaceFactory option
 //  map:     when unmarshaling to the map, assumes this AST form:
 //           `((key value) (key value) (key value))`, doesn't clear the map
-//           before appending all the key value pairs
+//           before appending all the key value pairs, keys must be atoms and
+//           of a basic type or a type implementing Unmarshaler or
+//           encoding.TextUnmarshaler; values are decoded into addressable
+//           temporaries, so types implementing Unmarshaler with pointer
+//           receivers work as map values too; if the map has interface
+//           values and the entry exists and holds a non-nil pointer, the
+//           value it points to is decoded instead, i.e. the type of the
+//           value is taken from the entry
 //  struct:  uses the same AST form as the map, where `key` means `field`,
 //           supports `sexp` tags (see description below), will try to match
 //           name specified in the tag, the field name and the field name
 //           ignoring the case in that order
+//  *Node:   receives the node itself, nothing is decoded, which is useful for
+//           deferring the decoding of a subtree (keep in mind that the node
+//           is shared with the AST, including its Next pointer)
 //
 // Struct tags have the form: "name,opt,opt". Special tag "-" means "skip me".
 // Supported options:
 //  siblings: will use sibling nodes instead of children for unmarshaling
-//            to an array or a slice.
+//            to an array, a slice or a struct, e.g. a struct with positional
+//            fields can be decoded from `(version 3.0 stable)`.
+//  inline:   like siblings, but the items may be given in a nested list as
+//            well, i.e. both `(functions a b c)` and `(functions (a b c))`
+//            decode to the same slice. A single list item is always taken
+//            as the nested list, use siblings if it's an element.
+//  repeated: the field must be a slice, each occurrence of the key appends
+//            an element decoded from its value, e.g. `(plugin a) (plugin b)`,
+//            other options apply to the elements. Without the option the
+//            last occurrence of a key wins.
+//  pos=N:    the field is decoded from the Nth item of the list (counting
+//            from 0) instead of being matched against keys. A struct with
+//            such fields is decoded from a list where the positional items
+//            come first, followed by key/value pairs, e.g. `(3.0 stable
+//            (date 2014-05-01))`. Other options have no effect on the field.
+//  location: the field must be of type SourceLoc, it receives the location of
+//            the node the struct is decoded from instead of being matched
+//            against keys, use SourceContext.Decode to get the position.
+//  node:     the field must be of type *Node, it receives the node the struct
+//            is decoded from instead of being matched against keys.
+//  enum=a|b: the value must be one of the given atoms, e.g.
+//            `sexp:"level,enum=debug|info|warn|error"`, for arrays and slices
+//            the option applies to their elements.
+//  symbol:   the value must be a bare atom, a number included (see
+//            NodeKind), e.g. to tell names from free text, for arrays and
+//            slices the option applies to their elements.
+//  string:   the value must be a '"' or '`' string, for arrays and slices
+//            the option applies to their elements.
+//  flags=F:  the field must be of an integer type, it's decoded from a list
+//            of flag names, e.g. `(read write)`, which are ORed together. F
+//            is either a list of flags in the form "name:bits;name:bits",
+//            e.g. `sexp:"perms,flags=read:1;write:2;execute:4"`, or a name
+//            of a flag set registered using the FlagSet option. Numeric
+//            atoms are taken as raw bits.
+//  using=F:  the field is decoded using the function registered with the
+//            DecodeUsing option under the name F.
+//  deprecated=M: the key is still decoded, but its use is reported to the
+//            function given with the OnDeprecated option, with the message M
+//            (which cannot contain commas), e.g.
+//            `sexp:"timeout,deprecated=use timeout-ms"`. The message is
+//            optional, `sexp:"timeout,deprecated"` works as well.
+//  min=N, max=N: the value must be a number within the bounds (inclusive),
+//            e.g. `sexp:"port,min=1,max=65535"`, for arrays and slices the
+//            options apply to their elements.
+//  pattern=R: the value must be an atom matching the regular expression R
+//            (see package regexp, R cannot contain commas), e.g.
+//            `sexp:"name,pattern=^[a-z]+$"`, for arrays and slices the option
+//            applies to their elements.
+//  minlen=N, maxlen=N: the length of a string (in characters), or the
+//            number of items of an array, a slice or a map must be within
+//            the bounds, e.g. `sexp:"items,minlen=1"`. Like the other
+//            constraints above, it's checked only if the key is present.
+//            For repeated fields, it's the number of occurrences of the key.
+//  secret:   the value is sensitive, e.g. a password, errors about it don't
+//            include it, see UnmarshalError.Redacted. Use SecretKeys with
+//            (*Node).Redact or FormatOptions.Redact to mask it when printing.
+//
+// Unknown options are reported when the field is decoded, which catches
+// pattern and deprecated options split at a comma as well.
 //
 // Important note: If the type implements Unmarshaler interface, it will use it
-// instead of applying default unmarshaling strategies described above.
+// instead of applying default unmarshaling strategies described above. Structs
+// implementing Validator are validated after decoding.
 func (n *Node) Unmarshal(vals ...interface{}) (err error) {
 	if len(vals) == 0 {
 		return nil
@@ -236,8 +376,20 @@ func (n *Node) Unmarshal(vals ...interface{}) (err error) {
 }
 
 type UnmarshalError struct {
-	Type    reflect.Type
-	Node    *Node
+	Type reflect.Type
+	Node *Node
+
+	// Path to the value which failed to unmarshal, relative to the value
+	// passed to Unmarshal, e.g. `servers[3].port`. Struct fields are
+	// denoted by their keys, map entries by their quoted keys in brackets
+	// and array or slice elements by their indices in brackets. Empty if
+	// the error is about the value passed to Unmarshal itself.
+	Path string
+
+	// True if the value is secret, see the "secret" tag option of
+	// (*Node).Unmarshal. The value is not included in the message then.
+	Redacted bool
+
 	message string
 }
 
@@ -261,9 +413,15 @@ func NewUnmarshalError(n *Node, t reflect.Type, format string, args ...interface
 func (e *UnmarshalError) Error() string {
 	args := []interface{}{e.message}
 	format := "%s"
+	if e.Path != "" {
+		format = "%s: " + format
+		args = append([]interface{}{strings.TrimPrefix(e.Path, ".")}, args...)
+	}
 	if e.Node != nil {
 		if e.Node.IsList() {
 			format += " (list value)"
+		} else if e.Redacted {
+			format += " (value redacted)"
 		} else {
 			format += " (value: %q)"
 			args = append(args, e.Node.Value)
@@ -277,8 +435,33 @@ func (e *UnmarshalError) Error() string {
 	return fmt.Sprintf(format, args...)
 }
 
+// Marks the error as redacted, masking the quoted value of the node in the
+// message as well, e.g. strconv errors quote the value. Unquoted occurrences
+// are left alone, a short value would match unrelated parts of the message.
+func (e *UnmarshalError) redact() {
+	e.Redacted = true
+	if e.Node != nil && e.Node.IsScalar() && e.Node.Value != "" {
+		v := e.Node.Value
+		e.message = strings.Replace(e.message, strconv.Quote(v), redacted_value, -1)
+	}
+}
+
+// Redacts *UnmarshalError panics passing through if `secret` is true, must be
+// deferred.
+func redact_panic(secret bool) {
+	if !secret {
+		return
+	}
+	if e := recover(); e != nil {
+		if ue, ok := e.(*UnmarshalError); ok {
+			ue.redact()
+		}
+		panic(e)
+	}
+}
+
 func (n *Node) unmarshal_error(t reflect.Type, format string, args ...interface{}) {
-	panic(NewUnmarshalError(n, t, fmt.Sprintf(format, args...)))
+	panic(NewUnmarshalError(n, t, format, args...))
 }
 
 func (n *Node) unmarshal_unmarshaler(v reflect.Value) bool {
@@ -298,13 +481,35 @@ func (n *Node) unmarshal_unmarshaler(v reflect.Value) bool {
 			if ue, ok := err.(*UnmarshalError); ok {
 				panic(ue)
 			}
-			n.unmarshal_error(v.Type(), err.Error())
+			n.unmarshal_error(v.Type(), "%s", err)
 		}
 		return true
 	}
 	return false
 }
 
+// Unmarshals an element of a container (see unmarshal_value), prepending the
+// element to the path of an error. The element is a key if `key` is not nil,
+// an index otherwise.
+func (n *Node) unmarshal_elem(v reflect.Value, use_siblings bool, key *Node, index int, in_map bool, o *unmarshal_options) {
+	defer func() {
+		if e := recover(); e != nil {
+			if ue, ok := e.(*UnmarshalError); ok {
+				switch {
+				case key == nil:
+					ue.Path = "[" + strconv.Itoa(index) + "]" + ue.Path
+				case in_map:
+					ue.Path = "[" + strconv.Quote(key.Value) + "]" + ue.Path
+				default:
+					ue.Path = "." + key.Value + ue.Path
+				}
+			}
+			panic(e)
+		}
+	}()
+	n.unmarshal_value(v, use_siblings, o)
+}
+
 func (n *Node) ensure_scalar(t reflect.Type) {
 	if n.IsScalar() {
 		return
@@ -314,15 +519,34 @@ func (n *Node) ensure_scalar(t reflect.Type) {
 }
 
 func (n *Node) ensure_list(t reflect.Type) {
-	if n.IsList() {
+	// an empty list has no children, but it's a list nevertheless
+	if n.IsList() || n.Kind == NodeList {
 		return
 	}
 
 	n.unmarshal_error(t, "list value required")
 }
 
-func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
+var node_ptr_type = reflect.TypeOf((*Node)(nil))
+var unmarshaler_type = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool, o *unmarshal_options) {
+	forms := o.forms
+	if forms {
+		nested := *o
+		nested.forms = false
+		o = &nested
+	}
 	t := v.Type()
+	if t == node_ptr_type {
+		v.Set(reflect.ValueOf(n))
+		return
+	}
+	if h := o.type_hooks[t]; h != nil {
+		n.unmarshal_hook(v, h)
+		return
+	}
+
 	// we support one level of indirection at the moment
 	if v.Kind() == reflect.Ptr {
 		// if the pointer is nil, allocate a new element of the type it
@@ -331,6 +555,15 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 			v.Set(reflect.New(t.Elem()))
 		}
 		v = v.Elem()
+		t = v.Type()
+		if t == node_ptr_type {
+			v.Set(reflect.ValueOf(n))
+			return
+		}
+		if h := o.type_hooks[t]; h != nil {
+			n.unmarshal_hook(v, h)
+			return
+		}
 	}
 
 	// try Unmarshaler interface
@@ -339,49 +572,10 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 	}
 
 	// fallback to default unmarshaling scheme
+	if n.unmarshal_scalar(v, o) {
+		return
+	}
 	switch v.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		// TODO: more string -> int conversion options (hex, binary, octal, etc.)
-		n.ensure_scalar(t)
-		num, err := strconv.ParseInt(n.Value, 10, 64)
-		if err != nil {
-			n.unmarshal_error(t, err.Error())
-		}
-		if v.OverflowInt(num) {
-			n.unmarshal_error(t, "integer overflow")
-		}
-		v.SetInt(num)
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		// TODO: more string -> int conversion options (hex, binary, octal, etc.)
-		n.ensure_scalar(t)
-		num, err := strconv.ParseUint(n.Value, 10, 64)
-		if err != nil {
-			n.unmarshal_error(t, err.Error())
-		}
-		if v.OverflowUint(num) {
-			n.unmarshal_error(t, "integer overflow")
-		}
-		v.SetUint(num)
-	case reflect.Float32, reflect.Float64:
-		n.ensure_scalar(t)
-		num, err := strconv.ParseFloat(n.Value, 64)
-		if err != nil {
-			n.unmarshal_error(t, err.Error())
-		}
-		v.SetFloat(num)
-	case reflect.Bool:
-		n.ensure_scalar(t)
-		switch n.Value {
-		case "true":
-			v.SetBool(true)
-		case "false":
-			v.SetBool(false)
-		default:
-			n.unmarshal_error(t, "undefined boolean value, use true|false")
-		}
-	case reflect.String:
-		n.ensure_scalar(t)
-		v.SetString(n.Value)
 	case reflect.Array, reflect.Slice:
 		if !use_siblings {
 			n.ensure_list(t)
@@ -394,13 +588,18 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 		for ; c != nil; c = c.Next {
 			if i >= v.Len() {
 				if v.Kind() == reflect.Array {
+					if o.strict_arrays {
+						err := NewUnmarshalError(c, t, "too many elements, the array has %d", v.Len())
+						err.Path = "[" + strconv.Itoa(i) + "]"
+						panic(err)
+					}
 					break
 				} else {
 					v.Set(reflect.Append(v, reflect.Zero(t.Elem())))
 				}
 			}
 
-			c.unmarshal_value(v.Index(i), false)
+			c.unmarshal_elem(v.Index(i), false, nil, i, false, o)
 			i++
 		}
 
@@ -416,7 +615,8 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 		}
 	case reflect.Interface:
 		if v.NumMethod() != 0 {
-			n.unmarshal_error(t, "unsupported type")
+			n.unmarshal_factory(v, o)
+			return
 		}
 
 		v.Set(reflect.ValueOf(n.unmarshal_as_interface()))
@@ -429,8 +629,15 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 		keyv := reflect.New(t.Key()).Elem()
 		valv := reflect.New(t.Elem()).Elem()
 		err := n.IterKeyValues(func(key, val *Node) error {
-			key.unmarshal_value(keyv, false)
-			val.unmarshal_value(valv, false)
+			// values may be partially reused otherwise, e.g. slices
+			keyv.Set(reflect.Zero(t.Key()))
+			valv.Set(reflect.Zero(t.Elem()))
+			key.unmarshal_map_key(keyv, o)
+			if p := existing_map_pointer(v, keyv); p.IsValid() {
+				val.unmarshal_elem(p.Elem(), false, key, 0, true, o)
+				return nil
+			}
+			val.unmarshal_elem(valv, false, key, 0, true, o)
 			v.SetMapIndex(keyv, valv)
 			return nil
 		})
@@ -438,7 +645,14 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 			n.unmarshal_error(t, "%s", err)
 		}
 	case reflect.Struct:
-		err := n.IterKeyValues(func(key, val *Node) error {
+		n.unmarshal_special_fields(v)
+		list := n
+		if use_siblings {
+			list = &Node{Location: n.Location, Children: n}
+		}
+		pairs := list.unmarshal_positional(v, o)
+		seen := make(map[string]repeated_key) // repeated fields decoded so far
+		err := pairs.IterKeyValues(func(key, val *Node) error {
 			var f reflect.StructField
 			var ok bool
 			var opts tag_options
@@ -453,6 +667,12 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 					continue
 				}
 				tagname, opts = parse_tag(tag)
+				if opts.contains("location") || opts.contains("node") {
+					continue
+				}
+				if _, pos := opts.value("pos"); pos {
+					continue
+				}
 
 				ok = tagname == key.Value
 				if ok {
@@ -470,9 +690,47 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 			if ok {
 				if f.PkgPath != "" {
 					n.unmarshal_error(t, "writing to an unexported field")
+				} else if opt := opts.unknown(); opt != "" {
+					n.unmarshal_error(t, "unknown option %q of field %s", opt, f.Name)
 				} else {
+					o.check_deprecated(key, opts)
+					defer redact_panic(opts.contains("secret"))
 					v := v.FieldByIndex(f.Index)
-					val.unmarshal_value(v, opts.contains("siblings"))
+					siblings := opts.contains("siblings") ||
+						forms && (val.Next != nil || is_container(v.Type(), o))
+					if opts.contains("inline") && (val.Next != nil || val.IsScalar() && val.Kind != NodeList) {
+						siblings = true
+					}
+					if name, ok := opts.value("using"); ok {
+						val.unmarshal_using(v, key, name, o)
+						return nil
+					}
+					if spec, ok := opts.value("flags"); ok {
+						val.unmarshal_flags(v, siblings, key, spec, o)
+						return nil
+					}
+					ft := v.Type()
+					repeated := opts.contains("repeated")
+					if repeated {
+						if ft.Kind() != reflect.Slice {
+							n.unmarshal_error(t, "repeated field %s must be a slice", f.Name)
+						}
+						ft = ft.Elem()
+					}
+					if values, ok := opts.value("enum"); ok {
+						val.check_enum(ft, siblings, key, values)
+					}
+					if opts.contains("symbol") || opts.contains("string") {
+						val.check_kind(ft, siblings, key, opts.contains("symbol"))
+					}
+					val.check_constraints(t, f.Name, ft, siblings, key, opts, repeated)
+					if repeated {
+						_, decoded := seen[f.Name]
+						val.unmarshal_repeated(v, siblings, key, !decoded, o)
+						seen[f.Name] = repeated_key{key, val, opts}
+						return nil
+					}
+					val.unmarshal_elem(v, siblings, key, 0, false, o)
 				}
 			}
 			return nil
@@ -480,11 +738,512 @@ func (n *Node) unmarshal_value(v reflect.Value, use_siblings bool) {
 		if err != nil {
 			n.unmarshal_error(t, "%s", err)
 		}
+		for i, num := 0, t.NumField(); i < num; i++ {
+			f := t.Field(i)
+			if r, ok := seen[f.Name]; ok {
+				r.check_count(t, f, v.FieldByIndex(f.Index).Len())
+			}
+		}
+		n.validate(v)
 	default:
 		n.unmarshal_error(t, "unsupported type")
 	}
 }
 
+// Unmarshals a bool, a number or a string, returns false if the value is of
+// any other kind.
+func (n *Node) unmarshal_scalar(v reflect.Value, o *unmarshal_options) bool {
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// TODO: more string -> int conversion options (hex, binary, octal, etc.)
+		n.ensure_scalar(t)
+		num, err := strconv.ParseInt(n.Value, 10, 64)
+		if err != nil {
+			n.unmarshal_error(t, "%s", err)
+		}
+		if v.OverflowInt(num) {
+			n.unmarshal_error(t, "integer overflow")
+		}
+		v.SetInt(num)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// TODO: more string -> int conversion options (hex, binary, octal, etc.)
+		n.ensure_scalar(t)
+		num, err := strconv.ParseUint(n.Value, 10, 64)
+		if err != nil {
+			n.unmarshal_error(t, "%s", err)
+		}
+		if v.OverflowUint(num) {
+			n.unmarshal_error(t, "integer overflow")
+		}
+		v.SetUint(num)
+	case reflect.Float32, reflect.Float64:
+		n.ensure_scalar(t)
+		num, err := strconv.ParseFloat(n.Value, 64)
+		if err != nil {
+			n.unmarshal_error(t, "%s", err)
+		}
+		v.SetFloat(num)
+	case reflect.Bool:
+		n.ensure_scalar(t)
+		switch b, ok := o.bools[n.Value]; {
+		case ok:
+			v.SetBool(b)
+		case n.Value == "true":
+			v.SetBool(true)
+		case n.Value == "false":
+			v.SetBool(false)
+		default:
+			n.unmarshal_error(t, "undefined boolean value, use %s", o.bool_names())
+		}
+	case reflect.String:
+		n.ensure_scalar(t)
+		v.SetString(n.Value)
+	default:
+		return false
+	}
+	return true
+}
+
+// Makes sure the value of a field tagged with the "enum" option is one of the
+// allowed atoms, separated by '|'. For arrays and slices every element is
+// checked instead.
+func (n *Node) check_enum(t reflect.Type, siblings bool, key *Node, values string) {
+	allowed := strings.Split(values, "|")
+	n.check_elems(t, siblings, key, "invalid value, use "+values, func(n *Node) bool {
+		if n.IsScalar() {
+			for _, a := range allowed {
+				if n.Value == a {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// Makes sure the value of a field tagged with the "symbol" or "string"
+// option is a scalar of that kind. For arrays and slices every element is
+// checked instead.
+func (n *Node) check_kind(t reflect.Type, siblings bool, key *Node, symbol bool) {
+	message := "string expected"
+	if symbol {
+		message = "symbol expected"
+	}
+	n.check_elems(t, siblings, key, message, func(n *Node) bool {
+		return n.IsScalar() && n.Kind != NodeList && n.Kind.is_bare() == symbol
+	})
+}
+
+// Enforces the min, max, pattern, minlen and maxlen options of a field, see
+// (*Node).Unmarshal. Values which are not numbers are left to the decoding to
+// report.
+func (n *Node) check_constraints(st reflect.Type, name string, t reflect.Type, siblings bool, key *Node, opts tag_options, repeated bool) {
+	elem := t
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array {
+		elem = elem.Elem()
+	}
+	bound := func(option string) (float64, string, bool) {
+		s, ok := opts.value(option)
+		if !ok {
+			return 0, "", false
+		}
+		num, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			n.unmarshal_error(st, "invalid %s option of field %s: %q", option, name, s)
+		}
+		return num, s, true
+	}
+	number := func(n *Node) (float64, bool) {
+		if n.IsList() {
+			return 0, false
+		}
+		num, err := strconv.ParseFloat(n.Value, 64)
+		return num, err == nil
+	}
+
+	for _, option := range [...]string{"min", "max"} {
+		limit, s, ok := bound(option)
+		if !ok {
+			continue
+		}
+		if !is_number_kind(elem.Kind()) {
+			n.unmarshal_error(st, "%s option of field %s requires a number", option, name)
+		}
+		message := "value is less than the minimum " + s
+		if option == "max" {
+			message = "value is greater than the maximum " + s
+		}
+		n.check_elems(t, siblings, key, message, func(n *Node) bool {
+			num, ok := number(n)
+			return !ok || (option == "min" && num >= limit) || (option == "max" && num <= limit)
+		})
+	}
+
+	if pattern, ok := opts.value("pattern"); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			n.unmarshal_error(st, "invalid pattern option of field %s: %s", name, err)
+		}
+		n.check_elems(t, siblings, key, "value doesn't match pattern "+pattern, func(n *Node) bool {
+			return n.IsScalar() && re.MatchString(n.Value)
+		})
+	}
+
+	_, minlen := opts.value("minlen")
+	_, maxlen := opts.value("maxlen")
+	if repeated || !minlen && !maxlen {
+		// the number of occurrences of repeated keys is checked after the
+		// last one, see check_count
+		return
+	}
+	lt := t
+	if lt.Kind() == reflect.Ptr {
+		lt = lt.Elem()
+	}
+	length := 0
+	switch lt.Kind() {
+	case reflect.String:
+		length = utf8.RuneCountInString(n.Value)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		c := n.Children
+		if siblings {
+			c = n
+		}
+		for ; c != nil; c = c.Next {
+			length++
+		}
+	default:
+		n.unmarshal_error(st, "minlen and maxlen options of field %s require a string, an array, a slice or a map", name)
+	}
+	n.check_length(st, name, lt, key, opts, length, "length")
+}
+
+// Checks the length against the "minlen" and "maxlen" options, `what` is how
+// the length is called in errors.
+func (n *Node) check_length(st reflect.Type, name string, t reflect.Type, key *Node, opts tag_options, length int, what string) {
+	for _, option := range [...]string{"minlen", "maxlen"} {
+		s, ok := opts.value(option)
+		if !ok {
+			continue
+		}
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			n.unmarshal_error(st, "invalid %s option of field %s: %q", option, name, s)
+		}
+		if option == "minlen" && length < limit {
+			n.constraint_error(t, key, what+" is less than the minimum "+s)
+		}
+		if option == "maxlen" && length > limit {
+			n.constraint_error(t, key, what+" is greater than the maximum "+s)
+		}
+	}
+}
+
+// The last occurrence of the key of a repeated field, see unmarshal_repeated.
+type repeated_key struct {
+	key, val *Node
+	opts     tag_options
+}
+
+// Checks the number of occurrences of the key against the "minlen" and
+// "maxlen" options, the errors point at the last occurrence.
+func (r repeated_key) check_count(st reflect.Type, f reflect.StructField, count int) {
+	defer redact_panic(r.opts.contains("secret"))
+	r.val.check_length(st, f.Name, f.Type, r.key, r.opts, count, "number of occurrences")
+}
+
+func (n *Node) constraint_error(t reflect.Type, key *Node, message string) {
+	err := NewUnmarshalError(n, t, "%s", message)
+	err.Path = "." + key.Value
+	panic(err)
+}
+
+func is_number_kind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Checks the value of a field using `valid`, or each of its elements if the
+// field is an array or a slice. Invalid values are reported with `message`.
+func (n *Node) check_elems(t reflect.Type, siblings bool, key *Node, message string, valid func(n *Node) bool) {
+	check := func(n *Node, t reflect.Type, path string) {
+		if valid(n) {
+			return
+		}
+		err := NewUnmarshalError(n, t, "%s", message)
+		err.Path = path
+		panic(err)
+	}
+
+	path := "." + key.Value
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		check(n, t, path)
+		return
+	}
+	c := n.Children
+	if siblings {
+		c = n
+	}
+	for i := 0; c != nil; c, i = c.Next, i+1 {
+		check(c, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+	}
+}
+
+// Decode function registered using DecodeType or DecodeUsing, returns a
+// value of type `typ`.
+type decode_hook struct {
+	typ reflect.Type
+	f   func(n *Node) (reflect.Value, error)
+}
+
+func (n *Node) unmarshal_hook(v reflect.Value, h *decode_hook) {
+	r, err := h.f(n)
+	if err != nil {
+		if ue, ok := err.(*UnmarshalError); ok {
+			panic(ue)
+		}
+		n.unmarshal_error(h.typ, "%s", err)
+	}
+	v.Set(r)
+}
+
+// Decodes a field tagged with the "using" option.
+func (n *Node) unmarshal_using(v reflect.Value, key *Node, name string, o *unmarshal_options) {
+	defer func() {
+		if e := recover(); e != nil {
+			if ue, ok := e.(*UnmarshalError); ok {
+				ue.Path = "." + key.Value + ue.Path
+			}
+			panic(e)
+		}
+	}()
+
+	t := v.Type()
+	h := o.using_hooks[name]
+	if h == nil {
+		n.unmarshal_error(t, "unknown decode function %q", name)
+	}
+	if !h.typ.AssignableTo(t) && t.Kind() == reflect.Ptr && h.typ.AssignableTo(t.Elem()) {
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		v = v.Elem()
+		t = v.Type()
+	}
+	if !h.typ.AssignableTo(t) {
+		n.unmarshal_error(t, "decode function %q returns %s, which is not assignable to the field",
+			name, h.typ)
+	}
+	n.unmarshal_hook(v, h)
+}
+
+// Returns the non-nil pointer held by the interface value of an existing map
+// entry, an invalid value if there is no such entry.
+func existing_map_pointer(m, key reflect.Value) reflect.Value {
+	if m.Type().Elem().Kind() != reflect.Interface {
+		return reflect.Value{}
+	}
+	old := m.MapIndex(key)
+	if !old.IsValid() || old.IsNil() {
+		return reflect.Value{}
+	}
+	p := old.Elem()
+	if p.Kind() != reflect.Ptr || p.IsNil() || p.Type() == node_ptr_type {
+		return reflect.Value{}
+	}
+	return p
+}
+
+var text_unmarshaler_type = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Unmarshals a map key, which must be a scalar. Besides the types supported
+// by unmarshal_value, keys of types implementing encoding.TextUnmarshaler are
+// supported.
+func (n *Node) unmarshal_map_key(v reflect.Value, o *unmarshal_options) {
+	t := v.Type()
+	n.ensure_scalar(t)
+	if n.unmarshal_unmarshaler(v) {
+		return
+	}
+	if reflect.PtrTo(t).Implements(text_unmarshaler_type) {
+		err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(n.Value))
+		if err != nil {
+			n.unmarshal_error(t, "%s", err)
+		}
+		return
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		n.unmarshal_value(v, false, o)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			n.unmarshal_error(t, "unsupported map key type")
+		}
+		v.Set(reflect.ValueOf(n.Value))
+	default:
+		n.unmarshal_error(t, "unsupported map key type, "+
+			"it must be a basic type or implement encoding.TextUnmarshaler")
+	}
+}
+
+// Decodes a non-empty interface using the factory given by the
+// WithInterfaceFactory option.
+func (n *Node) unmarshal_factory(v reflect.Value, o *unmarshal_options) {
+	t := v.Type()
+	if o.factory == nil {
+		n.unmarshal_error(t, "unsupported type")
+	}
+	r, err := o.factory(n, t)
+	if err != nil {
+		if ue, ok := err.(*UnmarshalError); ok {
+			panic(ue)
+		}
+		n.unmarshal_error(t, "%s", err)
+	}
+	if r == nil {
+		n.unmarshal_error(t, "unsupported type")
+	}
+	rv := reflect.ValueOf(r)
+	if !rv.Type().Implements(t) {
+		n.unmarshal_error(t, "interface factory returned %s, which doesn't implement the interface",
+			rv.Type())
+	}
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		n.unmarshal_value(rv.Elem(), false, o)
+	}
+	v.Set(rv)
+}
+
+// Calls the ValidateSexp method of a decoded struct, if there is one.
+func (n *Node) validate(v reflect.Value) {
+	var val Validator
+	var ok bool
+	if v.CanAddr() {
+		val, ok = v.Addr().Interface().(Validator)
+	} else {
+		val, ok = v.Interface().(Validator)
+	}
+	if !ok {
+		return
+	}
+	if err := val.ValidateSexp(n); err != nil {
+		if ue, ok := err.(*UnmarshalError); ok {
+			panic(ue)
+		}
+		n.unmarshal_error(v.Type(), "%s", err)
+	}
+}
+
+// Appends the value of a repeated key to the slice `v`, the slice is
+// truncated at the first occurrence of the key.
+func (n *Node) unmarshal_repeated(v reflect.Value, siblings bool, key *Node, first bool, o *unmarshal_options) {
+	if first {
+		v.SetLen(0)
+	}
+	i := v.Len()
+	v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	defer func() {
+		if e := recover(); e != nil {
+			if ue, ok := e.(*UnmarshalError); ok {
+				ue.Path = "." + key.Value + "[" + strconv.Itoa(i) + "]" + ue.Path
+			}
+			panic(e)
+		}
+	}()
+	n.unmarshal_value(v.Index(i), siblings, o)
+}
+
+// Decodes the leading items of the list into the struct fields with the
+// "pos" option, returns a list of the items following them, which are
+// key/value pairs.
+func (n *Node) unmarshal_positional(v reflect.Value, o *unmarshal_options) *Node {
+	t := v.Type()
+	var fields map[int]reflect.StructField
+	count := 0
+	for i, num := 0, t.NumField(); i < num; i++ {
+		f := t.Field(i)
+		_, opts := parse_tag(f.Tag.Get("sexp"))
+		s, ok := opts.value("pos")
+		if !ok {
+			continue
+		}
+		pos, err := strconv.Atoi(s)
+		if err != nil || pos < 0 {
+			n.unmarshal_error(t, "invalid pos option of field %s: %q", f.Name, s)
+		}
+		if other, dup := fields[pos]; dup {
+			n.unmarshal_error(t, "fields %s and %s have the same position %d", other.Name, f.Name, pos)
+		}
+		if fields == nil {
+			fields = make(map[int]reflect.StructField)
+		}
+		fields[pos] = f
+		if pos >= count {
+			count = pos + 1
+		}
+	}
+	if count == 0 {
+		return n
+	}
+
+	c := n.Children
+	for i := 0; i < count && c != nil; i, c = i+1, c.Next {
+		f, ok := fields[i]
+		if !ok {
+			continue
+		}
+		if f.PkgPath != "" {
+			n.unmarshal_error(t, "writing to an unexported field")
+		}
+		c.unmarshal_elem(v.FieldByIndex(f.Index), false, nil, i, false, o)
+	}
+	return &Node{Location: n.Location, Children: c}
+}
+
+var source_loc_type = reflect.TypeOf(SourceLoc(0))
+
+// Sets the struct fields tagged with the "location" and "node" options.
+func (n *Node) unmarshal_special_fields(v reflect.Value) {
+	t := v.Type()
+	for i, num := 0, t.NumField(); i < num; i++ {
+		f := t.Field(i)
+		_, opts := parse_tag(f.Tag.Get("sexp"))
+		var val reflect.Value
+		switch {
+		case opts.contains("location"):
+			if f.Type != source_loc_type {
+				n.unmarshal_error(t, "location field %s must be of type sexp.SourceLoc", f.Name)
+			}
+			val = reflect.ValueOf(n.Location)
+		case opts.contains("node"):
+			if f.Type != node_ptr_type {
+				n.unmarshal_error(t, "node field %s must be of type *sexp.Node", f.Name)
+			}
+			val = reflect.ValueOf(n)
+		default:
+			continue
+		}
+		if f.PkgPath != "" {
+			n.unmarshal_error(t, "writing to an unexported field")
+		}
+		v.Field(i).Set(val)
+	}
+}
+
 func (n *Node) unmarshal_as_interface() interface{} {
 	// interface parsing for sexp isn't really useful, the outcome is
 	// []interface{} or string
@@ -498,21 +1257,228 @@ func (n *Node) unmarshal_as_interface() interface{} {
 	return n.Value
 }
 
-func (n *Node) unmarshal(v interface{}) (err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			if _, ok := e.(*UnmarshalError); ok {
-				err = e.(error)
-			} else {
-				panic(e)
-			}
-		}
-	}()
+// Unmarshals the node directly to a reflect.Value, which is handy when the
+// target type is constructed at runtime. The value must be settable, e.g. a
+// field of an addressable struct or reflect.New(t).Elem(), otherwise it will
+// panic. Semantics are the same as in (*Node).Unmarshal.
+func (n *Node) UnmarshalValue(v reflect.Value) error {
+	return n.unmarshal_reflect(v, &unmarshal_options{})
+}
+
+func (n *Node) unmarshal_reflect(v reflect.Value, o *unmarshal_options) (err error) {
+	defer catch_unmarshal_error(&err)
+
+	if !v.CanSet() {
+		panic("Node.UnmarshalValue expects a settable value")
+	}
+	n.unmarshal_value(v, false, o)
+	return nil
+}
 
+// Converts a scalar node to the value `dst` points to using exactly the same
+// rules as Unmarshal does, which is handy for hand-written UnmarshalSexp
+// methods. `dst` must be a non-nil pointer to a bool, an integer, a floating
+// point number or a string, named types included; only the BoolStrings option
+// has an effect. Errors are returned as *UnmarshalError.
+//
+//     var port uint16
+//     if err := sexp.ConvertScalar(n, &port); err != nil {
+//         return err
+//     }
+func ConvertScalar(n *Node, dst interface{}, opts ...UnmarshalOption) (err error) {
+	pv := reflect.ValueOf(dst)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() {
+		panic("ConvertScalar expects a non-nil pointer argument")
+	}
+	defer catch_unmarshal_error(&err)
+	if !n.unmarshal_scalar(pv.Elem(), new_unmarshal_options(opts)) {
+		n.unmarshal_error(pv.Elem().Type(), "unsupported type, a scalar type expected")
+	}
+	return nil
+}
+
+func (n *Node) unmarshal(v interface{}) error {
+	return n.unmarshal_with(v, &unmarshal_options{})
+}
+
+func (n *Node) unmarshal_with(v interface{}, o *unmarshal_options) error {
 	pv := reflect.ValueOf(v)
 	if pv.Kind() != reflect.Ptr || pv.IsNil() {
 		panic("Node.Unmarshal expects a non-nil pointer argument")
 	}
-	n.unmarshal_value(pv.Elem(), false)
-	return nil
+	return n.unmarshal_reflect(pv.Elem(), o)
+}
+
+// Option for (*Node).UnmarshalWith and Decoder which adjusts unmarshaling.
+// Options are not passed to UnmarshalSexp methods, which decode the nodes
+// they receive on their own.
+type UnmarshalOption func(o *unmarshal_options)
+
+type unmarshal_options struct {
+	bools       map[string]bool
+	flag_sets   map[string][]flag_bit
+	type_hooks  map[reflect.Type]*decode_hook
+	using_hooks map[string]*decode_hook
+	factory     func(n *Node, t reflect.Type) (interface{}, error)
+	forms       bool // see TopLevelForms, cleared once the root is reached
+
+	strict_arrays bool
+	deprecated    func(d *Diagnostic)
+}
+
+// Adds boolean atoms accepted in addition to "true" and "false", e.g.:
+//
+//     sexp.BoolStrings(map[string]bool{"yes": true, "no": false})
+//
+// Multiple BoolStrings options are merged, the map may override the meaning
+// of "true" and "false" as well.
+func BoolStrings(m map[string]bool) UnmarshalOption {
+	return func(o *unmarshal_options) {
+		if o.bools == nil {
+			o.bools = make(map[string]bool)
+		}
+		for k, v := range m {
+			o.bools[k] = v
+		}
+	}
+}
+
+// Sets the function which chooses concrete types for non-empty interfaces,
+// which are not supported otherwise. The factory receives the node and the
+// interface type and returns a value implementing the interface. If the value
+// is a non-nil pointer, the node is unmarshaled to the value it points to,
+// otherwise the value is used as is. If the factory returns nil, the
+// interface type is reported as unsupported. E.g.:
+//
+//     sexp.WithInterfaceFactory(func(n *sexp.Node, t reflect.Type) (interface{}, error) {
+//         var v struct{ Kind string }
+//         if err := n.Unmarshal(&v); err != nil {
+//             return nil, err
+//         }
+//         switch v.Kind {
+//         case "http":
+//             return new(HTTPPlugin), nil
+//         case "file":
+//             return new(FilePlugin), nil
+//         }
+//         return nil, fmt.Errorf("unknown plugin kind %q", v.Kind)
+//     })
+func WithInterfaceFactory(f func(n *Node, t reflect.Type) (interface{}, error)) UnmarshalOption {
+	return func(o *unmarshal_options) {
+		o.factory = f
+	}
+}
+
+// Makes elements which don't fit into an array an error, they are ignored
+// by default. E.g. `(1 2 3 4)` cannot be decoded into [3]int with the option,
+// the error points at the 4th element.
+func StrictArrays() UnmarshalOption {
+	return func(o *unmarshal_options) {
+		o.strict_arrays = true
+	}
+}
+
+// Decodes a struct from the top-level forms of a file, i.e. from the root
+// node returned by Parse or Loader.Load. Each form `(key item ...)` is matched
+// against the fields by its head just like a key/value pair, but if the field
+// is a struct, a map, an array or a slice (or there are several items), the
+// items are decoded as if the field was tagged with `siblings`, regardless of
+// their number. Hence `(tags a)` fills a []string with one element and
+//
+//     (namespace Gtk)
+//     (version 3.0)
+//     (blacklist (structs (StockItem)))
+//
+// fills the Namespace, Version and Blacklist fields of:
+//
+//     struct {
+//         Namespace string
+//         Version   float64
+//         Blacklist struct {
+//             Structs   []string
+//             Functions []string
+//         }
+//     }
+//
+// The option applies to the node being decoded only, nested lists are
+// decoded as usual. Load, LoadFS and LoadLayers use it.
+func TopLevelForms() UnmarshalOption {
+	return func(o *unmarshal_options) {
+		o.forms = true
+	}
+}
+
+// Returns true if values of the type are decoded from lists, hence the items
+// of top-level forms are decoded as siblings, see TopLevelForms. Types with
+// hooks or their own unmarshalers decode from a single node, as do byte
+// slices.
+func is_container(t reflect.Type, o *unmarshal_options) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if o.type_hooks[t] != nil || reflect.PtrTo(t).Implements(unmarshaler_type) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Array:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8
+	}
+	return false
+}
+
+// Sets a function receiving warnings about the use of keys of fields tagged
+// with the "deprecated" option, e.g. to collect telemetry during a migration
+// to new keys. Warnings are diagnostics with SeverityWarning located at the
+// key, the message reads "key K is deprecated: M". Decoding is not affected.
+func OnDeprecated(f func(d *Diagnostic)) UnmarshalOption {
+	return func(o *unmarshal_options) {
+		o.deprecated = f
+	}
+}
+
+// Reports the use of a deprecated key if the field is tagged so, see
+// OnDeprecated.
+func (o *unmarshal_options) check_deprecated(key *Node, opts tag_options) {
+	message, ok := opts.value("deprecated")
+	if o.deprecated == nil || !ok && !opts.contains("deprecated") {
+		return
+	}
+	text := "key " + key.Value + " is deprecated"
+	if message != "" {
+		text += ": " + message
+	}
+	o.deprecated(&Diagnostic{
+		Severity: SeverityWarning,
+		Message:  text,
+		Location: key.Location,
+	})
+}
+
+func new_unmarshal_options(opts []UnmarshalOption) *unmarshal_options {
+	o := new(unmarshal_options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Returns accepted boolean atoms for error messages, e.g. "true|false|yes".
+func (o *unmarshal_options) bool_names() string {
+	names := []string{"true", "false"}
+	var extra []string
+	for k := range o.bools {
+		if k != "true" && k != "false" {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+	return strings.Join(append(names, extra...), "|")
+}
+
+// Unmarshals the node to a pointer value, like Unmarshal does, using the
+// given options.
+func (n *Node) UnmarshalWith(v interface{}, opts ...UnmarshalOption) error {
+	return n.unmarshal_with(v, new_unmarshal_options(opts))
 }
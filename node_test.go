@@ -2,6 +2,7 @@ package sexp
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
@@ -114,6 +115,172 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
+func TestUnmarshalNode(t *testing.T) {
+	var v struct {
+		Name    string
+		Payload *Node
+		Extra   **Node
+	}
+	test_unmarshal(t, `(name x) (payload (1 2 3)) (extra y)`, &v)
+	if v.Name != "x" {
+		t.Errorf("\"x\" expected, got: %q", v.Name)
+	}
+	if v.Payload == nil || v.Payload.NumChildren() != 3 {
+		t.Fatalf("a list with 3 children expected")
+	}
+	var nums []int
+	if err := v.Payload.Unmarshal(&nums); err != nil {
+		t.Fatal(err)
+	}
+	if len(nums) != 3 || nums[2] != 3 {
+		t.Errorf("[1 2 3] expected, got: %v", nums)
+	}
+	if v.Extra == nil || *v.Extra == nil || (*v.Extra).Value != "y" {
+		t.Errorf("node with value \"y\" expected")
+	}
+}
+
+func TestUnmarshalLocation(t *testing.T) {
+	type item struct {
+		Loc  SourceLoc `sexp:",location"`
+		Name string
+	}
+	var v struct {
+		Items []item
+	}
+	var ctx SourceContext
+	f := ctx.AddFile("test.sexp", -1)
+	root, err := Parse(strings.NewReader("(items (\n  ((name a))\n  ((name b) (loc x))))"), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Unmarshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Items) != 2 || v.Items[1].Name != "b" {
+		t.Fatalf("unexpected items: %+v", v.Items)
+	}
+	if loc := ctx.Decode(v.Items[1].Loc); loc.Line != 3 || loc.Offset != 24 {
+		t.Errorf("unexpected location: %+v", loc)
+	}
+
+	var bad struct {
+		Loc int `sexp:",location"`
+	}
+	test_unmarshal_error(t, "(a 1)", "must be of type sexp.SourceLoc", &bad)
+}
+
+func TestUnmarshalNodeField(t *testing.T) {
+	type window struct {
+		Node  *Node `sexp:",node"`
+		Title string
+	}
+	var v struct {
+		Window window
+	}
+	test_unmarshal(t, "(window ((title x) (extra 1) (node 2)))", &v)
+	if v.Window.Title != "x" {
+		t.Errorf("\"x\" expected, got: %q", v.Window.Title)
+	}
+	if n := v.Window.Node; n == nil || n.NumChildren() != 3 {
+		t.Fatalf("a list with 3 children expected")
+	}
+
+	var bad struct {
+		Node Node `sexp:",node"`
+	}
+	test_unmarshal_error(t, "(a 1)", "must be of type \\*sexp.Node", &bad)
+}
+
+func TestUnmarshalValue(t *testing.T) {
+	root, err := Parse(strings.NewReader("(1 2 3)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := root.Nth(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typ := reflect.SliceOf(reflect.TypeOf(int16(0)))
+	v := reflect.New(typ).Elem()
+	if err := list.UnmarshalValue(v); err != nil {
+		t.Fatal(err)
+	}
+	if s := v.Interface().([]int16); len(s) != 3 || s[1] != 2 {
+		t.Errorf("[1 2 3] expected, got: %v", s)
+	}
+
+	var st struct{ A [2]uint8 }
+	if err := list.UnmarshalValue(reflect.ValueOf(&st).Elem().Field(0)); err != nil {
+		t.Fatal(err)
+	}
+	if st.A != [2]uint8{1, 2} {
+		t.Errorf("[1 2] expected, got: %v", st.A)
+	}
+
+	expect_panic(func() {
+		list.UnmarshalValue(reflect.ValueOf(st))
+	}, func(v interface{}) {
+		if s, ok := v.(string); ok {
+			must_contain(t, s, "expects a settable value")
+		} else {
+			t.Errorf("unexpected panic: %v", v)
+		}
+	})
+}
+
+type text_key struct {
+	ns, name string
+}
+
+func (k *text_key) UnmarshalText(text []byte) error {
+	i := strings.IndexByte(string(text), ':')
+	if i == -1 {
+		return errors.New("namespace expected")
+	}
+	k.ns, k.name = string(text[:i]), string(text[i+1:])
+	return nil
+}
+
+func (k text_key) MarshalText() ([]byte, error) {
+	return []byte(k.ns + ":" + k.name), nil
+}
+
+func TestUnmarshalMapKeys(t *testing.T) {
+	root, err := Parse(strings.NewReader(`((x:a (1 2)) (y:b (3)))`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[text_key][]int
+	if err := root.Children.Unmarshal(&m); err != nil {
+		t.Fatal(err)
+	}
+	a, b := m[text_key{"x", "a"}], m[text_key{"y", "b"}]
+	if len(m) != 2 || len(a) != 2 || len(b) != 1 || a[1] != 2 || b[0] != 3 {
+		t.Errorf("unexpected map: %v", m)
+	}
+
+	data, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "((x:a (1 2)) (y:b (3)))" {
+		t.Errorf("unexpected encoding: %s", data)
+	}
+
+	var mi map[interface{}]int
+	test_unmarshal(t, "(a 1) (2 2)", &mi)
+	if mi["a"] != 1 || mi["2"] != 2 {
+		t.Errorf("unexpected map: %v", mi)
+	}
+
+	var ms map[struct{ A int }]int
+	test_unmarshal_error(t, "(x 1)", `unsupported map key type.+\(value: "x"\)`, &ms)
+	test_unmarshal_error(t, "(xa 1)", "namespace expected", &m)
+	test_unmarshal_error(t, "((x y) 1)", "scalar value required", &m)
+}
+
 func test_unmarshal_error(t *testing.T, source, what string, args ...interface{}) {
 	ast, err := Parse(strings.NewReader(source), nil)
 	if err != nil {
@@ -165,6 +332,425 @@ func TestUnmarshalErrors(t *testing.T) {
 	test_unmarshal_error(t, "xxx", "unsupported type", &k)
 }
 
+func TestUnmarshalErrorPath(t *testing.T) {
+	type server struct {
+		Port  uint8    `sexp:"port"`
+		Hosts []string `sexp:"hosts,siblings"`
+	}
+	var v struct {
+		Servers []server            `sexp:"servers"`
+		Limits  map[string][2]uint8 `sexp:"limits"`
+	}
+	test := func(source, path, what string) {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = root.Unmarshal(&v)
+		ue, ok := err.(*UnmarshalError)
+		if !ok {
+			t.Errorf("*UnmarshalError expected, got: %v", err)
+			return
+		}
+		if ue.Path != path {
+			t.Errorf("%q != %q", ue.Path, path)
+		}
+		must_contain(t, ue.Error(), what)
+	}
+	test("(servers (((port 1)) ((port 300))))", ".servers[1].port",
+		`^servers\[1\]\.port: integer overflow \(value: "300"\)`)
+	test("(servers (((hosts a (b)))))", ".servers[0].hosts[1]", "scalar value required")
+	test(`(limits (("a b" (1 2)) (c (1 x))))`, `.limits["c"][1]`, "invalid syntax")
+	test("(servers x)", ".servers", "list value required")
+}
+
+func TestUnmarshalBoolStrings(t *testing.T) {
+	root, err := Parse(strings.NewReader("(yes no #t true false)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v []bool
+	err = root.Children.UnmarshalWith(&v,
+		BoolStrings(map[string]bool{"yes": true, "no": false}),
+		BoolStrings(map[string]bool{"#t": true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, []bool{true, false, true, true, false}) {
+		t.Errorf("unexpected value: %v", v)
+	}
+
+	err = root.Children.UnmarshalWith(&v, BoolStrings(map[string]bool{"yes": true, "no": false}))
+	error_must_contain(t, err, `^\[2\]: undefined boolean value, use true\|false\|no\|yes`)
+	err = root.Children.Unmarshal(&v)
+	error_must_contain(t, err, `undefined boolean value, use true\|false \(value: "yes"\)`)
+
+	d := NewDecoder(strings.NewReader("(on off)"), nil)
+	d.SetUnmarshalOptions(BoolStrings(map[string]bool{"on": true, "off": false}))
+	if err := d.Decode(&v); err != nil || !reflect.DeepEqual(v, []bool{true, false}) {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+}
+
+func TestUnmarshalStrictArrays(t *testing.T) {
+	root, err := Parse(strings.NewReader("(pos 1 2 3 4)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Pos [3]int `sexp:"pos,siblings"`
+	}
+	if err := root.Unmarshal(&v); err != nil || v.Pos != [3]int{1, 2, 3} {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+	err = root.UnmarshalWith(&v, StrictArrays())
+	error_must_contain(t, err, `^pos\[3\]: too many elements, the array has 3 \(value: "4"\)`)
+	if ue, ok := err.(*UnmarshalError); !ok || ue.Node.Location != 11 {
+		t.Errorf("the error must point at the 4th element: %v", err)
+	}
+	root, _ = Parse(strings.NewReader("(pos 1 2)"), nil)
+	if err := root.UnmarshalWith(&v, StrictArrays()); err != nil || v.Pos != [3]int{1, 2, 0} {
+		t.Errorf("unexpected result: %v, %v", v, err)
+	}
+}
+
+func TestUnmarshalEnum(t *testing.T) {
+	var v struct {
+		Level string   `sexp:"level,enum=debug|info|warn|error"`
+		Modes []string `sexp:"modes,siblings,enum=r|w"`
+		Pair  *[2]int  `sexp:"pair,enum=1|2"`
+	}
+	test_unmarshal(t, "(level warn) (modes r w r) (pair (2 1))", &v)
+	if v.Level != "warn" || len(v.Modes) != 3 || v.Pair[0] != 2 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	test_unmarshal_error(t, "(level trace)",
+		`^level: invalid value, use debug\|info\|warn\|error \(value: "trace"\)`, &v)
+	test_unmarshal_error(t, "(level (info))", `^level: invalid value`, &v)
+	test_unmarshal_error(t, "(modes r x)", `^modes\[1\]: invalid value, use r\|w \(value: "x"\) \(type: string\)`, &v)
+	test_unmarshal_error(t, "(pair (1 3))", `^pair\[1\]: invalid value, use 1\|2`, &v)
+}
+
+func TestUnmarshalKind(t *testing.T) {
+	var v struct {
+		Level string   `sexp:"level,symbol"`
+		Title string   `sexp:"title,string"`
+		Notes []string `sexp:"notes,siblings,string"`
+	}
+	test_unmarshal(t, "(level info) (title \"Hello\") (notes `a` \"b\")", &v)
+	if v.Level != "info" || v.Title != "Hello" || len(v.Notes) != 2 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	test_unmarshal_error(t, `(level "info")`, `^level: symbol expected \(value: "info"\)`, &v)
+	test_unmarshal_error(t, `(title Hello)`, `^title: string expected`, &v)
+	test_unmarshal_error(t, `(title ())`, `^title: string expected`, &v)
+	test_unmarshal_error(t, `(notes "a" b)`, `^notes\[1\]: string expected`, &v)
+}
+
+func TestUnmarshalInline(t *testing.T) {
+	var v struct {
+		Functions []string   `sexp:"functions,inline"`
+		Pairs     [][]string `sexp:"pairs,inline"`
+	}
+	for _, src := range []string{
+		"(functions a b c) (pairs (a b) (c d))",
+		"(functions (a b c)) (pairs ((a b) (c d)))",
+	} {
+		v.Functions, v.Pairs = nil, nil
+		test_unmarshal(t, src, &v)
+		if strings.Join(v.Functions, " ") != "a b c" || len(v.Pairs) != 2 || v.Pairs[1][0] != "c" {
+			t.Errorf("unexpected value: %+v", v)
+		}
+	}
+	test_unmarshal(t, "(functions x)", &v)
+	if len(v.Functions) != 1 || v.Functions[0] != "x" {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	test_unmarshal_error(t, "(functions a (b))", `^functions\[1\]: scalar value required`, &v)
+}
+
+func TestUnmarshalConstraints(t *testing.T) {
+	var v struct {
+		Port  int      `sexp:"port,min=1,max=65535"`
+		Ratio float64  `sexp:"ratio,min=0,max=1"`
+		Name  string   `sexp:"name,pattern=^[a-z]+$,maxlen=8"`
+		Items []string `sexp:"items,minlen=1,pattern=^x"`
+		Tags  []string `sexp:"tags,repeated,minlen=2"`
+	}
+	test_unmarshal(t, "(port 8080) (ratio 0.5) (name server) (items (x1 x2)) (tags ab) (tags cd)", &v)
+	if v.Port != 8080 || v.Name != "server" || len(v.Items) != 2 || len(v.Tags) != 2 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	test_unmarshal_error(t, "(port 0)", `^port: value is less than the minimum 1 \(value: "0"\) \(type: int\)`, &v)
+	test_unmarshal_error(t, "(port 70000)", `^port: value is greater than the maximum 65535`, &v)
+	test_unmarshal_error(t, "(port x)", `^port: strconv.ParseInt`, &v)
+	test_unmarshal_error(t, "(ratio 1.5)", `^ratio: value is greater than the maximum 1`, &v)
+	test_unmarshal_error(t, "(name Server)", `^name: value doesn't match pattern \^\[a-z\]\+\$`, &v)
+	test_unmarshal_error(t, "(name serverlong)", `^name: length is greater than the maximum 8`, &v)
+	test_unmarshal_error(t, "(items (x1 y))", `^items\[1\]: value doesn't match pattern \^x \(value: "y"\)`, &v)
+	test_unmarshal_error(t, "(items ())", `^items: length is less than the minimum 1`, &v)
+	test_unmarshal_error(t, "(tags a)", `^tags: number of occurrences is less than the minimum 2 \(value: "a"\)`, &v)
+	test_unmarshal(t, "(tags a) (tags b)", &v)
+	if len(v.Tags) != 2 {
+		t.Errorf("unexpected tags: %v", v.Tags)
+	}
+
+	var most struct {
+		Tags []string `sexp:"tags,repeated,maxlen=2"`
+	}
+	test_unmarshal(t, "(tags abc) (tags def)", &most)
+	test_unmarshal_error(t, "(tags a) (tags b) (tags c)", `^tags: number of occurrences is greater than the maximum 2 \(value: "c"\)`, &most)
+
+	var bad struct {
+		Name string `sexp:"name,min=1"`
+	}
+	test_unmarshal_error(t, "(name a)", "min option of field Name requires a number", &bad)
+	var bad_pattern struct {
+		Name string `sexp:"name,pattern=["`
+	}
+	test_unmarshal_error(t, "(name a)", "invalid pattern option of field Name", &bad_pattern)
+	var split_pattern struct {
+		Name string `sexp:"name,pattern=^[a-z]{1,3}$"`
+	}
+	test_unmarshal_error(t, "(name a)", `unknown option "3}\$" of field Name`, &split_pattern)
+}
+
+type version_info struct {
+	Number  float64 `sexp:",pos=0"`
+	Channel string  `sexp:",pos=1"`
+	Date    string  `sexp:"date"`
+}
+
+func TestUnmarshalPositional(t *testing.T) {
+	var v struct {
+		Version version_info `sexp:"version,siblings"`
+		Build   version_info `sexp:"build"`
+	}
+	test_unmarshal(t, "(version 3.0 stable (date 2014-05-01)) (build (2.5))", &v)
+	if v.Version != (version_info{3.0, "stable", "2014-05-01"}) || v.Build.Number != 2.5 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	test_unmarshal_error(t, "(version x)", `^version\[0\]: strconv.ParseFloat`, &v)
+	test_unmarshal_error(t, "(version 1 a b)", `^version: node is not a list, expected key/value pair \(value: "b"\)`, &v)
+
+	var dup struct {
+		A int `sexp:",pos=0"`
+		B int `sexp:",pos=0"`
+	}
+	test_unmarshal_error(t, "(1)", "fields A and B have the same position 0", &dup)
+	var bad struct {
+		A int `sexp:",pos=first"`
+	}
+	test_unmarshal_error(t, "(1)", `invalid pos option of field A: "first"`, &bad)
+}
+
+func TestUnmarshalRepeated(t *testing.T) {
+	type plugin struct {
+		Name string `sexp:",pos=0"`
+		Port int    `sexp:"port"`
+	}
+	var v struct {
+		Plugins []plugin `sexp:"plugin,repeated,siblings"`
+		Paths   []string `sexp:"path,repeated,enum=/a|/b"`
+	}
+	v.Paths = []string{"/old"}
+	test_unmarshal(t, "(plugin http (port 80)) (path /a) (plugin file) (path /b)", &v)
+	if len(v.Plugins) != 2 || v.Plugins[0] != (plugin{"http", 80}) || v.Plugins[1].Name != "file" {
+		t.Errorf("unexpected plugins: %+v", v.Plugins)
+	}
+	if len(v.Paths) != 2 || v.Paths[0] != "/a" || v.Paths[1] != "/b" {
+		t.Errorf("unexpected paths: %v", v.Paths)
+	}
+	test_unmarshal_error(t, "(plugin a) (plugin b (port x))", `^plugin\[1\]\.port: strconv.ParseInt`, &v)
+	test_unmarshal_error(t, "(path /c)", `^path: invalid value, use /a\|/b`, &v)
+
+	var bad struct {
+		Plugin plugin `sexp:"plugin,repeated"`
+	}
+	test_unmarshal_error(t, "(plugin x)", "repeated field Plugin must be a slice", &bad)
+}
+
+func TestUnmarshalDeprecated(t *testing.T) {
+	var v struct {
+		Timeout   int `sexp:"timeout,deprecated=use timeout-ms"`
+		TimeoutMS int `sexp:"timeout-ms"`
+		Retries   int `sexp:"retries,deprecated"`
+	}
+	var ctx SourceContext
+	root, err := Parse(strings.NewReader("(\n  (timeout 5)\n  (retries 3)\n  (timeout-ms 100))"),
+		ctx.AddFile("config.sexp", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []string
+	err = root.Children.UnmarshalWith(&v, OnDeprecated(func(d *Diagnostic) {
+		loc := ctx.Decode(d.Location)
+		warnings = append(warnings, fmt.Sprintf("%d: %s", loc.Line, d.Message))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Timeout != 5 || v.Retries != 3 || v.TimeoutMS != 100 {
+		t.Errorf("deprecated keys must be decoded: %+v", v)
+	}
+	gold := "2: key timeout is deprecated: use timeout-ms,3: key retries is deprecated"
+	if s := strings.Join(warnings, ","); s != gold {
+		t.Errorf("%s != %s", s, gold)
+	}
+}
+
+type upper_string string
+
+func (u *upper_string) UnmarshalSexp(n *Node) error {
+	*u = upper_string(strings.ToUpper(n.Value))
+	return nil
+}
+
+func TestUnmarshalMapValues(t *testing.T) {
+	var v struct {
+		Names map[string]upper_string
+		Lists map[string][]upper_string
+	}
+	test_unmarshal(t, "(names ((a x) (b y))) (lists ((a (x y))))", &v)
+	if v.Names["a"] != "X" || v.Names["b"] != "Y" || len(v.Lists["a"]) != 2 || v.Lists["a"][1] != "Y" {
+		t.Errorf("unexpected value: %+v", v)
+	}
+
+	// existing entries tell the types of interface values
+	type size struct{ W, H int }
+	m := map[string]interface{}{"size": &size{1, 2}}
+	test_unmarshal(t, "(size ((w 3))) (name x)", &m)
+	if s := m["size"].(*size); *s != (size{3, 2}) || m["name"] != "x" {
+		t.Errorf("unexpected value: %+v", m)
+	}
+}
+
+type validated_range struct {
+	Min, Max int
+	Node     *Node `sexp:",node"`
+}
+
+func (r *validated_range) ValidateSexp(n *Node) error {
+	if r.Min > r.Max {
+		max, _ := n.Nth(1)
+		return NewUnmarshalError(max, nil, "max must not be less than min")
+	}
+	if r.Max > 100 {
+		return errors.New("range exceeds 100%")
+	}
+	return nil
+}
+
+func TestUnmarshalValidator(t *testing.T) {
+	var v struct {
+		Ranges []validated_range `sexp:"ranges"`
+	}
+	test_unmarshal(t, "(ranges (((min 1) (max 2))))", &v)
+	if len(v.Ranges) != 1 || v.Ranges[0].Max != 2 {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	test_unmarshal_error(t, "(ranges (((min 1) (max 2)) ((min 3) (max 2))))",
+		`^ranges\[1\]: max must not be less than min \(list value\)$`, &v)
+	test_unmarshal_error(t, "(ranges (((max 101))))",
+		`^ranges\[0\]: range exceeds 100% \(list value\) \(type: sexp.validated_range\)`, &v)
+
+	root, err := Parse(strings.NewReader("(min 3) (max 2)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = root.Unmarshal(&validated_range{})
+	ue, ok := err.(*UnmarshalError)
+	if !ok || ue.Node != root.Children.Next {
+		t.Errorf("the error must point at the (max 2) node, got: %v", err)
+	}
+}
+
+type plugin interface {
+	Name() string
+}
+
+type http_plugin struct {
+	URL string `sexp:"url"`
+}
+
+func (p *http_plugin) Name() string { return "http:" + p.URL }
+
+type const_plugin string
+
+func (p const_plugin) Name() string { return string(p) }
+
+func TestUnmarshalInterfaceFactory(t *testing.T) {
+	factory := WithInterfaceFactory(func(n *Node, t reflect.Type) (interface{}, error) {
+		if t != reflect.TypeOf((*plugin)(nil)).Elem() {
+			return nil, nil
+		}
+		var v struct{ Kind string }
+		if err := n.Unmarshal(&v); err != nil {
+			return nil, err
+		}
+		switch v.Kind {
+		case "http":
+			return new(http_plugin), nil
+		case "const":
+			return const_plugin("const"), nil
+		case "bad":
+			return "bad", nil
+		}
+		return nil, fmt.Errorf("unknown plugin kind %q", v.Kind)
+	})
+
+	root, err := Parse(strings.NewReader(`((kind http) (url "a.b")) ((kind const))`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plugins [2]plugin
+	if err := root.UnmarshalWith(&plugins, factory); err != nil {
+		t.Fatal(err)
+	}
+	if plugins[0].Name() != "http:a.b" || plugins[1].Name() != "const" {
+		t.Errorf("unexpected value: %v", plugins)
+	}
+
+	test := func(source, what string, v interface{}) {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		error_must_contain(t, root.UnmarshalWith(v, factory), what)
+	}
+	test("((kind ftp))", `^\[0\]: unknown plugin kind "ftp" \(list value\) \(type: sexp.plugin\)`, &plugins)
+	test("((kind bad))", `factory returned string, which doesn't implement`, &plugins)
+	test("x", `^\[0\]: unsupported type`, &[]fmt.Stringer{})
+	test_unmarshal_error(t, "x", "unsupported type", &plugins)
+}
+
+func TestConvertScalar(t *testing.T) {
+	type port uint16
+	var p port
+	if err := ConvertScalar(&Node{Value: "8080"}, &p); err != nil || p != 8080 {
+		t.Errorf("8080 expected, got %d (%v)", p, err)
+	}
+	var f float32
+	if err := ConvertScalar(&Node{Value: "0.5"}, &f); err != nil || f != 0.5 {
+		t.Errorf("0.5 expected, got %g (%v)", f, err)
+	}
+	var b bool
+	err := ConvertScalar(&Node{Value: "yes"}, &b, BoolStrings(map[string]bool{"yes": true}))
+	if err != nil || !b {
+		t.Errorf("true expected, got %v (%v)", b, err)
+	}
+
+	err = ConvertScalar(&Node{Value: "70000"}, &p)
+	error_must_contain(t, err, "integer overflow")
+	err = ConvertScalar(&Node{Value: "yes"}, &b)
+	error_must_contain(t, err, "undefined boolean value, use true|false")
+	err = ConvertScalar(&Node{Children: &Node{Value: "1"}}, &f)
+	error_must_contain(t, err, "scalar value required")
+	var s []string
+	err = ConvertScalar(&Node{Value: "a"}, &s)
+	error_must_contain(t, err, "a scalar type expected")
+}
+
 func TestNodeNth(t *testing.T) {
 	root, err := Parse(strings.NewReader("0 1 2 3"), nil)
 	if err != nil {
@@ -0,0 +1,90 @@
+package sexp
+
+// Maps the nodes of a tree to the lists containing them, the tree itself
+// links parents to children only. Detach and Attach move subtrees around
+// keeping both the sibling chains and the index consistent, which is handy
+// for tools doing tree surgery, they don't have to carry a stack of
+// ancestors around.
+//
+// The index reflects the tree at the time it was built, modifications made
+// by other means (including other functions of this package) are not
+// tracked, build a new index after them.
+type ParentIndex struct {
+	parents map[*Node]*Node
+}
+
+// Builds the index of the tree rooted at `root` (without its siblings).
+func NewParentIndex(root *Node) *ParentIndex {
+	p := &ParentIndex{parents: make(map[*Node]*Node)}
+	p.add(root)
+	return p
+}
+
+func (p *ParentIndex) add(list *Node) {
+	for c := list.Children; c != nil; c = c.Next {
+		p.parents[c] = list
+		p.add(c)
+	}
+}
+
+// Returns the list containing the node, nil for the root and for the nodes
+// which are not in the tree.
+func (p *ParentIndex) Parent(n *Node) *Node {
+	return p.parents[n]
+}
+
+// Returns the ancestors of the node starting from its parent and ending with
+// the root.
+func (p *ParentIndex) Ancestors(n *Node) []*Node {
+	var out []*Node
+	for a := p.parents[n]; a != nil; a = p.parents[a] {
+		out = append(out, a)
+	}
+	return out
+}
+
+// Unlinks the node from the list containing it, the node's Next link is
+// cleared, its subtree is kept intact. Returns the former parent, nil if the
+// node was not in the tree, nothing is done then.
+func (p *ParentIndex) Detach(n *Node) *Node {
+	parent := p.parents[n]
+	if parent == nil {
+		return nil
+	}
+	if parent.Children == n {
+		parent.Children = n.Next
+	} else {
+		prev := parent.Children
+		for prev.Next != n {
+			prev = prev.Next
+		}
+		prev.Next = n.Next
+	}
+	n.Next = nil
+	delete(p.parents, n)
+	parent.ResetIndex()
+	return parent
+}
+
+// Inserts the node into the list right after the `after` child, or as the
+// first child if `after` is nil. The node must not be in the tree, see
+// Detach, `after` must be a child of the list. The node's subtree is added
+// to the index.
+func (p *ParentIndex) Attach(list, after, n *Node) {
+	if p.parents[n] != nil || n.Next != nil {
+		panic("sexp: the node being attached is linked into a tree")
+	}
+	if after == nil {
+		n.Next = list.Children
+		list.Children = n
+	} else {
+		if p.parents[after] != list {
+			panic("sexp: the insertion point is not a child of the list")
+		}
+		n.Next = after.Next
+		after.Next = n
+	}
+	p.parents[n] = list
+	p.add(n)
+	list.ResetIndex()
+}
@@ -0,0 +1,60 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParentIndex(t *testing.T) {
+	root, err := Parse(strings.NewReader("(a (b c) d) (e)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewParentIndex(root)
+	a := root.Children
+	b := a.Children.Next
+	c := b.Children.Next
+	e := a.Next
+	if p.Parent(c) != b || p.Parent(b) != a || p.Parent(a) != root || p.Parent(root) != nil {
+		t.Error("unexpected parents")
+	}
+	if anc := p.Ancestors(c); len(anc) != 3 || anc[0] != b || anc[2] != root {
+		t.Errorf("unexpected ancestors: %v", anc)
+	}
+
+	write := func() string {
+		var buf bytes.Buffer
+		for n := root.Children; n != nil; n = n.Next {
+			n.WriteTo(&buf)
+			buf.WriteByte(' ')
+		}
+		return buf.String()
+	}
+	if p.Detach(b) != a || p.Detach(b) != nil || p.Parent(b) != nil {
+		t.Error("Detach must return the former parent once")
+	}
+	p.Attach(e, e.Children, b)
+	if s := write(); s != "(a d) (e (b c)) " {
+		t.Errorf("unexpected tree: %s", s)
+	}
+	if p.Parent(b) != e || p.Parent(c) != b {
+		t.Error("parents of the attached subtree must be updated")
+	}
+	p.Detach(a)
+	p.Attach(root, nil, a)
+	p.Detach(a.Children)
+	if s := write(); s != "(d) (e (b c)) " {
+		t.Errorf("unexpected tree: %s", s)
+	}
+	if err := ValidateTree(root); err != nil {
+		t.Error(err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Attach must panic on attached nodes")
+		}
+	}()
+	p.Attach(root, nil, e)
+}
@@ -1,10 +1,19 @@
 package sexp
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Parses S-expressions from a given io.RuneReader.
@@ -19,23 +28,32 @@ import (
 //     f := ctx.AddFile(filename, length)
 //
 // And you'll be able to use ctx later for decoding source location
-// information. It's ok to provide -1 as length if it's unknown. In that case
-// though you won't be able to add more files to the given SourceContext until
-// the file with unknown length is finalized, which happens when parsing is
-// finished.
+// information. It's ok to provide -1 as length if it's unknown. The file with
+// unknown length is finalized when parsing is finished, successfully or not,
+// files added to the context in the meantime get their own space, see
+// SourceContext.AddFile.
 //
 // Also f is optional, nil is a perfectly valid argument for it, in that case
 // it will create a temporary context and add an unnamed file to it. Less setup
 // work is required, but you lose the ability to decode error source code
 // locations.
-func Parse(r io.RuneReader, f *SourceFile) (*Node, error) {
+//
+// The syntax accepted by the parser can be adjusted using options, e.g.
+// CommentSyntax.
+func Parse(r io.RuneReader, f *SourceFile, opts ...ParseOption) (*Node, error) {
 	var ctx SourceContext
 	if f == nil {
 		f = ctx.AddFile("", -1)
 	}
 
-	var p parser
-	p.r = r
+	p := get_parser()
+	defer p.release()
+	p.init_options(opts)
+	if err := p.init_reader(r); err != nil {
+		f.finish(0)
+		return nil, err
+	}
+	p.init_fast(true)
 	p.f = f
 	p.last_seq = seq{offset: -1}
 	p.expect_eof = true
@@ -61,13 +79,14 @@ func Parse(r io.RuneReader, f *SourceFile) (*Node, error) {
 //
 // NOTE: Maybe ParseOne will be changed in future to better serve the need of
 // good error reporting.
-func ParseOne(r io.RuneScanner, f *SourceFile) (*Node, error) {
+func ParseOne(r io.RuneScanner, f *SourceFile, opts ...ParseOption) (*Node, error) {
 	var ctx SourceContext
 	if f == nil {
 		f = ctx.AddFile("", -1)
 	}
 
 	var p parser
+	p.init_options(opts)
 	p.r = r
 	p.rs = r
 	p.f = f
@@ -76,12 +95,407 @@ func ParseOne(r io.RuneScanner, f *SourceFile) (*Node, error) {
 	return p.parse_one_node()
 }
 
+// Option for Parse and ParseOne which adjusts the syntax accepted by the
+// parser.
+type ParseOption func(o *parse_options)
+
+type parse_options struct {
+	semicolon_comments bool
+	hash_comments      bool
+	slash_comments     bool
+	string_newlines    bool
+	heredocs           bool
+	text_blocks        bool
+	invalid_utf8       InvalidUTF8Policy
+	strict_atoms       bool
+	shared_labels      bool
+	spki_atoms         bool
+	decompress         bool
+	decompressors      []Decompressor
+	exactly_one        bool
+	warnings           func(d *Diagnostic)
+	line_directives    bool
+	list_open          map[rune]rune // additional list delimiters
+	list_close         map[rune]bool
+	atom_chars         func(r rune) bool
+	piped_symbols      bool
+	bind_context       bool
+	shebang            bool
+	doc_comments       bool
+}
+
+// Sets the line comment introducers recognized by the parser. Supported
+// introducers are ";" (the default), "#" and "//", any other string causes a
+// panic. A comment extends to the end of the line.
+//
+// A ';' always terminates an atom, hence "a;b" is the atom "a" followed by a
+// comment if ";" comments are enabled. Unlike that, "#" and "//" are only
+// recognized at the beginning of an item, so that atoms like "a#b" or
+// "http://example.com" remain intact. Without ";" in the list, ';' is an
+// ordinary atom character.
+func CommentSyntax(introducers ...string) ParseOption {
+	return func(o *parse_options) {
+		o.semicolon_comments = false
+		o.hash_comments = false
+		o.slash_comments = false
+		for _, s := range introducers {
+			switch s {
+			case ";":
+				o.semicolon_comments = true
+			case "#":
+				o.hash_comments = true
+			case "//":
+				o.slash_comments = true
+			default:
+				panic("sexp: unsupported comment introducer: " + s)
+			}
+		}
+	}
+}
+
+// Allows newlines within '"' strings, they become part of the value as is.
+// Also enables the line continuation escape sequence: a backslash followed by
+// a newline, both are removed from the value along with the leading spaces
+// and tabs of the next line.
+//
+//     (query "SELECT name \
+//             FROM users")
+//
+// The value above is "SELECT name FROM users".
+func AllowNewlinesInStrings() ParseOption {
+	return func(o *parse_options) {
+		o.string_newlines = true
+	}
+}
+
+// Enables heredoc strings, which can contain arbitrary text verbatim,
+// including backticks:
+//
+//     (script #<<END
+//     echo `date`
+//     END
+//     )
+//
+// "#<<" is followed by a terminator which extends to the end of the line. The
+// string consists of the lines following it up to (not including) the first
+// line which is exactly the terminator. The newline preceding the terminator
+// line is not part of the value. When heredocs are enabled, "#<" at the
+// beginning of an item always starts a heredoc, even if "#" comments are
+// enabled as well.
+func Heredocs() ParseOption {
+	return func(o *parse_options) {
+		o.heredocs = true
+	}
+}
+
+// Enables text blocks, multi-line raw strings with the common indentation
+// removed, which allows to indent them according to the surrounding code:
+//
+//     (query """
+//         SELECT name
+//           FROM users
+//         """)
+//
+// The value above is "SELECT name\n  FROM users". The opening '"""' must be
+// the last thing on its line, that line is not part of the value. The
+// content is taken verbatim (there are no escape sequences) up to the
+// closing '"""'. If the closing '"""' is on a line of its own, the line
+// participates in determining the common indentation, but it's not a part of
+// the value otherwise. The common indentation is the longest prefix of
+// spaces and tabs shared by all non-blank lines, it's removed from all the
+// lines. Blank lines become empty and the '\r' of "\r\n" line endings is
+// removed.
+func TextBlocks() ParseOption {
+	return func(o *parse_options) {
+		o.text_blocks = true
+	}
+}
+
+// Defines how the parser treats byte sequences which are not valid UTF-8.
+type InvalidUTF8Policy int
+
+const (
+	// Invalid bytes are replaced with U+FFFD (utf8.RuneError), the default.
+	InvalidUTF8Replace InvalidUTF8Policy = iota
+
+	// Invalid bytes are reported as a *ParseError.
+	InvalidUTF8Error
+
+	// Invalid bytes are passed through to the values as is. Requires the
+	// reader given to the parser to implement io.RuneScanner and
+	// io.ByteReader (bufio.Reader, bytes.Reader and strings.Reader do),
+	// a *ParseError is returned otherwise.
+	InvalidUTF8Raw
+)
+
+// Defines exactly which characters may appear in bare (unquoted) atoms, any
+// other character is reported as a *ParseError pointing at it. Strings are
+// not affected. E.g. to allow identifiers and numbers only:
+//
+//     sexp.AtomChars(func(r rune) bool {
+//         return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.+", r)
+//     })
+//
+// Atoms with other characters, e.g. spaces, may be written as strings or as
+// piped symbols, see PipedSymbols.
+func AtomChars(valid func(r rune) bool) ParseOption {
+	return func(o *parse_options) {
+		o.atom_chars = valid
+	}
+}
+
+// Enables piped symbols, atoms enclosed in '|' characters, which may contain
+// any characters: `|hello world|`. Within them `\|` and `\\` stand for '|'
+// and '\' respectively, other escape sequences are errors. Piped symbols are
+// read as regular atoms, QuoteSymbol writes them. The option has no effect if
+// SPKIAtoms is enabled, '|' starts a base64 atom in that case.
+func PipedSymbols() ParseOption {
+	return func(o *parse_options) {
+		o.piped_symbols = true
+	}
+}
+
+// Sets the policy for invalid UTF-8 input, see InvalidUTF8Policy.
+func InvalidUTF8(policy InvalidUTF8Policy) ParseOption {
+	return func(o *parse_options) {
+		o.invalid_utf8 = policy
+	}
+}
+
+// Rejects atoms containing characters which have special meaning in other
+// Lisp dialects: '\'', ',', '|', '[', ']', '{' and '}'. Such atoms usually
+// come from copy-pasted code and are reported as a *ParseError pointing at
+// the offending character. Strings are not affected.
+func StrictAtoms() ParseOption {
+	return func(o *parse_options) {
+		o.strict_atoms = true
+	}
+}
+
+// Enables Common Lisp style labels for shared structure, as printed with
+// *print-circle*:
+//
+//     (#1=(x y) #1#)
+//
+// "#n=" labels the expression following it, "#n#" refers to a previously
+// labeled expression, n is a decimal number. References are replaced with
+// copies of the labeled expressions, the example above reads as
+// ((x y) (x y)). Circular structure (a reference within the expression it
+// refers to) and references to undefined labels are reported as errors.
+func SharedLabels() ParseOption {
+	return func(o *parse_options) {
+		o.shared_labels = true
+	}
+}
+
+// Parses S-expressions from a given io.Reader one top-level node at a time,
+// calling `f` for each of them as soon as it's parsed. Unlike Parse, it
+// doesn't keep the nodes around, hence the memory use is bounded by the size
+// of the largest top-level node rather than the size of the input. Parsing
+// stops at the first syntax error, which is returned as *ParseError, or at the
+// first error returned by `f`, which is returned as is.
+//
+// The reader is wrapped with bufio.Reader unless it implements io.RuneReader.
+// Source locations are byte offsets from the beginning of the input, see
+// Parse for details.
+func ParseEach(r io.Reader, f func(*Node) error, opts ...ParseOption) error {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+
+	var ctx SourceContext
+	p := get_parser()
+	defer p.release()
+	p.init_options(opts)
+	if err := p.init_reader(rr); err != nil {
+		return err
+	}
+	p.init_fast(false)
+	p.f = ctx.AddFile("", -1)
+	p.last_seq = seq{offset: -1}
+	p.expect_eof = true
+	return p.parse_each(f)
+}
+
+// Parses S-expressions from a given io.Reader in a separate goroutine,
+// top-level nodes are sent to the returned node channel as soon as they are
+// parsed, see ParseEach. When parsing is finished, the node channel is closed
+// and then the error channel receives the error (if any) and is closed too.
+// A syntax error is sent as *ParseError. If the context is cancelled, parsing
+// stops and ctx.Err() is sent, though a blocked read from `r` is not
+// interrupted by it.
+//
+//     nodes, errc := sexp.ParseChan(ctx, r)
+//     for n := range nodes {
+//         // ...
+//     }
+//     if err := <-errc; err != nil {
+//         // ...
+//     }
+func ParseChan(ctx context.Context, r io.Reader, opts ...ParseOption) (<-chan *Node, <-chan error) {
+	nodes := make(chan *Node)
+	errc := make(chan error, 1)
+	go func() {
+		err := ParseEach(r, func(n *Node) error {
+			select {
+			case nodes <- n:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, opts...)
+		close(nodes)
+		if err != nil {
+			errc <- err
+		}
+		close(errc)
+	}()
+	return nodes, errc
+}
+
+// Makes Parse and ParseEach require the input to contain exactly one
+// top-level expression. Any content following it, except for spaces and
+// comments, is reported as "trailing content at line N", empty input is an
+// error as well.
+func ExactlyOne() ParseOption {
+	return func(o *parse_options) {
+		o.exactly_one = true
+	}
+}
+
+// Sets a function receiving warnings about suspicious but valid constructs,
+// which linting tools may want to surface:
+//  - escape sequences in raw strings, which are not interpreted there
+//  - lines of multi-line strings indented with both tabs and spaces
+//  - atoms longer than 64 KiB
+//  - lists nested deeper than 256 levels
+//
+// Warnings are diagnostics with SeverityWarning, they are reported in the
+// source order and don't affect parsing.
+func Warnings(f func(d *Diagnostic)) ParseOption {
+	return func(o *parse_options) {
+		o.warnings = f
+	}
+}
+
+// Enables line directives, which map the following lines to lines of another
+// file, similar to the line markers of the C preprocessor:
+//
+//     (#line "config.sexp" 17)
+//
+// The line following the directive decodes as line 17 of "config.sexp", see
+// SourceContext.Decode. Directives are allowed anywhere an item is, they are
+// removed from the tree. Tools writing preprocessed files emit them using
+// (*Node).WriteLineDirectives, so that errors found in such files point at
+// the original sources. If '#' comments are enabled, the directive must be
+// written as `("#line" "config.sexp" 17)`.
+func LineDirectives() ParseOption {
+	return func(o *parse_options) {
+		o.line_directives = true
+	}
+}
+
+// Makes the parser skip a "#!" line at the beginning of the input (following
+// the byte order mark, if any), so that executable scripts starting with a
+// line like `#!/usr/bin/env some-tool` can be parsed directly. The line is
+// treated as a comment regardless of the comment syntax.
+func Shebang() ParseOption {
+	return func(o *parse_options) {
+		o.shebang = true
+	}
+}
+
+// Makes the parser keep the comment blocks preceding nodes, retrievable
+// using (*Node).DocComment. A comment block is a sequence of comments on
+// lines of their own, the last of which is right above the node's first
+// line, comments following items on the same line are not a part of it:
+//
+//     ;; The port to listen on,
+//     ;; 8080 by default.
+//     (port 8080) ; not a doc comment
+//
+// Implies BindContext, the blocks are kept by the SourceFile.
+func DocComments() ParseOption {
+	return func(o *parse_options) {
+		o.doc_comments = true
+		o.bind_context = true
+	}
+}
+
+// Binds the source context of the file being parsed to every node of the
+// tree, so that code holding just a node can decode its location with
+// (*Node).LocationIn(nil). The binding costs a pointer per node, it's kept by
+// the copies of nodes made by this package, but it's never serialized.
+func BindContext() ParseOption {
+	return func(o *parse_options) {
+		o.bind_context = true
+	}
+}
+
+// Adds pairs of delimiters enclosing lists in addition to parentheses, which
+// allows ingesting near S-expression dialects, e.g.:
+//
+//     sexp.ListDelimiters("<>", "[]")
+//
+// Each pair is a string of the opening and the closing characters. Closing
+// delimiters terminate atoms just like ')' does, a list must be closed by the
+// delimiter matching the opening one. The resulting lists are regular lists,
+// the kind of delimiters is not preserved. Delimiters must be ASCII
+// punctuation characters other than the ones having a special meaning
+// already: ()"`;#|\, the option panics otherwise.
+func ListDelimiters(pairs ...string) ParseOption {
+	for _, pair := range pairs {
+		if len(pair) != 2 || !is_list_delim(pair[0]) || !is_list_delim(pair[1]) ||
+			pair[0] == pair[1] {
+			panic("sexp: invalid list delimiters " + strconv.Quote(pair))
+		}
+	}
+	return func(o *parse_options) {
+		if o.list_open == nil {
+			o.list_open = make(map[rune]rune)
+			o.list_close = make(map[rune]bool)
+		}
+		for _, pair := range pairs {
+			o.list_open[rune(pair[0])] = rune(pair[1])
+			o.list_close[rune(pair[1])] = true
+		}
+	}
+}
+
+func is_list_delim(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPrint(rune(c)) && c != ' ' &&
+		!unicode.IsLetter(rune(c)) && !unicode.IsDigit(rune(c)) &&
+		!strings.ContainsRune("()\"`;#|\\", rune(c))
+}
+
+// Enables hexadecimal and base64 atoms of the advanced transport form from
+// Rivest's S-expressions draft, as produced by SPKI tools:
+//
+//     (rsa (e #010001#) (n |AMyx5C0=|))
+//
+// Whitespace within them is ignored. With this option '#' at the beginning of
+// an item never starts a comment. It should not be combined with
+// SharedLabels, "#n#" is a label reference in that case. See also
+// WriteAdvanced.
+func SPKIAtoms() ParseOption {
+	return func(o *parse_options) {
+		o.spki_atoms = true
+	}
+}
+
 // This error structure is Parse* functions family specific, it returns information
 // about errors encountered during parsing. Location can be decoded using the
 // context you passed in as an argument. If the context was nil, then the location
 // is simply a byte offset from the beginning of the input stream.
+//
+// Related holds secondary locations of the error, if any. E.g. for a missing
+// closing delimiter, Location points at the opening delimiter and Related
+// contains the location where the end of input was reached, together they
+// span the unclosed region.
 type ParseError struct {
 	Location SourceLoc
+	Related  []SourceLoc
 	message  string
 }
 
@@ -95,6 +509,8 @@ var seq_delims = map[rune]rune{
 	'(': ')',
 	'`': '`',
 	'"': '"',
+	'#': '#',
+	'|': '|',
 }
 
 func is_hex(r rune) bool {
@@ -103,6 +519,72 @@ func is_hex(r rune) bool {
 		(r >= 'A' && r <= 'F')
 }
 
+func is_digit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Classifies a bare atom: NodeInt for integers, optionally signed, either
+// decimal or with a 0x, 0o or 0b radix prefix; NodeFloat for decimal numbers
+// with a fraction and/or an exponent; NodeSymbol for anything else. Whether
+// the number fits any Go type is not checked.
+func atom_kind(s string) NodeKind {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	if len(s) > 2 && s[0] == '0' {
+		max := byte(0)
+		switch s[1] {
+		case 'x', 'X':
+			max = 'f'
+		case 'o', 'O':
+			max = '7'
+		case 'b', 'B':
+			max = '1'
+		}
+		if max != 0 {
+			for i := 2; i < len(s); i++ {
+				c := s[i]
+				if !is_hex(rune(c)) || max != 'f' && c > max {
+					return NodeSymbol
+				}
+			}
+			return NodeInt
+		}
+	}
+
+	kind := NodeInt
+	i, digits := 0, 0
+	for ; i < len(s) && is_digit(s[i]); i++ {
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		kind = NodeFloat
+		for i++; i < len(s) && is_digit(s[i]); i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return NodeSymbol
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		kind = NodeFloat
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		exp := i
+		for ; i < len(s) && is_digit(s[i]); i++ {
+		}
+		if i == exp {
+			return NodeSymbol
+		}
+	}
+	if i != len(s) {
+		return NodeSymbol
+	}
+	return kind
+}
+
 func is_space(r rune) bool {
 	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
 }
@@ -124,12 +606,194 @@ type delim_state struct {
 type parser struct {
 	r      io.RuneReader
 	rs     io.RuneScanner
+	br     *bufio.Reader // bytes are consumed from its buffer, see init_fast
 	f      *SourceFile
 	buf    bytes.Buffer
 	offset int
 	cur    rune
 	curlen int
+	depth  int // list nesting depth
+	inner  bool // the input is a part of a larger one, see Validate
 	delim_state
+	parse_options
+
+	// one rune lookahead, see peek
+	peeked   bool
+	peek_r   rune
+	peek_len int
+	peek_err error
+
+	// shared structure labels, nil value means the labeled expression is
+	// being parsed, see SharedLabels
+	labels map[string]*Node
+
+	// the current rune is an invalid byte passed through as is, see
+	// InvalidUTF8Raw
+	raw      bool
+	raw_byte byte
+
+	// the unused part of the current node batch, see new_node
+	nodes []Node
+
+	// the comment block being collected and the line of its last comment,
+	// the line of the last item, see DocComments
+	doc        []string
+	doc_line   int
+	token_line int
+
+	// the reader wrapping the input, kept by pooled parsers, see init_fast
+	own_br *bufio.Reader
+}
+
+// Nodes are allocated in batches of this size, see new_node.
+const node_batch = 64
+
+// Atom buffers grown larger than that are not kept by pooled parsers.
+const max_pooled_buf = 64 << 10
+
+// Parsers used by Parse, ParseEach and Validate are pooled, which saves the
+// allocations of the atom buffer, the input buffer and of the unused part of
+// the last node batch in services parsing lots of small inputs.
+var parser_pool = sync.Pool{
+	New: func() interface{} { return new(parser) },
+}
+
+func get_parser() *parser {
+	return parser_pool.Get().(*parser)
+}
+
+// Returns the parser to the pool, everything but the buffers is cleared.
+func (p *parser) release() {
+	buf, nodes, own_br := p.buf, p.nodes, p.own_br
+	if buf.Cap() > max_pooled_buf {
+		buf = bytes.Buffer{}
+	}
+	buf.Reset()
+	if own_br != nil {
+		own_br.Reset(nil)
+	}
+	*p = parser{buf: buf, nodes: nodes, own_br: own_br}
+	parser_pool.Put(p)
+}
+
+// Returns a new node initialized with `n`. Nodes are carved out of batches
+// to spare the allocator, a batch is kept in memory while any of its nodes
+// is referenced, which is a small price for a tree of nodes being allocated
+// at once anyway.
+func (p *parser) new_node(n Node) *Node {
+	if len(p.nodes) == 0 {
+		p.nodes = make([]Node, node_batch)
+	}
+	node := &p.nodes[0]
+	*node = n
+	p.nodes = p.nodes[1:]
+	return node
+}
+
+func (p *parser) init_options(opts []ParseOption) {
+	p.semicolon_comments = true
+	for _, opt := range opts {
+		opt(&p.parse_options)
+	}
+}
+
+// Sets the reader, wrapping it with a decompressing one if the Decompress
+// option is enabled.
+func (p *parser) init_reader(r io.RuneReader) error {
+	p.r = r
+	if !p.decompress {
+		return nil
+	}
+	rd, ok := r.(io.Reader)
+	if !ok {
+		return errors.New("decompression requires the reader to implement io.Reader")
+	}
+	dr, err := NewDecompressReader(rd, p.decompressors...)
+	if err != nil {
+		return err
+	}
+	if rr, ok := dr.(io.RuneReader); ok {
+		p.r = rr
+	} else {
+		p.r = bufio.NewReader(dr)
+	}
+	return nil
+}
+
+// Makes the parser consume the input directly from the buffer of
+// bufio.Reader: ASCII is read byte by byte and runs of atom and string bytes
+// are copied in chunks, see write_run. Any other io.Reader is wrapped if
+// `wrap` is true. Only for parsers which never unread runes, hence not for
+// ParseOne.
+func (p *parser) init_fast(wrap bool) {
+	switch r := p.r.(type) {
+	case *bufio.Reader:
+		p.br = r
+	case io.Reader:
+		if wrap {
+			if p.own_br == nil {
+				p.own_br = bufio.NewReader(r)
+			} else {
+				p.own_br.Reset(r)
+			}
+			p.br = p.own_br
+			p.r = p.br
+		}
+	}
+}
+
+func (p *parser) is_delimiter(r rune) bool {
+	return is_space(r) || r == ')' || r == 0 || (r == ';' && p.semicolon_comments) ||
+		p.list_close[r]
+}
+
+// Returns the closing delimiter matching the opening one.
+func (p *parser) closing_delim(r rune) rune {
+	if c, ok := p.list_open[r]; ok {
+		return c
+	}
+	return seq_delims[r]
+}
+
+// Returns true if the current rune starts a comment, assumes it's called at
+// the beginning of an item.
+func (p *parser) is_comment() bool {
+	switch p.cur {
+	case ';':
+		return p.semicolon_comments
+	case '#':
+		return p.hash_comments && !p.is_heredoc() && !p.is_label() && !p.spki_atoms
+	case '/':
+		return p.slash_comments && p.peek() == '/'
+	}
+	return false
+}
+
+// Returns true if the current rune starts a heredoc.
+func (p *parser) is_heredoc() bool {
+	return p.heredocs && p.cur == '#' && p.peek() == '<'
+}
+
+// Returns true if the current rune starts a shared structure label.
+func (p *parser) is_label() bool {
+	if !p.shared_labels || p.cur != '#' {
+		return false
+	}
+	r := p.peek()
+	return r >= '0' && r <= '9'
+}
+
+// Returns the rune following the current one without consuming it, 0 on
+// errors which are reported by the following call to next.
+func (p *parser) peek() rune {
+	if !p.peeked {
+		p.peek_r, p.peek_len, p.peek_err = p.r.ReadRune()
+		p.peeked = true
+	}
+	if p.peek_err != nil {
+		return 0
+	}
+	return p.peek_r
 }
 
 func (p *parser) advance_delim_state() delim_state {
@@ -150,9 +814,91 @@ func (p *parser) error(loc SourceLoc, format string, args ...interface{}) {
 	})
 }
 
+const (
+	warn_atom_length   = 64 << 10
+	warn_nesting_depth = 256
+)
+
+func (p *parser) warn(loc SourceLoc, format string, args ...interface{}) {
+	if p.warnings != nil {
+		p.warnings(&Diagnostic{
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf(format, args...),
+			Location: loc,
+		})
+	}
+}
+
+// Checks a parsed string or atom for suspicious contents, see Warnings.
+func (p *parser) check_atom(n *Node) {
+	if p.warnings == nil {
+		return
+	}
+	if len(n.Value) > warn_atom_length {
+		p.warn(n.Location, "atom is %d bytes long", len(n.Value))
+	}
+	if has_mixed_indentation(n.Value) {
+		p.warn(n.Location, "string lines are indented with both tabs and spaces")
+	}
+}
+
+// Returns true if the text is multi-line and some of the lines following the
+// first one start with a mix of tabs and spaces, or some of them start with a
+// tab and others with a space.
+func has_mixed_indentation(s string) bool {
+	tabs, spaces := false, false
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i == -1 {
+			return false
+		}
+		s = s[i+1:]
+		for _, c := range []byte(s) {
+			if c == '\t' {
+				tabs = true
+			} else if c == ' ' {
+				spaces = true
+			} else {
+				break
+			}
+		}
+		if tabs && spaces {
+			return true
+		}
+	}
+}
+
+// Reports a construct starting at `loc` which is not closed when the end of
+// input is reached, the end of input is the related location of the error.
+func (p *parser) unclosed_error(loc SourceLoc, format string, args ...interface{}) {
+	panic(&ParseError{
+		Location: loc,
+		Related:  []SourceLoc{p.f.Encode(p.offset)},
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
 func (p *parser) next() {
 	p.offset += p.curlen
-	r, s, err := p.r.ReadRune()
+	var r rune
+	var s int
+	var err error
+	if p.peeked {
+		r, s, err = p.peek_r, p.peek_len, p.peek_err
+		p.peeked = false
+	} else if p.br != nil {
+		// multi-byte runes and errors take the slow path
+		if b, e := p.br.ReadByte(); e == nil && b < utf8.RuneSelf {
+			r, s = rune(b), 1
+		} else {
+			if e == nil {
+				p.br.UnreadByte()
+			}
+			r, s, err = p.br.ReadRune()
+		}
+	} else {
+		r, s, err = p.r.ReadRune()
+	}
 	if err != nil {
 		if err == io.EOF {
 			if p.expect_eof {
@@ -160,9 +906,9 @@ func (p *parser) next() {
 				p.curlen = 0
 				return
 			}
-			p.error(p.f.Encode(p.last_seq.offset),
+			p.unclosed_error(p.f.Encode(p.last_seq.offset),
 				"missing matching sequence delimiter '%c'",
-				seq_delims[p.last_seq.rune])
+				p.closing_delim(p.last_seq.rune))
 		}
 		p.error(p.f.Encode(p.offset),
 			"unexpected read error: %s", err)
@@ -170,11 +916,98 @@ func (p *parser) next() {
 
 	p.cur = r
 	p.curlen = s
+	p.raw = false
+	if r == utf8.RuneError && s == 1 {
+		p.invalid_rune()
+	}
 	if r == '\n' {
 		p.f.AddLine(p.offset + p.curlen)
 	}
 }
 
+func (p *parser) invalid_rune() {
+	switch p.invalid_utf8 {
+	case InvalidUTF8Error:
+		p.error(p.f.Encode(p.offset), "invalid UTF-8 encoding")
+	case InvalidUTF8Raw:
+		rs, ok1 := p.r.(io.RuneScanner)
+		br, ok2 := p.r.(io.ByteReader)
+		if !ok1 || !ok2 {
+			p.error(p.f.Encode(p.offset),
+				"invalid UTF-8 encoding (raw bytes require a byte and rune scanner)")
+		}
+		var b byte
+		err := rs.UnreadRune()
+		if err == nil {
+			b, err = br.ReadByte()
+		}
+		if err != nil {
+			p.error(p.f.Encode(p.offset),
+				"unexpected read error: %s", err)
+		}
+		p.raw = true
+		p.raw_byte = b
+	}
+}
+
+// Writes the current rune to the buffer.
+func (p *parser) write_cur(buf *bytes.Buffer) {
+	if p.raw {
+		buf.WriteByte(p.raw_byte)
+	} else {
+		buf.WriteRune(p.cur)
+	}
+}
+
+// Writes the run of buffered ASCII bytes following the current rune to
+// p.buf, the bytes are atom characters or, if `in_string` is true, '"'
+// string characters not requiring special treatment. The run becomes a part
+// of the current rune, so that the following next call skips it as a whole.
+// Does nothing without the fast path, see init_fast.
+func (p *parser) write_run(in_string bool) {
+	if p.br == nil || p.peeked {
+		return
+	}
+	buf, _ := p.br.Peek(p.br.Buffered())
+	n := 0
+	for ; n < len(buf); n++ {
+		b := buf[n]
+		if b >= utf8.RuneSelf {
+			break
+		}
+		if in_string {
+			if b == '"' || b == '\\' || b == '\n' {
+				break
+			}
+		} else if p.is_delimiter(rune(b)) || p.strict_atoms || p.atom_chars != nil {
+			// restricted atoms are checked rune by rune
+			break
+		}
+	}
+	if n == 0 {
+		return
+	}
+	p.buf.Write(buf[:n])
+	p.br.Discard(n)
+	p.offset += p.curlen
+	p.curlen = n
+}
+
+// Skips the UTF-8 byte order mark at the beginning of input.
+func (p *parser) skip_bom() {
+	if p.offset == 0 && !p.inner && p.cur == byte_order_mark {
+		p.next()
+	}
+}
+
+// Skips the "#!" line, expects to be called right after skip_bom, see
+// Shebang.
+func (p *parser) skip_shebang() {
+	if p.shebang && p.cur == '#' && p.peek() == '!' {
+		p.skip_comment()
+	}
+}
+
 func (p *parser) skip_spaces() {
 	for {
 		if is_space(p.cur) {
@@ -205,23 +1038,103 @@ func (p *parser) skip_comment() {
 	panic("unreachable")
 }
 
+// Reads the comment adding it to the comment block being collected, see
+// DocComments.
+func (p *parser) collect_comment() {
+	line := p.f.last_line().num
+	for p.cur != 0 && p.cur != '\n' {
+		p.write_cur(&p.buf)
+		p.next()
+	}
+	if p.cur == '\n' {
+		p.next()
+	}
+	text := p.buf.String()
+	p.buf.Reset()
+
+	if line == p.token_line {
+		// follows an item
+		p.doc = p.doc[:0]
+		return
+	}
+	if line != p.doc_line+1 {
+		p.doc = p.doc[:0]
+	}
+	text = strings.TrimLeft(text, text[:1])
+	text = strings.TrimPrefix(text, " ")
+	p.doc = append(p.doc, strings.TrimSuffix(text, "\r"))
+	p.doc_line = line
+}
+
+// Attaches the comment block collected so far to the node starting at the
+// current offset if the block is right above it.
+func (p *parser) attach_doc() {
+	if len(p.doc) != 0 && p.doc_line == p.f.last_line().num-1 {
+		if p.f.docs == nil {
+			p.f.docs = make(map[int]string)
+		}
+		p.f.docs[p.offset] = strings.Join(p.doc, "\n")
+	}
+	p.doc = p.doc[:0]
+}
+
 func (p *parser) parse_node() *Node {
+	if !p.doc_comments {
+		return p.parse_item()
+	}
+	node := p.parse_item()
+	p.token_line = p.f.find_line(p.offset).num
+	return node
+}
+
+func (p *parser) parse_item() *Node {
 again:
 	// the convention is that this function is called on a non-space `p.cur`
+	if p.is_comment() {
+		if p.doc_comments {
+			p.collect_comment()
+		} else {
+			p.skip_comment()
+		}
+		p.skip_spaces()
+		goto again
+	}
+	if p.doc_comments {
+		p.attach_doc()
+	}
+	if p.is_heredoc() {
+		return p.parse_heredoc()
+	}
+	if p.is_label() {
+		return p.parse_label()
+	}
+	if p.spki_atoms && (p.cur == '#' || p.cur == '|') {
+		return p.parse_spki_atom()
+	}
+	if p.piped_symbols && p.cur == '|' {
+		return p.parse_piped_symbol()
+	}
+	if p.list_close[p.cur] {
+		return nil
+	}
+	if close, ok := p.list_open[p.cur]; ok {
+		return p.parse_list(close)
+	}
 	switch p.cur {
 	case ')':
 		return nil
 	case '(':
-		return p.parse_list()
+		n := p.parse_list(')')
+		if p.line_directives && is_directive(n, "#line") {
+			p.line_directive(n)
+			p.skip_spaces()
+			goto again
+		}
+		return n
 	case '"':
 		return p.parse_string()
 	case '`':
 		return p.parse_raw_string()
-	case ';':
-		// skip comment
-		p.skip_comment()
-		p.skip_spaces()
-		goto again
 	case 0:
 		// delayed expected EOF
 		panic(io.EOF)
@@ -231,22 +1144,34 @@ again:
 	panic("unreachable")
 }
 
-func (p *parser) parse_list() *Node {
+// Parses a list closed by `close`.
+func (p *parser) parse_list(close rune) *Node {
 	loc := p.f.Encode(p.offset)
 	save := p.advance_delim_state()
 
-	head := &Node{Location: loc}
-	p.next() // skip opening '('
+	head := p.new_node(Node{Location: loc, Kind: NodeList})
+	if p.doc_comments {
+		p.token_line = p.f.last_line().num
+	}
+	p.next() // skip opening delimiter
+	p.depth++
+	if p.depth == warn_nesting_depth+1 {
+		p.warn(loc, "lists are nested deeper than %d levels", warn_nesting_depth)
+	}
 
 	var lastchild *Node
 	for {
 		p.skip_spaces()
-		if p.cur == ')' {
-			// skip enclosing ')', but it could be EOF also
+		if p.cur == close {
+			// skip enclosing delimiter, but it could be EOF also
 			p.restore_delim_state(save)
+			p.depth--
 			p.next()
 			return head
 		}
+		if p.cur == ')' || p.list_close[p.cur] {
+			p.error(p.f.Encode(p.offset), "unexpected '%c', '%c' expected", p.cur, close)
+		}
 
 		node := p.parse_node()
 		if node == nil {
@@ -262,6 +1187,23 @@ func (p *parser) parse_list() *Node {
 	panic("unreachable")
 }
 
+// Registers a line directive, which applies from the line following the one
+// the directive ends at.
+func (p *parser) line_directive(n *Node) {
+	name := n.Children.Next
+	if name == nil || name.IsList() || name.Next == nil || name.Next.IsList() ||
+		name.Next.Next != nil {
+		p.error(n.Location, "line directive expects a file name and a line number")
+	}
+	line, err := strconv.Atoi(name.Next.Value)
+	if err != nil || line < 1 {
+		p.error(name.Next.Location, "invalid line number %q", name.Next.Value)
+	}
+	// the directive's ')' is the rune preceding the current one
+	end := p.f.find_line(p.offset - 1)
+	p.f.add_line_directive(end.num+1, name.Value, line)
+}
+
 func (p *parser) parse_esc_seq() {
 	loc := p.f.Encode(p.offset)
 
@@ -294,6 +1236,20 @@ func (p *parser) parse_esc_seq() {
 	case '"':
 		p.next()
 		p.buf.WriteByte('"')
+	case '\n', '\r':
+		if !p.string_newlines {
+			p.error(loc, `unrecognized escape sequence within '"' string`)
+		}
+		if p.cur == '\r' {
+			p.next()
+			if p.cur != '\n' {
+				p.error(loc, `unrecognized escape sequence within '"' string`)
+			}
+		}
+		p.next() // skip '\n'
+		for p.cur == ' ' || p.cur == '\t' {
+			p.next()
+		}
 	default:
 		switch p.cur {
 		case 'x':
@@ -343,82 +1299,384 @@ func (p *parser) parse_string() *Node {
 	save := p.advance_delim_state()
 
 	p.next() // skip opening '"'
+	if p.text_blocks && p.cur == '"' && p.peek() == '"' {
+		p.next()
+		p.next()
+		return p.parse_text_block(loc, save)
+	}
 	for {
 		switch p.cur {
 		case '\n':
-			p.error(loc, `newline is not allowed within '"' strings`)
+			if !p.string_newlines {
+				p.error(loc, `newline is not allowed within '"' strings`)
+			}
+			p.write_cur(&p.buf)
+			p.next()
 		case '\\':
 			p.parse_esc_seq()
 		case '"':
-			node := &Node{
+			node := p.new_node(Node{
 				Location: loc,
 				Value:    p.buf.String(),
-			}
+				Kind:     NodeString,
+			})
 			p.buf.Reset()
+			p.check_atom(node)
 
 			// consume enclosing '"', could be EOF
 			p.restore_delim_state(save)
 			p.next()
 			return node
 		default:
-			p.buf.WriteRune(p.cur)
+			p.write_cur(&p.buf)
+			p.write_run(true)
 			p.next()
 		}
 	}
 	panic("unreachable")
 }
 
+// Parses the rest of a text block, expects the opening '"""' to be consumed
+// already.
+func (p *parser) parse_text_block(loc SourceLoc, save delim_state) *Node {
+	for p.cur == ' ' || p.cur == '\t' || p.cur == '\r' {
+		p.next()
+	}
+	if p.cur != '\n' {
+		p.error(loc, `text block must start on a new line after '"""'`)
+	}
+	p.next()
+
+	for {
+		p.write_cur(&p.buf)
+		if bytes.HasSuffix(p.buf.Bytes(), []byte(`"""`)) {
+			break
+		}
+		p.next()
+	}
+	text := p.buf.String()
+	p.buf.Reset()
+	lines := strings.Split(text[:len(text)-3], "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	// find the common indentation, the last line is the one with the
+	// closing '"""', it counts even if it's blank
+	indent := ""
+	first := true
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" && i != len(lines)-1 {
+			continue
+		}
+		prefix := line[:len(line)-len(trimmed)]
+		if first {
+			indent = prefix
+			first = false
+			continue
+		}
+		n := 0
+		for n < len(indent) && n < len(prefix) && indent[n] == prefix[n] {
+			n++
+		}
+		indent = indent[:n]
+	}
+
+	if strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		if strings.TrimLeft(line, " \t") == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = line[len(indent):]
+		}
+	}
+
+	node := p.new_node(Node{
+		Location: loc,
+		Value:    strings.Join(lines, "\n"),
+		Kind:     NodeString,
+	})
+	// consume enclosing '"', could be EOF
+	p.restore_delim_state(save)
+	p.next()
+	return node
+}
+
 func (p *parser) parse_raw_string() *Node {
 	loc := p.f.Encode(p.offset)
 	save := p.advance_delim_state()
 
 	p.next() // skip opening '`'
+	warned := false
 	for {
 		if p.cur == '`' {
-			node := &Node{
+			node := p.new_node(Node{
 				Location: loc,
 				Value:    p.buf.String(),
-			}
+				Kind:     NodeRawString,
+			})
 			p.buf.Reset()
+			p.check_atom(node)
 			// consume enclosing '`', could be EOF
 			p.restore_delim_state(save)
 			p.next()
 			return node
 		} else {
-			p.buf.WriteRune(p.cur)
+			if p.cur == '\\' && p.warnings != nil && !warned &&
+				strings.ContainsRune(`abfnrtv\"'`, p.peek()) {
+				p.warn(p.f.Encode(p.offset),
+					"escape sequence \\%c is not interpreted in raw strings", p.peek())
+				warned = true
+			}
+			p.write_cur(&p.buf)
 			p.next()
 		}
 	}
 	panic("unreachable")
 }
 
+func (p *parser) parse_piped_symbol() *Node {
+	loc := p.f.Encode(p.offset)
+	save := p.advance_delim_state()
+
+	p.next() // skip opening '|'
+	for p.cur != '|' {
+		if p.cur == '\\' {
+			esc := p.f.Encode(p.offset)
+			p.next() // skip '\\'
+			if p.cur != '|' && p.cur != '\\' {
+				p.error(esc, `unrecognized escape sequence within '|' symbol`)
+			}
+		}
+		p.write_cur(&p.buf)
+		p.next()
+	}
+	node := p.new_node(Node{
+		Location: loc,
+		Value:    p.buf.String(),
+	})
+	p.buf.Reset()
+	p.check_atom(node)
+
+	// consume enclosing '|', could be EOF
+	p.restore_delim_state(save)
+	p.next()
+	return node
+}
+
+func (p *parser) parse_heredoc() *Node {
+	loc := p.f.Encode(p.offset)
+	save := p.advance_delim_state()
+	// EOF is handled here
+	p.expect_eof = true
+
+	p.next() // skip '#'
+	p.next() // skip '<'
+	if p.cur != '<' {
+		p.error(loc, "'#<<' expected")
+	}
+	p.next()
+	for p.cur != '\n' && p.curlen != 0 {
+		p.write_cur(&p.buf)
+		p.next()
+	}
+	term := strings.TrimSuffix(p.buf.String(), "\r")
+	p.buf.Reset()
+	if term == "" {
+		p.error(loc, "heredoc terminator expected after '#<<'")
+	}
+
+	var line bytes.Buffer
+	first := true
+	for {
+		if p.curlen == 0 {
+			p.unclosed_error(loc, "missing heredoc terminator %q", term)
+		}
+		p.next() // skip '\n'
+		for p.cur != '\n' && p.curlen != 0 {
+			p.write_cur(&line)
+			p.next()
+		}
+		if line.String() == term || line.String() == term+"\r" {
+			break
+		}
+		if !first {
+			p.buf.WriteByte('\n')
+		}
+		p.buf.Write(line.Bytes())
+		line.Reset()
+		first = false
+	}
+
+	node := p.new_node(Node{
+		Location: loc,
+		Value:    p.buf.String(),
+		Kind:     NodeString,
+	})
+	p.buf.Reset()
+	p.restore_delim_state(save)
+	return node
+}
+
+func (p *parser) parse_label() *Node {
+	loc := p.f.Encode(p.offset)
+	p.next() // skip '#'
+	for p.cur >= '0' && p.cur <= '9' {
+		p.buf.WriteRune(p.cur)
+		p.next()
+	}
+	label := p.buf.String()
+	p.buf.Reset()
+	if p.labels == nil {
+		p.labels = make(map[string]*Node)
+	}
+
+	switch p.cur {
+	case '=':
+		if _, ok := p.labels[label]; ok {
+			p.error(loc, "label #%s= is already defined", label)
+		}
+		p.labels[label] = nil
+		p.next()
+		p.skip_spaces()
+		var node *Node
+		if p.curlen != 0 {
+			// not EOF
+			node = p.parse_node()
+		}
+		if node == nil {
+			p.error(loc, "label #%s= must be followed by an expression", label)
+		}
+		p.labels[label] = node
+		return node
+	case '#':
+		node, ok := p.labels[label]
+		if !ok {
+			p.error(loc, "undefined label #%s#", label)
+		}
+		if node == nil {
+			p.error(loc, "circular reference #%s# to the enclosing expression", label)
+		}
+		p.next()
+		c := copy_tree(node)
+		c.Location = loc
+		return c
+	}
+	p.error(p.f.Encode(p.offset), "'=' or '#' expected after label #%s", label)
+	return nil
+}
+
+// Parses #hex# and |base64| atoms.
+func (p *parser) parse_spki_atom() *Node {
+	loc := p.f.Encode(p.offset)
+	save := p.advance_delim_state()
+	delim := p.cur
+
+	p.next() // skip opening delimiter
+	for p.cur != delim {
+		if !is_space(p.cur) {
+			p.write_cur(&p.buf)
+		}
+		p.next()
+	}
+	text := p.buf.String()
+	p.buf.Reset()
+
+	var value []byte
+	var err error
+	if delim == '#' {
+		value, err = hex.DecodeString(text)
+	} else {
+		value, err = base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			value, err = base64.RawStdEncoding.DecodeString(text)
+		}
+	}
+	if err != nil {
+		kind := "hexadecimal"
+		if delim == '|' {
+			kind = "base64"
+		}
+		p.error(loc, "invalid %s atom: %s", kind, err)
+	}
+
+	// consume enclosing delimiter, could be EOF
+	p.restore_delim_state(save)
+	p.next()
+	return p.new_node(Node{Location: loc, Value: string(value)})
+}
+
 func (p *parser) parse_ident() *Node {
 	loc := p.f.Encode(p.offset)
 	for {
-		if is_delimiter(p.cur) {
-			node := &Node{
+		if p.is_delimiter(p.cur) {
+			node := p.new_node(Node{
 				Location: loc,
 				Value:    p.buf.String(),
-			}
+			})
 			p.buf.Reset()
+			p.check_atom(node)
+			node.Kind = atom_kind(node.Value)
 			return node
 		} else {
-			p.buf.WriteRune(p.cur)
+			if p.strict_atoms && strings.ContainsRune("',|[]{}", p.cur) ||
+				p.atom_chars != nil && !p.atom_chars(p.cur) {
+				p.error(p.f.Encode(p.offset),
+					"'%c' is not allowed within atoms", p.cur)
+			}
+			p.write_cur(&p.buf)
+			p.write_run(false)
 			p.next()
 		}
 	}
 	panic("unreachable")
 }
 
-func (p *parser) parse() (root *Node, err error) {
+func (p *parser) parse() (*Node, error) {
+	root := p.new_node(Node{Kind: NodeList})
+	if p.bind_context {
+		root.ctx = p.f.ctx
+	}
+	var lastchild *Node
+	err := p.parse_each(func(node *Node) error {
+		if root.Children == nil {
+			root.Children = node
+		} else {
+			lastchild.Next = node
+		}
+		lastchild = node
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Parses top-level nodes one by one passing them to `f`, stops at the first
+// error returned by `f`.
+func (p *parser) parse_each(f func(*Node) error) (err error) {
+	count := 0
 	defer func() {
 		if e := recover(); e != nil {
-			p.f.Finalize(p.offset)
+			ferr := p.f.finalize(p.offset)
 			if e == io.EOF {
+				if ferr != nil {
+					err = ferr
+					return
+				}
+				if p.exactly_one && count == 0 {
+					err = &ParseError{
+						Location: p.f.Encode(p.offset),
+						message:  "an expression expected, the input is empty",
+					}
+				}
 				return
 			}
 			if sexperr, ok := e.(*ParseError); ok {
-				root = nil
 				err = sexperr
 				return
 			}
@@ -426,24 +1684,38 @@ func (p *parser) parse() (root *Node, err error) {
 		}
 	}()
 
-	root = new(Node)
 	p.next()
+	p.skip_bom()
+	p.skip_shebang()
 
 	// don't worry, will eventually panic with io.EOF :D
-	var lastchild *Node
 	for {
 		p.skip_spaces()
+		if p.exactly_one && count == 1 {
+			for p.is_comment() {
+				p.skip_comment()
+				p.skip_spaces()
+			}
+			if p.cur == 0 && p.curlen == 0 {
+				panic(io.EOF)
+			}
+			p.error(p.f.Encode(p.offset),
+				"trailing content at line %d, a single expression expected",
+				p.f.find_line(p.offset).num)
+		}
 		node := p.parse_node()
 		if node == nil {
 			p.error(p.f.Encode(p.offset),
-				"unexpected ')' at the top level")
+				"unexpected '%c' at the top level", p.cur)
 		}
-		if root.Children == nil {
-			root.Children = node
-		} else {
-			lastchild.Next = node
+		count++
+		if p.bind_context {
+			bind_context(node, p.f.ctx)
+		}
+		if err := f(node); err != nil {
+			p.f.finalize(p.offset)
+			return err
 		}
-		lastchild = node
 	}
 	panic("unreachable")
 }
@@ -451,8 +1723,12 @@ func (p *parser) parse() (root *Node, err error) {
 func (p *parser) parse_one_node() (node *Node, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			p.f.Finalize(p.offset)
+			ferr := p.f.finalize(p.offset)
 			if e == io.EOF {
+				if ferr != nil {
+					node = nil
+					err = ferr
+				}
 				return
 			}
 			if sexperr, ok := e.(*ParseError); ok {
@@ -465,11 +1741,16 @@ func (p *parser) parse_one_node() (node *Node, err error) {
 	}()
 
 	p.next()
+	p.skip_bom()
+	p.skip_shebang()
 	p.skip_spaces()
 	node = p.parse_node()
 	if node == nil {
 		p.error(p.f.Encode(p.offset),
-			"unexpected ')' at the top level")
+			"unexpected '%c' at the top level", p.cur)
+	}
+	if p.bind_context {
+		bind_context(node, p.f.ctx)
 	}
 	err = p.rs.UnreadRune()
 	return
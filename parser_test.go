@@ -3,10 +3,13 @@ package sexp
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"io/ioutil"
 )
 
@@ -126,8 +129,8 @@ func format_siblings(buf *bytes.Buffer, n *Node) {
 	}
 }
 
-func test_tree(t *testing.T, source, gold string) {
-	root, err := Parse(strings.NewReader(source), nil)
+func test_tree(t *testing.T, source, gold string, opts ...ParseOption) {
+	root, err := Parse(strings.NewReader(source), nil, opts...)
 	if err != nil {
 		t.Error(err)
 		return
@@ -173,6 +176,192 @@ func TestParser(t *testing.T) {
 	test_tree(t, "`123` `456`", `"123" "456"`)
 }
 
+func TestCommentSyntax(t *testing.T) {
+	hash := CommentSyntax("#")
+	test_tree(t, "1 # comment\n2", `"1" "2"`, hash)
+	test_tree(t, "(a#b #c\n)", `("a#b")`, hash)
+	test_tree(t, "1 ;2", `"1" ";2"`, hash)
+
+	slashes := CommentSyntax(";", "//")
+	test_tree(t, "(1 // comment\n2) ; another", `("1" "2")`, slashes)
+	test_tree(t, "http://x / /a //", `"http://x" "/" "/a"`, slashes)
+	test_tree(t, "(/)", `("/")`, slashes)
+
+	sr := strings.NewReader("/x rest")
+	node, err := ParseOne(sr, nil, slashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(sr)
+	if node.Value != "/x" || string(data) != " rest" {
+		t.Errorf("unexpected ParseOne result: %q, %q", node.Value, data)
+	}
+}
+
+func TestNewlinesInStrings(t *testing.T) {
+	nl := AllowNewlinesInStrings()
+	test_tree(t, "\"a\nb\"", `"a\nb"`, nl)
+	test_tree(t, "\"a \\\n\t  b\\\r\nc\"", `"a bc"`, nl)
+
+	_, err := Parse(strings.NewReader("\"a\\\nb\""), nil)
+	error_must_contain(t, err, `unrecognized escape sequence`)
+}
+
+func TestHeredocs(t *testing.T) {
+	h := Heredocs()
+	test_tree(t, "(#<<END\necho `date`\n  END\nEND\n)", "(\"echo `date`\\n  END\")", h)
+	test_tree(t, "#<<EOF\r\na\r\n\r\nEOF\r\n1", `"a\r\n\r" "1"`, h)
+	test_tree(t, "#<<X\nX", `""`, h)
+	test_tree(t, "#<<X\n\nX", `""`, h)
+	test_tree(t, "#<<X\n\n\nX", `"\n"`, h)
+	test_tree(t, "(a#<b # comment\n)", `("a#<b")`, h, CommentSyntax("#"))
+	test_tree(t, "#<<X", `"#<<X"`)
+
+	var ctx SourceContext
+	f := ctx.AddFile("test.txt", -1)
+	_, err := Parse(strings.NewReader("(#<<X\nabc)"), f, h)
+	error_must_contain(t, err, `missing heredoc terminator "X"`)
+	_, err = Parse(strings.NewReader("#<<\nabc"), f, h)
+	error_must_contain(t, err, `heredoc terminator expected`)
+	_, err = Parse(strings.NewReader("#<a"), f, h)
+	error_must_contain(t, err, `'#<<' expected`)
+}
+
+func TestTextBlocks(t *testing.T) {
+	tb := TextBlocks()
+	test_tree(t, "(q \"\"\"\n    SELECT *\n      FROM t\n\n    \"\"\")", `("q" "SELECT *\n  FROM t\n")`, tb)
+	test_tree(t, "\"\"\"  \r\n  a\r\n  b\"\"\"", `"a\nb"`, tb)
+	test_tree(t, "\"\"\"\n    a\n  \"\"\"", `"  a"`, tb)
+	test_tree(t, "\"\"\"\n\"\"\"", `""`, tb)
+	test_tree(t, "(\"\" \"\")", `("" "")`, tb)
+	test_tree(t, "\"\"\"a\"\"\"", `"" "a" ""`)
+
+	_, err := Parse(strings.NewReader("\"\"\"a\n\"\"\""), nil, tb)
+	error_must_contain(t, err, `must start on a new line`)
+	_, err = Parse(strings.NewReader("\"\"\"\na\"\""), nil, tb)
+	error_must_contain(t, err, `missing.+"`)
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	test_tree(t, "(a\xff \"\xfe\")", `("a�" "�")`)
+	test_tree(t, "(a\xff \"\xfe\" \ufffd)", `("a\xff" "\xfe" "�")`,
+		InvalidUTF8(InvalidUTF8Raw))
+
+	var ctx SourceContext
+	f := ctx.AddFile("test.txt", -1)
+	_, err := Parse(strings.NewReader("(a\n b\xff)"), f, InvalidUTF8(InvalidUTF8Error))
+	error_must_contain(t, err, `invalid UTF-8 encoding`)
+	if loc := ctx.Decode(err.(*ParseError).Location); loc.Line != 2 || loc.Offset != 5 {
+		t.Errorf("unexpected error location: %+v", loc)
+	}
+
+	rr := runes_only{strings.NewReader("\xff")}
+	_, err = Parse(rr, nil, InvalidUTF8(InvalidUTF8Raw))
+	error_must_contain(t, err, `raw bytes require`)
+}
+
+type runes_only struct {
+	r io.RuneReader
+}
+
+func (r runes_only) ReadRune() (rune, int, error) {
+	return r.r.ReadRune()
+}
+
+// The byte-level fast path must produce the same trees as the rune-level
+// reading, including locations.
+func TestFastPath(t *testing.T) {
+	for _, src := range []string{
+		"(abc def-ghi \"jkl mno\" 12 3.5)\n(a\n  \"b\\\"c\\n\" ;comment\n  d)",
+		"(atoms-longer-than-the-buffer \"a string longer than the buffer\")",
+		"(été \"日本語 é\" abécd \"ab\\u00e9cd\")",
+		"(a \"b\xffc\" d\xffe)",
+	} {
+		for _, opts := range [][]ParseOption{
+			nil,
+			{InvalidUTF8(InvalidUTF8Raw)},
+			{StrictAtoms(), AtomChars(func(r rune) bool { return r != '!' })},
+		} {
+			r := strings.NewReader(src)
+			slow, err1 := Parse(struct {
+				io.RuneScanner
+				io.ByteReader
+			}{r, r}, nil, opts...)
+			fast, err2 := Parse(bufio.NewReaderSize(strings.NewReader(src), 16), nil, opts...)
+			if (err1 == nil) != (err2 == nil) || err1 != nil && err1.Error() != err2.Error() {
+				t.Errorf("%q: errors mismatch: %v != %v", src, err1, err2)
+			} else if err1 == nil && !trees_equal(slow, fast) {
+				t.Errorf("%q: trees mismatch", src)
+			}
+		}
+	}
+}
+
+func TestBOM(t *testing.T) {
+	test_tree(t, "\ufeff(a \ufeff)", `("a" "\ufeff")`)
+
+	utf16le := "\xff\xfe(\x00a\x00 \x00=\xd8\x00\xde)\x00"
+	utf16be := "\xfe\xff\x00(\x00a\x00 \xd8=\xde\x00\x00)"
+	for _, src := range []string{utf16le, utf16be} {
+		r := bufio.NewReader(NewUTF16Reader(strings.NewReader(src)))
+		root, err := Parse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := root.Children.Children.Next.Value; v != "\U0001F600" {
+			t.Errorf("unexpected value: %q", v)
+		}
+	}
+
+	data, _ := ioutil.ReadAll(NewUTF16Reader(strings.NewReader("\xff\xfe\x00\xd8a\x00\x00")))
+	if string(data) != "\ufffda\ufffd" {
+		t.Errorf("unexpected transcoding result: %q", data)
+	}
+	data, _ = ioutil.ReadAll(NewUTF16Reader(strings.NewReader("(a)")))
+	if string(data) != "(a)" {
+		t.Errorf("unexpected passthrough result: %q", data)
+	}
+}
+
+func TestStrictAtoms(t *testing.T) {
+	strict := StrictAtoms()
+	test_tree(t, "(a-b \"[x]\" `{y}`)", `("a-b" "[x]" "{y}")`, strict)
+	test_tree(t, "('a, [b])", `("'a," "[b]")`)
+
+	var ctx SourceContext
+	for _, src := range []string{"(x 'a)", "(x a,)", "(x |a|)", "(x a[0])", "(x {a})"} {
+		f := ctx.AddFile("test.txt", -1)
+		_, err := Parse(strings.NewReader(src), f, strict)
+		error_must_contain(t, err, `is not allowed within atoms`)
+		if err == nil {
+			continue
+		}
+		loc := ctx.Decode(err.(*ParseError).Location)
+		if c := src[loc.Offset]; !strings.ContainsRune("',|[{", rune(c)) {
+			t.Errorf("%s: error points at '%c'", src, c)
+		}
+	}
+}
+
+func TestSharedLabels(t *testing.T) {
+	sl := SharedLabels()
+	test_tree(t, "(#1=(x y) #1#)", `(("x" "y") ("x" "y"))`, sl)
+	test_tree(t, "#1=a (#2= (b #1#) #2# #1#)", `"a" (("b" "a") ("b" "a") "a")`, sl)
+	test_tree(t, "(#1=a #1#)", `("#1=a" "#1#")`)
+	test_tree(t, "(#1=a #x\n#1#) # c", `("a" "a")`, sl, CommentSyntax("#"))
+
+	test := func(source, err string) {
+		_, e := Parse(strings.NewReader(source), nil, sl)
+		error_must_contain(t, e, err)
+	}
+	test("#1=(a #1#)", `circular reference #1#`)
+	test("(#1#)", `undefined label #1#`)
+	test("#1=a #1=b", `label #1= is already defined`)
+	test("(#1=)", `must be followed by an expression`)
+	test("#1=", `must be followed by an expression`)
+	test("#12x", `'=' or '#' expected after label #12`)
+}
+
 func TestParserErrors(t *testing.T) {
 	// fail reader
 	_, err := Parse(bufio.NewReader(fail_reader(0)), nil)
@@ -197,6 +386,155 @@ func TestParserErrors(t *testing.T) {
 	error_must_contain(t, test(`123)`), `unexpected '\)'`)
 }
 
+func TestParseEach(t *testing.T) {
+	var values []string
+	err := ParseEach(bytes.NewBufferString("(a 1) b ; comment\n(c)"), func(n *Node) error {
+		if n.Next != nil {
+			t.Error("nodes are not expected to be linked")
+		}
+		var buf bytes.Buffer
+		n.WriteTo(&buf)
+		values = append(values, buf.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(values, " ") != "(a 1) b (c)" {
+		t.Errorf("unexpected nodes: %q", values)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = ParseEach(strings.NewReader("a b c"), func(*Node) error {
+		n++
+		if n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 2 {
+		t.Errorf("callback error is expected to stop parsing: %v, %d", err, n)
+	}
+
+	n = 0
+	err = ParseEach(strings.NewReader("a b (c"), func(*Node) error {
+		n++
+		return nil
+	})
+	error_must_contain(t, err, `missing.+\)`)
+	if n != 2 {
+		t.Errorf("nodes preceding the syntax error are expected to be passed: %d", n)
+	}
+}
+
+func TestListDelimiters(t *testing.T) {
+	opt := ListDelimiters("<>", "[]")
+	root, err := Parse(strings.NewReader("(a <b [c d]> e)"), nil, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	root.Children.WriteTo(&buf)
+	if buf.String() != "(a (b (c d)) e)" {
+		t.Errorf("unexpected tree: %s", buf.String())
+	}
+
+	_, err = Parse(strings.NewReader("<a]"), nil, opt)
+	error_must_contain(t, err, `unexpected '\]', '>' expected`)
+	_, err = Parse(strings.NewReader("(a>"), nil, opt)
+	error_must_contain(t, err, `unexpected '>', '\)' expected`)
+	_, err = Parse(strings.NewReader("a ]"), nil, opt)
+	error_must_contain(t, err, `unexpected '\]' at the top level`)
+	_, err = Parse(strings.NewReader("(a <b"), nil, opt)
+	error_must_contain(t, err, `missing matching sequence delimiter '>'`)
+
+	test_scan(t, "<a]", "open:< atom:a close:]", opt)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("ListDelimiters must panic on invalid pairs")
+		}
+	}()
+	ListDelimiters("{|")
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		opts []ParseOption
+	}{
+		{"(a (b c) d e f g) `raw` \"str\"", nil},
+		{"(a (b (c (d (e f g h i j)))) k l m n)", nil},
+		{"(a b", nil},
+		{"(a \"\\q\")", nil},
+		{"(a \"b\nc\")", nil},
+		{"(a `b", nil},
+		{"a) b", nil},
+		{"(a b c d e (f", nil},
+		{"(a \"\xff\")", []ParseOption{InvalidUTF8(InvalidUTF8Error)}},
+		{"[a (b c] d)", []ParseOption{ListDelimiters("[]")}},
+		{"[a (b c) d] e]", []ParseOption{ListDelimiters("[]")}},
+		{"(a b)\n; comment\n(c)", []ParseOption{ExactlyOne()}},
+		{" ; comment", []ParseOption{ExactlyOne()}},
+		{"(a #<<END\nb\n)", []ParseOption{Heredocs()}},
+		{"\uFEFF#!/bin/tool\n(a b c)", []ParseOption{Shebang()}},
+		{" \uFEFF`", nil},
+		{"(a \uFEFFb)", nil},
+	} {
+		// one byte at a time makes every token cross a chunk boundary
+		for _, r := range []io.Reader{
+			strings.NewReader(c.src),
+			iotest.OneByteReader(strings.NewReader(c.src)),
+		} {
+			_, perr := Parse(strings.NewReader(c.src), nil, c.opts...)
+			verr := Validate(r, c.opts...)
+			if (perr == nil) != (verr == nil) || perr != nil && verr.Error() != perr.Error() {
+				t.Errorf("%q: %v != %v", c.src, verr, perr)
+				continue
+			}
+			if perr == nil {
+				continue
+			}
+			pe, ve := perr.(*ParseError), verr.(*ParseError)
+			if ve.Location != pe.Location || fmt.Sprint(ve.Related) != fmt.Sprint(pe.Related) {
+				t.Errorf("%q: error locations differ: %d %v != %d %v",
+					c.src, ve.Location, ve.Related, pe.Location, pe.Related)
+			}
+		}
+	}
+
+	if Validate(strings.NewReader("(a)"), LineDirectives()) == nil {
+		t.Error("Validate must reject LineDirectives")
+	}
+}
+
+func TestParseChan(t *testing.T) {
+	nodes, errc := ParseChan(context.Background(), strings.NewReader("a (b c) d ("))
+	var values []string
+	for n := range nodes {
+		var buf bytes.Buffer
+		n.WriteTo(&buf)
+		values = append(values, buf.String())
+	}
+	if strings.Join(values, " ") != "a (b c) d" {
+		t.Errorf("unexpected nodes: %q", values)
+	}
+	error_must_contain(t, <-errc, `missing.+\)`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	nodes, errc = ParseChan(ctx, strings.NewReader("a b c"))
+	<-nodes
+	cancel()
+	// nobody receives nodes now, so the parser can't do anything but stop
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("context.Canceled expected, got: %v", err)
+	}
+	if _, ok := <-nodes; ok {
+		t.Error("node channel is expected to be closed")
+	}
+}
+
 const mixed_text = `(node 1 2 3)Some text here`
 
 func TestParseOne(t *testing.T) {
@@ -226,3 +564,238 @@ func TestParseOne(t *testing.T) {
 		t.Fatalf(`"Some text here" expected, got: %s`, string(data))
 	}
 }
+
+func TestExactlyOne(t *testing.T) {
+	root, err := Parse(strings.NewReader("; header\n(a b) ; trailing comment\n\n"), nil, ExactlyOne())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.NumChildren() != 1 {
+		t.Errorf("a single child expected, got: %d", root.NumChildren())
+	}
+
+	_, err = Parse(strings.NewReader("(a b)\n; comment\nc (d"), nil, ExactlyOne())
+	error_must_contain(t, err, `^trailing content at line 3, a single expression expected$`)
+	if err.(*ParseError).Location != 16 {
+		t.Errorf("unexpected error location: %d", err.(*ParseError).Location)
+	}
+	_, err = Parse(strings.NewReader(" ; nothing"), nil, ExactlyOne())
+	error_must_contain(t, err, `an expression expected, the input is empty`)
+	_, err = Parse(strings.NewReader("a )"), nil, ExactlyOne())
+	error_must_contain(t, err, `trailing content at line 1`)
+
+	err = ParseEach(strings.NewReader("a b"), func(*Node) error { return nil }, ExactlyOne())
+	error_must_contain(t, err, `trailing content at line 1`)
+}
+
+func TestUnclosedErrorRelated(t *testing.T) {
+	test := func(src string, loc SourceLoc, related SourceLoc, opts ...ParseOption) {
+		_, err := Parse(strings.NewReader(src), nil, opts...)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("*ParseError expected, got: %v", err)
+			return
+		}
+		if perr.Location != loc || len(perr.Related) != 1 || perr.Related[0] != related {
+			t.Errorf("%q: unexpected locations: %d %v", src, perr.Location, perr.Related)
+		}
+	}
+	test("(a (b c)\n  (d", 11, 13)
+	test("(a \"b\nc", 3, 7, AllowNewlinesInStrings())
+	test("x #<<END\nabc\n", 2, 13, Heredocs())
+
+	_, err := Parse(strings.NewReader("(a) b)"), nil)
+	if perr := err.(*ParseError); perr.Related != nil {
+		t.Errorf("no related locations expected, got: %v", perr.Related)
+	}
+	_, err = ParseCanonical([]byte("(1:a(1:b)"))
+	if perr := err.(*ParseError); perr.Location != 0 || len(perr.Related) != 1 || perr.Related[0] != 9 {
+		t.Errorf("unexpected locations: %d %v", perr.Location, perr.Related)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	var warnings []*Diagnostic
+	collect := Warnings(func(d *Diagnostic) { warnings = append(warnings, d) })
+	test := func(src string, what ...string) {
+		warnings = nil
+		if _, err := Parse(strings.NewReader(src), nil, collect, AllowNewlinesInStrings()); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(warnings) != len(what) {
+			t.Errorf("%d warnings expected, got: %v", len(what), warnings)
+			return
+		}
+		for i, w := range warnings {
+			if w.Severity != SeverityWarning {
+				t.Errorf("warning severity expected")
+			}
+			must_contain(t, w.Message, what[i])
+		}
+	}
+	test("(a `b\\n` `\\x` \"c\\n\")", `^escape sequence \\n is not interpreted in raw strings$`)
+	test("\"a\n\t b\"", "indented with both tabs and spaces")
+	test("`a\n\tb\n  c`", "indented with both tabs and spaces")
+	test("\"\t\n  \"")
+	test(strings.Repeat("x", 70000), "atom is 70000 bytes long")
+	test(strings.Repeat("(", 300)+strings.Repeat(")", 300), "nested deeper than 256 levels")
+	if warnings[0].Location != 256 {
+		t.Errorf("unexpected warning location: %d", warnings[0].Location)
+	}
+	test(strings.Repeat("(a)", 300))
+}
+
+func TestNodeKind(t *testing.T) {
+	root, err := Parse(strings.NewReader("(a \"b\" `c` () (d) |e f|)"), nil, PipedSymbols())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for c := root.Children.Children; c != nil; c = c.Next {
+		kinds = append(kinds, c.Kind.String())
+	}
+	gold := "symbol string raw string list list symbol"
+	if s := strings.Join(kinds, " "); s != gold {
+		t.Errorf("%s != %s", s, gold)
+	}
+	if root.Kind != NodeList || root.Children.Kind != NodeList {
+		t.Error("lists are expected to be of the NodeList kind")
+	}
+	if s := NodeKind(42).String(); s != "NodeKind(42)" {
+		t.Errorf("%s != NodeKind(42)", s)
+	}
+}
+
+func TestAtomKind(t *testing.T) {
+	for _, c := range []struct {
+		atom string
+		kind NodeKind
+	}{
+		{"0", NodeInt}, {"-42", NodeInt}, {"+7", NodeInt}, {"0x1F", NodeInt},
+		{"0o17", NodeInt}, {"-0b101", NodeInt}, {"007", NodeInt},
+		{"3.0", NodeFloat}, {".5", NodeFloat}, {"5.", NodeFloat}, {"1e9", NodeFloat},
+		{"-2.5E-3", NodeFloat},
+		{"", NodeSymbol}, {"-", NodeSymbol}, {".", NodeSymbol}, {"0x", NodeSymbol},
+		{"0b102", NodeSymbol}, {"0o8", NodeSymbol}, {"0xg", NodeSymbol}, {"1e", NodeSymbol},
+		{"1.2.3", NodeSymbol}, {"e5", NodeSymbol}, {"inf", NodeSymbol}, {"1_000", NodeSymbol},
+	} {
+		if k := atom_kind(c.atom); k != c.kind {
+			t.Errorf("%q: %s != %s", c.atom, k, c.kind)
+		}
+	}
+
+	root, err := Parse(strings.NewReader("(5 |5| \"5\" 2.5)"), nil, PipedSymbols())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for c := root.Children.Children; c != nil; c = c.Next {
+		kinds = append(kinds, c.Kind.String())
+	}
+	if s := strings.Join(kinds, " "); s != "int symbol string float" {
+		t.Errorf("unexpected kinds: %s", s)
+	}
+}
+
+func TestAtomChars(t *testing.T) {
+	opt := AtomChars(func(r rune) bool {
+		return r >= 'a' && r <= 'z' || r == '-'
+	})
+	if _, err := Parse(strings.NewReader(`(foo-bar "x y" |a b|)`), nil, opt, PipedSymbols()); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Parse(strings.NewReader("(foo\n  ba$r)"), nil, opt)
+	error_must_contain(t, err, `'\$' is not allowed within atoms`)
+	if perr, ok := err.(*ParseError); !ok || perr.Location != 9 {
+		t.Errorf("unexpected error location: %v", err)
+	}
+}
+
+func TestPipedSymbols(t *testing.T) {
+	root, err := Parse(strings.NewReader(`(|hello world| |a\|b\\c|x ||)`), nil, PipedSymbols())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for c := root.Children.Children; c != nil; c = c.Next {
+		values = append(values, c.Value)
+	}
+	if s := strings.Join(values, ","); s != `hello world,a|b\c,x,` {
+		t.Errorf("unexpected atoms: %s", s)
+	}
+
+	_, err = Parse(strings.NewReader(`|a\nb|`), nil, PipedSymbols())
+	error_must_contain(t, err, `unrecognized escape sequence within '\|' symbol`)
+	_, err = Parse(strings.NewReader(`(|a b)`), nil, PipedSymbols())
+	error_must_contain(t, err, `missing matching sequence delimiter '\|'`)
+
+	test_scan(t, `(|a\| b|)`, `open:( atom:|a\| b| close:)`, PipedSymbols())
+	test_scan(t, `|a b`, `invalid:|a b`, PipedSymbols())
+}
+
+func TestShebang(t *testing.T) {
+	script := "#!/usr/bin/env guile -s\n(display \"hello\")"
+	test_tree(t, script, `("display" "hello")`, Shebang())
+	test_tree(t, "\ufeff"+script, `("display" "hello")`, Shebang())
+	test_tree(t, "(a)\n#!b", `("a") "#!b"`, Shebang())
+	test_tree(t, "#!a b", `"#!a" "b"`)
+
+	root, err := Parse(strings.NewReader(script), nil, Shebang(), BindContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := root.Children.LocationIn(nil); loc.Line != 2 {
+		t.Errorf("the form must be on line 2, got %d", loc.Line)
+	}
+
+	test_scan(t, script, "comment:#!/usr/bin/env guile -s space:\n open:( "+
+		`atom:display space:  string:"hello" close:)`, Shebang())
+}
+
+func TestDocComments(t *testing.T) {
+	src := `;; Server settings.
+(server
+  ;; The port to listen on,
+  ;; 8080 by default.
+  (port 8080) ; not a doc comment
+  (host localhost)
+
+  ; separated by a blank line
+
+  (user nobody) ; trailing
+  (group nobody)
+  ( ; after the paren
+    x))
+# hash comment
+(a)`
+	root, err := Parse(strings.NewReader(src), nil, DocComments(), CommentSyntax(";", "#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, gold := range map[string]string{
+		"server":       "Server settings.",
+		"server/port":  "The port to listen on,\n8080 by default.",
+		"server/host":  "",
+		"server/user":  "",
+		"server/group": "",
+		"server/5/0":   "",
+		"a":            "hash comment",
+	} {
+		n, err := ResolvePath(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc := n.DocComment(); doc != gold {
+			t.Errorf("%s: %q != %q", path, doc, gold)
+		}
+	}
+
+	root, err = Parse(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc := root.Children.DocComment(); doc != "" {
+		t.Errorf("no doc comments expected without the option, got %q", doc)
+	}
+}
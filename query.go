@@ -0,0 +1,287 @@
+package sexp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled path query, see CompileQuery for the syntax.
+type Query struct {
+	path string
+	segs []query_seg
+}
+
+type query_seg_kind int
+
+const (
+	query_index query_seg_kind = iota
+	query_name
+	query_any
+	query_descendants
+)
+
+type query_seg struct {
+	kind  query_seg_kind
+	index int
+	name  string
+}
+
+// Compiles a path query. Path is a sequence of segments separated by '/'
+// (a leading '/' is optional), each segment selects nodes relative to the
+// nodes selected by the previous segment, starting with the root:
+//
+//     N      N-th child (starting from 0)
+//     *      all children
+//     **     the node itself and all its descendants
+//     name   children which are lists with the first element equal to the
+//            name, e.g. "version" selects the `(version 3.0)` form
+//     "..."  same as above, but the name is a quoted Go string, use it for
+//            names containing special characters
+//
+// For example, using the config from the Gtk bindings generator,
+// "blacklist/functions/1/*" selects all the blacklisted functions, while
+// "**/stock_lookup" finds all the forms starting with `stock_lookup` anywhere
+// in the document.
+func CompileQuery(path string) (*Query, error) {
+	q := &Query{path: path}
+	p := strings.TrimPrefix(path, "/")
+	if p == "" {
+		return q, nil
+	}
+	for p != "" {
+		var seg string
+		if p[0] == '"' {
+			// find the end of the quoted name
+			end := 1
+			for end < len(p) && p[end] != '"' {
+				if p[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(p) {
+				return nil, fmt.Errorf("unterminated quoted name in query %q", path)
+			}
+			seg, p = p[:end+1], p[end+1:]
+			if p != "" && p[0] != '/' {
+				return nil, fmt.Errorf("'/' expected after quoted name in query %q", path)
+			}
+		} else if i := strings.IndexByte(p, '/'); i != -1 {
+			seg, p = p[:i], p[i:]
+		} else {
+			seg, p = p, ""
+		}
+		if p != "" {
+			p = p[1:]
+			if p == "" {
+				return nil, fmt.Errorf("empty segment in query %q", path)
+			}
+		}
+
+		switch {
+		case seg == "":
+			return nil, fmt.Errorf("empty segment in query %q", path)
+		case seg == "*":
+			q.segs = append(q.segs, query_seg{kind: query_any})
+		case seg == "**":
+			q.segs = append(q.segs, query_seg{kind: query_descendants})
+		case seg[0] == '"':
+			name, err := strconv.Unquote(seg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted name %s in query %q", seg, path)
+			}
+			q.segs = append(q.segs, query_seg{kind: query_name, name: name})
+		default:
+			if i, err := strconv.Atoi(seg); err == nil && i >= 0 {
+				q.segs = append(q.segs, query_seg{kind: query_index, index: i})
+			} else {
+				q.segs = append(q.segs, query_seg{kind: query_name, name: seg})
+			}
+		}
+	}
+	return q, nil
+}
+
+// Returns the path the query was compiled from.
+func (q *Query) String() string {
+	return q.path
+}
+
+// Returns all the nodes matching the query in the document order. The same
+// node can be matched more than once when "**" is used multiple times.
+func (q *Query) Find(root *Node) []*Node {
+	var out []*Node
+	q.walk(root, 0, func(n *Node) bool {
+		out = append(out, n)
+		return true
+	})
+	return out
+}
+
+// Returns the number of nodes Find would return, without collecting them.
+func (q *Query) Count(root *Node) int {
+	n := 0
+	q.walk(root, 0, func(*Node) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Returns true if at least one node matches the query, the walk stops at the
+// first match.
+func (q *Query) Exists(root *Node) bool {
+	found := false
+	q.walk(root, 0, func(*Node) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// Compiles the path and returns all the nodes matching it, see CompileQuery
+// for the syntax.
+func Find(root *Node, path string) ([]*Node, error) {
+	q, err := CompileQuery(path)
+	if err != nil {
+		return nil, err
+	}
+	return q.Find(root), nil
+}
+
+// Calls `f` for every node matching the query segments starting with `i`,
+// stops as soon as `f` returns false. Returns false if it was stopped.
+func (q *Query) walk(n *Node, i int, f func(*Node) bool) bool {
+	if i == len(q.segs) {
+		return f(n)
+	}
+	seg := &q.segs[i]
+	switch seg.kind {
+	case query_index:
+		j := 0
+		for c := n.Children; c != nil; c = c.Next {
+			if j == seg.index {
+				return q.walk(c, i+1, f)
+			}
+			j++
+		}
+	case query_name:
+		for c := n.Children; c != nil; c = c.Next {
+			if c.IsList() && c.Children.IsScalar() && c.Children.Value == seg.name {
+				if !q.walk(c, i+1, f) {
+					return false
+				}
+			}
+		}
+	case query_any:
+		for c := n.Children; c != nil; c = c.Next {
+			if !q.walk(c, i+1, f) {
+				return false
+			}
+		}
+	case query_descendants:
+		if !q.walk(n, i+1, f) {
+			return false
+		}
+		for c := n.Children; c != nil; c = c.Next {
+			if !q.walk(c, i, f) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the canonical path of the node within the tree rooted at `root`,
+// the path is a query (see CompileQuery) matching that node only. A list
+// starting with a name none of its siblings start with is selected by the
+// name, e.g. "/blacklist/functions/15", other nodes by their index. The path
+// of the root itself is "/". Returns an empty string if the node is not in
+// the tree.
+func (n *Node) PathIn(root *Node) string {
+	chain := path_chain(root, n)
+	if chain == nil {
+		return ""
+	}
+	if len(chain) == 1 {
+		return "/"
+	}
+	var buf strings.Builder
+	for i := 1; i < len(chain); i++ {
+		buf.WriteByte('/')
+		buf.WriteString(path_segment(chain[i-1], chain[i]))
+	}
+	return buf.String()
+}
+
+// Returns the nodes on the way from the root to the node (both inclusive),
+// nil if the node is not in the tree.
+func path_chain(root, n *Node) []*Node {
+	if root == n {
+		return []*Node{root}
+	}
+	for c := root.Children; c != nil; c = c.Next {
+		if chain := path_chain(c, n); chain != nil {
+			return append([]*Node{root}, chain...)
+		}
+	}
+	return nil
+}
+
+func query_name_of(n *Node) (string, bool) {
+	if n.IsList() && n.Children.IsScalar() {
+		return n.Children.Value, true
+	}
+	return "", false
+}
+
+func path_segment(parent, child *Node) string {
+	if name, ok := query_name_of(child); ok {
+		unique := true
+		for c := parent.Children; c != nil; c = c.Next {
+			if other, ok := query_name_of(c); ok && c != child && other == name {
+				unique = false
+				break
+			}
+		}
+		if unique {
+			return quote_query_name(name)
+		}
+	}
+	i := 0
+	for c := parent.Children; c != child; c = c.Next {
+		i++
+	}
+	return strconv.Itoa(i)
+}
+
+// Quotes the name if CompileQuery would not read it back as a name.
+func quote_query_name(name string) string {
+	if _, err := strconv.Atoi(name); err == nil || name == "" || name == "*" ||
+		name == "**" || name[0] == '"' || strings.IndexByte(name, '/') != -1 {
+		return strconv.Quote(name)
+	}
+	return name
+}
+
+// Resolves the path to the only node it selects, see PathIn. Any query can be
+// used as a path, it's an error if it matches no nodes or more than one node.
+func ResolvePath(root *Node, path string) (*Node, error) {
+	q, err := CompileQuery(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Node
+	q.walk(root, 0, func(n *Node) bool {
+		out = append(out, n)
+		return len(out) < 2
+	})
+	switch len(out) {
+	case 0:
+		return nil, fmt.Errorf("path %q matches no nodes", path)
+	case 1:
+		return out[0], nil
+	}
+	return nil, fmt.Errorf("path %q matches more than one node", path)
+}
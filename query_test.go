@@ -0,0 +1,143 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	root, err := Parse(strings.NewReader(config), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := func(path, gold string) {
+		nodes, err := Find(root, path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		for i, n := range nodes {
+			if i != 0 {
+				buf.WriteString(" ")
+			}
+			n.WriteTo(&buf)
+		}
+		if buf.String() != gold {
+			t.Errorf("%s: %s != %s", path, buf.String(), gold)
+		}
+	}
+
+	test("/version", "(version 3.0)")
+	test("version/1", "3.0")
+	test("/0/*", "namespace Gtk")
+	test("blacklist/structdefs/1/*", "ActionEntry RadioActionEntry ToggleActionEntry")
+	test("blacklist/*/0", "structs structdefs functions")
+	test("**/structs", "(structs (StockItem))")
+	test(`"namespace"/1`, "Gtk")
+	test("blacklist/functions/1/100", "")
+	test("nothing", "")
+	test("", "((namespace Gtk) (version 3.0) (blacklist (structs (StockItem)) "+
+		"(structdefs (ActionEntry RadioActionEntry ToggleActionEntry)) "+
+		"(functions (accelerator_parse_with_keycode binding_entry_add_signal_from_string "+
+		"binding_entry_add_signall binding_entry_remove binding_entry_skip binding_set_find "+
+		"paper_size_get_default paper_size_get_paper_sizes rc_property_parse_border "+
+		"rc_property_parse_color rc_property_parse_enum rc_property_parse_flags "+
+		"rc_property_parse_requisition print_run_page_setup_dialog "+
+		"print_run_page_setup_dialog_async init_with_args stock_add stock_lookup "+
+		"stock_add_static rc_parse_color rc_parse_color_full rc_parse_priority "+
+		"rc_parse_state rc_find_pixmap_in_path stock_set_translate_func "+
+		"tree_row_reference_deleted tree_row_reference_inserted))))")
+
+	count := func(path string, gold int) {
+		q, err := CompileQuery(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if n := q.Count(root); n != gold {
+			t.Errorf("%s: count %d != %d", path, n, gold)
+		}
+		if q.Exists(root) != (gold != 0) {
+			t.Errorf("%s: unexpected Exists result", path)
+		}
+	}
+	count("/version", 1)
+	count("blacklist/structdefs/1/*", 3)
+	all, _ := Find(root, "**/*")
+	count("**/*", len(all))
+	count("nothing", 0)
+	count("blacklist/functions/1/100", 0)
+
+	for _, path := range []string{"a//b", "a/", `"abc`, `"a"b`} {
+		_, err := CompileQuery(path)
+		if err == nil {
+			t.Errorf("%s: error expected", path)
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	test_write := func(source, gold string) {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		root.Children.WriteTo(&buf)
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+	test_write("(a  b (c))", "(a b (c))")
+	test_write(`("a b" "" "x\ny" "(" "\"")`, `("a b" "" "x\ny" "(" "\"")`)
+	test_write("(`;` `\\`)", "(`;` `\\`)")
+	test_write("(() a \"b\" `c`)", "(() a \"b\" `c`)")
+	test_write("(x `\n`)", `(x "\n")`)
+}
+
+func TestPathIn(t *testing.T) {
+	root, err := Parse(strings.NewReader(`(a (b x) (b y) ("1" z) ("c/d" (e f)) () g)`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, _ := Find(root, "**")
+	for _, n := range all {
+		path := n.PathIn(root)
+		m, err := ResolvePath(root, path)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+		} else if m != n {
+			t.Errorf("%s: resolved to a different node", path)
+		}
+	}
+
+	at := func(path string) *Node {
+		nodes, _ := Find(root, path)
+		return nodes[0]
+	}
+	for _, c := range []struct {
+		n    *Node
+		gold string
+	}{
+		{root, "/"},
+		{at("0"), "/a"},
+		{at("0/1/1"), "/a/1/1"},
+		{at("0/3"), `/a/"1"`},
+		{at("0/4/1/1"), `/a/"c/d"/e/1`},
+		{at("0/5"), "/a/5"},
+		{&Node{}, ""},
+	} {
+		if path := c.n.PathIn(root); path != c.gold {
+			t.Errorf("%s != %s", path, c.gold)
+		}
+	}
+
+	for _, path := range []string{"a/b", "a/7", `a/"`} {
+		if _, err := ResolvePath(root, path); err == nil {
+			t.Errorf("%s: error expected", path)
+		}
+	}
+}
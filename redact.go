@@ -0,0 +1,87 @@
+package sexp
+
+import (
+	"reflect"
+	"sort"
+)
+
+// The atom replacing secret values, see (*Node).Redact.
+const redacted_value = "<redacted>"
+
+// Returns a copy of the tree with the values of key/value pairs whose key is
+// one of `keys` replaced by "<redacted>" atoms, e.g. `(password hunter2)`
+// becomes `(password <redacted>)`. Each value item is replaced by a single
+// atom, lists included, locations are kept. Use it to print trees holding
+// secrets, e.g. with WriteTo or String. Siblings of the node are not copied.
+func (n *Node) Redact(keys ...string) *Node {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return redact_node(n, set)
+}
+
+func redact_node(n *Node, keys map[string]bool) *Node {
+	out := &Node{Location: n.Location, Value: n.Value, Kind: n.Kind, ctx: n.ctx}
+	if n.Children == nil {
+		return out
+	}
+	var chain node_chain
+	key := n.Children
+	secret := key.IsScalar() && keys[key.Value]
+	for c := n.Children; c != nil; c = c.Next {
+		if secret && c != key {
+			chain.push(&Node{Location: c.Location, Value: redacted_value, ctx: c.ctx})
+		} else {
+			chain.push(redact_node(c, keys))
+		}
+	}
+	out.Children = chain.finish()
+	return out
+}
+
+// Returns the keys of struct fields tagged with the "secret" option, see
+// (*Node).Unmarshal, found in the type of `v` and the types it contains
+// (fields, elements of arrays, slices and maps, pointers), sorted and
+// without duplicates. The result is meant for (*Node).Redact and
+// FormatOptions.Redact.
+func SecretKeys(v interface{}) []string {
+	set := make(map[string]bool)
+	collect_secret_keys(reflect.TypeOf(v), set, make(map[reflect.Type]bool))
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func collect_secret_keys(t reflect.Type, keys map[string]bool, seen map[reflect.Type]bool) {
+	if t == nil || seen[t] {
+		return
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Array, reflect.Slice:
+		collect_secret_keys(t.Elem(), keys, seen)
+	case reflect.Map:
+		collect_secret_keys(t.Key(), keys, seen)
+		collect_secret_keys(t.Elem(), keys, seen)
+	case reflect.Struct:
+		for i, n := 0, t.NumField(); i < n; i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("sexp")
+			if tag == "-" || f.Anonymous || f.PkgPath != "" {
+				continue
+			}
+			name, opts := parse_tag(tag)
+			if opts.contains("secret") {
+				if name == "" {
+					name = f.Name
+				}
+				keys[name] = true
+			}
+			collect_secret_keys(f.Type, keys, seen)
+		}
+	}
+}
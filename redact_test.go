@@ -0,0 +1,69 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type redact_config struct {
+	User     string `sexp:"user"`
+	Password string `sexp:"password,secret"`
+	Token    struct {
+		Value int `sexp:"value,secret"`
+	} `sexp:"token"`
+}
+
+func TestRedact(t *testing.T) {
+	src := "((user bob)\n (password hunter2)\n (token ((value abc123))))"
+	var ctx SourceContext
+	f := ctx.AddFile("test.sexp", len(src))
+	root, err := Parse(strings.NewReader(src), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v redact_config
+	err = root.Children.Unmarshal(&v)
+	ue, ok := err.(*UnmarshalError)
+	if !ok || !ue.Redacted {
+		t.Fatalf("redacted error expected, got: %v", err)
+	}
+	if s := err.Error(); strings.Contains(s, "abc123") || s != `token.value: strconv.ParseInt: parsing <redacted>: invalid syntax (value redacted) (type: int)` {
+		t.Errorf("unexpected error: %s", s)
+	}
+	getcont := func(string) []byte { return []byte(src) }
+	out := Beautify(err, getcont, &ctx, false)
+	if strings.Contains(out, "abc123") || !strings.Contains(out, " (token ((value <redacted>\n") {
+		t.Errorf("unexpected output: %s", out)
+	}
+
+	// a short secret must not be replaced within the rest of the message
+	short, err := Parse(strings.NewReader("((token ((value a))))"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := short.Children.Unmarshal(&v).Error(); s != `token.value: strconv.ParseInt: parsing <redacted>: invalid syntax (value redacted) (type: int)` {
+		t.Errorf("unexpected error: %s", s)
+	}
+
+	keys := SecretKeys(&v)
+	if strings.Join(keys, ",") != "password,value" {
+		t.Errorf("unexpected secret keys: %v", keys)
+	}
+	var buf bytes.Buffer
+	root.Children.Redact(keys...).WriteTo(&buf)
+	if s := buf.String(); s != "((user bob) (password <redacted>) (token ((value <redacted>))))" {
+		t.Errorf("unexpected redacted tree: %s", s)
+	}
+	if root.Children.Children.Next.Children.Next.Value != "hunter2" {
+		t.Error("Redact must not modify the tree")
+	}
+
+	out_src, err := Format([]byte("(config\n  (password hunter2 ; old\n    (x y))\n  (user bob))\n"), FormatOptions{Redact: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(out_src); s != "(config\n  (password <redacted> ; old\n    <redacted>)\n  (user bob))\n" {
+		t.Errorf("unexpected formatted source: %q", s)
+	}
+}
@@ -0,0 +1,282 @@
+package sexp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Kind of a token returned by Scanner.
+type TokenKind int
+
+const (
+	TokenEOF       TokenKind = iota
+	TokenSpace               // a run of spaces, tabs and newlines, or the BOM
+	TokenComment             // a line comment, without the terminating newline
+	TokenOpen                // '(' or another opening list delimiter
+	TokenClose               // ')' or another closing list delimiter
+	TokenAtom                // an unquoted atom
+	TokenString              // a '"' string, a text block or a heredoc
+	TokenRawString           // a '`' string
+	TokenInvalid             // an unterminated string, raw string or heredoc
+	TokenNumber              // an unquoted atom which is a number, see NodeKind
+)
+
+var token_kind_names = [...]string{
+	TokenEOF:       "EOF",
+	TokenSpace:     "space",
+	TokenComment:   "comment",
+	TokenOpen:      "open",
+	TokenClose:     "close",
+	TokenAtom:      "atom",
+	TokenString:    "string",
+	TokenRawString: "raw string",
+	TokenInvalid:   "invalid",
+	TokenNumber:    "number",
+}
+
+func (k TokenKind) String() string {
+	if k >= 0 && int(k) < len(token_kind_names) {
+		return token_kind_names[k]
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+// A run of input classified by Scanner, Start and End are byte offsets, End
+// is exclusive.
+type Token struct {
+	Kind  TokenKind
+	Start int
+	End   int
+}
+
+// Returns the source text of the token.
+func (t Token) Text(src []byte) []byte {
+	return src[t.Start:t.End]
+}
+
+// Scanner splits S-expressions source code into tokens covering the input
+// entirely, without gaps, which makes it suitable for syntax highlighting.
+// Unlike the parser it never fails: nesting is not checked and malformed
+// strings are returned as TokenInvalid, so it can be used on the text being
+// edited.
+//
+// Parse options affecting the lexical syntax are honored: comment
+// introducers, newlines within strings, heredocs, text blocks, shared
+// structure labels, SPKI atoms, piped symbols, list delimiters and the
+// shebang line. Labels (`#1=` and `#1#`), SPKI atoms and piped symbols are
+// returned as TokenAtom, the shebang line is returned as TokenComment.
+type Scanner struct {
+	src []byte
+	pos int
+	parse_options
+
+	// offset of `src` within the whole input and whether the input starts
+	// with the BOM, for scanning the input in chunks, see Validate
+	base int
+	bom  bool
+}
+
+// Creates a new scanner of `src`.
+func NewScanner(src []byte, opts ...ParseOption) *Scanner {
+	s := &Scanner{src: src}
+	s.semicolon_comments = true
+	for _, opt := range opts {
+		opt(&s.parse_options)
+	}
+	return s
+}
+
+// Returns the offset at which the next token starts.
+func (s *Scanner) Offset() int {
+	return s.pos
+}
+
+// Returns the next token, TokenEOF at the end of input.
+func (s *Scanner) Scan() Token {
+	start := s.pos
+	kind := s.scan()
+	return Token{Kind: kind, Start: start, End: s.pos}
+}
+
+func (s *Scanner) at(i int) byte {
+	if s.pos+i < len(s.src) {
+		return s.src[s.pos+i]
+	}
+	return 0
+}
+
+func (s *Scanner) has_prefix(prefix string) bool {
+	return bytes.HasPrefix(s.src[s.pos:], []byte(prefix))
+}
+
+func (s *Scanner) is_comment() bool {
+	switch s.at(0) {
+	case ';':
+		return s.semicolon_comments
+	case '#':
+		return s.hash_comments && !s.is_heredoc() && !s.is_label() && !s.spki_atoms
+	case '/':
+		return s.slash_comments && s.at(1) == '/'
+	}
+	return false
+}
+
+func (s *Scanner) is_heredoc() bool {
+	return s.heredocs && s.has_prefix("#<")
+}
+
+func (s *Scanner) is_label() bool {
+	c := s.at(1)
+	return s.shared_labels && s.at(0) == '#' && c >= '0' && c <= '9'
+}
+
+func (s *Scanner) scan() TokenKind {
+	if s.pos >= len(s.src) {
+		return TokenEOF
+	}
+	c := s.src[s.pos]
+	switch {
+	case s.base+s.pos == 0 && s.has_prefix("\uFEFF"):
+		// the byte order mark is skipped by the parser as well
+		s.pos += 3
+		s.bom = true
+		return TokenSpace
+	case s.shebang && s.has_prefix("#!") &&
+		(s.base+s.pos == 0 || s.base+s.pos == 3 && s.bom):
+		s.skip_to("\n")
+		return TokenComment
+	case is_space(rune(c)):
+		for s.pos < len(s.src) && is_space(rune(s.src[s.pos])) {
+			s.pos++
+		}
+		return TokenSpace
+	case s.is_comment():
+		s.skip_to("\n")
+		return TokenComment
+	case s.is_heredoc():
+		return s.scan_heredoc()
+	case s.is_label():
+		s.pos++
+		for c := s.at(0); c >= '0' && c <= '9'; c = s.at(0) {
+			s.pos++
+		}
+		if c := s.at(0); c == '=' || c == '#' {
+			s.pos++
+		}
+		return TokenAtom
+	case s.piped_symbols && !s.spki_atoms && c == '|':
+		s.pos++
+		for s.pos < len(s.src) && s.src[s.pos] != '|' {
+			if s.src[s.pos] == '\\' {
+				s.pos++
+			}
+			s.pos++
+		}
+		if s.pos >= len(s.src) {
+			s.pos = len(s.src)
+			return TokenInvalid
+		}
+		s.pos++
+		return TokenAtom
+	case s.spki_atoms && (c == '#' || c == '|'):
+		s.pos++
+		if !s.skip_past(string(c)) {
+			return TokenInvalid
+		}
+		return TokenAtom
+	case c == '(' || s.list_open[rune(c)] != 0:
+		s.pos++
+		return TokenOpen
+	case c == ')' || s.list_close[rune(c)]:
+		s.pos++
+		return TokenClose
+	case c == '"':
+		return s.scan_string()
+	case c == '`':
+		s.pos++
+		if !s.skip_past("`") {
+			return TokenInvalid
+		}
+		return TokenRawString
+	}
+	start := s.pos
+	for s.pos < len(s.src) {
+		c := rune(s.src[s.pos])
+		if is_space(c) || c == ')' || (c == ';' && s.semicolon_comments) || s.list_close[c] {
+			break
+		}
+		s.pos++
+	}
+	if k := atom_kind(string(s.src[start:s.pos])); k == NodeInt || k == NodeFloat {
+		return TokenNumber
+	}
+	return TokenAtom
+}
+
+// Moves to the first occurrence of `sep` or to the end of input.
+func (s *Scanner) skip_to(sep string) {
+	i := bytes.Index(s.src[s.pos:], []byte(sep))
+	if i == -1 {
+		s.pos = len(s.src)
+	} else {
+		s.pos += i
+	}
+}
+
+// Moves past the first occurrence of `sep`, returns false if there is none,
+// the scanner is at the end of input then.
+func (s *Scanner) skip_past(sep string) bool {
+	s.skip_to(sep)
+	if s.pos == len(s.src) {
+		return false
+	}
+	s.pos += len(sep)
+	return true
+}
+
+func (s *Scanner) scan_string() TokenKind {
+	if s.text_blocks && s.has_prefix(`"""`) {
+		s.pos += 3
+		if !s.skip_past(`"""`) {
+			return TokenInvalid
+		}
+		return TokenString
+	}
+	s.pos++ // skip opening '"'
+	for s.pos < len(s.src) {
+		switch s.src[s.pos] {
+		case '"':
+			s.pos++
+			return TokenString
+		case '\\':
+			s.pos++
+		case '\n':
+			if !s.string_newlines {
+				return TokenInvalid
+			}
+		}
+		s.pos++
+	}
+	s.pos = len(s.src)
+	return TokenInvalid
+}
+
+func (s *Scanner) scan_heredoc() TokenKind {
+	start := s.pos
+	s.skip_to("\n")
+	term := bytes.TrimSuffix(s.src[start:s.pos], []byte("\r"))
+	if !bytes.HasPrefix(term, []byte("#<<")) || len(term) == 3 {
+		return TokenInvalid
+	}
+	term = term[3:]
+	for s.pos < len(s.src) {
+		s.pos++ // skip '\n'
+		line_start := s.pos
+		s.skip_to("\n")
+		line := bytes.TrimSuffix(s.src[line_start:s.pos], []byte("\r"))
+		if bytes.Equal(line, term) {
+			return TokenString
+		}
+	}
+	return TokenInvalid
+}
@@ -0,0 +1,61 @@
+package sexp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Returns tokens in the "kind:text" form separated by spaces.
+func scan_all(src string, opts ...ParseOption) string {
+	s := NewScanner([]byte(src), opts...)
+	var out []string
+	end := 0
+	for {
+		tok := s.Scan()
+		if tok.Start != end {
+			panic("a gap between tokens")
+		}
+		end = tok.End
+		if tok.Kind == TokenEOF {
+			break
+		}
+		out = append(out, fmt.Sprintf("%s:%s", tok.Kind, tok.Text([]byte(src))))
+	}
+	return strings.Join(out, " ")
+}
+
+func test_scan(t *testing.T, src, gold string, opts ...ParseOption) {
+	out := scan_all(src, opts...)
+	if out != gold {
+		t.Errorf("%q: %s != %s", src, out, gold)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	test_scan(t, "", "")
+	test_scan(t, "(a b)", "open:( atom:a space:  atom:b close:)")
+	test_scan(t, "a;c\n", "atom:a comment:;c space:\n")
+	test_scan(t, `("x\"y" `+"`r\\`)", `open:( string:"x\"y" space:  raw string:`+"`r\\` close:)")
+	test_scan(t, "a(b)", "atom:a(b close:)")
+	test_scan(t, "\"ab\ncd\"", "invalid:\"ab space:\n atom:cd\"")
+	test_scan(t, "\"ab\ncd\"", "string:\"ab\ncd\"", AllowNewlinesInStrings())
+	test_scan(t, "(`abc", "open:( invalid:`abc")
+	test_scan(t, "# x\n// y", "comment:# x space:\n comment:// y", CommentSyntax("#", "//"))
+	test_scan(t, "a#b //c", "atom:a#b space:  comment://c", CommentSyntax("//"))
+	test_scan(t, "(#<<END\nx\nEND\n)", "open:( string:#<<END\nx\nEND space:\n close:)", Heredocs())
+	test_scan(t, "#<<END\nx", "invalid:#<<END\nx", Heredocs())
+	test_scan(t, "\"\"\"\n  a\n  \"\"\" b", "string:\"\"\"\n  a\n  \"\"\" space:  atom:b", TextBlocks())
+	test_scan(t, "(#0=(a) #0#)", "open:( atom:#0= open:( atom:a close:) space:  atom:#0# close:)", SharedLabels())
+	test_scan(t, "#61 62# |YQ==|", "atom:#61 62# space:  atom:|YQ==|", SPKIAtoms())
+	test_scan(t, "(1 -2.5 0x1f 1x)", "open:( number:1 space:  number:-2.5 space:  number:0x1f space:  atom:1x close:)")
+}
+
+func TestTokenKindString(t *testing.T) {
+	if s := TokenRawString.String(); s != "raw string" {
+		t.Errorf("%s != raw string", s)
+	}
+	if s := TokenKind(42).String(); s != "TokenKind(42)" {
+		t.Errorf("%s != TokenKind(42)", s)
+	}
+}
@@ -0,0 +1,447 @@
+package sexp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Schema describes the expected structure of a document. Schemas are written
+// as S-expressions themselves, a schema consists of named type definitions
+// and a single root type, which is matched against the root node returned by
+// Parse:
+//
+//     (define name-list (list atom))
+//     (root (record
+//       (namespace atom)
+//       (version float)
+//       (blacklist (tail (record
+//         (structs name-list optional)
+//         (functions name-list optional))))))
+//
+// Types:
+//
+//     any                anything
+//     atom               any atom
+//     symbol             a bare atom, numbers included (see NodeKind)
+//     string             a '"' or '`' string
+//     int, uint, float   an atom which is a number of that kind
+//     bool               "true" or "false"
+//     name               a type defined using `(define name type)`
+//     (one-of a b ...)   one of the given atoms
+//     (list T)           a list, each item of which is T
+//     (tuple T1 T2 ...)  a list of exactly that many items of given types
+//     (or T1 T2 ...)     any of the given types
+//     (record FIELD ...) a list of key/value pairs, FIELD is `(key T flags...)`
+//
+// A field matches the value of a key/value pair `(key value)`. If the type of
+// a field is `(tail T)`, all the items following the key are matched against
+// T as a list instead, e.g. `(position (tail (tuple int int)))` matches
+// `(position 5 10)`. Field flags: `optional` allows the key to be missing,
+// `repeated` allows the key to appear multiple times. Keys not mentioned in
+// a record are errors.
+type Schema struct {
+	root schema_type
+	defs map[string]schema_type
+}
+
+type schema_type interface {
+	check(n *Node, v *schema_validator)
+}
+
+type schema_validator struct {
+	schema *Schema
+	errors []error
+}
+
+func (v *schema_validator) error(n *Node, format string, args ...interface{}) {
+	v.errors = append(v.errors, NewUnmarshalError(n, nil, format, args...))
+}
+
+// Compiles a schema from its parsed source (the root node returned by Parse).
+// Errors in the schema itself are returned as *ParseError.
+func ParseSchema(root *Node) (s *Schema, err error) {
+	defer catch_parse_error(&err)
+
+	s = &Schema{defs: make(map[string]schema_type)}
+	var rootdef *Node
+	for c := root.Children; c != nil; c = c.Next {
+		switch {
+		case is_directive(c, "define"):
+			name := c.Children.Next
+			if name == nil || name.IsList() || name.Next == nil || name.Next.Next != nil {
+				node_error(c, "define expects a name and a type")
+			}
+			if _, ok := s.defs[name.Value]; ok {
+				node_error(name, "type %q is already defined", name.Value)
+			}
+			if schema_builtins[name.Value] != nil {
+				node_error(name, "cannot redefine built-in type %q", name.Value)
+			}
+			s.defs[name.Value] = nil
+		case is_directive(c, "root"):
+			if rootdef != nil {
+				node_error(c, "schema has multiple root definitions")
+			}
+			if c.Children.Next == nil || c.Children.Next.Next != nil {
+				node_error(c, "root expects a single type")
+			}
+			rootdef = c
+		default:
+			node_error(c, "define or root form expected")
+		}
+	}
+	if rootdef == nil {
+		node_error(root, "schema has no root definition")
+	}
+
+	// all names are known, compile the definitions now
+	for c := root.Children; c != nil; c = c.Next {
+		if is_directive(c, "define") {
+			name := c.Children.Next
+			s.defs[name.Value] = s.compile(name.Next)
+		}
+	}
+	for c := root.Children; c != nil; c = c.Next {
+		if is_directive(c, "define") {
+			s.check_cycle(c.Children.Next)
+		}
+	}
+	s.root = s.compile(rootdef.Children.Next)
+	return s, nil
+}
+
+// Validates a document (the root node returned by Parse) against the schema.
+// Returns all the violations found in the document order, each violation is
+// an *UnmarshalError pointing at the offending node.
+func (s *Schema) Validate(root *Node) []error {
+	v := schema_validator{schema: s}
+	s.root.check(root, &v)
+	return v.errors
+}
+
+// Like Validate, but returns the violations as error diagnostics.
+func (s *Schema) Diagnostics(root *Node) []*Diagnostic {
+	errs := s.Validate(root)
+	out := make([]*Diagnostic, len(errs))
+	for i, err := range errs {
+		out[i] = err.(*UnmarshalError).Diagnostic()
+	}
+	return out
+}
+
+//----------------------------------------------------------------------------
+// types
+//----------------------------------------------------------------------------
+
+type schema_any struct{}
+
+func (schema_any) check(n *Node, v *schema_validator) {}
+
+type schema_atom struct {
+	name  string
+	kinds []NodeKind // any kind if empty
+	valid func(s string) bool
+}
+
+func (t *schema_atom) check(n *Node, v *schema_validator) {
+	if !n.IsScalar() || !t.kind_allowed(n.Kind) {
+		v.error(n, "%s expected", t.name)
+		return
+	}
+	if t.valid != nil && !t.valid(n.Value) {
+		v.error(n, "%s expected", t.name)
+	}
+}
+
+func (t *schema_atom) kind_allowed(k NodeKind) bool {
+	if len(t.kinds) == 0 {
+		return true
+	}
+	for _, allowed := range t.kinds {
+		if k == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+var schema_builtins = map[string]schema_type{
+	"any":    schema_any{},
+	"atom":   &schema_atom{name: "atom"},
+	"symbol": &schema_atom{name: "symbol", kinds: []NodeKind{NodeSymbol, NodeInt, NodeFloat}},
+	"string": &schema_atom{name: "string", kinds: []NodeKind{NodeString, NodeRawString}},
+	"int": &schema_atom{name: "int", valid: func(s string) bool {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	}},
+	"uint": &schema_atom{name: "uint", valid: func(s string) bool {
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err == nil
+	}},
+	"float": &schema_atom{name: "float", valid: func(s string) bool {
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	}},
+	"bool": &schema_atom{name: "bool", valid: func(s string) bool {
+		return s == "true" || s == "false"
+	}},
+}
+
+type schema_ref struct {
+	name string
+}
+
+func (t *schema_ref) check(n *Node, v *schema_validator) {
+	v.schema.defs[t.name].check(n, v)
+}
+
+type schema_one_of struct {
+	values []string
+}
+
+func (t *schema_one_of) check(n *Node, v *schema_validator) {
+	if n.IsScalar() {
+		for _, s := range t.values {
+			if s == n.Value {
+				return
+			}
+		}
+	}
+	v.error(n, "one of %s expected", strings.Join(t.values, ", "))
+}
+
+type schema_list struct {
+	elem schema_type
+}
+
+func (t *schema_list) check(n *Node, v *schema_validator) {
+	// empty lists are scalars, see NodeKind
+	if n.IsScalar() && n.Value == "" {
+		return
+	}
+	if !n.IsList() {
+		v.error(n, "list expected")
+		return
+	}
+	for c := n.Children; c != nil; c = c.Next {
+		t.elem.check(c, v)
+	}
+}
+
+type schema_tuple struct {
+	elems []schema_type
+}
+
+func (t *schema_tuple) check(n *Node, v *schema_validator) {
+	if !n.IsList() || n.NumChildren() != len(t.elems) {
+		v.error(n, "list of %d items expected", len(t.elems))
+		return
+	}
+	i := 0
+	for c := n.Children; c != nil; c = c.Next {
+		t.elems[i].check(c, v)
+		i++
+	}
+}
+
+type schema_or struct {
+	alts []schema_type
+}
+
+func (t *schema_or) check(n *Node, v *schema_validator) {
+	var first []error
+	for i, alt := range t.alts {
+		sub := schema_validator{schema: v.schema}
+		alt.check(n, &sub)
+		if len(sub.errors) == 0 {
+			return
+		}
+		if i == 0 {
+			first = sub.errors
+		}
+	}
+	// report the errors of the first alternative, it's the most likely
+	// one
+	v.errors = append(v.errors, first...)
+}
+
+type schema_tail struct {
+	list schema_type
+}
+
+func (t *schema_tail) check(n *Node, v *schema_validator) {
+	v.error(n, "tail type is only allowed as a record field type")
+}
+
+type schema_field struct {
+	key      string
+	typ      schema_type
+	optional bool
+	repeated bool
+}
+
+type schema_record struct {
+	fields []*schema_field
+}
+
+func (t *schema_record) check(n *Node, v *schema_validator) {
+	if n.IsScalar() && n.Value == "" {
+		n = &Node{Location: n.Location}
+	} else if !n.IsList() {
+		v.error(n, "list of key/value pairs expected")
+		return
+	}
+
+	seen := make(map[string]bool)
+	for c := n.Children; c != nil; c = c.Next {
+		if !c.IsList() || !c.Children.IsScalar() {
+			v.error(c, "key/value pair expected")
+			continue
+		}
+		key := c.Children.Value
+		var f *schema_field
+		for _, sf := range t.fields {
+			if sf.key == key {
+				f = sf
+				break
+			}
+		}
+		if f == nil {
+			v.error(c.Children, "unknown key %q", key)
+			continue
+		}
+		if seen[key] && !f.repeated {
+			v.error(c.Children, "duplicate key %q", key)
+		}
+		seen[key] = true
+
+		if tail, ok := f.typ.(*schema_tail); ok {
+			list := &Node{Location: c.Location, Children: c.Children.Next}
+			if list.Children != nil {
+				list.Location = list.Children.Location
+			}
+			tail.list.check(list, v)
+			continue
+		}
+		val := c.Children.Next
+		if val == nil || val.Next != nil {
+			v.error(c, "key %q expects a single value", key)
+			continue
+		}
+		f.typ.check(val, v)
+	}
+
+	for _, f := range t.fields {
+		if !f.optional && !seen[f.key] {
+			v.error(n, "missing required key %q", f.key)
+		}
+	}
+}
+
+//----------------------------------------------------------------------------
+// compiler
+//----------------------------------------------------------------------------
+
+func (s *Schema) compile(n *Node) schema_type {
+	if n.IsScalar() {
+		if t, ok := schema_builtins[n.Value]; ok {
+			return t
+		}
+		if _, ok := s.defs[n.Value]; ok {
+			return &schema_ref{name: n.Value}
+		}
+		node_error(n, "unknown type %q", n.Value)
+	}
+
+	head := n.Children
+	if head.IsList() {
+		node_error(head, "type constructor name expected")
+	}
+	args := head.Next
+	switch head.Value {
+	case "one-of":
+		t := &schema_one_of{}
+		for a := args; a != nil; a = a.Next {
+			if a.IsList() {
+				node_error(a, "one-of expects atoms")
+			}
+			t.values = append(t.values, a.Value)
+		}
+		return t
+	case "list", "tail":
+		if args == nil || args.Next != nil {
+			node_error(n, "%s expects a single type", head.Value)
+		}
+		if head.Value == "tail" {
+			return &schema_tail{list: s.compile(args)}
+		}
+		return &schema_list{elem: s.compile(args)}
+	case "tuple", "or":
+		var types []schema_type
+		for a := args; a != nil; a = a.Next {
+			types = append(types, s.compile(a))
+		}
+		if head.Value == "or" {
+			if len(types) == 0 {
+				node_error(n, "or expects at least one type")
+			}
+			return &schema_or{alts: types}
+		}
+		return &schema_tuple{elems: types}
+	case "record":
+		t := &schema_record{}
+		for a := args; a != nil; a = a.Next {
+			t.fields = append(t.fields, s.compile_field(a))
+		}
+		return t
+	}
+	node_error(head, "unknown type constructor %q", head.Value)
+	return nil
+}
+
+// Makes sure the type doesn't refer to itself other than through the items
+// of a list, e.g. `(define a b)` and `(define b (or a int))`, such types would
+// make the validator recurse forever. Other cycles are reported for the types
+// they contain.
+func (s *Schema) check_cycle(name *Node) {
+	seen := make(map[string]bool)
+	var walk func(t schema_type)
+	walk = func(t schema_type) {
+		switch t := t.(type) {
+		case *schema_ref:
+			if t.name == name.Value {
+				node_error(name, "type %q is defined in terms of itself", name.Value)
+			}
+			if !seen[t.name] {
+				seen[t.name] = true
+				walk(s.defs[t.name])
+			}
+		case *schema_or:
+			for _, alt := range t.alts {
+				walk(alt)
+			}
+		}
+	}
+	walk(s.defs[name.Value])
+}
+
+func (s *Schema) compile_field(n *Node) *schema_field {
+	if !n.IsList() || n.Children.IsList() || n.Children.Next == nil {
+		node_error(n, "record field expects a key and a type")
+	}
+	f := &schema_field{
+		key: n.Children.Value,
+		typ: s.compile(n.Children.Next),
+	}
+	for flag := n.Children.Next.Next; flag != nil; flag = flag.Next {
+		switch flag.Value {
+		case "optional":
+			f.optional = true
+		case "repeated":
+			f.repeated = true
+		default:
+			node_error(flag, "unknown field flag %q", flag.Value)
+		}
+	}
+	return f
+}
@@ -0,0 +1,133 @@
+package sexp
+
+import (
+	"strings"
+	"testing"
+)
+
+const gtk_schema = `
+(define name-list (list atom))
+(root (record
+  (namespace atom)
+  (version float)
+  (blacklist (tail (record
+    (structs name-list optional)
+    (structdefs name-list optional)
+    (functions name-list optional))))))
+`
+
+func must_parse_schema(t *testing.T, source string) *Schema {
+	root, err := Parse(strings.NewReader(source), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ParseSchema(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func validate(t *testing.T, s *Schema, source string) []error {
+	root, err := Parse(strings.NewReader(source), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Validate(root)
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s := must_parse_schema(t, gtk_schema)
+	if errs := validate(t, s, config); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	errs := validate(t, s, `
+		(namespace (Gtk))
+		(version three)
+		(blacklist (structs x) (unknown (a)))
+		(blacklist (structs (x)))
+	`)
+	gold := []string{
+		`atom expected \(list value\)`,
+		`float expected \(value: "three"\)`,
+		`list expected \(value: "x"\)`,
+		`unknown key "unknown"`,
+		`duplicate key "blacklist"`,
+	}
+	if len(errs) != len(gold) {
+		t.Fatalf("%d errors expected, got: %v", len(gold), errs)
+	}
+	for i, err := range errs {
+		error_must_contain(t, err, gold[i])
+	}
+
+	errs = validate(t, s, `(version 1)`)
+	if len(errs) != 2 {
+		t.Fatalf("2 errors expected, got: %v", errs)
+	}
+	error_must_contain(t, errs[0], `missing required key "namespace"`)
+	error_must_contain(t, errs[1], `missing required key "blacklist"`)
+
+	s = must_parse_schema(t, `
+		(define level (one-of debug info))
+		(root (record
+		  (level level)
+		  (pos (tail (tuple int int)))
+		  (flag bool optional)
+		  (id (or uint (one-of auto)) repeated)))
+	`)
+	if errs := validate(t, s, "(level info) (pos 1 2) (id 5) (id auto)"); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	errs = validate(t, s, "(level warn) (pos 1) (flag yes) (id -1)")
+	gold = []string{
+		`one of debug, info expected`,
+		`list of 2 items expected`,
+		`bool expected`,
+		`uint expected`,
+	}
+	if len(errs) != len(gold) {
+		t.Fatalf("%d errors expected, got: %v", len(gold), errs)
+	}
+	for i, err := range errs {
+		error_must_contain(t, err, gold[i])
+	}
+}
+
+func TestSchemaKinds(t *testing.T) {
+	s := must_parse_schema(t, `(root (record (name symbol) (title string optional)))`)
+	if errs := validate(t, s, "(name foo) (title `Foo`)"); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	errs := validate(t, s, `(name "foo") (title Foo)`)
+	if len(errs) != 2 {
+		t.Fatalf("2 errors expected, got: %v", errs)
+	}
+	error_must_contain(t, errs[0], `symbol expected \(value: "foo"\)`)
+	error_must_contain(t, errs[1], `string expected \(value: "Foo"\)`)
+}
+
+func TestSchemaErrors(t *testing.T) {
+	test := func(source string) error {
+		root, err := Parse(strings.NewReader(source), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParseSchema(root)
+		return err
+	}
+	error_must_contain(t, test(`(define x int)`), "schema has no root")
+	error_must_contain(t, test(`(root int) (root int)`), "multiple root definitions")
+	error_must_contain(t, test(`(root foo)`), `unknown type "foo"`)
+	error_must_contain(t, test(`(root (foo int))`), `unknown type constructor "foo"`)
+	error_must_contain(t, test(`(define a b) (define b a) (root a)`), `defined in terms of itself`)
+	error_must_contain(t, test(`(define a (or int b)) (define b (or a string)) (root a)`),
+		`type "a" is defined in terms of itself`)
+	if err := test(`(define a (or int (list a))) (root a)`); err != nil {
+		t.Error(err)
+	}
+	error_must_contain(t, test(`(define int float) (root int)`), `cannot redefine built-in`)
+	error_must_contain(t, test(`(root (record (x int sometimes)))`), `unknown field flag "sometimes"`)
+	error_must_contain(t, test(`(something)`), `define or root form expected`)
+}
@@ -0,0 +1,93 @@
+package sexptest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The corpora below are representative inputs for benchmarks, they are
+// deterministic, so that the results of different runs are comparable. The
+// size is the number of items, not bytes.
+
+// Returns one list of `n` bare atoms: `(a0 a1 a2 ...)`.
+func WideList(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "a%d", i)
+	}
+	buf.WriteByte(')')
+	return buf.Bytes()
+}
+
+// Returns `depth` nested lists with an atom inside: `((((x))))`.
+func DeepList(depth int) []byte {
+	return []byte(strings.Repeat("(", depth) + "x" + strings.Repeat(")", depth))
+}
+
+// Returns one list of `n` quoted strings of various lengths, some of them
+// contain escape sequences.
+func StringList(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			buf.WriteByte('\n')
+		}
+		s := strings.Repeat("lorem ipsum ", i%8+1)
+		if i%4 == 0 {
+			s += "\"dolor\"\tsit\n"
+		}
+		fmt.Fprintf(&buf, "%q", s)
+	}
+	buf.WriteByte(')')
+	return buf.Bytes()
+}
+
+// Returns one list of `n` numbers, integers and floats alternate.
+func NumberList(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			buf.WriteByte(' ')
+		}
+		if i%2 == 0 {
+			fmt.Fprintf(&buf, "%d", i*7919-50000)
+		} else {
+			fmt.Fprintf(&buf, "%g", float64(i)*1.25e-3)
+		}
+	}
+	buf.WriteByte(')')
+	return buf.Bytes()
+}
+
+// A record of the Records corpus.
+type Record struct {
+	ID    int      `sexp:"id"`
+	Name  string   `sexp:"name"`
+	Tags  []string `sexp:"tags,siblings"`
+	Score float64  `sexp:"score"`
+}
+
+// Returns one list of `n` records, each one decodes into Record:
+//
+//     ((id 0) (name "record 0") (tags red green) (score 0.5))
+func Records(n int) []byte {
+	tags := []string{"red", "green", "blue", "alpha"}
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "((id %d) (name \"record %d\") (tags %s) (score %g))",
+			i, i, strings.Join(tags[:i%len(tags)+1], " "), float64(i)+0.5)
+	}
+	buf.WriteByte(')')
+	return buf.Bytes()
+}
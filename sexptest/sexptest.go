@@ -0,0 +1,123 @@
+// Package sexptest provides helpers for testing code built on top of the sexp
+// package: parsing tree literals, comparing trees and golden files.
+//
+// Golden files are rewritten instead of compared if the tests are run with
+// the -sexptest.update flag:
+//
+//     go test -sexptest.update
+package sexptest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/nsf/sexp"
+)
+
+var update = flag.Bool("sexptest.update", false, "rewrite golden files instead of comparing them")
+
+// Parses `src` using sexp.Parse, failing the test on syntax errors. Returns
+// the virtual list node holding the top-level expressions.
+func MustParse(t testing.TB, src string, opts ...sexp.ParseOption) *sexp.Node {
+	t.Helper()
+	root, err := sexp.Parse(strings.NewReader(src), nil, opts...)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", src, err)
+		return nil
+	}
+	return root
+}
+
+// Reports a test error if the trees differ, locations are not compared. The
+// error message points at the first difference and contains both trees
+// written with (*sexp.Node).WriteIndent.
+func AssertTreeEqual(t testing.TB, want, got *sexp.Node) {
+	t.Helper()
+	path, diff := tree_diff(want, got, "")
+	if diff == "" {
+		return
+	}
+	if path == "" {
+		path = "root"
+	}
+	t.Errorf("trees differ at %s: %s\nwant:\n%s\ngot:\n%s", path, diff,
+		indent_tree(want), indent_tree(got))
+}
+
+// Compares the nodes and their children, siblings are not compared. Returns
+// the path to the first difference, e.g. "[1][0]", and its description, or
+// an empty string if the trees are equal.
+func tree_diff(want, got *sexp.Node, path string) (string, string) {
+	switch {
+	case want == nil && got == nil:
+		return "", ""
+	case want == nil || got == nil:
+		return path, fmt.Sprintf("%s != %s", describe(want), describe(got))
+	case want.IsList() != got.IsList() || want.Value != got.Value:
+		return path, fmt.Sprintf("%s != %s", describe(want), describe(got))
+	}
+	w, g := want.Children, got.Children
+	for i := 0; w != nil || g != nil; i++ {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		if w == nil || g == nil {
+			return p, fmt.Sprintf("%s != %s", describe(w), describe(g))
+		}
+		if p, diff := tree_diff(w, g, p); diff != "" {
+			return p, diff
+		}
+		w, g = w.Next, g.Next
+	}
+	return "", ""
+}
+
+func describe(n *sexp.Node) string {
+	switch {
+	case n == nil:
+		return "nothing"
+	case n.IsList():
+		return fmt.Sprintf("a list of %d items", n.NumChildren())
+	}
+	return fmt.Sprintf("atom %s", sexp.QuoteAtom(n.Value))
+}
+
+func indent_tree(n *sexp.Node) string {
+	if n == nil {
+		return "<nil>"
+	}
+	var buf bytes.Buffer
+	if _, err := n.WriteIndent(&buf, "  "); err != nil {
+		return err.Error()
+	}
+	return buf.String()
+}
+
+// Formats `got` using sexp.Format and compares it with the contents of the
+// golden file, reporting a test error if they differ. With the
+// -sexptest.update flag the golden file is written instead.
+func AssertGolden(t testing.TB, filename string, got []byte) {
+	t.Helper()
+	formatted, err := sexp.Format(got, sexp.FormatOptions{})
+	if err != nil {
+		t.Fatalf("cannot format the output: %s", err)
+		return
+	}
+	if *update {
+		if err := ioutil.WriteFile(filename, formatted, 0666); err != nil {
+			t.Fatalf("cannot update the golden file: %s", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("cannot read the golden file: %s", err)
+		return
+	}
+	if !bytes.Equal(want, formatted) {
+		t.Errorf("output doesn't match %s, run with -sexptest.update to rewrite it\nwant:\n%s\ngot:\n%s",
+			filename, want, formatted)
+	}
+}
@@ -0,0 +1,64 @@
+package sexptest
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Records errors instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+}
+
+func TestMustParse(t *testing.T) {
+	root := MustParse(t, "(a b) c")
+	if root.NumChildren() != 2 {
+		t.Errorf("2 children expected, got %d", root.NumChildren())
+	}
+
+	r := &recorder{TB: t}
+	if MustParse(r, "(a") != nil || len(r.errors) != 1 {
+		t.Errorf("a syntax error expected, got %q", r.errors)
+	}
+}
+
+func TestAssertTreeEqual(t *testing.T) {
+	AssertTreeEqual(t, MustParse(t, "(a (b c))"), MustParse(t, "(a\n  (b c))"))
+
+	for _, c := range []struct{ want, got, msg string }{
+		{"(a (b c))", "(a (b d))", `trees differ at [0][1][1]: atom c != atom d`},
+		{"(a (b c))", "(a (b))", `trees differ at [0][1][1]: atom c != nothing`},
+		{"(a b)", "(a b) c", `trees differ at [1]: nothing != atom c`},
+		{"(a b)", "a", `trees differ at [0]: a list of 2 items != atom a`},
+	} {
+		r := &recorder{TB: t}
+		AssertTreeEqual(r, MustParse(t, c.want), MustParse(t, c.got))
+		if len(r.errors) != 1 || !strings.HasPrefix(r.errors[0], c.msg) {
+			t.Errorf("%q expected, got %q", c.msg, r.errors)
+		}
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	filename := filepath.Join("testdata", "golden.sexp")
+	AssertGolden(t, filename, []byte("(a\n(b c))"))
+
+	r := &recorder{TB: t}
+	AssertGolden(r, filename, []byte("(a (b c))"))
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "doesn't match") {
+		t.Errorf("a mismatch expected, got %q", r.errors)
+	}
+}
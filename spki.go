@@ -0,0 +1,179 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Parses a single S-expression in the canonical form from Rivest's
+// S-expressions draft (see Canonicalize), or in the transport form, which is
+// the canonical form encoded with base64 and enclosed in braces:
+//
+//     (3:abc(1:a))
+//     {KDM6YWJjKDE6YSkp}
+//
+// Whitespace around the expression is ignored. Display hints ("[hint]atom")
+// are not supported. Unlike Parse, returns the expression node itself, not a
+// virtual list node. Source locations are byte offsets within the canonical
+// form. In case of a syntax error, the error is a *ParseError.
+func ParseCanonical(data []byte) (n *Node, err error) {
+	defer catch_parse_error(&err)
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		if data[len(data)-1] != '}' {
+			return nil, &ParseError{message: "missing matching sequence delimiter '}'"}
+		}
+		text := bytes.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, data[1:len(data)-1])
+		data = make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+		num, err := base64.StdEncoding.Decode(data, text)
+		if err != nil {
+			return nil, &ParseError{message: "invalid transport encoding: " + err.Error()}
+		}
+		data = data[:num]
+	}
+
+	var ctx SourceContext
+	p := canonical_parser{data: data, f: ctx.AddFile("", len(data))}
+	n = p.parse_node()
+	if p.pos != len(data) {
+		p.error("unexpected data after the expression")
+	}
+	return n, nil
+}
+
+type canonical_parser struct {
+	data []byte
+	pos  int
+	f    *SourceFile
+}
+
+func (p *canonical_parser) error(format string, args ...interface{}) {
+	node_error(&Node{Location: p.f.Encode(p.pos)}, format, args...)
+}
+
+func (p *canonical_parser) parse_node() *Node {
+	if p.pos >= len(p.data) {
+		p.error("unexpected end of input")
+	}
+	loc := p.f.Encode(p.pos)
+	switch c := p.data[p.pos]; {
+	case c == '(':
+		p.pos++
+		var chain node_chain
+		for {
+			if p.pos >= len(p.data) {
+				panic(&ParseError{
+					Location: loc,
+					Related:  []SourceLoc{p.f.Encode(p.pos)},
+					message:  "missing matching sequence delimiter ')'",
+				})
+			}
+			if p.data[p.pos] == ')' {
+				p.pos++
+				return &Node{Location: loc, Children: chain.finish()}
+			}
+			chain.push(p.parse_node())
+		}
+	case c == '[':
+		p.error("display hints are not supported")
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+		}
+		digits := string(p.data[start:p.pos])
+		if len(digits) > 1 && digits[0] == '0' {
+			p.pos = start
+			p.error("invalid atom length %q", digits)
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+			p.error("':' expected after atom length")
+		}
+		p.pos++
+		length, err := strconv.Atoi(digits)
+		if err != nil || length > len(p.data)-p.pos {
+			p.pos = start
+			p.error("atom length %s exceeds the input", digits)
+		}
+		value := string(p.data[p.pos : p.pos+length])
+		p.pos += length
+		return &Node{Location: loc, Value: value}
+	}
+	p.error("unexpected character %q", p.data[p.pos])
+	return nil
+}
+
+// Writes the node (without its siblings) in the transport form, which is
+// the canonical form (see Canonicalize) encoded with base64 and enclosed in
+// braces. The order of key/value pairs is preserved, so that signatures
+// computed over the canonical form remain valid.
+func WriteTransport(w io.Writer, n *Node) error {
+	data := Canonicalize(n, CanonicalPreserveOrder())
+	_, err := io.WriteString(w, "{"+base64.StdEncoding.EncodeToString(data)+"}")
+	return err
+}
+
+// Writes the node (without its siblings) like WriteTo does, but atoms which
+// are not printable UTF-8 text are written as "#hex#" atoms of the advanced
+// transport form from Rivest's S-expressions draft. The output can be read
+// back using the SPKIAtoms parser option.
+func WriteAdvanced(w io.Writer, n *Node) error {
+	if err := ValidateTree(n); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	write_advanced(&buf, n)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func write_advanced(buf *bytes.Buffer, n *Node) {
+	if n.IsScalar() {
+		switch {
+		case n.Kind == NodeList:
+			buf.WriteString("()")
+		case n.Kind.is_bare() && !atom_needs_quoting(n.Value):
+			buf.WriteString(n.Value)
+		case is_printable(n.Value):
+			buf.WriteString(strconv.Quote(n.Value))
+		default:
+			buf.WriteByte('#')
+			buf.WriteString(hex.EncodeToString([]byte(n.Value)))
+			buf.WriteByte('#')
+		}
+		return
+	}
+	buf.WriteByte('(')
+	for c := n.Children; c != nil; c = c.Next {
+		if c != n.Children {
+			buf.WriteByte(' ')
+		}
+		write_advanced(buf, c)
+	}
+	buf.WriteByte(')')
+}
+
+// Returns true if the string is valid UTF-8 consisting of printable
+// characters and common whitespace.
+func is_printable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !strconv.IsPrint(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseCanonical(t *testing.T) {
+	test := func(data, gold string) {
+		n, err := ParseCanonical([]byte(data))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var buf bytes.Buffer
+		format_tree(&buf, n)
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+	test("(3:abc(1:a))", `("abc" ("a"))`)
+	test("{KDM6YWJjKDE6YSkp}", `("abc" ("a"))`)
+	test(" {KDM6YWJj\n KDE6YSkp} \n", `("abc" ("a"))`)
+	test("0:", `""`)
+	test("(2:()0:)", `("()" "")`)
+
+	for data, err := range map[string]string{
+		"(3:abc":        `missing.+\)`,
+		"(5:ab)":        `exceeds the input`,
+		"(03:abc)":      `invalid atom length`,
+		"(3abc)":        `':' expected`,
+		"([4:mime]1:a)": `display hints`,
+		"(1:a))":        `unexpected data`,
+		"{KDM6YWJj":     `missing.+}`,
+		"{!!}":          `invalid transport encoding`,
+		"abc":           `unexpected character 'a'`,
+		"":              `unexpected end of input`,
+	} {
+		_, e := ParseCanonical([]byte(data))
+		error_must_contain(t, e, err)
+	}
+}
+
+func TestSPKIRoundTrip(t *testing.T) {
+	src := `(public-key (rsa (e #010001#) (n |AMyx5C0=|) (comment "my key")))`
+	root, err := Parse(strings.NewReader(src), nil, SPKIAtoms())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := root.Children
+	e := key.Children.Next.Children.Next.Children.Next
+	if e.Value != "\x01\x00\x01" {
+		t.Errorf("unexpected hex atom value: %q", e.Value)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAdvanced(&buf, key); err != nil {
+		t.Fatal(err)
+	}
+	gold := `(public-key (rsa (e #010001#) (n #00ccb1e42d#) (comment "my key")))`
+	if buf.String() != gold {
+		t.Errorf("%s != %s", buf.String(), gold)
+	}
+
+	buf.Reset()
+	if err := WriteTransport(&buf, key); err != nil {
+		t.Fatal(err)
+	}
+	n, err := ParseCanonical(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(Canonicalize(n), Canonicalize(key)) {
+		t.Errorf("transport form round trip mismatch")
+	}
+
+	_, err = Parse(strings.NewReader("#0g#"), nil, SPKIAtoms())
+	error_must_contain(t, err, `invalid hexadecimal atom`)
+	_, err = Parse(strings.NewReader("(|abc"), nil, SPKIAtoms())
+	error_must_contain(t, err, `missing.+\|`)
+}
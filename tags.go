@@ -32,3 +32,52 @@ func (this tag_options) contains(option_name string) bool {
 	}
 	return false
 }
+
+// Returns the value of a "name=value" option.
+func (this tag_options) value(option_name string) (string, bool) {
+	s := string(this)
+	for s != "" {
+		var next string
+		i := strings.Index(s, ",")
+		if i != -1 {
+			s, next = s[:i], s[i+1:]
+		}
+		if strings.HasPrefix(s, option_name+"=") {
+			return s[len(option_name)+1:], true
+		}
+		s = next
+	}
+	return "", false
+}
+
+// Options understood by Marshal and (*Node).Unmarshal.
+var known_tag_options = map[string]bool{
+	"siblings": true, "inline": true, "repeated": true, "pos": true,
+	"location": true, "node": true, "enum": true, "symbol": true,
+	"string": true, "flags": true, "using": true, "deprecated": true,
+	"min": true, "max": true, "pattern": true, "minlen": true,
+	"maxlen": true, "secret": true, "omitempty": true, "omitzero": true,
+	"order": true,
+}
+
+// Returns the first option which is not one of `known_tag_options`, e.g. a
+// part of a regular expression containing a comma, or "" if there is none.
+func (this tag_options) unknown() string {
+	s := string(this)
+	for s != "" {
+		var next string
+		i := strings.Index(s, ",")
+		if i != -1 {
+			s, next = s[:i], s[i+1:]
+		}
+		name := s
+		if j := strings.Index(s, "="); j != -1 {
+			name = s[:j]
+		}
+		if !known_tag_options[name] {
+			return s
+		}
+		s = next
+	}
+	return ""
+}
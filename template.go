@@ -0,0 +1,150 @@
+package sexp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Template builds trees from an S-expression with placeholders, which are
+// replaced by nodes made of Go values, see CompileTemplate. Since the values
+// become nodes directly and are never parsed, they don't need any quoting,
+// like arguments of a prepared SQL statement.
+type Template struct {
+	root  *Node
+	verbs []byte      // of the placeholders in the document order
+	locs  []SourceLoc // of the placeholders
+}
+
+// Compiles a template, `src` must contain a single expression. Atoms equal to
+// one of the following verbs are placeholders:
+//
+//     %s  a string
+//     %d  an integer
+//     %f  a floating point number or an integer
+//     %t  a bool
+//     %v  any value, converted using the Marshal rules, may be a list, a
+//         *Node is inserted as a copy
+//     %%  the literal "%" atom
+//
+// Placeholders must be whole atoms, e.g. `(name %s)`, while `(name x-%s)` has
+// no placeholders. Note that quoted strings are atoms as well, `"%s"` is a
+// placeholder too. Syntax errors are returned as *ParseError.
+//
+//     t, err := sexp.CompileTemplate("(user (name %s) (uid %d))")
+//     ...
+//     n, err := t.Expand("O'Brien (admin)", 1000)
+func CompileTemplate(src string) (*Template, error) {
+	root, err := Parse(strings.NewReader(src), nil, ExactlyOne())
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{root: root.Children}
+	t.collect_verbs(t.root)
+	return t, nil
+}
+
+// Like CompileTemplate, but panics on errors, it simplifies initialization of
+// global variables holding templates.
+func MustCompileTemplate(src string) *Template {
+	t, err := CompileTemplate(src)
+	if err != nil {
+		panic(`sexp: CompileTemplate(` + strconv.Quote(src) + `): ` + err.Error())
+	}
+	return t
+}
+
+func template_verb(n *Node) byte {
+	if n.IsScalar() && len(n.Value) == 2 && n.Value[0] == '%' &&
+		strings.IndexByte("sdftv", n.Value[1]) != -1 {
+		return n.Value[1]
+	}
+	return 0
+}
+
+func (t *Template) collect_verbs(n *Node) {
+	if verb := template_verb(n); verb != 0 {
+		t.verbs = append(t.verbs, verb)
+		t.locs = append(t.locs, n.Location)
+	}
+	for c := n.Children; c != nil; c = c.Next {
+		t.collect_verbs(c)
+	}
+}
+
+// Returns a new tree with the placeholders replaced by the arguments. The
+// number of arguments must match the number of placeholders and their types
+// must match the verbs.
+func (t *Template) Expand(args ...interface{}) (*Node, error) {
+	if len(args) != len(t.verbs) {
+		return nil, fmt.Errorf("template expects %d arguments, got %d",
+			len(t.verbs), len(args))
+	}
+	nodes := make([]*Node, len(args))
+	for i, arg := range args {
+		n, err := template_arg(t.verbs[i], arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		if _, ok := arg.(*Node); !ok {
+			// nodes made of Go values take the location of the
+			// placeholder, copied nodes keep theirs
+			set_location(n, t.locs[i])
+		}
+		nodes[i] = n
+	}
+	return t.expand(t.root, &nodes), nil
+}
+
+// Converts an argument to a node according to the verb.
+func template_arg(verb byte, arg interface{}) (*Node, error) {
+	if verb == 'v' {
+		return marshal_node(arg)
+	}
+	v := reflect.ValueOf(arg)
+	kind := reflect.Invalid
+	if v.IsValid() {
+		kind = v.Kind()
+	}
+	switch {
+	case verb == 's' && kind == reflect.String:
+		return &Node{Value: v.String()}, nil
+	case verb == 't' && kind == reflect.Bool:
+		return &Node{Value: strconv.FormatBool(v.Bool())}, nil
+	case (verb == 'd' || verb == 'f') && is_integer_kind(kind),
+		verb == 'f' && (kind == reflect.Float32 || kind == reflect.Float64):
+		return marshal_node(arg)
+	}
+	return nil, fmt.Errorf("%%%c verb cannot be used with %T", verb, arg)
+}
+
+func is_integer_kind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// Returns a copy of the node with placeholders replaced by `nodes`, which are
+// consumed in order.
+func (t *Template) expand(n *Node, nodes *[]*Node) *Node {
+	if template_verb(n) != 0 {
+		arg := (*nodes)[0]
+		*nodes = (*nodes)[1:]
+		arg.Location = n.Location
+		return arg
+	}
+	c := &Node{Location: n.Location, Value: n.Value, Kind: n.Kind}
+	if n.IsScalar() && n.Value == "%%" {
+		c.Value = "%"
+	}
+	var chain node_chain
+	for child := n.Children; child != nil; child = child.Next {
+		chain.push(t.expand(child, nodes))
+	}
+	c.Children = chain.finish()
+	return c
+}
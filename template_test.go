@@ -0,0 +1,60 @@
+package sexp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func test_template(t *testing.T, src, gold string, args ...interface{}) {
+	tmpl, err := CompileTemplate(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n, err := tmpl.Expand(args...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	if _, err := n.WriteTo(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if buf.String() != gold {
+		t.Errorf("%s != %s", buf.String(), gold)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	test_template(t, "(user (name %s) (uid %d))", `(user (name "O'Brien (admin)") (uid 1000))`,
+		"O'Brien (admin)", 1000)
+	test_template(t, "(a %f %t %% x-%s)", "(a 1.5 true % x-%s)", 1.5, true)
+	test_template(t, "(a %v %v)", "(a (1 2) ((k v)))", []int{1, 2}, map[string]string{"k": "v"})
+	test_template(t, "%s", `""`, "")
+	test_template(t, `(a "true" () %d)`, `(a "true" () 1)`, 1)
+
+	tmpl := MustCompileTemplate("(a %d %s)")
+	_, err := tmpl.Expand(1)
+	error_must_contain(t, err, "template expects 2 arguments, got 1")
+	_, err = tmpl.Expand(1.5, "x")
+	error_must_contain(t, err, "argument 1: %d verb cannot be used with float64")
+	_, err = tmpl.Expand(1, nil)
+	error_must_contain(t, err, "argument 2: %s verb cannot be used with <nil>")
+
+	// nodes made of values take the location of the placeholder, copied
+	// nodes keep theirs
+	tmpl = MustCompileTemplate("(a %v %v)")
+	arg := &Node{Location: 100, Children: &Node{Location: 101}}
+	n, err := tmpl.Expand([]int{1, 2}, arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := n.Children.Next; v.Location != 3 || v.Children.Next.Location != 3 ||
+		v.Next.Location != 6 || v.Next.Children.Location != 101 {
+		t.Error("unexpected locations of the expanded nodes")
+	}
+
+	_, err = CompileTemplate("(a) (b)")
+	error_must_contain(t, err, "trailing content")
+}
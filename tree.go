@@ -0,0 +1,77 @@
+package sexp
+
+// Checks the invariants of the tree rooted at `n` (without its siblings):
+// every node must be reachable exactly once via the Children and Next links.
+// Trees built by the parser always satisfy them, but a buggy transformation
+// may link a node into two places or create a cycle, which makes most of the
+// functions in this package misbehave or loop forever. The returned error is
+// a *ParseError pointing at the first offending node.
+//
+// The writers check the tree before writing it, see also WriteCircle.
+func ValidateTree(n *Node) (err error) {
+	defer catch_parse_error(&err)
+	v := tree_validator{state: map[*Node]int{n: node_active}}
+	v.check(n)
+	return nil
+}
+
+const (
+	node_unseen = iota
+	node_active // an ancestor or a member of a sibling chain being walked
+	node_done
+)
+
+type tree_validator struct {
+	state map[*Node]int
+}
+
+func (v *tree_validator) check(n *Node) {
+	var chain []*Node
+	for c := n.Children; c != nil; c = c.Next {
+		switch v.state[c] {
+		case node_active:
+			node_error(c, "cycle detected: the node is its own ancestor or sibling")
+		case node_done:
+			node_error(c, "the node is reachable more than once (shared structure)")
+		}
+		v.state[c] = node_active
+		chain = append(chain, c)
+		v.check(c)
+	}
+	for _, c := range chain {
+		v.state[c] = node_done
+	}
+}
+
+// Statistics of a tree, see Stats.
+type TreeStats struct {
+	Nodes      int // total number of nodes, including the root
+	MaxDepth   int // the longest path from the root to a node, in edges
+	MaxListLen int // the largest number of children of a single node
+	ValueBytes int // total length of all the values
+}
+
+// Collects statistics of the tree rooted at `root` (without its siblings).
+// Useful for enforcing resource limits on untrusted documents before doing
+// anything expensive with them. The tree must be valid, see ValidateTree.
+func Stats(root *Node) TreeStats {
+	var s TreeStats
+	s.collect(root, 0)
+	return s
+}
+
+func (s *TreeStats) collect(n *Node, depth int) {
+	s.Nodes++
+	s.ValueBytes += len(n.Value)
+	if depth > s.MaxDepth {
+		s.MaxDepth = depth
+	}
+	num := 0
+	for c := n.Children; c != nil; c = c.Next {
+		s.collect(c, depth+1)
+		num++
+	}
+	if num > s.MaxListLen {
+		s.MaxListLen = num
+	}
+}
@@ -0,0 +1,21 @@
+package sexp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	root, err := Parse(strings.NewReader("(ab (c d e f) ()) gh"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Stats(root)
+	expected := TreeStats{Nodes: 10, MaxDepth: 3, MaxListLen: 4, ValueBytes: 8}
+	if s != expected {
+		t.Errorf("%+v != %+v", s, expected)
+	}
+	if s := Stats(&Node{}); s != (TreeStats{Nodes: 1}) {
+		t.Errorf("unexpected stats of an empty node: %+v", s)
+	}
+}
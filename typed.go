@@ -0,0 +1,213 @@
+package sexp
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Converts `v` to a tree where every value is annotated with its Go type, so
+// that dynamic data (interface{} values, as produced by decoding JSON or
+// by templates) survives a round-trip through text with the exact types,
+// int vs float64 vs string, see (*Node).UnmarshalTyped. Each value is a list
+// headed by its type:
+//
+//     (:int 8080)
+//     (:float64 1.5)
+//     (:string "8080")
+//     (:bool true)
+//     (:nil)
+//     (:bytes "\x00\x01")
+//     (:list (:int 1) (:string "a"))
+//     (:map (name :string "web") (port :int 8080))
+//
+// All sizes of integers and floating point numbers are supported, e.g.
+// (:uint16 80). Map entries are written as their key followed by the items of
+// the typed value, map keys must be strings, the entries are sorted by key.
+// Arrays and slices other than []byte are written as :list, maps as :map,
+// hence they are read back as []interface{} and map[string]interface{}.
+// Pointers and interfaces are written as the values they point to, or as
+// :nil. Other types cause *MarshalError.
+func TypedValueOf(v interface{}) (*Node, error) {
+	return marshal_node_with(v, typed_value)
+}
+
+// Like TypedValueOf, but the tree is written on a single line, see
+// (*Node).WriteTo.
+func MarshalTyped(v interface{}) ([]byte, error) {
+	n, err := TypedValueOf(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := n.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var bytes_type = reflect.TypeOf([]byte(nil))
+
+// Returns a typed value node: a list with the type annotation as its head.
+func typed_value(v reflect.Value) *Node {
+	if !v.IsValid() {
+		return typed_node("nil")
+	}
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return typed_node(t.Kind().String(), &Node{Value: strconv.FormatInt(v.Int(), 10), Kind: NodeInt})
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return typed_node(t.Kind().String(), &Node{Value: strconv.FormatUint(v.Uint(), 10), Kind: NodeInt})
+	case reflect.Float32, reflect.Float64:
+		s := strconv.FormatFloat(v.Float(), 'g', -1, t.Bits())
+		return typed_node(t.Kind().String(), &Node{Value: s, Kind: atom_kind(s)})
+	case reflect.Bool:
+		return typed_node("bool", &Node{Value: strconv.FormatBool(v.Bool())})
+	case reflect.String:
+		return typed_node("string", &Node{Value: v.String(), Kind: NodeString})
+	case reflect.Array, reflect.Slice:
+		if t.Kind() == reflect.Slice && t.Elem() == bytes_type.Elem() {
+			return typed_node("bytes", &Node{Value: string(v.Bytes()), Kind: NodeString})
+		}
+		items := make([]*Node, v.Len())
+		for i := range items {
+			items[i] = typed_value(v.Index(i))
+		}
+		return typed_node("list", items...)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return typed_node("nil")
+		}
+		return typed_value(v.Elem())
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			marshal_error(t, "map keys must be strings")
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		entries := make([]*Node, len(keys))
+		for i, k := range keys {
+			key := &Node{Value: k.String()}
+			key.Next = typed_value(v.MapIndex(k)).Children
+			entries[i] = &Node{Children: key}
+		}
+		return typed_node("map", entries...)
+	}
+	marshal_error(t, "unsupported type")
+	return nil
+}
+
+func typed_node(typ string, items ...*Node) *Node {
+	var chain node_chain
+	chain.push(&Node{Value: ":" + typ})
+	for _, it := range items {
+		chain.push(it)
+	}
+	return &Node{Children: chain.finish()}
+}
+
+// Decodes a value written by TypedValueOf or MarshalTyped, integers and
+// floating point numbers are returned as values of their annotated types,
+// e.g. int64 or float32, see TypedValueOf for the others. Errors are
+// returned as *UnmarshalError pointing at the offending nodes.
+func (n *Node) UnmarshalTyped() (v interface{}, err error) {
+	defer catch_unmarshal_error(&err)
+	return typed_decode(n.Children, n), nil
+}
+
+// Decodes a typed value given as a chain of the annotation and the items
+// following it, `at` is the node to report errors about if the chain is
+// empty.
+func typed_decode(head, at *Node) interface{} {
+	if head == nil || head.IsList() || len(head.Value) < 2 || head.Value[0] != ':' {
+		at.unmarshal_error(nil, "type annotation expected")
+	}
+	typ := head.Value[1:]
+	val := head.Next
+	switch typ {
+	case "nil":
+		if val != nil {
+			val.unmarshal_error(nil, "unexpected value of type :nil")
+		}
+		return nil
+	case "list":
+		out := make([]interface{}, 0)
+		for c := val; c != nil; c = c.Next {
+			out = append(out, typed_decode(c.Children, c))
+		}
+		return out
+	case "map":
+		out := make(map[string]interface{})
+		for c := val; c != nil; c = c.Next {
+			key := c.Children
+			if key == nil || key.IsList() {
+				c.unmarshal_error(nil, "map entry must start with a key")
+			}
+			out[key.Value] = typed_decode(key.Next, c)
+		}
+		return out
+	}
+
+	t, ok := typed_scalar_types[typ]
+	if !ok {
+		head.unmarshal_error(nil, "unknown type annotation")
+	}
+	if val == nil || val.IsList() || val.Next != nil {
+		head.unmarshal_error(t, "a single scalar value expected")
+	}
+	switch typ {
+	case "bytes":
+		return []byte(val.Value)
+	case "string":
+		return val.Value
+	case "bool":
+		if val.Value != "true" && val.Value != "false" {
+			val.unmarshal_error(t, "undefined boolean value, use true|false")
+		}
+		return val.Value == "true"
+	}
+	out := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num, err := strconv.ParseInt(val.Value, 10, t.Bits())
+		if err != nil {
+			val.unmarshal_error(t, "%s", err)
+		}
+		out.SetInt(num)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		num, err := strconv.ParseUint(val.Value, 10, t.Bits())
+		if err != nil {
+			val.unmarshal_error(t, "%s", err)
+		}
+		out.SetUint(num)
+	default:
+		num, err := strconv.ParseFloat(val.Value, t.Bits())
+		if err != nil {
+			val.unmarshal_error(t, "%s", err)
+		}
+		out.SetFloat(num)
+	}
+	return out.Interface()
+}
+
+var typed_scalar_types = map[string]reflect.Type{
+	"bool":    reflect.TypeOf(false),
+	"string":  reflect.TypeOf(""),
+	"bytes":   bytes_type,
+	"int":     reflect.TypeOf(int(0)),
+	"int8":    reflect.TypeOf(int8(0)),
+	"int16":   reflect.TypeOf(int16(0)),
+	"int32":   reflect.TypeOf(int32(0)),
+	"int64":   reflect.TypeOf(int64(0)),
+	"uint":    reflect.TypeOf(uint(0)),
+	"uint8":   reflect.TypeOf(uint8(0)),
+	"uint16":  reflect.TypeOf(uint16(0)),
+	"uint32":  reflect.TypeOf(uint32(0)),
+	"uint64":  reflect.TypeOf(uint64(0)),
+	"float32": reflect.TypeOf(float32(0)),
+	"float64": reflect.TypeOf(float64(0)),
+}
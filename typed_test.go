@@ -0,0 +1,65 @@
+package sexp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTyped(t *testing.T) {
+	var nilptr *int
+	v := map[string]interface{}{
+		"port":  8080,
+		"ratio": 1.0,
+		"id":    "8080",
+		"small": []interface{}{int8(-1), uint16(80), float32(0.5), true, nil},
+		"raw":   []byte{0, 1, 'a'},
+		"ptr":   nilptr,
+		"sub":   map[string]int64{"a": 1},
+	}
+	data, err := MarshalTyped(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gold := `(:map (id :string "8080") (port :int 8080) (ptr :nil) (ratio :float64 1) ` +
+		`(raw :bytes "\x00\x01a") (small :list (:int8 -1) (:uint16 80) (:float32 0.5) (:bool true) (:nil)) ` +
+		`(sub :map (a :int64 1)))`
+	if string(data) != gold {
+		t.Errorf("unexpected output:\n%s\n%s", data, gold)
+	}
+
+	root, err := Parse(strings.NewReader(string(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := root.Children.UnmarshalTyped()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v["ptr"] = nil
+	v["sub"] = map[string]interface{}{"a": int64(1)}
+	if !reflect.DeepEqual(out, v) {
+		t.Errorf("unexpected value: %#v", out)
+	}
+
+	for src, msg := range map[string]string{
+		"(int 1)":           `^type annotation expected`,
+		"(:complex 1)":      `^unknown type annotation \(value: ":complex"\)`,
+		"(:int8 300)":       `^strconv.ParseInt: parsing "300": value out of range`,
+		"(:int 1 2)":        `^a single scalar value expected`,
+		"(:list (:bool x))": `^undefined boolean value`,
+		"(:map ((a) :nil))": `^map entry must start with a key`,
+		"(:nil x)":          `^unexpected value of type :nil`,
+	} {
+		root, err := Parse(strings.NewReader(src), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = root.Children.UnmarshalTyped()
+		error_must_contain(t, err, msg)
+	}
+	_, err = MarshalTyped(map[int]string{1: "a"})
+	error_must_contain(t, err, "map keys must be strings")
+	_, err = MarshalTyped(struct{}{})
+	error_must_contain(t, err, "unsupported type")
+}
@@ -0,0 +1,129 @@
+package sexp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const byte_order_mark = '\uFEFF'
+
+// Returns a reader which transcodes UTF-16 input to UTF-8. The encoding is
+// detected using the byte order mark, which must be present: "\xFF\xFE" for
+// little-endian and "\xFE\xFF" for big-endian input. The mark itself is
+// dropped. Input without a UTF-16 byte order mark is passed through as is.
+// Invalid UTF-16 sequences are replaced with U+FFFD.
+//
+// Note that source locations of nodes parsed from the returned reader are
+// offsets within the transcoded UTF-8 text, not within the original input.
+//
+// A UTF-8 byte order mark on the other hand is understood by the parser
+// directly, it's skipped if found at the beginning of input.
+func NewUTF16Reader(r io.Reader) io.Reader {
+	return &utf16_reader{r: bufio.NewReader(r)}
+}
+
+// Transcodes `data` to UTF-8 if it starts with a UTF-16 byte order mark,
+// returns it as is otherwise.
+func transcode_utf16(data []byte) []byte {
+	if len(data) < 2 || !is_utf16_bom(data[0], data[1]) {
+		return data
+	}
+	// reading from memory never fails
+	out, _ := ioutil.ReadAll(NewUTF16Reader(bytes.NewReader(data)))
+	return out
+}
+
+func is_utf16_bom(b0, b1 byte) bool {
+	return (b0 == 0xFF && b1 == 0xFE) || (b0 == 0xFE && b1 == 0xFF)
+}
+
+type utf16_reader struct {
+	r           *bufio.Reader
+	started     bool
+	passthrough bool
+	big_endian  bool
+	out         []byte
+	err         error
+
+	// a unit read after an unpaired high surrogate
+	pending     uint16
+	has_pending bool
+}
+
+func (r *utf16_reader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		bom, err := r.r.Peek(2)
+		if err != nil || !is_utf16_bom(bom[0], bom[1]) {
+			r.passthrough = true
+		} else {
+			r.big_endian = bom[0] == 0xFE
+			r.r.Discard(2)
+		}
+	}
+	if r.passthrough {
+		return r.r.Read(p)
+	}
+
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.decode()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *utf16_reader) read_unit() (uint16, error) {
+	if r.has_pending {
+		r.has_pending = false
+		return r.pending, nil
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, err
+	}
+	if r.big_endian {
+		return uint16(b[0])<<8 | uint16(b[1]), nil
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
+
+// Decodes a single code point and appends it to the output buffer.
+func (r *utf16_reader) decode() {
+	u, err := r.read_unit()
+	switch err {
+	case nil:
+	case io.ErrUnexpectedEOF:
+		r.out = utf8.AppendRune(r.out, utf8.RuneError)
+		r.err = io.EOF
+		return
+	default:
+		r.err = err
+		return
+	}
+
+	c := rune(u)
+	if utf16.IsSurrogate(c) {
+		if c >= 0xDC00 {
+			// lone low surrogate
+			c = utf8.RuneError
+		} else if u2, err := r.read_unit(); err == nil {
+			c = utf16.DecodeRune(c, rune(u2))
+			if c == utf8.RuneError {
+				// not a low surrogate, decode it on its own
+				r.pending = u2
+				r.has_pending = true
+			}
+		} else {
+			c = utf8.RuneError
+		}
+	}
+	r.out = utf8.AppendRune(r.out, c)
+}
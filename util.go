@@ -10,6 +10,37 @@ func panic_if_error(err error) {
 	}
 }
 
+// Panics with a *ParseError pointing at the node, tree transformations use it
+// along with catch_parse_error to report errors.
+func node_error(n *Node, format string, args ...interface{}) {
+	panic(&ParseError{
+		Location: n.Location,
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Recovers a *ParseError panic and stores it to `err`, must be deferred.
+func catch_parse_error(err *error) {
+	if e := recover(); e != nil {
+		if pe, ok := e.(*ParseError); ok {
+			*err = pe
+			return
+		}
+		panic(e)
+	}
+}
+
+// Recovers an *UnmarshalError panic and stores it to `err`, must be deferred.
+func catch_unmarshal_error(err *error) {
+	if e := recover(); e != nil {
+		if ue, ok := e.(*UnmarshalError); ok {
+			*err = ue
+			return
+		}
+		panic(e)
+	}
+}
+
 func number_suffix(n int) string {
 	if n >= 10 && n <= 20 {
 		return "th"
@@ -32,3 +63,57 @@ func the_list_has_n_children(n int) string {
 	}
 	return fmt.Sprintf("the list has %d children only", n)
 }
+
+// A helper for rebuilding sibling chains, used by tree transformations.
+type node_chain struct {
+	head *Node
+	tail *Node
+}
+
+func (c *node_chain) push(n *Node) {
+	if c.tail == nil {
+		c.head = n
+	} else {
+		c.tail.Next = n
+	}
+	c.tail = n
+}
+
+// Pushes the node and all its siblings.
+func (c *node_chain) push_all(n *Node) {
+	for n != nil {
+		next := n.Next
+		c.push(n)
+		n = next
+	}
+}
+
+// Terminates the chain and returns its first node.
+func (c *node_chain) finish() *Node {
+	if c.tail != nil {
+		c.tail.Next = nil
+	}
+	return c.head
+}
+
+// Sets the location of the node and its descendants, siblings are not
+// affected.
+func set_location(n *Node, loc SourceLoc) {
+	n.Location = loc
+	for c := n.Children; c != nil; c = c.Next {
+		set_location(c, loc)
+	}
+}
+
+// Returns a deep copy of the node, siblings are not copied.
+func copy_tree(n *Node) *Node {
+	c := *n
+	c.Next = nil
+	c.index = nil
+	var chain node_chain
+	for child := n.Children; child != nil; child = child.Next {
+		chain.push(copy_tree(child))
+	}
+	c.Children = chain.finish()
+	return &c
+}
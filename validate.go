@@ -0,0 +1,273 @@
+package sexp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Checks that the input is well-formed without building the tree, which suits
+// gigantic files. The input is read in chunks and split into tokens by
+// Scanner, nesting is checked using a stack of the open lists, hence the
+// memory use is proportional to the nesting depth and the length of the
+// longest token rather than the size of the input. Tokens which may be
+// malformed (e.g. strings with escape sequences) are checked by the parser.
+//
+// The first syntax error is returned as *ParseError, it's the same error
+// Parse would return. Source locations are byte offsets from the beginning of
+// the input. The SharedLabels and LineDirectives options are not supported.
+func Validate(r io.Reader, opts ...ParseOption) (err error) {
+	v := validator{r: r, opts: opts}
+	v.semicolon_comments = true
+	for _, opt := range opts {
+		opt(&v.parse_options)
+	}
+	if v.shared_labels || v.line_directives {
+		return errors.New("SharedLabels and LineDirectives options are not supported by Validate")
+	}
+	if v.decompress {
+		dr, err := NewDecompressReader(r, v.decompressors...)
+		if err != nil {
+			return err
+		}
+		v.r = dr
+	}
+	defer catch_parse_error(&err)
+	v.run()
+	return nil
+}
+
+const validate_chunk = 32 << 10
+
+type validator struct {
+	r    io.Reader
+	opts []ParseOption
+	parse_options
+
+	s      Scanner
+	buf    []byte // the part of input being scanned
+	offset int    // offset of `buf` within the input
+	eof    bool
+
+	stack []seq // open lists, runes are the closing delimiters
+	count int   // number of top-level expressions
+	lines int   // number of newlines scanned, for ExactlyOne only
+}
+
+func (v *validator) run() {
+	v.s.parse_options = v.parse_options
+	v.buf = make([]byte, 0, validate_chunk)
+	v.fill()
+	for {
+		tok := v.s.Scan()
+		if tok.Kind == TokenEOF {
+			if v.eof {
+				v.finish()
+				return
+			}
+			v.carry(tok.End)
+			continue
+		}
+		// a token reaching the end of the buffer may continue past it,
+		// lists delimiters are the only ones which can't
+		if tok.End == len(v.buf) && !v.eof && tok.Kind != TokenOpen && tok.Kind != TokenClose {
+			v.carry(tok.Start)
+			continue
+		}
+		v.token(tok)
+	}
+}
+
+// Drops the scanned part of the buffer up to `start`, the rest is moved to
+// the beginning, and reads more input after it. The scanner restarts at the
+// beginning of the buffer.
+func (v *validator) carry(start int) {
+	n := copy(v.buf, v.buf[start:])
+	v.buf = v.buf[:n]
+	v.offset += start
+	if n+validate_chunk > cap(v.buf) {
+		buf := make([]byte, n, 2*cap(v.buf)+validate_chunk)
+		copy(buf, v.buf)
+		v.buf = buf
+	}
+	v.fill()
+}
+
+// Reads more input into the buffer, at least one byte unless it's the end of
+// input.
+func (v *validator) fill() {
+	for !v.eof {
+		n, err := v.r.Read(v.buf[len(v.buf):cap(v.buf)])
+		v.buf = v.buf[:len(v.buf)+n]
+		if err == io.EOF {
+			v.eof = true
+		} else if err != nil {
+			panic(&ParseError{
+				Location: SourceLoc(v.offset + len(v.buf)),
+				message:  fmt.Sprintf("unexpected read error: %s", err),
+			})
+		}
+		if n > 0 {
+			break
+		}
+	}
+	v.s.src = v.buf
+	v.s.pos = 0
+	v.s.base = v.offset
+}
+
+func (v *validator) error(offset int, format string, args ...interface{}) {
+	panic(&ParseError{
+		Location: SourceLoc(v.offset + offset),
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) token(tok Token) {
+	text := tok.Text(v.buf)
+	switch tok.Kind {
+	case TokenSpace, TokenComment:
+		if v.needs_check(tok) {
+			v.check(tok)
+		}
+		if v.exactly_one {
+			v.lines += bytes.Count(text, []byte("\n"))
+		}
+		return
+	}
+
+	if len(v.stack) == 0 {
+		if v.exactly_one && v.count == 1 {
+			v.error(tok.Start, "trailing content at line %d, a single expression expected",
+				v.lines+1)
+		}
+		if tok.Kind == TokenClose {
+			r, _ := utf8.DecodeRune(text)
+			v.error(tok.Start, "unexpected '%c' at the top level", r)
+		}
+		v.count++
+	}
+
+	switch tok.Kind {
+	case TokenOpen:
+		r, _ := utf8.DecodeRune(text)
+		close, ok := v.list_open[r]
+		if !ok {
+			close = ')'
+		}
+		v.stack = append(v.stack, seq{v.offset + tok.Start, close})
+		if len(v.stack) == warn_nesting_depth+1 && v.warnings != nil {
+			v.warnings(&Diagnostic{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("lists are nested deeper than %d levels", warn_nesting_depth),
+				Location: SourceLoc(v.offset + tok.Start),
+			})
+		}
+	case TokenClose:
+		r, _ := utf8.DecodeRune(text)
+		top := v.stack[len(v.stack)-1]
+		if r != top.rune {
+			v.error(tok.Start, "unexpected '%c', '%c' expected", r, top.rune)
+		}
+		v.stack = v.stack[:len(v.stack)-1]
+	default:
+		if v.needs_check(tok) {
+			v.check(tok)
+		}
+		if v.exactly_one {
+			v.lines += bytes.Count(text, []byte("\n"))
+		}
+	}
+}
+
+// Returns true if the token must be checked by the parser, which is the case
+// for tokens that may be malformed or trigger warnings.
+func (v *validator) needs_check(tok Token) bool {
+	text := tok.Text(v.buf)
+	switch tok.Kind {
+	case TokenInvalid:
+		return true
+	case TokenAtom, TokenNumber, TokenString, TokenRawString:
+		if v.strict_atoms || v.atom_chars != nil || v.warnings != nil {
+			return true
+		}
+		if c := text[0]; c == '#' || c == '|' || bytes.HasPrefix(text, []byte(`"""`)) {
+			return true
+		}
+		if bytes.IndexByte(text, '\\') != -1 {
+			return true
+		}
+	}
+	for _, c := range text {
+		if c >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the token on its own, a parse error is reported with its location
+// adjusted to the token's position in the input. Invalid tokens are followed
+// by the byte terminating them (if any), so that the error is the one Parse
+// would report.
+func (v *validator) check(tok Token) {
+	end := tok.End
+	if tok.Kind == TokenInvalid && end < len(v.buf) {
+		end++
+	}
+	start := v.offset + tok.Start
+
+	var ctx SourceContext
+	p := get_parser()
+	defer p.release()
+	p.init_options(v.opts)
+	p.decompress = false
+	p.exactly_one = false
+	p.bind_context = false
+	p.doc_comments = false
+	p.shebang = p.shebang && tok.Kind == TokenComment &&
+		(start == 0 || start == 3 && v.s.bom)
+	if v.warnings != nil {
+		p.warnings = func(d *Diagnostic) {
+			d.Location += SourceLoc(start)
+			v.warnings(d)
+		}
+	}
+	// a U+FEFF within the input is not the BOM
+	p.inner = start != 0
+	p.r = bytes.NewReader(v.buf[tok.Start:end])
+	p.f = ctx.AddFile("", end-tok.Start)
+	p.last_seq = seq{offset: -1}
+	p.expect_eof = true
+	err := p.parse_each(func(*Node) error { return nil })
+	if err == nil {
+		return
+	}
+	e := err.(*ParseError)
+	e.Location += SourceLoc(start)
+	for i := range e.Related {
+		e.Related[i] += SourceLoc(start)
+	}
+	panic(e)
+}
+
+// Reports lists left open and empty input, called at the end of input.
+func (v *validator) finish() {
+	end := SourceLoc(v.offset + len(v.buf))
+	if len(v.stack) != 0 {
+		top := v.stack[len(v.stack)-1]
+		panic(&ParseError{
+			Location: SourceLoc(top.offset),
+			Related:  []SourceLoc{end},
+			message:  fmt.Sprintf("missing matching sequence delimiter '%c'", top.rune),
+		})
+	}
+	if v.exactly_one && v.count == 0 {
+		panic(&ParseError{
+			Location: end,
+			message:  "an expression expected, the input is empty",
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package sexp
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// How often Watch checks files for modifications.
+var WatchInterval = time.Second
+
+// Watcher is returned by Watch, use Close to stop watching.
+type Watcher struct {
+	filename string
+	out      reflect.Value
+	mu       sync.Locker // held while `out` is written, may be nil
+	onchange func(error)
+	stamps   map[string]file_stamp
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type file_stamp struct {
+	modtime time.Time
+	size    int64
+}
+
+// Loads a file just like Load does and keeps watching it (and all the files
+// it includes) for modifications, reloading it each time it changes.
+//
+// The file is loaded into a new value of the type `out` points to, which is
+// then copied to `out` only if loading succeeded, hence `out` always contains
+// a complete value. The `onChange` callback is invoked with the result of
+// every load (nil or a *LoadError most likely), the initial load happens
+// before Watch returns. Keep in mind that reloads happen in a separate
+// goroutine and `out` is written there without any synchronization, use
+// WatchLocked if `out` is read while the watcher is running.
+//
+// Modifications are detected by polling, see WatchInterval.
+func Watch(filename string, out interface{}, onChange func(error)) *Watcher {
+	return WatchLocked(filename, out, nil, onChange)
+}
+
+// Like Watch, but `mu` is held while `out` is written, so that other
+// goroutines can read `out` holding it as well, e.g. a *sync.RWMutex with
+// readers using RLock. The lock is not held while loading the files or
+// calling onChange.
+func WatchLocked(filename string, out interface{}, mu sync.Locker, onChange func(error)) *Watcher {
+	pv := reflect.ValueOf(out)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() {
+		panic("Watch expects a non-nil pointer argument")
+	}
+
+	w := &Watcher{
+		filename: filename,
+		out:      pv.Elem(),
+		mu:       mu,
+		onchange: onChange,
+		quit:     make(chan struct{}),
+	}
+	w.reload()
+	w.wg.Add(1)
+	go w.loop(WatchInterval)
+	return w
+}
+
+// Stops watching, after Close returns, onChange is never called again.
+func (w *Watcher) Close() {
+	close(w.quit)
+	w.wg.Wait()
+}
+
+func (w *Watcher) loop(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+			if w.modified() {
+				w.reload()
+			}
+		}
+	}
+}
+
+func stat_file(filename string) file_stamp {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return file_stamp{}
+	}
+	return file_stamp{fi.ModTime(), fi.Size()}
+}
+
+func (w *Watcher) modified() bool {
+	for filename, stamp := range w.stamps {
+		if stat_file(filename) != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) reload() {
+	var l Loader
+	v := reflect.New(w.out.Type())
+	err := l.load_value(w.filename, v.Interface())
+
+	// remember the state of all the files involved, if the main file
+	// failed to load, watch at least it
+	w.stamps = map[string]file_stamp{w.filename: stat_file(w.filename)}
+	for filename := range l.files {
+		w.stamps[filename] = stat_file(filename)
+	}
+
+	if err == nil {
+		if w.mu != nil {
+			w.mu.Lock()
+		}
+		w.out.Set(v.Elem())
+		if w.mu != nil {
+			w.mu.Unlock()
+		}
+	}
+	w.onchange(err)
+}
@@ -0,0 +1,107 @@
+package sexp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := write_files(t, map[string]string{
+		"main.sexp": "(include \"port.sexp\")",
+		"port.sexp": "(port 80)",
+	})
+	port := filepath.Join(dir, "port.sexp")
+
+	old := WatchInterval
+	WatchInterval = 10 * time.Millisecond
+	defer func() { WatchInterval = old }()
+
+	var v struct{ Port int }
+	changes := make(chan error, 10)
+	w := Watch(filepath.Join(dir, "main.sexp"), &v, func(err error) {
+		changes <- err
+	})
+	defer w.Close()
+
+	modify := func(contents string, when time.Time) error {
+		if err := ioutil.WriteFile(port, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(port, when, when); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-changes:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for a change")
+		}
+		return nil
+	}
+
+	if err := <-changes; err != nil || v.Port != 80 {
+		t.Fatalf("initial load failed: %v, %+v", err, v)
+	}
+	now := time.Now()
+	err := modify("(port 8080)", now.Add(time.Second))
+	if err != nil || v.Port != 8080 {
+		t.Fatalf("reload failed: %v, %+v", err, v)
+	}
+	err = modify("(port oops)", now.Add(2*time.Second))
+	error_must_contain(t, err, `port\.sexp:1:7: .*invalid syntax`)
+	if v.Port != 8080 {
+		t.Errorf("value should not be modified on error, got: %+v", v)
+	}
+}
+
+// Run with -race, reads of the value must not race with reloads.
+func TestWatchLocked(t *testing.T) {
+	dir := write_files(t, map[string]string{"main.sexp": "(port 80)"})
+	main := filepath.Join(dir, "main.sexp")
+
+	old := WatchInterval
+	WatchInterval = time.Millisecond
+	defer func() { WatchInterval = old }()
+
+	var mu sync.RWMutex
+	var v struct{ Port int }
+	changes := make(chan error, 100)
+	w := WatchLocked(main, &v, &mu, func(err error) {
+		select {
+		case changes <- err:
+		default:
+		}
+	})
+	defer w.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			when := time.Now().Add(time.Duration(i+1) * time.Second)
+			if err := ioutil.WriteFile(main, []byte("(port 8080)"), 0666); err != nil {
+				t.Error(err)
+				return
+			}
+			os.Chtimes(main, when, when)
+			time.Sleep(2 * time.Millisecond)
+		}
+	}()
+	for {
+		mu.RLock()
+		port := v.Port
+		mu.RUnlock()
+		if port != 80 && port != 8080 {
+			t.Fatalf("unexpected port: %d", port)
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package sexp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Writes the node (without its siblings) as an S-expression on a single line,
+// lists are written with a single space between the items. Implements the
+// io.WriterTo interface. Trees with cycles or shared nodes are rejected, see
+// ValidateTree.
+func (n *Node) WriteTo(w io.Writer) (int64, error) {
+	if err := ValidateTree(n); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	write_node(&buf, n)
+	return buf.WriteTo(w)
+}
+
+// Writes the node like WriteTo does, but nodes reachable more than once are
+// labeled with "#n=" when written for the first time and written as "#n#"
+// references afterwards, the output can be read back using the SharedLabels
+// parser option. Parent-child cycles are written the same way, however they
+// are not readable by this package. Cycles within a chain of siblings cannot
+// be written at all, they are reported as *ParseError.
+func (n *Node) WriteCircle(w io.Writer) (int64, error) {
+	c := circle_writer{
+		seen:   make(map[*Node]bool),
+		shared: make(map[*Node]bool),
+		labels: make(map[*Node]int),
+	}
+	if err := c.find_shared(n); err != nil {
+		return 0, err
+	}
+	c.write_node(n)
+	return c.buf.WriteTo(w)
+}
+
+// Writes the node (without its siblings) as an S-expression in a human
+// readable form. Lists consisting of atoms only are written on a single line,
+// other lists have their first item written right after the opening
+// parenthesis and each of the following items on its own line, indented by
+// `indent` per nesting level.
+func (n *Node) WriteIndent(w io.Writer, indent string) (int64, error) {
+	if err := ValidateTree(n); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	write_node_indent(&buf, n, indent, 0)
+	return buf.WriteTo(w)
+}
+
+func write_node_indent(buf *bytes.Buffer, n *Node, indent string, depth int) {
+	flat := true
+	for c := n.Children; c != nil; c = c.Next {
+		if c.IsList() {
+			flat = false
+			break
+		}
+	}
+	if flat {
+		write_node(buf, n)
+		return
+	}
+
+	buf.WriteByte('(')
+	for c := n.Children; c != nil; c = c.Next {
+		if c != n.Children {
+			buf.WriteByte('\n')
+			for i := 0; i <= depth; i++ {
+				buf.WriteString(indent)
+			}
+		}
+		write_node_indent(buf, c, indent, depth+1)
+	}
+	buf.WriteByte(')')
+}
+
+// Writes the node (without its siblings) as an S-expression using the minimal
+// amount of whitespace, which suits machine-to-machine payloads. A space is
+// written only after an unquoted atom followed by another item, e.g.
+// `(a (b "c d") "e f" g)` is written as `(a (b "c d")"e f"g)`. The output
+// reads back as the same tree, an empty string is separated from a string
+// following it, so that the output is not read as a text block.
+func (n *Node) WriteCompact(w io.Writer) (int64, error) {
+	if err := ValidateTree(n); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	write_node_compact(&buf, n)
+	return buf.WriteTo(w)
+}
+
+func write_node_compact(buf *bytes.Buffer, n *Node) {
+	if n.IsScalar() {
+		write_scalar(buf, n)
+		return
+	}
+	buf.WriteByte('(')
+	empty := false
+	for c := n.Children; c != nil; c = c.Next {
+		if c.IsScalar() {
+			// `""` followed by a string reads as a text block, see
+			// TextBlocks
+			s := quote_scalar(c)
+			if empty && s[0] == '"' {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(s)
+			empty = s == `""`
+		} else {
+			write_node_compact(buf, c)
+			empty = false
+		}
+		// '(' and quotes don't terminate unquoted atoms
+		if c.Next != nil && c.IsScalar() && c.Kind.is_bare() && !atom_needs_quoting(c.Value) {
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteByte(')')
+}
+
+// Writes the children of the list node `n`, e.g. the root node returned by
+// Loader.Load, as top-level expressions annotated with line directives (see
+// LineDirectives), so that locations in the output map back to the source
+// locations of the nodes, decoded using `ctx`. Items start on the same lines
+// as their sources do, columns are not preserved.
+func (n *Node) WriteLineDirectives(w io.Writer, ctx *SourceContext) (int64, error) {
+	if err := ValidateTree(n); err != nil {
+		return 0, err
+	}
+	lw := line_writer{ctx: ctx, bol: true}
+	for c := n.Children; c != nil; c = c.Next {
+		lw.write_node(c, c == n.Children)
+	}
+	if !lw.bol {
+		lw.buf.WriteByte('\n')
+	}
+	return lw.buf.WriteTo(w)
+}
+
+// Up to that many lines are skipped using newlines, a directive is written
+// otherwise.
+const max_line_gap = 3
+
+type line_writer struct {
+	buf  bytes.Buffer
+	ctx  *SourceContext
+	file string
+	line int  // the source line of the current output line
+	bol  bool // at the beginning of an output line
+}
+
+func (lw *line_writer) write_node(n *Node, first bool) {
+	lw.move_to(n.Location)
+	if !first && !lw.bol {
+		lw.buf.WriteByte(' ')
+	}
+	lw.bol = false
+	if n.IsScalar() {
+		write_scalar(&lw.buf, n)
+		return
+	}
+	lw.buf.WriteByte('(')
+	for c := n.Children; c != nil; c = c.Next {
+		lw.write_node(c, c == n.Children)
+	}
+	lw.buf.WriteByte(')')
+}
+
+// Makes sure the current output line maps to the source line of `loc`.
+func (lw *line_writer) move_to(loc SourceLoc) {
+	ex := lw.ctx.Decode(loc)
+	if lw.line != 0 && ex.Filename == lw.file && ex.Line >= lw.line &&
+		ex.Line-lw.line <= max_line_gap {
+		for ; lw.line < ex.Line; lw.line++ {
+			lw.buf.WriteByte('\n')
+			lw.bol = true
+		}
+		return
+	}
+	if !lw.bol {
+		lw.buf.WriteByte('\n')
+	}
+	fmt.Fprintf(&lw.buf, "(#line %s %d)\n", strconv.Quote(ex.Filename), ex.Line)
+	lw.file = ex.Filename
+	lw.line = ex.Line
+	lw.bol = true
+}
+
+// Writes the node (without its siblings) as an S-expression formatted to fit
+// within `width` columns, the way Lisp code is usually formatted by hand. A
+// list which fits on the rest of the line is written on a single line.
+// Otherwise, if its first item is an atom, the second item follows it and the
+// rest are aligned with the second one:
+//
+//     (blacklist (structs StockItem)
+//                (functions accelerator_parse binding_set_find))
+//
+// If the first item is a list, or the alignment column would exceed half of
+// the width, each item after the first one is written on its own line,
+// indented by two spaces relative to the opening parenthesis. Atoms longer
+// than the width are never broken, so the output may still exceed it.
+func (n *Node) WriteWidth(w io.Writer, width int) (int64, error) {
+	if err := ValidateTree(n); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	write_node_width(&buf, n, width, 0)
+	return buf.WriteTo(w)
+}
+
+// Writes the node starting at the column `col`.
+func write_node_width(buf *bytes.Buffer, n *Node, width, col int) {
+	if n.IsScalar() || col+flat_width(n, width-col) <= width {
+		write_node(buf, n)
+		return
+	}
+
+	head := n.Children
+	align := col + 2
+	rest := head.Next
+	buf.WriteByte('(')
+	write_node_width(buf, head, width, col+1)
+	if head.IsScalar() && rest != nil {
+		if a := col + 2 + utf8.RuneCountInString(quote_scalar(head)); a <= width/2 {
+			align = a
+			buf.WriteByte(' ')
+			write_node_width(buf, rest, width, align)
+			rest = rest.Next
+		}
+	}
+	for c := rest; c != nil; c = c.Next {
+		buf.WriteByte('\n')
+		buf.WriteString(strings.Repeat(" ", align))
+		write_node_width(buf, c, width, align)
+	}
+	buf.WriteByte(')')
+}
+
+// Returns the width of the node written on a single line, stops counting
+// once the width exceeds `limit`.
+func flat_width(n *Node, limit int) int {
+	if n.IsScalar() {
+		return utf8.RuneCountInString(quote_scalar(n))
+	}
+	w := 1
+	for c := n.Children; c != nil && w <= limit; c = c.Next {
+		w += flat_width(c, limit-w) + 1 // a space or ')'
+	}
+	return w
+}
+
+func write_node(buf *bytes.Buffer, n *Node) {
+	if n.IsScalar() {
+		write_scalar(buf, n)
+		return
+	}
+	buf.WriteByte('(')
+	for c := n.Children; c != nil; c = c.Next {
+		if c != n.Children {
+			buf.WriteByte(' ')
+		}
+		write_node(buf, c)
+	}
+	buf.WriteByte(')')
+}
+
+type circle_writer struct {
+	buf    bytes.Buffer
+	seen   map[*Node]bool
+	shared map[*Node]bool
+	labels map[*Node]int
+}
+
+func (c *circle_writer) find_shared(n *Node) (err error) {
+	defer catch_parse_error(&err)
+	c.seen[n] = true
+	c.mark_shared(n)
+	return nil
+}
+
+func (c *circle_writer) mark_shared(n *Node) {
+	chain := make(map[*Node]bool)
+	for child := n.Children; child != nil; child = child.Next {
+		if chain[child] {
+			node_error(child, "cycle detected within a chain of siblings")
+		}
+		chain[child] = true
+		if c.seen[child] {
+			c.shared[child] = true
+			continue
+		}
+		c.seen[child] = true
+		c.mark_shared(child)
+	}
+}
+
+func (c *circle_writer) write_node(n *Node) {
+	if c.shared[n] {
+		if label, ok := c.labels[n]; ok {
+			c.buf.WriteString("#" + strconv.Itoa(label) + "#")
+			return
+		}
+		label := len(c.labels) + 1
+		c.labels[n] = label
+		c.buf.WriteString("#" + strconv.Itoa(label) + "=")
+	}
+	if n.IsScalar() {
+		write_scalar(&c.buf, n)
+		return
+	}
+	c.buf.WriteByte('(')
+	for child := n.Children; child != nil; child = child.Next {
+		if child != n.Children {
+			c.buf.WriteByte(' ')
+		}
+		c.write_node(child)
+	}
+	c.buf.WriteByte(')')
+}
+
+func write_scalar(buf *bytes.Buffer, n *Node) {
+	buf.WriteString(quote_scalar(n))
+}
+
+// Returns the scalar node in the form matching its kind, see NodeKind. Raw
+// strings which cannot be written as such are written as '"' strings.
+func quote_scalar(n *Node) string {
+	switch n.Kind {
+	case NodeString:
+		return strconv.Quote(n.Value)
+	case NodeRawString:
+		if strconv.CanBackquote(n.Value) {
+			return "`" + n.Value + "`"
+		}
+		return strconv.Quote(n.Value)
+	case NodeList:
+		return "()"
+	}
+	return QuoteAtom(n.Value)
+}
+
+// Returns the atom in a form which reads back as the same atom: as is if it's
+// a bare identifier, as a '"' string otherwise. All the writers in this
+// package follow these rules for bare atoms, see NodeKind.
+//
+// An atom is written as a string if it is empty, contains spaces, parentheses,
+// quotes (any of '"', '`'), ';', '\\' or non-printable characters, or starts
+// with '#', '|' or "//" (which start comments, heredocs, piped symbols and the
+// like with some of the parser options). Escape sequences used in strings are the subset of Go
+// ones understood by the parser.
+func QuoteAtom(s string) string {
+	if atom_needs_quoting(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Like QuoteAtom, but atoms are quoted as piped symbols: `|hello world|`,
+// readable using the PipedSymbols parser option. Within them only '|' and
+// '\\' are escaped, other characters are written as is. Atoms which are not
+// valid UTF-8 are quoted as strings, there is no way to write them as symbols.
+func QuoteSymbol(s string) string {
+	if !atom_needs_quoting(s) {
+		return s
+	}
+	if !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	return "|" + symbol_escaper.Replace(s) + "|"
+}
+
+var symbol_escaper = strings.NewReplacer(`|`, `\|`, `\`, `\\`)
+
+func atom_needs_quoting(s string) bool {
+	return s == "" ||
+		strings.ContainsAny(s, " \t\r\n()\"`;\\") ||
+		strings.HasPrefix(s, "#") ||
+		strings.HasPrefix(s, "|") ||
+		strings.HasPrefix(s, "//") ||
+		!strconv.CanBackquote(s)
+}
@@ -0,0 +1,212 @@
+package sexp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQuoteAtom(t *testing.T) {
+	all := []ParseOption{
+		CommentSyntax(";", "#", "//"),
+		AllowNewlinesInStrings(),
+		Heredocs(),
+		TextBlocks(),
+	}
+	for _, s := range []string{
+		"foo", "hello world", "", "a(b", "a)b", `"quoted"`, "`raw`",
+		";comment", "a;b", `back\slash`, "#t", "#<<EOF", "//x", "a/b",
+		"tab\there", "line\nbreak", "\x00\x7f", "\xff", "\ufeff", "ж", "|a|",
+	} {
+		q := QuoteAtom(s)
+		for _, opts := range [][]ParseOption{nil, all} {
+			root, err := Parse(strings.NewReader(q), nil, opts...)
+			if err != nil {
+				t.Errorf("%s: %s", q, err)
+				continue
+			}
+			n := root.Children
+			if n == nil || n.Next != nil || n.IsList() || n.Value != s {
+				t.Errorf("%q doesn't read back as itself", q)
+			}
+		}
+	}
+
+	for _, s := range []string{"foo", "a/b", "1.5", "-x", "ж"} {
+		if q := QuoteAtom(s); q != s {
+			t.Errorf("%q is not expected to be quoted: %s", s, q)
+		}
+	}
+}
+
+func TestQuoteSymbol(t *testing.T) {
+	for _, s := range []string{
+		"foo", "hello world", "", `a|b`, `|x`, `back\slash`, "line\nbreak",
+		`"quoted"`, ";x", "#t", "\xff", "ж",
+	} {
+		q := QuoteSymbol(s)
+		root, err := Parse(strings.NewReader(q), nil, PipedSymbols(), CommentSyntax(";", "#"))
+		if err != nil {
+			t.Errorf("%s: %s", q, err)
+			continue
+		}
+		n := root.Children
+		if n == nil || n.Next != nil || n.IsList() || n.Value != s {
+			t.Errorf("%q doesn't read back as itself", q)
+		}
+	}
+	if q := QuoteSymbol(`a b|c`); q != `|a b\|c|` {
+		t.Errorf("unexpected symbol: %s", q)
+	}
+}
+
+// Returns a list of the given nodes, links them as siblings.
+func list_of(nodes ...*Node) *Node {
+	l := &Node{}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		nodes[i].Next = l.Children
+		l.Children = nodes[i]
+	}
+	return l
+}
+
+func TestValidateTree(t *testing.T) {
+	root, err := Parse(strings.NewReader("(a (b c) d)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateTree(root); err != nil {
+		t.Fatal(err)
+	}
+
+	// shared: x is the last item of two lists
+	x := &Node{Value: "x"}
+	inner := list_of(&Node{Value: "b"}, x)
+	shared := list_of(&Node{Value: "a"}, inner, x)
+	error_must_contain(t, ValidateTree(shared), `reachable more than once`)
+
+	// parent-child cycle
+	y := &Node{Value: "y"}
+	cycle := list_of(y)
+	y.Next = cycle
+	error_must_contain(t, ValidateTree(cycle), `cycle detected`)
+	var buf bytes.Buffer
+	_, err = cycle.WriteTo(&buf)
+	error_must_contain(t, err, `cycle detected`)
+
+	// sibling cycle
+	z := &Node{Value: "z"}
+	siblings := list_of(&Node{Value: "a"}, z)
+	z.Next = siblings.Children
+	error_must_contain(t, ValidateTree(siblings), `cycle detected`)
+	_, err = siblings.WriteCircle(&buf)
+	error_must_contain(t, err, `cycle detected within a chain of siblings`)
+}
+
+func TestWriteCircle(t *testing.T) {
+	test := func(n *Node, gold string) {
+		var buf bytes.Buffer
+		if _, err := n.WriteCircle(&buf); err != nil {
+			t.Error(err)
+			return
+		}
+		if buf.String() != gold {
+			t.Errorf("%s != %s", buf.String(), gold)
+		}
+	}
+
+	x := &Node{Value: "x"}
+	inner := list_of(&Node{Value: "b"}, x)
+	shared := list_of(&Node{Value: "a"}, inner, x)
+	test(shared, `(a (b #1=x) #1#)`)
+	test_tree(t, `(a (b #1=x) #1#)`, `("a" ("b" "x") "x")`, SharedLabels())
+
+	y := &Node{Value: "y"}
+	cycle := list_of(y)
+	y.Next = cycle
+	test(cycle, `#1=(y #1#)`)
+
+	test(list_of(&Node{Value: "#1#"}), `("#1#")`)
+}
+
+func test_write_width(t *testing.T, source string, width int, gold string) {
+	root, err := Parse(strings.NewReader(source), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	if _, err := root.Children.WriteWidth(&buf, width); err != nil {
+		t.Error(err)
+		return
+	}
+	if buf.String() != gold {
+		t.Errorf("width %d, got:\n%s\nexpected:\n%s", width, buf.String(), gold)
+	}
+}
+
+func TestWriteWidth(t *testing.T) {
+	src := "(blacklist (structs StockItem) (functions accelerator_parse binding_set_find))"
+	test_write_width(t, src, 100, src)
+	test_write_width(t, src, 60, `(blacklist (structs StockItem)
+           (functions accelerator_parse binding_set_find))`)
+	test_write_width(t, src, 50, `(blacklist (structs StockItem)
+           (functions accelerator_parse
+                      binding_set_find))`)
+	test_write_width(t, src, 40, `(blacklist (structs StockItem)
+           (functions
+             accelerator_parse
+             binding_set_find))`)
+	test_write_width(t, src, 20, `(blacklist
+  (structs
+    StockItem)
+  (functions
+    accelerator_parse
+    binding_set_find))`)
+	test_write_width(t, "((a b) (c d))", 10, "((a b)\n  (c d))")
+	test_write_width(t, `(a "x y z")`, 5, "(a\n  \"x y z\")")
+}
+
+func TestWriteCompact(t *testing.T) {
+	for _, c := range []struct{ source, gold string }{
+		{"a", "a"},
+		{`"a b"`, `"a b"`},
+		{`(a ( b "c d" ) "e f" g)`, `(a (b "c d")"e f"g)`},
+		{"((a) (b) c d)", "((a)(b)c d)"},
+		{"(`x` (y) `;` z)", "(`x`(y)`;`z)"},
+		{`(a "b" () c)`, `(a "b"()c)`},
+		{`("" "" "" a "")`, `("" "" ""a "")`},
+	} {
+		root, err := Parse(strings.NewReader(c.source), nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := root.Children.WriteCompact(&buf); err != nil {
+			t.Error(err)
+			continue
+		}
+		if buf.String() != c.gold {
+			t.Errorf("%s != %s", buf.String(), c.gold)
+		}
+		again, err := Parse(strings.NewReader(buf.String()), nil, TextBlocks())
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !trees_equal_values(again.Children, root.Children) {
+			t.Errorf("%s doesn't read back as the same tree", buf.String())
+		}
+	}
+}
+
+// Like trees_equal, but locations are not compared.
+func trees_equal_values(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Value == b.Value && a.Kind == b.Kind &&
+		trees_equal_values(a.Children, b.Children) &&
+		trees_equal_values(a.Next, b.Next)
+}